@@ -3,7 +3,20 @@ module go-netstat
 go 1.15
 
 require (
-	github.com/influxdata/influxdb-client-go/v2 v2.3.0 // indirect
-	github.com/sirupsen/logrus v1.8.1 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	github.com/BurntSushi/toml v0.3.1
+	github.com/aws/aws-sdk-go v1.38.40
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/hashicorp/mdns v1.0.4
+	github.com/hashicorp/memberlist v0.2.4
+	github.com/influxdata/influxdb-client-go/v2 v2.3.0
+	github.com/oschwald/geoip2-golang v1.5.0
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/sirupsen/logrus v1.8.1
+	golang.org/x/net v0.0.0-20210410081132-afb366fc7cd1
+	golang.org/x/sys v0.0.0-20210330210617-4fbd30eecc44
+	google.golang.org/grpc v1.38.0
+	google.golang.org/protobuf v1.26.0
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0
+	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
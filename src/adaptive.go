@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AdaptiveType is the policy for paths that keep failing: after After
+// failed checks in a row (3 by default) their probes are sent Factor times
+// (4 by default) faster, for a quicker diagnosis, or slower, to stop adding
+// load. After as many good checks in a row the normal rate returns.
+type AdaptiveType struct {
+	Policy string  `yaml:"policy"`
+	After  uint    `yaml:"after"`
+	Factor float64 `yaml:"factor"`
+}
+
+// minProbeGap keeps the faster policy from flooding a path.
+const minProbeGap = 100 * time.Millisecond
+
+// adaptState is the run of check outcomes of one site.
+type adaptState struct {
+	failures  uint
+	successes uint
+	active    bool
+}
+
+func (a AdaptiveType) after() uint {
+	if a.After == 0 {
+		return 3
+	}
+	return a.After
+}
+
+func (a AdaptiveType) factor() float64 {
+	if a.Factor == 0 {
+		return 4
+	}
+	return a.Factor
+}
+
+// noteOutcome counts a check of site towards switching its rate.
+func (s *schedulerType) noteOutcome(site SiteType, reachable bool) {
+	configLock.RLock()
+	policy := configData.Adaptive
+	configLock.RUnlock()
+	if policy.Policy == "" {
+		return
+	}
+	s.lock.Lock()
+	state := s.adapt[site.key()]
+	if reachable {
+		state.failures = 0
+		state.successes++
+	} else {
+		state.successes = 0
+		state.failures++
+	}
+	changed := false
+	if !state.active && state.failures >= policy.after() {
+		state.active, changed = true, true
+	} else if state.active && state.successes >= policy.after() {
+		state.active, changed = false, true
+	}
+	s.adapt[site.key()] = state
+	s.lock.Unlock()
+	if changed {
+		log.WithFields(log.Fields{"Region": site.Region, "Site": site.Site}).Info(fmt.Sprintf("Probing at %s probes/s", probeRate(s.probeGap(site, policy))))
+	}
+}
+
+// probeGap is the time between two probes of a check of site: a second,
+// unless policy changed it.
+func (s *schedulerType) probeGap(site SiteType, policy AdaptiveType) time.Duration {
+	if s == nil {
+		return time.Second
+	}
+	s.lock.Lock()
+	active := s.adapt[site.key()].active
+	s.lock.Unlock()
+	if !active {
+		return time.Second
+	}
+	switch policy.Policy {
+	case "faster":
+		gap := time.Duration(float64(time.Second) / policy.factor())
+		if gap < minProbeGap {
+			gap = minProbeGap
+		}
+		return gap
+	case "slower":
+		return time.Duration(float64(time.Second) * policy.factor())
+	}
+	return time.Second
+}
+
+// probeRate formats the probes per second of gap, for the rate tag.
+func probeRate(gap time.Duration) string {
+	return strconv.FormatFloat(float64(time.Second)/float64(gap), 'g', 3, 64)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+type AdminType struct {
+	Listen string `yaml:"listen"`
+	Token  string `yaml:"token"`
+	Debug  bool   `yaml:"debug"`
+	// Coordinator serves /api/register to hand out site lists to agents.
+	Coordinator bool `yaml:"coordinator"`
+}
+
+type siteStatus struct {
+	Region  string       `json:"region"`
+	Site    string       `json:"site"`
+	Address string       `json:"address"`
+	Paused  bool         `json:"paused"`
+	Results []ResultType `json:"results"`
+}
+
+var adminMux = http.NewServeMux()
+
+// startAdmin serves the admin API (and whatever else registered on
+// adminMux) on the configured address. A token, when set, must be sent as
+// a bearer token, except for the health endpoints. Without one the API,
+// which changes the site list, only listens on loopback.
+func startAdmin(cfg AdminType) error {
+	token, err := resolveSecret(cfg.Token)
+	if err != nil {
+		return fmt.Errorf("error resolving admin token: %s", err)
+	}
+	if token == "" && cfg.Coordinator {
+		// Any agent could register, and learn every other one.
+		return fmt.Errorf("admin.coordinator requires admin.token")
+	}
+	if token == "" && !isLoopback(cfg.Listen) {
+		return fmt.Errorf("admin.listen %s is not a loopback address, set admin.token", cfg.Listen)
+	}
+	if sched != nil {
+		adminMux.HandleFunc("/api/sites", handleSites)
+		adminMux.HandleFunc("/api/sites/", handleSite)
+		adminMux.HandleFunc("/api/matrix", handleMatrix)
+		adminMux.HandleFunc("/api/topology", handleTopology)
+		adminMux.HandleFunc("/status", handleStatus)
+		adminMux.Handle("/api/ws", resultSocket)
+		adminMux.HandleFunc("/api/events", handleEvents)
+		adminMux.HandleFunc("/", handleDashboard)
+	}
+	adminMux.HandleFunc("/healthz", healthHandler(false))
+	adminMux.HandleFunc("/readyz", healthHandler(true))
+	if cfg.Coordinator {
+		adminMux.HandleFunc("/api/register", handleRegister)
+	}
+	if cfg.Debug {
+		registerDebug()
+	}
+	handler := http.Handler(adminMux)
+	if token != "" {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if r.URL.Path != "/healthz" && r.URL.Path != "/readyz" && subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			adminMux.ServeHTTP(w, r)
+		})
+	} else {
+		handler = localOnly(adminMux)
+	}
+	l, err := listenTCP(cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %s", cfg.Listen, err)
+	}
+	go func() {
+		if err := http.Serve(l, handler); err != nil {
+			log.Error(fmt.Sprintf("Admin API stopped: %s", err))
+		}
+	}()
+	log.WithFields(log.Fields{"Address": cfg.Listen}).Info("Admin API listening")
+	return nil
+}
+
+// isLoopback tells whether address, host:port, only listens on loopback.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	return isLoopbackHost(host)
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// localOnly keeps web pages from using the tokenless API through the
+// operator's browser. A Host other than a loopback one is a page of
+// another site rebound to 127.0.0.1, and changes must have a JSON or YAML
+// content type, which other sites cannot send without the browser asking
+// the agent first. The health endpoints are left alone.
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !isLoopbackHost(strings.Trim(host, "[]")) {
+			http.Error(w, "host not allowed", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			switch mediaType {
+			case "application/json", "application/yaml", "application/x-yaml", "text/yaml":
+			default:
+				http.Error(w, "content type must be application/json or application/yaml", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	// Site addresses and results may carry credentials, such as a
+	// proxy's password.
+	w.Write([]byte(redact(string(data)) + "\n"))
+}
+
+func statusOf(site SiteType) siteStatus {
+	return siteStatus{
+		Region:  site.Region,
+		Site:    site.Site,
+		Address: site.Address,
+		Paused:  sched.isPaused(site.key()),
+		Results: siteResults(site.key()),
+	}
+}
+
+// handleSites lists all sites (GET) or adds one (POST, JSON or YAML body
+// with the same keys as a remoteSites entry).
+func handleSites(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sites := sched.list()
+		list := make([]siteStatus, 0, len(sites))
+		for _, site := range sites {
+			list = append(list, statusOf(site))
+		}
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var site SiteType
+		if err := yaml.Unmarshal(body, &site); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if site.Address == "" || site.Region == "" || site.Site == "" {
+			http.Error(w, "address, region and site are required", http.StatusBadRequest)
+			return
+		}
+		sched.addSite(site)
+		log.WithFields(log.Fields{"Region": site.Region, "Site": site.Site}).Info("Site added via admin API")
+		writeJSON(w, http.StatusCreated, statusOf(site))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSite serves /api/sites/{region}/{site}[/{action}]: GET shows the
+// site, DELETE removes it, POST to check, cancel, pause or resume acts on
+// it.
+func handleSite(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sites/"), "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+	key := parts[0] + "/" + parts[1]
+	site, ok := sched.find(key)
+	if !ok {
+		http.Error(w, fmt.Sprintf("site %s not found", key), http.StatusNotFound)
+		return
+	}
+	action := ""
+	if len(parts) == 3 {
+		action = parts[2]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, statusOf(site))
+	case action == "" && r.Method == http.MethodDelete:
+		sched.removeSite(key)
+		log.WithFields(log.Fields{"Region": site.Region, "Site": site.Site}).Info("Site removed via admin API")
+		w.WriteHeader(http.StatusNoContent)
+	case action == "history" && r.Method == http.MethodGet:
+		handleHistory(w, r, site)
+	case action == "check" && r.Method == http.MethodPost:
+		if !sched.check(site) {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, statusOf(site))
+	case action == "cancel" && r.Method == http.MethodPost:
+		n := sched.cancelChecks(key)
+		log.WithFields(log.Fields{"Region": site.Region, "Site": site.Site}).Info(fmt.Sprintf("%d checks cancelled via admin API", n))
+		writeJSON(w, http.StatusOK, statusOf(site))
+	case (action == "pause" || action == "resume") && r.Method == http.MethodPost:
+		sched.setPaused(key, action == "pause")
+		log.WithFields(log.Fields{"Region": site.Region, "Site": site.Site}).Info(fmt.Sprintf("Site %sd via admin API", action))
+		writeJSON(w, http.StatusOK, statusOf(site))
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// adminFlags are the flags of the commands that talk to the local agent's
+// admin API.
+type adminFlags struct {
+	url     string
+	token   string
+	timeout time.Duration
+}
+
+func addAdminFlags(fs *flag.FlagSet) *adminFlags {
+	a := &adminFlags{}
+	fs.StringVar(&a.url, "url", "", "Agent admin API, default from admin.listen in the config")
+	fs.StringVar(&a.token, "token", "", "Admin API token, default admin.token from the config")
+	fs.DurationVar(&a.timeout, "timeout", 5*time.Second, "Give up after this long")
+	fs.StringVar(&configFile, "config", configFile, "Config file to read admin.listen and admin.token from")
+	return a
+}
+
+// parseSiteArgs parses the command line of fs, where flags may also follow
+// the region/site arguments, and returns those arguments.
+func parseSiteArgs(fs *flag.FlagSet, args []string) []string {
+	fs.Parse(args)
+	var sites []string
+	for fs.NArg() > 0 {
+		sites = append(sites, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+	return sites
+}
+
+// do sends a request to the agent, taking the address and token from the
+// config unless given. Answers other than 2xx are returned as errors.
+func (a *adminFlags) do(method string, path string) (*http.Response, error) {
+	if a.url == "" || a.token == "" {
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", configFile, err)
+		}
+		if a.url == "" {
+			if a.url, err = adminURL(cfg); err != nil {
+				return nil, err
+			}
+		}
+		if a.token == "" {
+			if a.token, err = resolveSecret(cfg.Admin.Token); err != nil {
+				return nil, err
+			}
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(a.url, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	// Required of changes by an agent without a token, see localOnly.
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Timeout: a.timeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// siteActionCommand returns the run function of "netcheck pause" or
+// "netcheck resume", which post the action for every site given.
+func siteActionCommand(action string) func(args []string) int {
+	return func(args []string) int {
+		fs := flag.NewFlagSet(action, flag.ExitOnError)
+		fs.Usage = commandUsage(action, fs)
+		admin := addAdminFlags(fs)
+		sites := parseSiteArgs(fs, args)
+		if len(sites) == 0 {
+			fs.Usage()
+			return 2
+		}
+		code := 0
+		for _, site := range sites {
+			resp, err := admin.do("POST", "/api/sites/"+site+"/"+action)
+			if err != nil {
+				fmt.Fprintf(stderr, "%s: %s\n", site, err)
+				code = 1
+				continue
+			}
+			resp.Body.Close()
+			fmt.Printf("%s %sd\n", site, action)
+		}
+		return code
+	}
+}
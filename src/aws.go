@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+var awsSession *session.Session
+
+// getAWSSession lazily creates a session using the standard credential
+// chain. When no region is configured the instance metadata is consulted,
+// so agents on EC2 work without extra settings.
+func getAWSSession() (*session.Session, error) {
+	if awsSession != nil {
+		return awsSession, nil
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		region, err := ec2metadata.New(sess).Region()
+		if err != nil {
+			return nil, fmt.Errorf("no AWS region configured and instance metadata unavailable: %s", err)
+		}
+		sess = sess.Copy(&aws.Config{Region: aws.String(region)})
+	}
+	awsSession = sess
+	return awsSession, nil
+}
+
+// resolveAWSSecretsManager handles "aws-sm://name" and "aws-sm://name#key";
+// with a key the secret string is parsed as JSON and the key extracted.
+func resolveAWSSecretsManager(ref string) (string, error) {
+	sess, err := getAWSSession()
+	if err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(strings.TrimPrefix(ref, "aws-sm://"), "#", 2)
+	out, err := secretsmanager.New(sess).GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: aws.String(parts[0])})
+	if err != nil {
+		return "", fmt.Errorf("reading secret %s: %s", parts[0], err)
+	}
+	val := aws.StringValue(out.SecretString)
+	if len(parts) == 1 {
+		return val, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(val), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object: %s", parts[0], err)
+	}
+	field, ok := fields[parts[1]].(string)
+	if !ok {
+		return "", fmt.Errorf("key %s not found in secret %s", parts[1], parts[0])
+	}
+	return field, nil
+}
+
+// resolveAWSParameter handles "aws-ssm://path", decrypting SecureString
+// parameters.
+func resolveAWSParameter(ref string) (string, error) {
+	sess, err := getAWSSession()
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimPrefix(ref, "aws-ssm://")
+	if !strings.HasPrefix(name, "/") && strings.Contains(name, "/") {
+		name = "/" + name
+	}
+	out, err := ssm.New(sess).GetParameter(&ssm.GetParameterInput{Name: aws.String(name), WithDecryption: aws.Bool(true)})
+	if err != nil {
+		return "", fmt.Errorf("reading parameter %s: %s", name, err)
+	}
+	return aws.StringValue(out.Parameter.Value), nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// benchResult is what "netcheck bench" reports. RTTs and lateness are
+// in microseconds, the duration and CPU times in seconds.
+type benchResult struct {
+	Target      string  `json:"target"`
+	Rate        uint    `json:"rate"`
+	Duration    float64 `json:"duration"`
+	Sent        int     `json:"sent"`
+	SentPPS     float64 `json:"sent_pps"`
+	Received    int     `json:"received"`
+	ReceivedPPS float64 `json:"received_pps"`
+	Loss        float64 `json:"loss"`
+	RTTp50      int64   `json:"rtt_p50,omitempty"`
+	RTTp99      int64   `json:"rtt_p99,omitempty"`
+	RTTMax      int64   `json:"rtt_max,omitempty"`
+	LateMean    int64   `json:"late_mean"`
+	LateP99     int64   `json:"late_p99"`
+	LateMax     int64   `json:"late_max"`
+	CPUUser     float64 `json:"cpu_user"`
+	CPUSystem   float64 `json:"cpu_system"`
+	CPUPercent  float64 `json:"cpu_percent"`
+}
+
+// runBench implements "netcheck bench": probes at a fixed, high rate
+// against a reflector, reporting the rates achieved, the replies' RTTs,
+// how late packets left against their schedule and the CPU the sender
+// used, to size reflector hosts and check kernel tuning.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.Usage = commandUsage("bench", fs)
+	port := fs.Uint("port", 0, "Reflector port, when not given with the host")
+	rate := fs.Uint("rate", 1000, "Packets per second to send")
+	duration := fs.Duration("duration", 10*time.Second, "How long to send")
+	size := fs.Uint("size", 0, "Pad probes to this many bytes")
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+	probeKey := probeKeyFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	target := fs.Arg(0)
+	// Flags may also follow the host.
+	fs.Parse(fs.Args()[1:])
+	host, err := splitTarget(target, port)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if err := startProbeKey(*probeKey); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if *rate == 0 || *duration <= 0 {
+		fmt.Fprintln(stderr, "-rate and -duration must be positive")
+		return 2
+	}
+	if *size > maxPayload {
+		fmt.Fprintf(stderr, "-size must be at most %d\n", maxPayload)
+		return 2
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, fmt.Sprint(*port)))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	svc, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer svc.Close()
+
+	var lock sync.Mutex
+	var rtts []int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, maxPayload+1)
+		for {
+			n, err := svc.Read(buf)
+			if err != nil {
+				return
+			}
+			arrived := time.Now().UnixNano()
+			ts, _, err := parseProbe(buf[:n])
+			if err != nil {
+				continue
+			}
+			sent, _ := strconv.ParseInt(ts, 10, 64)
+			lock.Lock()
+			rtts = append(rtts, (arrived-sent)/1000)
+			lock.Unlock()
+		}
+	}()
+
+	site := SiteType{RequestSize: *size}
+	interval := time.Second / time.Duration(*rate)
+	total := int(float64(*rate) * duration.Seconds())
+	if total == 0 {
+		total = 1
+	}
+	late := make([]int64, 0, total)
+	userStart, sysStart := cpuTime()
+	start := time.Now()
+	for i := 0; i < total; i++ {
+		due := start.Add(time.Duration(i) * interval)
+		if wait := time.Until(due); wait > 0 {
+			time.Sleep(wait)
+		}
+		now := time.Now()
+		svc.Write(signPacket(probePayload(strconv.FormatInt(now.UnixNano(), 10), site)))
+		late = append(late, now.Sub(due).Microseconds())
+	}
+	elapsed := time.Since(start)
+	userEnd, sysEnd := cpuTime()
+	// Replies still on their way get a moment.
+	time.Sleep(time.Second)
+	svc.Close()
+	<-done
+
+	res := benchResult{Target: addr.String(), Rate: *rate, Duration: elapsed.Seconds(), Sent: total}
+	res.SentPPS = float64(total) / elapsed.Seconds()
+	res.Received = len(rtts)
+	res.ReceivedPPS = float64(res.Received) / elapsed.Seconds()
+	res.Loss = 100 * float64(total-res.Received) / float64(total)
+	if len(rtts) > 0 {
+		sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+		res.RTTp50 = percentile(rtts, 50)
+		res.RTTp99 = percentile(rtts, 99)
+		res.RTTMax = rtts[len(rtts)-1]
+	}
+	var sum int64
+	for _, l := range late {
+		sum += l
+	}
+	sort.Slice(late, func(i, j int) bool { return late[i] < late[j] })
+	res.LateMean = sum / int64(len(late))
+	res.LateP99 = percentile(late, 99)
+	res.LateMax = late[len(late)-1]
+	res.CPUUser = (userEnd - userStart).Seconds()
+	res.CPUSystem = (sysEnd - sysStart).Seconds()
+	res.CPUPercent = 100 * (res.CPUUser + res.CPUSystem) / elapsed.Seconds()
+
+	if *asJSON {
+		data, _ := json.Marshal(res)
+		fmt.Println(string(data))
+		return 0
+	}
+	ms := func(us int64) string { return fmt.Sprintf("%.3fms", float64(us)/1000) }
+	fmt.Printf("target   %s, %d pps for %s\n", res.Target, res.Rate, *duration)
+	fmt.Printf("sent     %d in %.2fs, %.1f pps\n", res.Sent, res.Duration, res.SentPPS)
+	fmt.Printf("received %d, %.1f pps, loss %.2f%%\n", res.Received, res.ReceivedPPS, res.Loss)
+	if res.Received > 0 {
+		fmt.Printf("rtt      p50 %s p99 %s max %s\n", ms(res.RTTp50), ms(res.RTTp99), ms(res.RTTMax))
+	}
+	fmt.Printf("timing   late by mean %s p99 %s max %s\n", ms(res.LateMean), ms(res.LateP99), ms(res.LateMax))
+	fmt.Printf("cpu      user %.2fs system %.2fs, %.0f%% of a core\n", res.CPUUser, res.CPUSystem, res.CPUPercent)
+	return 0
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// runBurst sends remoteSite.Burst probes to addr back to back and writes
+// how the burst came through as the "burst" measurement: sent and received
+// counts, loss in percent, average RTT, spread (max - min) and jitter (mean
+// difference between consecutive RTTs) in microseconds, and how many
+// echoes came back out of order.
+func runBurst(ctx context.Context, API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, addr *net.UDPAddr, opts sockOpts, extra map[string]string) {
+	network := "udp6"
+	if addr.IP.To4() != nil {
+		network = "udp4"
+	}
+	laddr, err := sourceAddr(remoteSite, addr.IP)
+	if err != nil {
+		return
+	}
+	svc, err := dialUDP(network, laddr, addr, opts)
+	if err != nil {
+		siteLog(remoteSite).Debug(fmt.Sprintf("Failed to dial %s: %s", addr, err))
+		return
+	}
+	defer svc.Close()
+	defer closeOnDone(ctx, svc)()
+	sent := int(remoteSite.Burst)
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+	for seq := 0; seq < sent; seq++ {
+		svc.Write(signPacket([]byte(fmt.Sprintf("burst:%s:%d:%d", id, seq, time.Now().UnixNano()))))
+	}
+	rtts := make([]int64, 0, sent)
+	last, reordered := -1, 0
+	buf := make([]byte, 64)
+	svc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(rtts) < sent {
+		n, err := svc.Read(buf)
+		if err != nil {
+			break
+		}
+		parts := strings.Split(string(buf[:n]), ":")
+		if len(parts) != 4 || parts[0] != "burst" || parts[1] != id {
+			continue
+		}
+		seq, err := strconv.Atoi(parts[2])
+		if err != nil {
+			continue
+		}
+		sentAt, err := strconv.ParseInt(parts[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		rtts = append(rtts, time.Since(time.Unix(0, sentAt)).Microseconds())
+		if seq < last {
+			reordered++
+		} else {
+			last = seq
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	fields := map[string]interface{}{
+		"sent":      sent,
+		"received":  len(rtts),
+		"loss":      100 * float64(sent-len(rtts)) / float64(sent),
+		"reordered": reordered,
+	}
+	if len(rtts) > 0 {
+		var sum, jitter float64
+		minRTT, maxRTT := rtts[0], rtts[0]
+		for i, rtt := range rtts {
+			sum += float64(rtt)
+			if rtt < minRTT {
+				minRTT = rtt
+			}
+			if rtt > maxRTT {
+				maxRTT = rtt
+			}
+			if i > 0 {
+				jitter += math.Abs(float64(rtt - rtts[i-1]))
+			}
+		}
+		fields["avg"] = sum / float64(len(rtts))
+		fields["spread"] = maxRTT - minRTT
+		if len(rtts) > 1 {
+			fields["jitter"] = jitter / float64(len(rtts)-1)
+		}
+	}
+	siteLog(remoteSite).Debug(fmt.Sprintf("Burst of %d to %s: %d received, %d reordered", sent, addr, len(rtts), reordered))
+	tags := siteTags(localSite, remoteSite)
+	for k, v := range extra {
+		tags[k] = v
+	}
+	writeResult(API, "burst", remoteSite, tags, fields)
+}
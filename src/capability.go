@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reflectorProtocol is the version of the probe protocol, bumped when the
+// meaning of a packet changes.
+const reflectorProtocol = 1
+
+// capabilityTTL is how long the outcome of a handshake is kept, so that
+// upgraded reflectors are noticed, silentTTL that of a handshake without
+// answer.
+const (
+	capabilityTTL = 10 * time.Minute
+	silentTTL     = time.Minute
+)
+
+var helloPrefix = []byte("hello:")
+
+func isHello(buf []byte) bool {
+	return bytes.HasPrefix(buf, helloPrefix)
+}
+
+// localFeatures are what this reflector answers beyond plain echoes.
+func localFeatures() []string {
+	features := []string{"burst", "frag", "keepalive", "padding", "stream", "timestamps"}
+	// The TOS byte of received packets is only read on Linux.
+	if runtime.GOOS == "linux" {
+		features = append(features, "dscp")
+	}
+	sort.Strings(features)
+	return features
+}
+
+// reflectHello answers a hello with the protocol version and the features
+// of the reflector.
+func reflectHello(svc net.PacketConn, addr net.Addr, buf []byte) {
+	svc.WriteTo([]byte(fmt.Sprintf("%s %d %s", buf, reflectorProtocol, strings.Join(localFeatures(), ","))), addr)
+}
+
+// capabilities are what a reflector told in its hello reply. Reflectors
+// from before the handshake, and plain echo servers, are version 0 with no
+// features.
+type capabilities struct {
+	version  int
+	features map[string]bool
+	expires  time.Time
+	// silent is set when the reflector answered nothing; that is
+	// retried sooner.
+	silent bool
+}
+
+var (
+	capabilityCache = make(map[string]capabilities)
+	capabilityLock  sync.Mutex
+)
+
+// siteFeatures maps the reflector features the settings of a site need
+// to the settings.
+func siteFeatures(site SiteType) map[string]string {
+	needs := make(map[string]string)
+	if site.RequestSize > 0 || site.ReplySize > 0 {
+		needs["padding"] = "requestSize and replySize"
+	}
+	if site.OWD {
+		needs["timestamps"] = "owd"
+	}
+	if site.ECN != "" {
+		needs["dscp"] = "ecn"
+	}
+	if site.Burst > 0 {
+		needs["burst"] = "burst"
+	}
+	if site.Stream.Rate > 0 {
+		needs["stream"] = "stream"
+	}
+	if site.FragTest > 0 {
+		needs["frag"] = "fragTest"
+	}
+	if site.Keepalive > 0 {
+		needs["keepalive"] = "keepalive"
+	}
+	return needs
+}
+
+// negotiate returns remoteSite without the settings its reflector at addr
+// does not support, so they are not measured as loss. Sites with no such
+// settings and reflectors that do not answer at all are left alone.
+func negotiate(ctx context.Context, remoteSite SiteType, addr *net.UDPAddr) SiteType {
+	needs := siteFeatures(remoteSite)
+	if len(needs) == 0 {
+		return remoteSite
+	}
+	caps, fresh, ok := reflectorCapabilities(ctx, remoteSite, addr)
+	if !ok {
+		return remoteSite
+	}
+	features := make([]string, 0, len(needs))
+	for feature := range needs {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	for _, feature := range features {
+		if caps.features[feature] {
+			continue
+		}
+		if fresh {
+			siteLog(remoteSite).Warn(fmt.Sprintf("Reflector at %s (protocol %d) does not support %s, probing without %s", addr, caps.version, feature, needs[feature]))
+		}
+		switch feature {
+		case "padding":
+			remoteSite.RequestSize, remoteSite.ReplySize = 0, 0
+		case "timestamps":
+			remoteSite.OWD = false
+		case "dscp":
+			remoteSite.ECN = ""
+		case "burst":
+			remoteSite.Burst = 0
+		case "stream":
+			remoteSite.Stream = StreamType{}
+		case "frag":
+			remoteSite.FragTest = 0
+		case "keepalive":
+			remoteSite.Keepalive = 0
+		}
+	}
+	return remoteSite
+}
+
+// reflectorCapabilities returns the capabilities of the reflector at addr,
+// from the cache or a handshake, and whether they changed, or were
+// learned, with this call. ok is false when the reflector answered
+// neither a hello nor a plain probe.
+func reflectorCapabilities(ctx context.Context, site SiteType, addr *net.UDPAddr) (capabilities, bool, bool) {
+	key := addr.String()
+	capabilityLock.Lock()
+	prev, cached := capabilityCache[key]
+	capabilityLock.Unlock()
+	if cached && time.Now().Before(prev.expires) {
+		return prev, false, !prev.silent
+	}
+	caps, err := handshake(ctx, site, addr)
+	if err != nil {
+		siteLog(site).Debug(fmt.Sprintf("No handshake with %s: %s", addr, err))
+		if ctx.Err() == nil {
+			capabilityLock.Lock()
+			capabilityCache[key] = capabilities{silent: true, expires: time.Now().Add(silentTTL)}
+			capabilityLock.Unlock()
+		}
+		return capabilities{}, false, false
+	}
+	caps.expires = time.Now().Add(capabilityTTL)
+	capabilityLock.Lock()
+	capabilityCache[key] = caps
+	capabilityLock.Unlock()
+	changed := !cached || prev.silent || caps.version != prev.version || len(caps.features) != len(prev.features)
+	for feature := range caps.features {
+		changed = changed || !prev.features[feature]
+	}
+	if changed {
+		siteLog(site).Debug(fmt.Sprintf("Reflector at %s speaks protocol %d with %s", addr, caps.version, caps))
+	}
+	return caps, changed, true
+}
+
+func (c capabilities) String() string {
+	if len(c.features) == 0 {
+		return "no features"
+	}
+	features := make([]string, 0, len(c.features))
+	for feature := range c.features {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	return strings.Join(features, ", ")
+}
+
+// handshake sends hellos to addr, three at most a second apart. A
+// reflector answering none gets a plain probe: an echo tells it is from
+// before the handshake.
+func handshake(ctx context.Context, site SiteType, addr *net.UDPAddr) (capabilities, error) {
+	svc, err := dialProbe(site, addr, probeSockOpts(site), 0)
+	if err != nil {
+		return capabilities{}, err
+	}
+	defer svc.Close()
+	defer closeOnDone(ctx, svc)()
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+	buf := make([]byte, 512)
+	exchange := func(msg string) (string, bool) {
+		svc.Write(signPacket([]byte(msg)))
+		svc.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			n, err := svc.Read(buf)
+			if err != nil {
+				return "", false
+			}
+			if reply := string(buf[:n]); strings.HasPrefix(reply, msg) {
+				return strings.TrimPrefix(reply, msg), true
+			}
+		}
+	}
+	for seq := 0; seq < 3 && ctx.Err() == nil; seq++ {
+		rest, ok := exchange(fmt.Sprintf("%s%s:%d", helloPrefix, id, seq))
+		if !ok {
+			continue
+		}
+		caps := capabilities{features: make(map[string]bool)}
+		// A plain echo server sends the hello back as it is.
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return caps, nil
+		}
+		if caps.version, err = strconv.Atoi(fields[0]); err != nil {
+			return capabilities{}, fmt.Errorf("bad hello reply %q", rest)
+		}
+		if len(fields) > 1 {
+			// Features this agent does not know are kept, and unused.
+			for _, feature := range strings.Split(fields[1], ",") {
+				caps.features[feature] = true
+			}
+		}
+		return caps, nil
+	}
+	if ctx.Err() != nil {
+		return capabilities{}, ctx.Err()
+	}
+	if _, ok := exchange(strconv.FormatInt(time.Now().UnixNano(), 10)); ok {
+		return capabilities{features: make(map[string]bool)}, nil
+	}
+	return capabilities{}, fmt.Errorf("no answer")
+}
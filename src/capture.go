@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CaptureType keeps the last window seconds of probe traffic in memory
+// and, when a check crosses the RTT (milliseconds) or loss (percent)
+// threshold, writes them and the next after seconds to a pcap file in dir.
+// Zero thresholds are the site's thresholds.
+type CaptureType struct {
+	Dir       string  `yaml:"dir"`
+	RTT       float64 `yaml:"rtt"`
+	Loss      float64 `yaml:"loss"`
+	Window    uint    `yaml:"window"`
+	After     uint    `yaml:"after"`
+	Interface string  `yaml:"interface"`
+	Snaplen   uint    `yaml:"snaplen"`
+	MaxFiles  uint    `yaml:"maxFiles"`
+}
+
+const (
+	defaultCaptureWindow   = 30
+	defaultCaptureAfter    = 5
+	defaultCaptureSnaplen  = 256
+	defaultCaptureMaxFiles = 100
+	// maxCaptureBytes bounds the memory the rolling capture takes.
+	maxCaptureBytes = 16 << 20
+)
+
+func (c CaptureType) window() time.Duration {
+	if c.Window == 0 {
+		return defaultCaptureWindow * time.Second
+	}
+	return time.Duration(c.Window) * time.Second
+}
+
+func (c CaptureType) after() time.Duration {
+	if c.After == 0 {
+		return defaultCaptureAfter * time.Second
+	}
+	return time.Duration(c.After) * time.Second
+}
+
+func (c CaptureType) snaplen() int {
+	if c.Snaplen == 0 {
+		return defaultCaptureSnaplen
+	}
+	return int(c.Snaplen)
+}
+
+// capturedPacket is an IP packet, cut to the snaplen, and its length on
+// the wire.
+type capturedPacket struct {
+	time    time.Time
+	data    []byte
+	wireLen int
+}
+
+// packetRing holds the packets of the last window.
+type packetRing struct {
+	sync.Mutex
+	window  time.Duration
+	packets []capturedPacket
+	bytes   int
+}
+
+func (r *packetRing) add(p capturedPacket) {
+	r.Lock()
+	defer r.Unlock()
+	r.packets = append(r.packets, p)
+	r.bytes += len(p.data)
+	drop := 0
+	for drop < len(r.packets) && (r.bytes > maxCaptureBytes || p.time.Sub(r.packets[drop].time) > r.window) {
+		r.bytes -= len(r.packets[drop].data)
+		drop++
+	}
+	if drop > 0 {
+		r.packets = append(r.packets[:0], r.packets[drop:]...)
+	}
+}
+
+// since returns the packets captured from start on.
+func (r *packetRing) since(start time.Time) []capturedPacket {
+	r.Lock()
+	defer r.Unlock()
+	i := sort.Search(len(r.packets), func(i int) bool {
+		return !r.packets[i].time.Before(start)
+	})
+	return append([]capturedPacket(nil), r.packets[i:]...)
+}
+
+// probePorts are the UDP ports probes are sent to, see captured.
+var probePorts atomic.Value
+
+func setProbePorts(cfg ConfigType, sites []SiteType) {
+	ports := map[uint16]bool{uint16(cfg.Port): true}
+	for _, site := range sites {
+		if site.Port != 0 {
+			ports[uint16(site.Port)] = true
+		}
+		for _, port := range site.Ports {
+			ports[uint16(port)] = true
+		}
+	}
+	probePorts.Store(ports)
+}
+
+// captured tells whether an IP packet is probe traffic: UDP from or to a
+// probe port, or ICMP, which carries the errors probes run into.
+func captured(pkt []byte) bool {
+	var proto byte
+	var udp []byte
+	switch {
+	case len(pkt) >= 20 && pkt[0]>>4 == 4:
+		// The header length comes from the wire: not trusted.
+		ihl := int(pkt[0]&0x0f) * 4
+		if ihl < 20 || len(pkt) < ihl {
+			return false
+		}
+		proto = pkt[9]
+		udp = pkt[ihl:]
+	case len(pkt) >= 40 && pkt[0]>>4 == 6:
+		proto = pkt[6]
+		udp = pkt[40:]
+	default:
+		return false
+	}
+	switch proto {
+	case 1, 58:
+		return true
+	case 17:
+		if len(udp) < 4 {
+			return false
+		}
+		ports, _ := probePorts.Load().(map[uint16]bool)
+		return ports[binary.BigEndian.Uint16(udp[0:])] || ports[binary.BigEndian.Uint16(udp[2:])]
+	}
+	return false
+}
+
+// runCapture records probe traffic until stop is closed, and writes a
+// capture when an rtt result is over the thresholds. A site is captured
+// again only once its previous capture is a window old.
+func runCapture(cfg CaptureType, stop <-chan struct{}) {
+	configLock.RLock()
+	setProbePorts(configData, sched.list())
+	configLock.RUnlock()
+	src, err := openCapture(cfg.Interface, cfg.snaplen())
+	if err != nil {
+		log.Warn(fmt.Sprintf("Packet capture disabled: %s", err))
+		return
+	}
+	ring := &packetRing{window: cfg.window() + cfg.after()}
+	go func() {
+		defer src.close()
+		buf := make([]byte, cfg.snaplen())
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			n, wireLen, err := src.read(buf)
+			if err != nil || !captured(buf[:n]) {
+				continue
+			}
+			ring.add(capturedPacket{time: time.Now(), data: append([]byte(nil), buf[:n]...), wireLen: wireLen})
+		}
+	}()
+	results := subscribeResults()
+	defer unsubscribeResults(results)
+	last := make(map[string]time.Time)
+	for {
+		select {
+		case <-stop:
+			return
+		case res := <-results:
+			if res.Measurement != "rtt" {
+				continue
+			}
+			key := SiteType{Region: res.Region, Site: res.Site}.key()
+			configLock.RLock()
+			cfg = configData.Capture
+			setProbePorts(configData, sched.list())
+			configLock.RUnlock()
+			site, _ := sched.find(key)
+			reason := captureReason(cfg, site, res)
+			if reason == "" || cfg.Dir == "" || time.Since(last[key]) < cfg.window() {
+				continue
+			}
+			last[key] = time.Now()
+			start := time.Now().Add(-cfg.window())
+			siteLog(site).Info(fmt.Sprintf("Capturing probe traffic, %s", reason))
+			time.AfterFunc(cfg.after(), func() {
+				writeCapture(cfg, res, ring.since(start))
+			})
+		}
+	}
+}
+
+// captureReason tells which threshold res crossed, if any.
+func captureReason(cfg CaptureType, site SiteType, res ResultType) string {
+	rtt, loss := cfg.RTT, cfg.Loss
+	if rtt == 0 {
+		rtt = site.Thresholds.RTT
+	}
+	if loss == 0 {
+		loss = site.Thresholds.Loss
+	}
+	if reachable, ok := res.Fields["reachable"].(bool); ok && !reachable {
+		return "no reply"
+	}
+	if l, ok := res.Fields["loss"].(float64); ok && loss > 0 && l > loss {
+		return fmt.Sprintf("loss %.0f%% over %g%%", l, loss)
+	}
+	if avg, ok := res.Fields["avg"].(int64); ok && rtt > 0 && float64(avg)/1000 > rtt {
+		return fmt.Sprintf("RTT %.1fms over %gms", float64(avg)/1000, rtt)
+	}
+	return ""
+}
+
+// writeCapture writes packets to netcheck-<cycle>-<region>-<site>.pcap in
+// the capture directory, then removes the oldest captures beyond
+// maxFiles.
+func writeCapture(cfg CaptureType, res ResultType, packets []capturedPacket) {
+	cycle := res.Cycle
+	if cycle == "" {
+		cycle = res.Time.Format("20060102T150405")
+	}
+	name := fmt.Sprintf("netcheck-%s-%s-%s.pcap", cycle, res.Region, res.Site)
+	logger := log.WithFields(log.Fields{"Capture": name, "Packets": len(packets)})
+	if dryRun {
+		logger.Info("Would write capture")
+		return
+	}
+	if err := writeFileAtomic(filepath.Join(cfg.Dir, name), pcapFile(packets, cfg.snaplen())); err != nil {
+		logger.Error(fmt.Sprintf("Failed to write capture: %s", err))
+		return
+	}
+	logger.Info("Wrote capture")
+	maxFiles := int(cfg.MaxFiles)
+	if maxFiles == 0 {
+		maxFiles = defaultCaptureMaxFiles
+	}
+	files, err := ioutil.ReadDir(cfg.Dir)
+	if err != nil {
+		return
+	}
+	var captures []os.FileInfo
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), "netcheck-") && strings.HasSuffix(f.Name(), ".pcap") {
+			captures = append(captures, f)
+		}
+	}
+	sort.Slice(captures, func(i, j int) bool {
+		return captures[i].ModTime().After(captures[j].ModTime())
+	})
+	if len(captures) > maxFiles {
+		for _, f := range captures[maxFiles:] {
+			os.Remove(filepath.Join(cfg.Dir, f.Name()))
+		}
+	}
+}
+
+// pcapFile renders packets in the pcap format, as raw IP.
+func pcapFile(packets []capturedPacket, snaplen int) []byte {
+	buf := make([]byte, 24)
+	binary.LittleEndian.PutUint32(buf[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(buf[4:], 2)
+	binary.LittleEndian.PutUint16(buf[6:], 4)
+	binary.LittleEndian.PutUint32(buf[16:], uint32(snaplen))
+	// LINKTYPE_RAW
+	binary.LittleEndian.PutUint32(buf[20:], 101)
+	for _, p := range packets {
+		var hdr [16]byte
+		binary.LittleEndian.PutUint32(hdr[0:], uint32(p.time.Unix()))
+		binary.LittleEndian.PutUint32(hdr[4:], uint32(p.time.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(hdr[8:], uint32(len(p.data)))
+		binary.LittleEndian.PutUint32(hdr[12:], uint32(p.wireLen))
+		buf = append(append(buf, hdr[:]...), p.data...)
+	}
+	return buf
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// packetSource reads the IP packets of one interface, or all of them,
+// through a packet socket.
+type packetSource struct {
+	fd int
+}
+
+// captureFilter passes UDP, ICMP and ICMPv6 packets only, so the rest of
+// the traffic does not reach the agent.
+var captureFilter = []bpf.Instruction{
+	bpf.LoadExtension{Num: bpf.ExtProto},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.ETH_P_IP, SkipFalse: 4},
+	bpf.LoadAbsolute{Off: 9, Size: 1},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.IPPROTO_UDP, SkipTrue: 7},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.IPPROTO_ICMP, SkipTrue: 6},
+	bpf.RetConstant{Val: 0},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.ETH_P_IPV6, SkipFalse: 3},
+	bpf.LoadAbsolute{Off: 6, Size: 1},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.IPPROTO_UDP, SkipTrue: 2},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.IPPROTO_ICMPV6, SkipTrue: 1},
+	bpf.RetConstant{Val: 0},
+	bpf.RetConstant{Val: 0xffff},
+}
+
+// openCapture opens a packet socket on iface, or on every interface when
+// empty. It needs CAP_NET_RAW.
+func openCapture(iface string, snaplen int) (*packetSource, error) {
+	filter, err := bpf.Assemble(captureFilter)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("opening packet socket: %s", err)
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: (*unix.SockFilter)(unsafe.Pointer(&filter[0]))}
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("attaching capture filter: %s", err)
+	}
+	if iface != "" {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			unix.Close(fd)
+			return nil, err
+		}
+		if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifi.Index}); err != nil {
+			unix.Close(fd)
+			return nil, err
+		}
+	}
+	// The timeout lets the reader notice the agent stopping.
+	tv := unix.NsecToTimeval(int64(time.Second))
+	unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	return &packetSource{fd: fd}, nil
+}
+
+// read reads a packet into buf, cut to its size, and returns the bytes
+// read and the length of the packet.
+func (p *packetSource) read(buf []byte) (int, int, error) {
+	n, _, err := unix.Recvfrom(p.fd, buf, unix.MSG_TRUNC)
+	if err != nil {
+		return 0, 0, err
+	}
+	if n > len(buf) {
+		return len(buf), n, nil
+	}
+	return n, n, nil
+}
+
+func (p *packetSource) close() {
+	unix.Close(p.fd)
+}
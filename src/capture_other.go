@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "fmt"
+
+type packetSource struct{}
+
+func openCapture(iface string, snaplen int) (*packetSource, error) {
+	return nil, fmt.Errorf("packet capture is only supported on Linux")
+}
+
+func (p *packetSource) read(buf []byte) (int, int, error) {
+	return 0, 0, fmt.Errorf("packet capture is only supported on Linux")
+}
+
+func (p *packetSource) close() {}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TagLimitsType bounds the values of the tags that come from what
+// was measured rather than from the config, so they cannot add series
+// without end.
+type TagLimitsType struct {
+	MaxValues uint                   `yaml:"maxValues"`
+	Tags      map[string]TagRuleType `yaml:"tags"`
+}
+
+// TagRuleType rewrites the values of one tag: IP addresses are cut to
+// their prefix, other values to truncate characters, then hashed, and
+// beyond maxValues distinct values the rest become "other".
+type TagRuleType struct {
+	Prefix    uint `yaml:"prefix"`
+	Prefix6   uint `yaml:"prefix6"`
+	Truncate  uint `yaml:"truncate"`
+	Hash      bool `yaml:"hash"`
+	MaxValues uint `yaml:"maxValues"`
+}
+
+// dynamicTags are the tags whose values are measured, which maxValues
+// applies to without a rule.
+var dynamicTags = map[string]bool{
+	"dst_ip": true, "src_ip": true, "ptr": true, "country": true,
+	"city": true, "asn": true, "as_name": true, "gateway": true,
+}
+
+const defaultMaxTagValues = 1000
+
+// tagValueIdle is how long a tag value keeps its place among the limited
+// ones after it was last written.
+const tagValueIdle = 24 * time.Hour
+
+// overflowValue replaces the values of a tag beyond its limit.
+const overflowValue = "other"
+
+var (
+	// tagValues has when each value of a tag was last written.
+	tagValues     = make(map[string]map[string]time.Time)
+	tagOverflows  = make(map[string]bool)
+	tagValuesLock sync.Mutex
+)
+
+// limitTags applies the cardinality rules to the tags of a point, before
+// any renaming, and returns them, copied when changed.
+func limitTags(tags map[string]string) map[string]string {
+	cfg := currentConfig().TagLimits
+	limited := tags
+	copied := false
+	for k, v := range tags {
+		rule, ok := cfg.Tags[k]
+		if !ok && !dynamicTags[k] {
+			continue
+		}
+		max := rule.MaxValues
+		if max == 0 {
+			max = cfg.MaxValues
+		}
+		if max == 0 {
+			max = defaultMaxTagValues
+		}
+		val := rule.apply(v)
+		if !admitTagValue(k, val, max) {
+			val = overflowValue
+		}
+		if val == v {
+			continue
+		}
+		if !copied {
+			limited = make(map[string]string, len(tags))
+			for k, v := range tags {
+				limited[k] = v
+			}
+			copied = true
+		}
+		limited[k] = val
+	}
+	return limited
+}
+
+func (r TagRuleType) apply(v string) string {
+	if ip := net.ParseIP(v); ip != nil && (r.Prefix > 0 || r.Prefix6 > 0) {
+		if ip.To4() != nil && r.Prefix > 0 && r.Prefix <= 32 {
+			v = fmt.Sprintf("%s/%d", ip.Mask(net.CIDRMask(int(r.Prefix), 32)), r.Prefix)
+		} else if ip.To4() == nil && r.Prefix6 > 0 && r.Prefix6 <= 128 {
+			v = fmt.Sprintf("%s/%d", ip.Mask(net.CIDRMask(int(r.Prefix6), 128)), r.Prefix6)
+		}
+	}
+	if runes := []rune(v); r.Truncate > 0 && uint(len(runes)) > r.Truncate {
+		v = string(runes[:r.Truncate])
+	}
+	if r.Hash {
+		sum := sha256.Sum256([]byte(v))
+		v = hex.EncodeToString(sum[:6])
+	}
+	return v
+}
+
+// admitTagValue tells whether val may be written as a value of tag: it
+// was seen before, or the tag has fewer than max values so far. Values
+// not written for tagValueIdle make room for new ones.
+func admitTagValue(tag string, val string, max uint) bool {
+	now := clock.Now()
+	tagValuesLock.Lock()
+	defer tagValuesLock.Unlock()
+	seen := tagValues[tag]
+	if seen == nil {
+		seen = make(map[string]time.Time)
+		tagValues[tag] = seen
+	}
+	if _, ok := seen[val]; ok {
+		seen[val] = now
+		return true
+	}
+	if uint(len(seen)) >= max {
+		for v, last := range seen {
+			if now.Sub(last) > tagValueIdle {
+				delete(seen, v)
+			}
+		}
+	}
+	if uint(len(seen)) >= max {
+		atomic.AddUint64(&telemetry.tagsLimited, 1)
+		if !tagOverflows[tag] {
+			tagOverflows[tag] = true
+			log.WithFields(log.Fields{"Tag": tag}).Warn(fmt.Sprintf("More than %d values of a tag, writing the new ones as %s", max, overflowValue))
+		}
+		return false
+	}
+	seen[val] = now
+	return true
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runCheck implements "netcheck check": one cycle against the configured
+// sites, a summary on stdout and a non-zero exit when a site is
+// unreachable or over a threshold. Nothing is written to InfluxDB.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.Usage = commandUsage("check", fs)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	sites := fs.String("sites", "", "Comma separated region/site list to check, default all")
+	maxRTT := fs.Float64("max-rtt", 0, "Fail sites with an average RTT above this many milliseconds, default the site's thresholds.rtt")
+	maxLoss := fs.Float64("max-loss", 0, "Fail sites with loss above this percentage, default the site's thresholds.loss")
+	fs.Parse(args)
+	if debug {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.WarnLevel)
+	}
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", configFile, err)
+		return 2
+	}
+	configData = cfg
+	if err := openVault(cfg.Vault); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if err := startProbeKey(cfg.ProbeKey); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	selected := cfg.RemoteSites
+	if *sites != "" {
+		selected = nil
+		for _, key := range strings.Split(*sites, ",") {
+			found := false
+			for _, site := range cfg.RemoteSites {
+				if site.key() == key {
+					selected = append(selected, site)
+					found = true
+				}
+			}
+			if !found {
+				fmt.Fprintf(stderr, "site %s is not configured\n", key)
+				return 2
+			}
+		}
+	}
+	out := &printWriter{quiet: true}
+	var wg sync.WaitGroup
+	for _, site := range selected {
+		wg.Add(1)
+		go func(site SiteType) {
+			defer wg.Done()
+			CheckSite(context.Background(), out, cfg.LocalSite, site, cfg.Port)
+		}(site)
+	}
+	wg.Wait()
+	failed := 0
+	for _, site := range selected {
+		rtt, loss := *maxRTT, *maxLoss
+		if rtt == 0 {
+			rtt = site.Thresholds.RTT
+		}
+		if loss == 0 {
+			loss = site.Thresholds.Loss
+		}
+		problem, summary := checkSummary(site, rtt, loss)
+		status := "OK  "
+		if problem {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("%s %-30s %s\n", status, site.key(), summary)
+	}
+	fmt.Printf("%d of %d sites failed\n", failed, len(selected))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkSummary describes the rtt results of a site and whether any of them
+// is missing or over a threshold. Zero thresholds are not checked.
+func checkSummary(site SiteType, maxRTT float64, maxLoss float64) (bool, string) {
+	var problem bool
+	var parts []string
+	for _, res := range siteResults(site.key()) {
+		if res.Measurement != "rtt" {
+			continue
+		}
+		avg, _ := res.Fields["avg"].(int64)
+		jitter, _ := res.Fields["jitter"].(int64)
+		rtt := float64(avg) / 1000
+		text := fmt.Sprintf("rtt %.3fms jitter %.3fms", rtt, float64(jitter)/1000)
+		if reachable, ok := res.Fields["reachable"].(bool); ok && !reachable {
+			problem = true
+			text = "no response"
+		} else if loss, ok := res.Fields["loss"].(float64); ok {
+			text += fmt.Sprintf(" loss %.1f%%", loss)
+			if maxLoss > 0 && loss > maxLoss {
+				problem = true
+				text += fmt.Sprintf(" (loss over %.1f%%)", maxLoss)
+			}
+		}
+		if maxRTT > 0 && rtt > maxRTT {
+			problem = true
+			text += fmt.Sprintf(" (rtt over %.3fms)", maxRTT)
+		}
+		for _, tag := range []string{"dst_ip", "class", "proto"} {
+			if v, ok := res.Tags[tag]; ok {
+				text = tag + "=" + v + " " + text
+			}
+		}
+		parts = append(parts, text)
+	}
+	if len(parts) == 0 {
+		return true, "no response"
+	}
+	return problem, strings.Join(parts, "; ")
+}
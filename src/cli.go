@@ -0,0 +1,235 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+type commandType struct {
+	name  string
+	args  string
+	short string
+	long  string
+	run   func(args []string) int
+}
+
+var (
+	commands []commandType
+	// version, commit and buildDate are set at build time with
+	// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=...".
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+func init() {
+	commands = []commandType{
+		{
+			name:  "run",
+			short: "Run the agent (the default when no command is given)",
+			long: "Runs the scheduler, the reflector or both, as set by role in the config,\n" +
+				"until SIGINT or SIGTERM. SIGHUP reloads the config. Every scalar config key\n" +
+				"can be overridden with a flag or NETCHECK_ environment variable.",
+			run: runAgent,
+		},
+		{
+			name:  "server",
+			short: "Run only the reflector, configured from flags",
+			long:  "Runs the reflector only. No config file is read.",
+			run:   runServer,
+		},
+		{
+			name:  "probe",
+			args:  "host[:port]",
+			short: "Check one reflector and print the result",
+			long:  "Runs one check against the reflector on host and prints the result.\nNo config file or InfluxDB is needed.",
+			run:   runProbe,
+		},
+		{
+			name:  "check",
+			short: "Check all or some sites once and report",
+			long: "Runs one cycle against the configured sites and prints a summary. Exits 1\n" +
+				"when a site gave no result or is over -max-rtt or -max-loss, 2 on bad usage.\n" +
+				"Nothing is written to InfluxDB. Config flags are accepted as for run.",
+			run: runCheck,
+		},
+		{
+			name:  "bench",
+			args:  "host[:port]",
+			short: "Load a reflector at a high probe rate and report",
+			long: "Sends probes to the reflector on host at -rate packets per second for\n" +
+				"-duration and reports the send and reply rates achieved, loss, RTT, how late\n" +
+				"packets left against their schedule and the CPU used, to size reflector\n" +
+				"hosts and check kernel tuning. No config file or InfluxDB is needed.",
+			run: runBench,
+		},
+		{
+			name:  "simulate",
+			short: "Check a reflector over a simulated path, in process",
+			long: "Runs the reflector and one check of it over loopback, delaying, dropping and\n" +
+				"reordering the replies as given, and prints the results. Losses and reordering\n" +
+				"repeat for the same -seed. With -expect-rtt or -expect-loss it exits 1 when\n" +
+				"the results differ, for regression tests of the measurements.",
+			run: runSimulate,
+		},
+		{
+			name:  "gen-dashboard",
+			short: "Print a Grafana dashboard for the configured sites",
+			long: "Prints a Grafana dashboard, in JSON ready to import, with RTT and jitter\n" +
+				"panels for every configured site. Queries use the configured bucket,\n" +
+				"measurement and tag names. Config flags are accepted as for run.",
+			run: runGenDashboard,
+		},
+		{
+			name:  "report",
+			short: "Print a site by site matrix of RTT and loss",
+			long: "Prints a table of the average RTT and loss from every measuring site to every\n" +
+				"measured one, from the local agent's history since -from through its admin\n" +
+				"API, or from one check of the configured sites with -run. Meant for pasting\n" +
+				"into incident channels.",
+			run: runReport,
+		},
+		{
+			name:  "topology",
+			args:  "agent-url...",
+			short: "Print the measured mesh as a Graphviz or JSON graph",
+			long: "Fetches /api/topology from the admin API of every agent given (for example\n" +
+				"http://netcheck-1:8080) and prints the merged graph, with the latest RTT,\n" +
+				"jitter and state of every measured path.",
+			run: runTopology,
+		},
+		{
+			name:  "status",
+			args:  "[region/site...]",
+			short: "Show the last known state of every or some paths",
+			long: "Asks the local agent's admin API for the state of its paths: OK, DEGRADED\n" +
+				"(over thresholds), DOWN, PAUSED or UNKNOWN, with the latest results. Exits 0\n" +
+				"when every path shown is OK, 1 otherwise, 2 on errors.",
+			run: runStatus,
+		},
+		{
+			name:  "pause",
+			args:  "region/site...",
+			short: "Stop checking sites until resumed",
+			long: "Pauses the given sites in the local agent through its admin API, for\n" +
+				"maintenance. The pause lasts until \"netcheck resume\" or a restart.",
+			run: siteActionCommand("pause"),
+		},
+		{
+			name:  "resume",
+			args:  "region/site...",
+			short: "Check paused sites again",
+			run:   siteActionCommand("resume"),
+		},
+		{
+			name:  "validate",
+			short: "Check a config file and exit",
+			long: "Parses the config, checks required fields and resolves every remote address.\n" +
+				"Exits non-zero when a problem is found.",
+			run: runValidate,
+		},
+		{
+			name:  "config",
+			args:  "show",
+			short: "Print the effective config",
+			long: "Prints the config as the agent would run it, after includes, environment\n" +
+				"references and flag overrides, leaving out keys at their zero value. With\n" +
+				"-redacted credentials and URL passwords are replaced, for sharing; references\n" +
+				"such as env:NAME are kept.",
+			run: runConfig,
+		},
+		{
+			name:  "healthcheck",
+			short: "Exit 0 when the local agent is healthy, 1 otherwise",
+			long:  "Queries the agent's /healthz (or /readyz) endpoint, for container HEALTHCHECK use.",
+			run:   runHealthcheck,
+		},
+		{
+			name:  "service",
+			args:  "install [run flags] | uninstall | start | stop",
+			short: "Install or control the Windows service",
+			run:   runService,
+		},
+		{
+			name:  "version",
+			short: "Print the version",
+			run:   runVersion,
+		},
+		{
+			name:  "help",
+			args:  "[command]",
+			short: "Show help for a command",
+			run:   runHelp,
+		},
+	}
+}
+
+func findCommand(name string) *commandType {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// commandUsage returns the usage function for a command's flag set.
+func commandUsage(name string, fs *flag.FlagSet) func() {
+	return func() {
+		cmd := findCommand(name)
+		out := fs.Output()
+		fmt.Fprintf(out, "%s\n\n", strings.TrimSpace("Usage: netcheck "+cmd.name+" [flags] "+cmd.args))
+		if cmd.long != "" {
+			fmt.Fprintf(out, "%s\n\n", cmd.long)
+		}
+		fs.PrintDefaults()
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: netcheck <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-14s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(out, "\nRun \"netcheck help <command>\" for the flags of a command.\n")
+}
+
+func runHelp(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return 0
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(stderr, "unknown command %s\n", args[0])
+		return 2
+	}
+	return cmd.run([]string{"-h"})
+}
+
+func runVersion(args []string) int {
+	fmt.Printf("netcheck %s (commit %s, built %s, %s)\n", version, commit, buildDate, runtime.Version())
+	return 0
+}
+
+func main() {
+	args := os.Args[1:]
+	if ok, code := runAsService(args); ok {
+		os.Exit(code)
+	}
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(stderr, "unknown command %s\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(args))
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the time source of the scheduler and of the pacing of probes:
+// periods, cron minutes, gaps between probes and the timestamps of
+// results. Round trips are always measured in real time, they are what
+// the network took.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is the part of time.Timer the agent uses.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker is the part of time.Ticker the agent uses.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// clock is the agent's Clock, real time unless replaced for a simulation
+// or a test before anything is started.
+var clock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                  { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// scaledClock runs speed times faster than real time from its start, for
+// replaying many periods in a short while. Its timers and tickers fire
+// after the scaled down real duration.
+type scaledClock struct {
+	start     time.Time
+	realStart time.Time
+	speed     float64
+}
+
+func newScaledClock(speed float64) scaledClock {
+	now := time.Now()
+	return scaledClock{start: now, realStart: now, speed: speed}
+}
+
+func (c scaledClock) Now() time.Time {
+	return c.start.Add(time.Duration(float64(time.Since(c.realStart)) * c.speed))
+}
+
+func (c scaledClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c scaledClock) real(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / c.speed)
+}
+
+func (c scaledClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(c.real(d))}
+}
+
+func (c scaledClock) NewTicker(d time.Duration) Ticker {
+	return scaledTicker{realTicker{time.NewTicker(c.real(d))}, c}
+}
+
+type scaledTicker struct {
+	realTicker
+	clock scaledClock
+}
+
+func (t scaledTicker) Reset(d time.Duration) {
+	t.realTicker.Reset(t.clock.real(d))
+}
+
+// fakeClock only moves when Advance is called, firing the timers and
+// tickers that come due on the way, so timing logic can be stepped
+// through deterministically in tests.
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter is a timer, or a ticker when period is set.
+type fakeWaiter struct {
+	clock  *fakeClock
+	c      chan time.Time
+	due    time.Time
+	period time.Duration
+	active bool
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	return fakeTimer{c.add(d, 0)}
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{c.add(d, d)}
+}
+
+func (c *fakeClock) add(d time.Duration, period time.Duration) *fakeWaiter {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	w := &fakeWaiter{clock: c, c: make(chan time.Time, 1), due: c.now.Add(d), period: period, active: true}
+	c.waiters = append(c.waiters, w)
+	return w
+}
+
+// Advance moves the clock forward by d. Like their real counterparts the
+// channels hold one tick; ticks a slow reader misses are dropped.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	end := c.now.Add(d)
+	for {
+		sort.Slice(c.waiters, func(i, j int) bool { return c.waiters[i].due.Before(c.waiters[j].due) })
+		if len(c.waiters) == 0 || c.waiters[0].due.After(end) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.due
+		select {
+		case w.c <- c.now:
+		default:
+		}
+		if w.period > 0 {
+			w.due = w.due.Add(w.period)
+		} else {
+			w.active = false
+			c.waiters = c.waiters[1:]
+		}
+	}
+	c.now = end
+}
+
+func (c *fakeClock) remove(w *fakeWaiter) {
+	for i, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+func (w *fakeWaiter) C() <-chan time.Time { return w.c }
+
+func (w *fakeWaiter) stop() bool {
+	w.clock.lock.Lock()
+	defer w.clock.lock.Unlock()
+	active := w.active
+	w.active = false
+	w.clock.remove(w)
+	return active
+}
+
+func (w *fakeWaiter) reset(d time.Duration) {
+	w.clock.lock.Lock()
+	defer w.clock.lock.Unlock()
+	w.clock.remove(w)
+	w.due = w.clock.now.Add(d)
+	w.period = d
+	w.active = true
+	w.clock.waiters = append(w.clock.waiters, w)
+}
+
+type fakeTimer struct{ *fakeWaiter }
+
+func (t fakeTimer) Stop() bool { return t.stop() }
+
+type fakeTicker struct{ *fakeWaiter }
+
+func (t fakeTicker) Stop()                 { t.stop() }
+func (t fakeTicker) Reset(d time.Duration) { t.reset(d) }
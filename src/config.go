@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+type SiteType struct {
+	Address string            `yaml:"address"`
+	Region  string            `yaml:"region"`
+	Site    string            `yaml:"site"`
+	Tags    map[string]string `yaml:"tags"`
+	Enabled *bool             `yaml:"enabled"`
+
+	AllAddresses  bool       `yaml:"allAddresses"`
+	Family        string     `yaml:"family"`
+	SourceAddress string     `yaml:"sourceAddress"`
+	Interface     string     `yaml:"interface"`
+	Netns         string     `yaml:"netns"`
+	DSCP          uint       `yaml:"dscp"`
+	TTL           uint       `yaml:"ttl"`
+	DontFragment  bool       `yaml:"dontFragment"`
+	FragTest      uint       `yaml:"fragTest"`
+	Burst         uint       `yaml:"burst"`
+	OWD           bool       `yaml:"owd"`
+	Schedule      string     `yaml:"schedule"`
+	Priority      int        `yaml:"priority"`
+	Keepalive     uint       `yaml:"keepalive"`
+	Stream        StreamType `yaml:"stream"`
+	RequestSize   uint       `yaml:"requestSize"`
+	ReplySize     uint       `yaml:"replySize"`
+	ECN           string     `yaml:"ecn"`
+	Traceroute    uint       `yaml:"traceroute"`
+	Protocols     []string   `yaml:"protocols"`
+	CheckTimeout  uint       `yaml:"checkTimeout"`
+	Flows         uint       `yaml:"flows"`
+	FlowPort      uint       `yaml:"flowPort"`
+	FlowLabels    uint       `yaml:"flowLabels"`
+	FlowLabel     uint       `yaml:"flowLabel"`
+	InfluxOrg     string     `yaml:"influxOrg"`
+	InfluxBucket  string     `yaml:"influxBucket"`
+	Type          string     `yaml:"type"`
+	Port          uint       `yaml:"port"`
+	Ports         []uint     `yaml:"ports"`
+	Proxy         string     `yaml:"proxy"`
+	URL           string     `yaml:"url"`
+	Count         uint       `yaml:"count"`
+	Debug         bool       `yaml:"debug"`
+	LogSample     uint       `yaml:"logSample"`
+
+	Thresholds ThresholdsType  `yaml:"thresholds"`
+	Classes    map[string]uint `yaml:"classes"`
+
+	// cycle is the ID of the measurement round the site is checked in.
+	cycle string
+}
+
+// ThresholdsType holds the highest acceptable average RTT in milliseconds
+// and loss in percent of a site; zero means no limit.
+type ThresholdsType struct {
+	RTT  float64 `yaml:"rtt"`
+	Loss float64 `yaml:"loss"`
+}
+
+type ConfigType struct {
+	Period          uint              `yaml:"period"`
+	LocalSite       SiteType          `yaml:"localSite"`
+	RemoteSites     []SiteType        `yaml:"remoteSites"`
+	InfluxURL       string            `yaml:"influxUrl"`
+	Port            uint              `yaml:"port"`
+	InfluxBucket    string            `yaml:"influxBucket"`
+	InfluxOrg       string            `yaml:"influxOrg"`
+	InfluxToken     string            `yaml:"influxToken"`
+	Vault           *VaultType        `yaml:"vault"`
+	ProbeKey        string            `yaml:"probeKey"`
+	InfluxWrite     InfluxWriteType   `yaml:"influxWrite"`
+	InfluxRoutes    []InfluxRouteType `yaml:"influxRoutes"`
+	TagLimits       TagLimitsType     `yaml:"tagLimits"`
+	RemoteSitesFile string            `yaml:"remoteSitesFile"`
+	RemoteSitesURL  string            `yaml:"remoteSitesUrl"`
+	WatchConfig     bool              `yaml:"watchConfig"`
+	Tags            map[string]string `yaml:"tags"`
+	TagHostname     bool              `yaml:"tagHostname"`
+	TagVersion      bool              `yaml:"tagVersion"`
+	TagSourceIP     bool              `yaml:"tagSourceIP"`
+	TagPTR          bool              `yaml:"tagPTR"`
+	TagResolvedIP   bool              `yaml:"tagResolvedIP"`
+	ResolveMode     string            `yaml:"resolveMode"`
+	AllAddresses    bool              `yaml:"allAddresses"`
+	Family          string            `yaml:"family"`
+	SourceAddress   string            `yaml:"sourceAddress"`
+	Interface       string            `yaml:"interface"`
+	DSCP            uint              `yaml:"dscp"`
+	TTL             uint              `yaml:"ttl"`
+	DontFragment    bool              `yaml:"dontFragment"`
+	SocketBuffers   SocketBuffersType `yaml:"socketBuffers"`
+	Proxy           string            `yaml:"proxy"`
+	TCPReflector    bool              `yaml:"tcpReflector"`
+	ListenPort      uint              `yaml:"listenPort"`
+	ListenPorts     []uint            `yaml:"listenPorts"`
+	Listen          []string          `yaml:"listen"`
+	Role            string            `yaml:"role"`
+	Admin           AdminType         `yaml:"admin"`
+	GRPC            GRPCType          `yaml:"grpc"`
+	Discovery       DiscoveryType     `yaml:"discovery"`
+	User            string            `yaml:"user"`
+	Group           string            `yaml:"group"`
+	GeoIP           GeoIPType         `yaml:"geoip"`
+	ASN             ASNType           `yaml:"asn"`
+	Grafana         GrafanaType       `yaml:"grafana"`
+	History         HistoryType       `yaml:"history"`
+	LogSample       uint              `yaml:"logSample"`
+	LogRate         uint              `yaml:"logRate"`
+	LogPayloads     bool              `yaml:"logPayloads"`
+	SelfTelemetry   bool              `yaml:"selfTelemetry"`
+	HostTiming      bool              `yaml:"hostTiming"`
+	EBPF            EBPFType          `yaml:"ebpf"`
+	TimeSource      TimeSourceType    `yaml:"timeSource"`
+	Rollup          RollupType        `yaml:"rollup"`
+	ProbeTimeout    ProbeTimeoutType  `yaml:"probeTimeout"`
+	PathStates      PathStatesType    `yaml:"pathStates"`
+	GNMI            GNMIType          `yaml:"gnmi"`
+	IPFIX           IPFIXType         `yaml:"ipfix"`
+	Redis           RedisType         `yaml:"redis"`
+	Adaptive        AdaptiveType      `yaml:"adaptive"`
+	STUN            STUNType          `yaml:"stun"`
+	FirstHop        FirstHopType      `yaml:"firstHop"`
+	Traceroute      uint              `yaml:"traceroute"`
+	CheckTimeout    uint              `yaml:"checkTimeout"`
+	Summaries       []SummaryType     `yaml:"summaries"`
+	Capture         CaptureType       `yaml:"capture"`
+
+	files []string
+	// lines maps YAML paths such as remoteSites[2].port to their line
+	// in the config file, see configLines.
+	lines map[string]int
+}
+
+// Defaults of the keys that are not set in the config.
+const (
+	defaultPeriod = 60
+	defaultPort   = 9999
+)
+
+// key identifies a remote site in the admin API and result cache.
+func (s SiteType) key() string {
+	return s.Region + "/" + s.Site
+}
+
+// enabled reports whether the site is checked; sites are unless enabled
+// is false.
+func (s SiteType) enabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// role is what this host does: "client" probes remote sites, "server"
+// only reflects, "both" (the default) does both.
+func (c ConfigType) role() string {
+	if c.Role == "" {
+		return "both"
+	}
+	return c.Role
+}
+
+// protocols are the probe types run against the site each cycle:
+// protocols when given, else its type, UDP by default.
+func (s SiteType) protocols() []string {
+	if len(s.Protocols) > 0 {
+		return s.Protocols
+	}
+	if s.Type != "" {
+		return []string{s.Type}
+	}
+	return []string{"udp"}
+}
+
+// checkTimeout is how long one check of site may take: the site's
+// checkTimeout, else the global one. Zero means no limit.
+func (c ConfigType) checkTimeout(site SiteType) time.Duration {
+	if site.CheckTimeout > 0 {
+		return time.Duration(site.CheckTimeout) * time.Second
+	}
+	return time.Duration(c.CheckTimeout) * time.Second
+}
+
+// probeCount is the number of probes per check, 10 unless count is set.
+func (s SiteType) probeCount() int {
+	if s.Count > 0 {
+		return int(s.Count)
+	}
+	return 10
+}
+
+// listenPort is the reflector's port: listenPort when set, else port,
+// which is also the default destination port of probes.
+func (c ConfigType) listenPort() uint {
+	if c.ListenPort != 0 {
+		return c.ListenPort
+	}
+	return c.Port
+}
+
+// loadConfig reads the config file, if any, and applies flag and
+// environment overrides on top. An empty path means flags and environment
+// only.
+func loadConfig(path string) (ConfigType, error) {
+	// Keys left out keep these defaults; explicit zeros are reported
+	// by validateConfig.
+	cfg := ConfigType{RemoteSites: make([]SiteType, 0), Period: defaultPeriod, Port: defaultPort}
+	if path == "" {
+		return cfg, applyOverrides(&cfg)
+	}
+	data, err := readConfigFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.files = []string{path}
+	var tree map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return cfg, fmt.Errorf("error parsing file %s", err)
+	}
+	if _, ok := tree["include"]; ok {
+		tree, err = resolveIncludes(path, tree, map[string]bool{path: true}, &cfg.files)
+		if err != nil {
+			return cfg, err
+		}
+		if data, err = yaml.Marshal(tree); err != nil {
+			return cfg, err
+		}
+	} else if fileFormat(path) == "yaml" {
+		// Lines are only known when the keys come from this one
+		// YAML file.
+		cfg.lines = configLines(data)
+	}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing file %s", err)
+	}
+	if cfg.RemoteSitesFile != "" {
+		sites, err := loadSites(cfg.RemoteSitesFile)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.RemoteSites = append(cfg.RemoteSites, sites...)
+		cfg.files = append(cfg.files, cfg.RemoteSitesFile)
+	}
+	err = applyOverrides(&cfg)
+	registerConfigSecrets(cfg)
+	return cfg, err
+}
+
+// readConfigFile returns the file as YAML with environment references
+// expanded.
+func readConfigFile(path string) ([]byte, error) {
+	var data []byte
+	var err error
+	if isEtcdURL(path) {
+		data, err = readEtcdConfig(path)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %s", err)
+	}
+	data, err = expandEnv(data)
+	if err != nil {
+		return nil, err
+	}
+	return toYAML(path, data)
+}
+
+// resolveIncludes merges the fragments named by the include key (a path or
+// list of paths or globs, relative to the including file) underneath tree.
+// Keys in the including file win; lists such as remoteSites are
+// concatenated.
+func resolveIncludes(path string, tree map[interface{}]interface{}, seen map[string]bool, files *[]string) (map[interface{}]interface{}, error) {
+	var patterns []string
+	switch inc := tree["include"].(type) {
+	case string:
+		patterns = []string{inc}
+	case []interface{}:
+		for _, p := range inc {
+			patterns = append(patterns, fmt.Sprint(p))
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("%s: include must be a path or a list of paths", path)
+	}
+	delete(tree, "include")
+	merged := make(map[interface{}]interface{})
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include %s: %s", path, pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: include %s matches no files", path, pattern)
+		}
+		for _, match := range matches {
+			if seen[match] {
+				return nil, fmt.Errorf("%s: include cycle through %s", path, match)
+			}
+			data, err := readConfigFile(match)
+			if err != nil {
+				return nil, err
+			}
+			var fragment map[interface{}]interface{}
+			if err := yaml.Unmarshal(data, &fragment); err != nil {
+				return nil, fmt.Errorf("error parsing file %s: %s", match, err)
+			}
+			*files = append(*files, match)
+			seen[match] = true
+			fragment, err = resolveIncludes(match, fragment, seen, files)
+			delete(seen, match)
+			if err != nil {
+				return nil, err
+			}
+			merged = mergeTrees(merged, fragment)
+		}
+	}
+	return mergeTrees(merged, tree), nil
+}
+
+func mergeTrees(base map[interface{}]interface{}, over map[interface{}]interface{}) map[interface{}]interface{} {
+	if base == nil {
+		base = make(map[interface{}]interface{})
+	}
+	for k, v := range over {
+		switch ov := v.(type) {
+		case map[interface{}]interface{}:
+			if bv, ok := base[k].(map[interface{}]interface{}); ok {
+				base[k] = mergeTrees(bv, ov)
+				continue
+			}
+		case []interface{}:
+			if bv, ok := base[k].([]interface{}); ok {
+				base[k] = append(bv, ov...)
+				continue
+			}
+		}
+		base[k] = v
+	}
+	return base
+}
+
+// loadSites reads a standalone YAML list of remote sites.
+func loadSites(path string) ([]SiteType, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open site list: %s", err)
+	}
+	return parseSites(path, data)
+}
+
+// toYAML converts TOML and JSON configs to YAML so a single set of struct
+// tags serves all formats. The format comes from -config-format or, when
+// that is "auto", from the file extension.
+func toYAML(path string, data []byte) ([]byte, error) {
+	format := fileFormat(path)
+	var tree interface{}
+	switch format {
+	case "toml":
+		if _, err := toml.Decode(string(data), &tree); err != nil {
+			return nil, fmt.Errorf("error parsing TOML file %s", err)
+		}
+	case "json":
+		if err := json.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("error parsing JSON file %s", err)
+		}
+	case "yaml":
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unsupported config format %s", format)
+	}
+	return yaml.Marshal(tree)
+}
+
+// fileFormat returns the format of the config file at path: toml, json,
+// yaml or the unsupported extension.
+func fileFormat(path string) string {
+	format := configFormat
+	if format == "" || format == "auto" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+	if format == "yml" || format == "" {
+		format = "yaml"
+	}
+	return format
+}
+
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${VAR} and ${VAR:-default} references with values from
+// the environment. A bare $ is left alone so literal tokens survive.
+func expandEnv(data []byte) ([]byte, error) {
+	var missing []string
+	out := envRef.ReplaceAllFunc(data, func(ref []byte) []byte {
+		m := envRef.FindSubmatch(ref)
+		if val, ok := os.LookupEnv(string(m[1])); ok {
+			return []byte(val)
+		}
+		if len(m[2]) > 0 {
+			return m[3]
+		}
+		missing = append(missing, string(m[1]))
+		return ref
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("undefined environment variables: %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
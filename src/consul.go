@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ConsulType discovers sites from the healthy instances of a Consul
+// catalog service. Region and site come from "key=value" service tags
+// named by regionTag and siteTag, falling back to the datacenter and the
+// node name.
+type ConsulType struct {
+	Address   string   `yaml:"address"`
+	Token     string   `yaml:"token"`
+	Service   string   `yaml:"service"`
+	Tags      []string `yaml:"tags"`
+	RegionTag string   `yaml:"regionTag"`
+	SiteTag   string   `yaml:"siteTag"`
+
+	client *http.Client
+}
+
+type consulEntry struct {
+	Node struct {
+		Node       string `json:"Node"`
+		Address    string `json:"Address"`
+		Datacenter string `json:"Datacenter"`
+	} `json:"Node"`
+	Service struct {
+		Address string   `json:"Address"`
+		Port    uint     `json:"Port"`
+		Tags    []string `json:"Tags"`
+	} `json:"Service"`
+}
+
+func (c *ConsulType) name() string {
+	return "consul"
+}
+
+func (c *ConsulType) discover() ([]SiteType, error) {
+	address := c.Address
+	if address == "" {
+		address = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if address == "" {
+		address = "127.0.0.1:8500"
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	if c.Service == "" {
+		return nil, fmt.Errorf("discovery.consul.service is required")
+	}
+	token, err := resolveSecret(c.Token)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	query := url.Values{"passing": {"1"}}
+	for _, tag := range c.Tags {
+		query.Add("tag", tag)
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/health/service/%s?%s", strings.TrimRight(address, "/"), url.PathEscape(c.Service), query.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	if c.client == nil {
+		c.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul returned %s", resp.Status)
+	}
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decoding consul response: %s", err)
+	}
+	sites := make([]SiteType, 0, len(entries))
+	for _, e := range entries {
+		site := SiteType{
+			Address: e.Service.Address,
+			Region:  e.Node.Datacenter,
+			Site:    e.Node.Node,
+			Port:    e.Service.Port,
+		}
+		if site.Address == "" {
+			site.Address = e.Node.Address
+		}
+		for _, tag := range e.Service.Tags {
+			parts := strings.SplitN(tag, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			switch parts[0] {
+			case c.RegionTag:
+				site.Region = parts[1]
+			case c.SiteTag:
+				site.Site = parts[1]
+			}
+		}
+		sites = append(sites, site)
+	}
+	return sites, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// sleepCtx waits for d and reports false when ctx ended first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
+
+// closeOnDone closes c when ctx ends, which unblocks reads on it. The
+// returned function stops watching and is called when c is done with.
+func closeOnDone(ctx context.Context, c io.Closer) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
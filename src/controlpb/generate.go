@@ -0,0 +1,4 @@
+// Package controlpb holds the generated code for the agent control service.
+package controlpb
+
+//go:generate protoc --go_out=paths=source_relative:. --go-grpc_out=paths=source_relative:. control.proto
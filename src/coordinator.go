@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// CoordinatorType registers the agent with a central coordinator, a
+// netcheck whose admin API has coordinator enabled, which answers with the
+// sites to probe.
+type CoordinatorType struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+}
+
+// registration is what an agent tells the coordinator about itself.
+type registration struct {
+	Hostname     string   `json:"hostname"`
+	Region       string   `json:"region"`
+	Site         string   `json:"site"`
+	Address      string   `json:"address"`
+	Port         uint     `json:"port,omitempty"`
+	Role         string   `json:"role"`
+	Version      string   `json:"version"`
+	Capabilities []string `json:"capabilities"`
+	Interval     uint     `json:"interval"`
+}
+
+// newRegistration describes this agent from the current config.
+func newRegistration() registration {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	capabilities := []string{"udp"}
+	if configData.TCPReflector {
+		capabilities = append(capabilities, "tcp")
+	}
+	interval := configData.Discovery.Interval
+	if interval == 0 {
+		interval = 60
+	}
+	return registration{
+		Hostname:     hostname,
+		Region:       configData.LocalSite.Region,
+		Site:         configData.LocalSite.Site,
+		Address:      configData.LocalSite.Address,
+		Port:         configData.listenPort(),
+		Role:         configData.role(),
+		Version:      version,
+		Capabilities: capabilities,
+		Interval:     interval,
+	}
+}
+
+func (c *CoordinatorType) name() string {
+	return "coordinator"
+}
+
+// discover registers (again) and returns the assigned sites. Registering
+// every interval also keeps the agent from expiring on the coordinator.
+func (c *CoordinatorType) discover() ([]SiteType, error) {
+	token, err := resolveSecret(c.Token)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(newRegistration())
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(c.URL, "/")+"/api/register", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registering with %s: %s: %s", c.URL, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return parseSites(c.URL, data)
+}
+
+// runRegistration keeps a reflector-only agent, which discovers nothing,
+// registered so clients are assigned to probe it.
+func runRegistration(c *CoordinatorType, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(newRegistration().Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		if _, err := c.discover(); err != nil {
+			log.WithFields(log.Fields{"Coordinator": c.URL}).Error(fmt.Sprintf("Registration failed: %s", err))
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type registeredAgent struct {
+	registration
+	seen time.Time
+}
+
+// maxRegisterInterval caps the registration interval, in seconds, an
+// agent claims: a day.
+const maxRegisterInterval = 24 * 60 * 60
+
+var (
+	registry     = make(map[string]registeredAgent)
+	registryLock sync.Mutex
+)
+
+// handleRegister records an agent and answers with its assignment in the
+// remoteSitesFile format: the coordinator's own remote sites plus every
+// other registered agent with a reflector. Agents that stopped
+// registering for three of their intervals are dropped. startAdmin only
+// serves it behind the admin token.
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var reg registration
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&reg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reg.Region == "" || reg.Site == "" {
+		http.Error(w, "region and site are required", http.StatusBadRequest)
+		return
+	}
+	if reg.Address == "" {
+		reg.Address, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	switch {
+	case reg.Interval == 0:
+		reg.Interval = 60
+	case reg.Interval > maxRegisterInterval:
+		// Its expiry would overflow.
+		reg.Interval = maxRegisterInterval
+	}
+	key := SiteType{Region: reg.Region, Site: reg.Site}.key()
+	now := time.Now()
+	registryLock.Lock()
+	if _, ok := registry[key]; !ok {
+		log.WithFields(log.Fields{"Region": reg.Region, "Site": reg.Site, "Address": reg.Address}).Info("Agent registered")
+	}
+	registry[key] = registeredAgent{reg, now}
+	var peers []SiteType
+	for k, agent := range registry {
+		if now.Sub(agent.seen) > 3*time.Duration(agent.Interval)*time.Second {
+			log.WithFields(log.Fields{"Region": agent.Region, "Site": agent.Site}).Info("Agent registration expired")
+			delete(registry, k)
+			continue
+		}
+		if k == key || agent.Role == "client" {
+			continue
+		}
+		peers = append(peers, SiteType{Region: agent.Region, Site: agent.Site, Address: agent.Address, Port: agent.Port})
+	}
+	registryLock.Unlock()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].key() < peers[j].key() })
+	configLock.RLock()
+	sites := append([]SiteType{}, configData.RemoteSites...)
+	configLock.RUnlock()
+	kept := sites[:0]
+	for _, site := range sites {
+		if site.key() != key {
+			kept = append(kept, site)
+		}
+	}
+	data, err := yaml.Marshal(append(kept, peers...))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(data)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// runCron checks the sites that have a schedule, a standard five field
+// cron expression in local time, at each minute it matches. Such sites
+// are left out of the periodic cycles.
+func runCron(stop <-chan struct{}) {
+	for {
+		now := clock.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		timer := clock.NewTimer(next.Sub(now))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+		for _, site := range sched.snapshot() {
+			if site.Schedule == "" {
+				continue
+			}
+			schedule, err := cron.ParseStandard(site.Schedule)
+			if err != nil {
+				siteLog(site).Error(fmt.Sprintf("Invalid schedule %s: %s", site.Schedule, err))
+				continue
+			}
+			if schedule.Next(next.Add(-time.Second)).Equal(next) {
+				siteLog(site).Debug("Scheduled check")
+				if !sched.check(site) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+)
+
+type matrixCell struct {
+	Avg     interface{} `json:"avg"`
+	Jitter  interface{} `json:"jitter"`
+	Loss    interface{} `json:"loss,omitempty"`
+	Time    int64       `json:"time"`
+	History []float64   `json:"history"`
+}
+
+type matrixType struct {
+	Rows  []string               `json:"rows"`
+	Cols  []string               `json:"cols"`
+	Cells map[string]*matrixCell `json:"cells"`
+}
+
+// handleMatrix returns the latest rtt result and the rtt history of every
+// local/remote site pair, keyed "row|col".
+func handleMatrix(w http.ResponseWriter, r *http.Request) {
+	configLock.RLock()
+	local := configData.LocalSite.key()
+	configLock.RUnlock()
+	matrix := matrixType{Rows: []string{local}, Cells: make(map[string]*matrixCell)}
+	rows := map[string]bool{local: true}
+	sites := sched.list()
+	for _, site := range sites {
+		matrix.Cols = append(matrix.Cols, site.key())
+		for _, res := range siteHistory(site.key(), "rtt") {
+			row := res.Tags["region1"] + "/" + res.Tags["site1"]
+			if !rows[row] {
+				rows[row] = true
+				matrix.Rows = append(matrix.Rows, row)
+			}
+			cell := matrix.Cells[row+"|"+site.key()]
+			if cell == nil {
+				cell = &matrixCell{}
+				matrix.Cells[row+"|"+site.key()] = cell
+			}
+			cell.Avg = res.Fields["avg"]
+			cell.Jitter = res.Fields["jitter"]
+			cell.Loss = res.Fields["loss"]
+			cell.Time = res.Time.Unix()
+			if avg, ok := res.Fields["avg"].(int64); ok {
+				cell.History = append(cell.History, float64(avg))
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, matrix)
+}
+
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardHTML))
+}
+
+// dashboardHTML is the whole dashboard: it polls /api/matrix and draws a
+// table with the latest RTT (microseconds), jitter, loss and a sparkline.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>netcheck</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: center; font-size: 13px; }
+td.ok { background: #e6f4e6; } td.warn { background: #fff3cd; } td.bad { background: #f8d7da; }
+td.stale { color: #999; }
+svg { display: block; margin: 2px auto 0; }
+</style>
+</head>
+<body>
+<h1>netcheck</h1>
+<p id="updated"></p>
+<table id="matrix"></table>
+<script>
+// Site keys and names come from the config and discovery: never markup.
+function esc(s) {
+  return String(s).replace(/[&<>"']/g, function (ch) {
+    return {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[ch];
+  });
+}
+function spark(values) {
+  if (values.length < 2) return '';
+  var w = 100, h = 20, lo = Math.min.apply(null, values), hi = Math.max.apply(null, values);
+  var span = hi - lo || 1;
+  var pts = values.map(function (v, i) {
+    return (i * w / (values.length - 1)).toFixed(1) + ',' + (h - (v - lo) * h / span).toFixed(1);
+  });
+  return '<svg width="' + w + '" height="' + h + '"><polyline fill="none" stroke="#36c" points="' + pts.join(' ') + '"/></svg>';
+}
+function cell(c, now) {
+  if (!c) return '<td>-</td>';
+  var cls = c.avg > 100000 || c.loss > 5 ? 'bad' : c.avg > 20000 || c.loss > 0 ? 'warn' : 'ok';
+  if (now - c.time > 600) cls += ' stale';
+  var text = (Number(c.avg) / 1000).toFixed(1) + ' ms &plusmn;' + (Number(c.jitter) / 1000).toFixed(1);
+  if (c.loss !== undefined) text += '<br>' + esc(c.loss) + '% loss';
+  return '<td class="' + cls + '">' + text + spark(c.history) + '</td>';
+}
+function render(m) {
+  var now = Date.now() / 1000;
+  var html = '<tr><th>from \\ to</th>' + m.cols.map(function (c) { return '<th>' + esc(c) + '</th>'; }).join('') + '</tr>';
+  m.rows.forEach(function (r) {
+    html += '<tr><th>' + esc(r) + '</th>' + m.cols.map(function (c) { return cell(m.cells[r + '|' + c], now); }).join('') + '</tr>';
+  });
+  document.getElementById('matrix').innerHTML = html;
+  document.getElementById('updated').textContent = 'Updated ' + new Date().toLocaleTimeString();
+}
+function refresh() {
+  fetch('api/matrix').then(function (r) { return r.json(); }).then(render).catch(function (e) {
+    document.getElementById('updated').textContent = 'Update failed: ' + e;
+  });
+}
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"expvar"
+	"net/http/pprof"
+	"runtime"
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// registerDebug adds the pprof profiles under /debug/pprof/ and the Go
+// runtime metrics (goroutines and memstats, which include heap and GC
+// figures) as JSON under /debug/vars.
+func registerDebug() {
+	adminMux.HandleFunc("/debug/pprof/", pprof.Index)
+	adminMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	adminMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	adminMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	adminMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	adminMux.Handle("/debug/vars", expvar.Handler())
+}
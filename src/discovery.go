@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// DiscoveryType configures the sources remote sites are discovered from,
+// in addition to the configured remoteSites.
+type DiscoveryType struct {
+	Interval    uint             `yaml:"interval"`
+	Kubernetes  *KubernetesType  `yaml:"kubernetes"`
+	Consul      *ConsulType      `yaml:"consul"`
+	Etcd        *EtcdType        `yaml:"etcd"`
+	SRV         *SRVType         `yaml:"srv"`
+	MDNS        *MDNSType        `yaml:"mdns"`
+	Gossip      *GossipType      `yaml:"gossip"`
+	Coordinator *CoordinatorType `yaml:"coordinator"`
+}
+
+// discoverer is one source of remote sites.
+type discoverer interface {
+	name() string
+	discover() ([]SiteType, error)
+}
+
+// watcher is a source that can tell when its sites changed, so they are
+// picked up without waiting for the interval.
+type watcher interface {
+	watch(changes chan<- struct{}, stop <-chan struct{})
+}
+
+// discoverySources returns every configured source of remote sites
+// besides the remoteSites list itself.
+func (c ConfigType) discoverySources() []discoverer {
+	var sources []discoverer
+	if c.RemoteSitesURL != "" {
+		sources = append(sources, &urlSites{url: c.RemoteSitesURL})
+	}
+	d := c.Discovery
+	if k := d.Kubernetes; k != nil {
+		if k.Service != "" || k.Nodes {
+			sources = append(sources, k)
+		}
+		if k.Targets {
+			sources = append(sources, kubeTargets{k})
+		}
+	}
+	if d.Consul != nil {
+		sources = append(sources, d.Consul)
+	}
+	if d.Etcd != nil {
+		sources = append(sources, d.Etcd)
+	}
+	if d.SRV != nil {
+		sources = append(sources, d.SRV)
+	}
+	if d.MDNS != nil {
+		sources = append(sources, d.MDNS)
+	}
+	if d.Gossip != nil {
+		sources = append(sources, gossipSites{})
+	}
+	if d.Coordinator != nil {
+		sources = append(sources, d.Coordinator)
+	}
+	return sources
+}
+
+// runDiscovery refreshes the discovered sites of every source each
+// interval (60 seconds by default), and as soon as a watching source sees
+// a change, until stop is closed. A failing source keeps its previous
+// sites. The sources are those of the current config, read on each pass,
+// so reloads add and remove them.
+func runDiscovery(stop <-chan struct{}) {
+	var (
+		sources   []discoverer
+		applied   string
+		watchStop chan struct{}
+	)
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	defer func() {
+		if watchStop != nil {
+			close(watchStop)
+		}
+	}()
+	changes := make(chan struct{}, 1)
+	for {
+		configLock.RLock()
+		cfg := ConfigType{RemoteSitesURL: configData.RemoteSitesURL, Discovery: configData.Discovery}
+		configLock.RUnlock()
+		if key := discoveryKey(cfg); key != applied {
+			applied = key
+			if watchStop != nil {
+				close(watchStop)
+			}
+			watchStop = make(chan struct{})
+			previous := sources
+			sources = cfg.discoverySources()
+			forgetSources(previous, sources)
+			interval := time.Duration(cfg.Discovery.Interval) * time.Second
+			if interval == 0 {
+				interval = time.Minute
+			}
+			ticker.Reset(interval)
+			for _, source := range sources {
+				if w, ok := source.(watcher); ok {
+					go w.watch(changes, watchStop)
+				}
+			}
+		}
+		for _, source := range sources {
+			sites, err := source.discover()
+			if err != nil {
+				log.WithFields(log.Fields{"Source": source.name()}).Error(fmt.Sprintf("Discovery failed: %s", err))
+				continue
+			}
+			sites = excludeLocal(sites)
+			log.WithFields(log.Fields{"Source": source.name()}).Debug(fmt.Sprintf("Discovered %d sites", len(sites)))
+			sched.setDiscovered(source.name(), sites)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-changes:
+		}
+	}
+}
+
+// discoveryKey tells discovery settings apart, leaving out the state of
+// the sources.
+func discoveryKey(cfg ConfigType) string {
+	data, _ := yaml.Marshal(cfg.Discovery)
+	return cfg.RemoteSitesURL + "\n" + string(data)
+}
+
+// forgetSources drops the sites of the previous sources no longer
+// configured.
+func forgetSources(previous []discoverer, current []discoverer) {
+	kept := make(map[string]bool)
+	for _, source := range current {
+		kept[source.name()] = true
+	}
+	for _, source := range previous {
+		if !kept[source.name()] {
+			sched.setDiscovered(source.name(), nil)
+		}
+	}
+}
+
+// excludeLocal drops the local site from discovered ones, so an agent
+// does not probe itself.
+func excludeLocal(sites []SiteType) []SiteType {
+	configLock.RLock()
+	local := configData.LocalSite
+	configLock.RUnlock()
+	kept := sites[:0]
+	for _, site := range sites {
+		if site.key() == local.key() || (local.Address != "" && site.Address == local.Address) {
+			continue
+		}
+		kept = append(kept, site)
+	}
+	return kept
+}
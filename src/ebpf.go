@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// EBPFType configures the eBPF mode of hostTiming: programs on the
+// interfaces timestamp probes as they leave at TC egress and replies as
+// they arrive, at TC ingress or XDP, and so tell replies the host lost
+// from those the network did.
+type EBPFType struct {
+	Enabled bool `yaml:"enabled"`
+	// Interfaces are those the programs are attached to: by default
+	// interface when set, else every interface that is up.
+	Interfaces []string `yaml:"interfaces"`
+	// XDP takes the arrival timestamps at XDP, before the kernel builds
+	// the packet, rather than at TC ingress.
+	XDP bool `yaml:"xdp"`
+}
+
+// ebpfInterfaces returns the interfaces the eBPF programs go on.
+func (c ConfigType) ebpfInterfaces() ([]net.Interface, error) {
+	names := c.EBPF.Interfaces
+	if len(names) == 0 && c.Interface != "" {
+		names = []string{c.Interface}
+	}
+	if len(names) == 0 {
+		all, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+		var up []net.Interface
+		for _, iface := range all {
+			if iface.Flags&net.FlagUp != 0 {
+				up = append(up, iface)
+			}
+		}
+		return up, nil
+	}
+	var ifaces []net.Interface
+	for _, name := range names {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: %s", name, err)
+		}
+		ifaces = append(ifaces, *iface)
+	}
+	return ifaces, nil
+}
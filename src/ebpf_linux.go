@@ -0,0 +1,428 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// From linux/bpf.h, newer than x/sys/unix here. TC links need Linux 6.6.
+const (
+	bpfTCXIngress        = 46
+	bpfTCXEgress         = 47
+	bpfFuncMapLookupElem = 1
+	bpfFuncMapUpdateElem = 2
+	bpfFuncKtimeGetNs    = 5
+	tcxNext              = -1
+	xdpPass              = 2
+)
+
+// Offsets of data and data_end in struct __sk_buff and struct xdp_md.
+const (
+	skbData    = 76
+	skbDataEnd = 80
+	xdpData    = 0
+	xdpDataEnd = 4
+)
+
+// stampKey is the key of a timestamp in the stamps map: the local port of
+// the probe socket, whether the packet was leaving (0) or arriving (1),
+// and the last 8 digits of the probe's timestamp, which tell the probes
+// of a socket apart.
+type stampKey struct {
+	Port uint32
+	Dir  uint32
+	Tail [8]byte
+}
+
+// probeTail is the offset in the payload of the part of the timestamp in
+// stampKey.
+const probeTail = 11
+
+var ebpf struct {
+	sync.Mutex
+	// ports and stamps are the map file descriptors, ports 0 when not
+	// loaded. ports holds the local ports of the probe sockets being
+	// watched, stamps the timestamps of their packets.
+	ports, stamps int
+	// links keep the programs attached until the agent exits.
+	links []int
+}
+
+// startEBPF loads the timestamping programs and attaches them to ifaces:
+// one on TC egress, one on TC ingress or, with xdp, XDP. It needs
+// CAP_BPF and CAP_NET_ADMIN, so runs before privileges are dropped.
+func startEBPF(ifaces []net.Interface, xdp bool) error {
+	ports, err := bpfMapCreate(unix.BPF_MAP_TYPE_HASH, 4, 4, 1024)
+	if err != nil {
+		return fmt.Errorf("creating the ports map: %s", err)
+	}
+	stamps, err := bpfMapCreate(unix.BPF_MAP_TYPE_LRU_HASH, uint32(unsafe.Sizeof(stampKey{})), 8, 16384)
+	if err != nil {
+		return fmt.Errorf("creating the stamps map: %s", err)
+	}
+	egress, err := bpfProgLoad(unix.BPF_PROG_TYPE_SCHED_CLS, stampProgram(false, false, ports, stamps))
+	if err != nil {
+		return fmt.Errorf("loading the egress program: %s", err)
+	}
+	ingressType, attach := uint32(unix.BPF_PROG_TYPE_SCHED_CLS), uint32(bpfTCXIngress)
+	if xdp {
+		ingressType, attach = unix.BPF_PROG_TYPE_XDP, unix.BPF_XDP
+	}
+	ingress, err := bpfProgLoad(ingressType, stampProgram(true, xdp, ports, stamps))
+	if err != nil {
+		return fmt.Errorf("loading the ingress program: %s", err)
+	}
+	var links []int
+	for _, iface := range ifaces {
+		link, err := bpfLinkCreate(egress, iface.Index, bpfTCXEgress)
+		if err != nil {
+			return fmt.Errorf("attaching to %s egress: %s", iface.Name, err)
+		}
+		links = append(links, link)
+		if link, err = bpfLinkCreate(ingress, iface.Index, attach); err != nil {
+			return fmt.Errorf("attaching to %s ingress: %s", iface.Name, err)
+		}
+		links = append(links, link)
+	}
+	// The links hold the programs.
+	unix.Close(egress)
+	unix.Close(ingress)
+	ebpf.Lock()
+	ebpf.ports, ebpf.stamps, ebpf.links = ports, stamps, links
+	ebpf.Unlock()
+	return nil
+}
+
+func ebpfLoaded() bool {
+	ebpf.Lock()
+	defer ebpf.Unlock()
+	return ebpf.ports != 0
+}
+
+// ebpfWatch has the programs timestamp the packets of conn until the
+// returned function is called.
+func ebpfWatch(conn *net.UDPConn) func() {
+	ebpf.Lock()
+	ports := ebpf.ports
+	ebpf.Unlock()
+	if ports == 0 {
+		return func() {}
+	}
+	port := localPort(conn)
+	one := uint32(1)
+	bpfMapElem(unix.BPF_MAP_UPDATE_ELEM, ports, unsafe.Pointer(&port), unsafe.Pointer(&one))
+	return func() {
+		bpfMapElem(unix.BPF_MAP_DELETE_ELEM, ports, unsafe.Pointer(&port), nil)
+	}
+}
+
+// ebpfStamps returns when the probe with timestamp ts left and its reply
+// arrived, in nanoseconds of the monotonic clock, 0 for those not seen,
+// and forgets them.
+func ebpfStamps(conn *net.UDPConn, ts string) (uint64, uint64) {
+	ebpf.Lock()
+	stamps := ebpf.stamps
+	ebpf.Unlock()
+	if stamps == 0 || len(ts) < probeTail+8 {
+		return 0, 0
+	}
+	key := stampKey{Port: localPort(conn)}
+	copy(key.Tail[:], ts[probeTail:])
+	var sent, arrived uint64
+	for dir, stamp := range []*uint64{&sent, &arrived} {
+		key.Dir = uint32(dir)
+		if bpfMapElem(unix.BPF_MAP_LOOKUP_ELEM, stamps, unsafe.Pointer(&key), unsafe.Pointer(stamp)) == nil {
+			bpfMapElem(unix.BPF_MAP_DELETE_ELEM, stamps, unsafe.Pointer(&key), nil)
+		}
+	}
+	return sent, arrived
+}
+
+// ebpfLost tells, once reading the reply to the probe with timestamp ts
+// timed out, whether the reply had arrived: lost by the host, not the
+// network.
+func ebpfLost(conn *net.UDPConn, ts string) bool {
+	var now unix.Timespec
+	unix.ClockGettime(unix.CLOCK_MONOTONIC, &now)
+	_, arrived := ebpfStamps(conn, ts)
+	return arrived != 0 && arrived < uint64(now.Nano())
+}
+
+func localPort(conn *net.UDPConn) uint32 {
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return uint32(addr.Port)
+	}
+	return 0
+}
+
+// stampProgram assembles the program that timestamps probe packets. It
+// finds the UDP header behind Ethernet and IPv4 or IPv6 and, when the
+// local port (source leaving, destination arriving) is in the ports map,
+// stores the time under the stampKey of the packet. Packets always go on.
+func stampProgram(ingress bool, xdp bool, ports int, stamps int) []bpfInsn {
+	var a bpfAsm
+	dataOff, endOff, pass := int16(skbData), int16(skbDataEnd), int32(tcxNext)
+	if xdp {
+		dataOff, endOff, pass = xdpData, xdpDataEnd, xdpPass
+	}
+	portOff, dir := int16(0), int32(0)
+	if ingress {
+		portOff, dir = 2, 1
+	}
+	const r0, r1, r2, r3, r4, r5, r6, r7, r10 = 0, 1, 2, 3, 4, 5, 6, 7, 10
+	a.ldx(unix.BPF_W, r2, r1, dataOff)
+	a.ldx(unix.BPF_W, r3, r1, endOff)
+	// Ethernet
+	a.bound(r4, r2, 14, r3, "out")
+	a.ldx(unix.BPF_H, r5, r2, 12)
+	a.be16(r5)
+	a.jeq(r5, 0x0800, "ipv4")
+	a.jne(r5, 0x86dd, "out")
+	a.bound(r4, r2, 14+40, r3, "out")
+	a.ldx(unix.BPF_B, r5, r2, 14+6)
+	a.jne(r5, unix.IPPROTO_UDP, "out")
+	a.addImm(r2, 14+40)
+	a.ja("udp")
+	a.label("ipv4")
+	a.bound(r4, r2, 14+20, r3, "out")
+	a.ldx(unix.BPF_B, r5, r2, 14+9)
+	a.jne(r5, unix.IPPROTO_UDP, "out")
+	a.ldx(unix.BPF_B, r5, r2, 14)
+	a.alu64Imm(unix.BPF_AND, r5, 0x0f)
+	a.alu64Imm(unix.BPF_LSH, r5, 2)
+	a.alu64Reg(unix.BPF_ADD, r2, r5)
+	a.addImm(r2, 14)
+	a.label("udp")
+	a.bound(r4, r2, 8+probeTail+8, r3, "out")
+	a.movReg(r6, r2)
+	a.ldx(unix.BPF_H, r7, r2, portOff)
+	a.be16(r7)
+	a.stx(unix.BPF_W, r10, r7, -4)
+	a.ldMapFD(r1, ports)
+	a.movReg(r2, r10)
+	a.addImm(r2, -4)
+	a.call(bpfFuncMapLookupElem)
+	a.jeq(r0, 0, "out")
+	a.stx(unix.BPF_W, r10, r7, -24)
+	a.st(unix.BPF_W, r10, -20, dir)
+	a.ldx(unix.BPF_DW, r5, r6, 8+probeTail)
+	a.stx(unix.BPF_DW, r10, r5, -16)
+	a.call(bpfFuncKtimeGetNs)
+	a.stx(unix.BPF_DW, r10, r0, -32)
+	a.ldMapFD(r1, stamps)
+	a.movReg(r2, r10)
+	a.addImm(r2, -24)
+	a.movReg(r3, r10)
+	a.addImm(r3, -32)
+	a.movImm(r4, unix.BPF_ANY)
+	a.call(bpfFuncMapUpdateElem)
+	a.label("out")
+	a.movImm(r0, pass)
+	a.exit()
+	return a.assemble()
+}
+
+// bpfInsn is an eBPF instruction, the destination register in the low
+// bits of Regs and the source in the high ones.
+type bpfInsn struct {
+	Code uint8
+	Regs uint8
+	Off  int16
+	Imm  int32
+}
+
+// bpfAsm builds a program, resolving jumps to labels.
+type bpfAsm struct {
+	insns  []bpfInsn
+	labels map[string]int
+	jumps  map[int]string
+}
+
+func (a *bpfAsm) emit(code uint8, dst, src uint8, off int16, imm int32) {
+	a.insns = append(a.insns, bpfInsn{Code: code, Regs: src<<4 | dst, Off: off, Imm: imm})
+}
+
+func (a *bpfAsm) jump(code uint8, dst uint8, imm int32, label string) {
+	if a.jumps == nil {
+		a.jumps = make(map[int]string)
+	}
+	a.jumps[len(a.insns)] = label
+	a.emit(code, dst, 0, 0, imm)
+}
+
+func (a *bpfAsm) label(name string) {
+	if a.labels == nil {
+		a.labels = make(map[string]int)
+	}
+	a.labels[name] = len(a.insns)
+}
+
+func (a *bpfAsm) ldx(size uint8, dst, src uint8, off int16) {
+	a.emit(unix.BPF_LDX|unix.BPF_MEM|size, dst, src, off, 0)
+}
+
+func (a *bpfAsm) stx(size uint8, dst, src uint8, off int16) {
+	a.emit(unix.BPF_STX|unix.BPF_MEM|size, dst, src, off, 0)
+}
+
+func (a *bpfAsm) st(size uint8, dst uint8, off int16, imm int32) {
+	a.emit(unix.BPF_ST|unix.BPF_MEM|size, dst, 0, off, imm)
+}
+
+func (a *bpfAsm) movReg(dst, src uint8) {
+	a.emit(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_X, dst, src, 0, 0)
+}
+
+func (a *bpfAsm) movImm(dst uint8, imm int32) {
+	a.emit(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_K, dst, 0, 0, imm)
+}
+
+func (a *bpfAsm) addImm(dst uint8, imm int32) {
+	a.alu64Imm(unix.BPF_ADD, dst, imm)
+}
+
+func (a *bpfAsm) alu64Imm(op uint8, dst uint8, imm int32) {
+	a.emit(unix.BPF_ALU64|op|unix.BPF_K, dst, 0, 0, imm)
+}
+
+func (a *bpfAsm) alu64Reg(op uint8, dst, src uint8) {
+	a.emit(unix.BPF_ALU64|op|unix.BPF_X, dst, src, 0, 0)
+}
+
+// be16 turns the network order 16 bits of dst into host order.
+func (a *bpfAsm) be16(dst uint8) {
+	a.emit(unix.BPF_ALU|unix.BPF_END|unix.BPF_TO_BE, dst, 0, 0, 16)
+}
+
+// bound jumps to label when n bytes from ptr go past end, using tmp.
+func (a *bpfAsm) bound(tmp, ptr uint8, n int32, end uint8, label string) {
+	a.movReg(tmp, ptr)
+	a.addImm(tmp, n)
+	if a.jumps == nil {
+		a.jumps = make(map[int]string)
+	}
+	a.jumps[len(a.insns)] = label
+	a.emit(unix.BPF_JMP|unix.BPF_JGT|unix.BPF_X, tmp, end, 0, 0)
+}
+
+func (a *bpfAsm) jeq(dst uint8, imm int32, label string) {
+	a.jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, dst, imm, label)
+}
+
+func (a *bpfAsm) jne(dst uint8, imm int32, label string) {
+	a.jump(unix.BPF_JMP|unix.BPF_JNE|unix.BPF_K, dst, imm, label)
+}
+
+func (a *bpfAsm) ja(label string) {
+	a.jump(unix.BPF_JMP|unix.BPF_JA, 0, 0, label)
+}
+
+func (a *bpfAsm) call(fn int32) {
+	a.emit(unix.BPF_JMP|unix.BPF_CALL, 0, 0, 0, fn)
+}
+
+func (a *bpfAsm) exit() {
+	a.emit(unix.BPF_JMP|unix.BPF_EXIT, 0, 0, 0, 0)
+}
+
+// ldMapFD loads a map into dst: a 16-byte instruction the kernel turns
+// into the map's address.
+func (a *bpfAsm) ldMapFD(dst uint8, fd int) {
+	a.emit(unix.BPF_LD|unix.BPF_IMM|unix.BPF_DW, dst, unix.BPF_PSEUDO_MAP_FD, 0, int32(fd))
+	a.emit(0, 0, 0, 0, 0)
+}
+
+// assemble fills in the jump offsets, counted from the next instruction.
+func (a *bpfAsm) assemble() []bpfInsn {
+	for at, label := range a.jumps {
+		a.insns[at].Off = int16(a.labels[label] - at - 1)
+	}
+	return a.insns
+}
+
+func bpfCall(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(fd), nil
+}
+
+func bpfMapCreate(mapType, keySize, valueSize, maxEntries uint32) (int, error) {
+	attr := struct {
+		MapType, KeySize, ValueSize, MaxEntries, MapFlags uint32
+	}{mapType, keySize, valueSize, maxEntries, 0}
+	return bpfCall(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+func bpfMapElem(cmd int, fd int, key, value unsafe.Pointer) error {
+	attr := struct {
+		MapFD uint32
+		_     uint32
+		Key   uint64
+		Value uint64
+		Flags uint64
+	}{MapFD: uint32(fd), Key: uint64(uintptr(key)), Value: uint64(uintptr(value))}
+	_, err := bpfCall(cmd, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+	return err
+}
+
+// bpfProgLoad loads a program, with the verifier's log in the error when
+// it is refused.
+func bpfProgLoad(progType uint32, insns []bpfInsn) (int, error) {
+	license := []byte("GPL\x00")
+	verifierLog := make([]byte, 64*1024)
+	attr := struct {
+		ProgType    uint32
+		InsnCnt     uint32
+		Insns       uint64
+		License     uint64
+		LogLevel    uint32
+		LogSize     uint32
+		LogBuf      uint64
+		KernVersion uint32
+		ProgFlags   uint32
+	}{
+		ProgType: progType,
+		InsnCnt:  uint32(len(insns)),
+		Insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		License:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		LogLevel: 1,
+		LogSize:  uint32(len(verifierLog)),
+		LogBuf:   uint64(uintptr(unsafe.Pointer(&verifierLog[0]))),
+	}
+	fd, err := bpfCall(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if err != nil {
+		if n := clen(verifierLog); n > 0 {
+			return 0, fmt.Errorf("%s: %s", err, verifierLog[:n])
+		}
+		return 0, err
+	}
+	return fd, nil
+}
+
+func bpfLinkCreate(prog int, ifindex int, attachType uint32) (int, error) {
+	attr := struct {
+		ProgFD, TargetIfindex, AttachType, Flags uint32
+	}{uint32(prog), uint32(ifindex), attachType, 0}
+	return bpfCall(unix.BPF_LINK_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+// clen is the length of the NUL terminated string in b.
+func clen(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+func startEBPF(ifaces []net.Interface, xdp bool) error {
+	return fmt.Errorf("eBPF timestamping is only supported on Linux")
+}
+
+func ebpfLoaded() bool {
+	return false
+}
+
+func ebpfWatch(conn *net.UDPConn) func() {
+	return func() {}
+}
+
+func ebpfStamps(conn *net.UDPConn, ts string) (uint64, uint64) {
+	return 0, 0
+}
+
+func ebpfLost(conn *net.UDPConn, ts string) bool {
+	return false
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// ecnCodepoints are the values of the ecn site setting.
+var ecnCodepoints = map[string]uint{"ect1": 1, "ect0": 2, "ce": 3}
+
+var ecnPrefix = []byte("ecn:")
+
+func isECNProbe(buf []byte) bool {
+	return bytes.HasPrefix(buf, ecnPrefix)
+}
+
+// reflectECN answers an ECN probe with the TOS byte it arrived with, or
+// "-" when the system does not tell.
+func reflectECN(svc net.PacketConn, addr net.Addr, buf []byte, tos uint, ok bool) {
+	seen := "-"
+	if ok {
+		seen = strconv.Itoa(int(tos))
+	}
+	svc.WriteTo([]byte(fmt.Sprintf("%s %s", buf, seen)), addr)
+}
+
+// runECNTest sends three probes to addr marked with the site's ECN
+// codepoint and DSCP, and writes the marks the reflector received as the
+// "ecn" measurement: dscp and ecn as sent and as received, whether each
+// was kept, and ce when a router on the way signalled congestion.
+func runECNTest(ctx context.Context, API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, addr *net.UDPAddr, opts sockOpts, extra map[string]string) {
+	opts.ECN = ecnCodepoints[remoteSite.ECN]
+	network := "udp6"
+	if addr.IP.To4() != nil {
+		network = "udp4"
+	}
+	laddr, err := sourceAddr(remoteSite, addr.IP)
+	if err != nil {
+		return
+	}
+	svc, err := dialUDP(network, laddr, addr, opts)
+	if err != nil {
+		siteLog(remoteSite).Debug(fmt.Sprintf("Failed to dial %s: %s", addr, err))
+		return
+	}
+	defer svc.Close()
+	defer closeOnDone(ctx, svc)()
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+	for seq := 0; seq < 3; seq++ {
+		svc.Write(signPacket([]byte(fmt.Sprintf("%s%s:%d", ecnPrefix, id, seq))))
+	}
+	svc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 128)
+	for {
+		n, err := svc.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			siteLog(remoteSite).Debug(fmt.Sprintf("No answer to ECN probes from %s", addr))
+			return
+		}
+		parts := strings.SplitN(string(buf[:n]), " ", 2)
+		if !strings.HasPrefix(parts[0], string(ecnPrefix)+id+":") {
+			continue
+		}
+		if len(parts) < 2 {
+			siteLog(remoteSite).Debug("Reflector does not report received marks")
+			return
+		}
+		tos, err := strconv.Atoi(parts[1])
+		if err != nil {
+			siteLog(remoteSite).Debug("Reflector cannot read the marks of received packets")
+			return
+		}
+		received := uint(tos)
+		fields := map[string]interface{}{
+			"dscp_sent":     int(opts.DSCP),
+			"ecn_sent":      int(opts.ECN),
+			"dscp_received": int(received >> 2),
+			"ecn_received":  int(received & 3),
+			"dscp_kept":     received>>2 == opts.DSCP,
+			// CE is a legitimate change of an ECT mark.
+			"ecn_kept": received&3 == opts.ECN || received&3 == 3,
+			"ce":       received&3 == 3 && opts.ECN != 3,
+		}
+		tags := siteTags(localSite, remoteSite)
+		for k, v := range extra {
+			tags[k] = v
+		}
+		writeResult(API, "ecn", remoteSite, tags, fields)
+		return
+	}
+}
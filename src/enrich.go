@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	log "github.com/sirupsen/logrus"
+)
+
+type GeoIPType struct {
+	Database string `yaml:"database"`
+}
+
+type ASNType struct {
+	Database string `yaml:"database"`
+	Cymru    bool   `yaml:"cymru"`
+}
+
+type asnInfo struct {
+	asn     string
+	name    string
+	expires time.Time
+}
+
+var (
+	geoDB    *geoip2.Reader
+	asnDB    *geoip2.Reader
+	asnCymru bool
+	asnCache = make(map[string]asnInfo)
+	asnLock  sync.Mutex
+)
+
+func openGeoIP(cfg GeoIPType) error {
+	if cfg.Database == "" {
+		return nil
+	}
+	db, err := geoip2.Open(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("opening GeoIP database %s: %s", cfg.Database, err)
+	}
+	geoDB = db
+	return nil
+}
+
+func openASN(cfg ASNType) error {
+	asnCymru = cfg.Cymru
+	if cfg.Database == "" {
+		return nil
+	}
+	db, err := geoip2.Open(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("opening ASN database %s: %s", cfg.Database, err)
+	}
+	asnDB = db
+	return nil
+}
+
+// lookupASN returns the origin AS number and name of ip, from the local
+// database when configured and from Team Cymru's DNS service otherwise.
+// Answers are cached for an hour.
+func lookupASN(ip net.IP) (asnInfo, error) {
+	key := ip.String()
+	asnLock.Lock()
+	info, ok := asnCache[key]
+	asnLock.Unlock()
+	if ok && time.Now().Before(info.expires) {
+		return info, nil
+	}
+	if asnDB != nil {
+		rec, err := asnDB.ASN(ip)
+		if err != nil {
+			return info, err
+		}
+		if rec.AutonomousSystemNumber == 0 {
+			return info, fmt.Errorf("no ASN for %s", key)
+		}
+		info = asnInfo{asn: fmt.Sprint(rec.AutonomousSystemNumber), name: rec.AutonomousSystemOrganization}
+	} else {
+		origin, err := cymruQuery(cymruOriginName(ip))
+		if err != nil {
+			return info, err
+		}
+		info = asnInfo{asn: strings.Fields(origin[0])[0]}
+		if desc, err := cymruQuery(fmt.Sprintf("AS%s.asn.cymru.com", info.asn)); err == nil && len(desc) == 5 {
+			info.name = desc[4]
+		}
+	}
+	info.expires = time.Now().Add(time.Hour)
+	asnLock.Lock()
+	asnCache[key] = info
+	asnLock.Unlock()
+	return info, nil
+}
+
+func cymruOriginName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", v4[3], v4[2], v4[1], v4[0])
+	}
+	const hexDigits = "0123456789abcdef"
+	var b strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[ip[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("origin6.asn.cymru.com")
+	return b.String()
+}
+
+// cymruQuery returns the "|"-separated fields of the first TXT answer.
+func cymruQuery(name string) ([]string, error) {
+	txts, err := net.LookupTXT(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(txts) == 0 {
+		return nil, fmt.Errorf("no answer for %s", name)
+	}
+	fields := strings.Split(txts[0], "|")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	if fields[0] == "" {
+		return nil, fmt.Errorf("empty answer for %s", name)
+	}
+	return fields, nil
+}
+
+// enrichTags adds the optional lookups about the probed address to tags.
+func enrichTags(tags map[string]string, ip net.IP) {
+	if geoDB != nil {
+		city, err := geoDB.City(ip)
+		if err != nil {
+			log.WithFields(log.Fields{"Address": ip.String()}).Debug(fmt.Sprintf("GeoIP lookup failed: %s", err))
+		} else {
+			if city.Country.IsoCode != "" {
+				tags["country"] = city.Country.IsoCode
+			}
+			if name := city.City.Names["en"]; name != "" {
+				tags["city"] = name
+			}
+		}
+	}
+	if asnDB != nil || asnCymru {
+		info, err := lookupASN(ip)
+		if err != nil {
+			log.WithFields(log.Fields{"Address": ip.String()}).Debug(fmt.Sprintf("ASN lookup failed: %s", err))
+		} else {
+			tags["asn"] = info.asn
+			if info.name != "" {
+				tags["as_name"] = info.name
+			}
+		}
+	}
+	if currentConfig().TagPTR {
+		names, err := net.LookupAddr(ip.String())
+		if err != nil || len(names) == 0 {
+			log.WithFields(log.Fields{"Address": ip.String()}).Debug(fmt.Sprintf("PTR lookup failed: %v", err))
+		} else {
+			tags["ptr"] = strings.TrimSuffix(names[0], ".")
+		}
+	}
+}
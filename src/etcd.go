@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// etcdClient talks to the etcd v3 JSON gateway, which every etcd since
+// 3.4 serves on its client port, trying the endpoints in turn.
+type etcdClient struct {
+	endpoints []string
+	username  string
+	password  string
+	http      *http.Client
+	token     string
+	tokenLock sync.Mutex
+}
+
+type etcdKV struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func newEtcdClient(endpoints []string, username string, password string) *etcdClient {
+	return &etcdClient{endpoints: endpoints, username: username, password: password, http: &http.Client{}}
+}
+
+// parseEtcdURL splits "etcd://[user:pass@]host:2379[,host:2379]/key" into
+// a client and the key. etcds:// uses TLS. The password is registered as
+// a secret, see registerSecret.
+func parseEtcdURL(ref string) (*etcdClient, string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		// The error quotes the URL.
+		return nil, "", fmt.Errorf("%s", redact(err.Error()))
+	}
+	scheme := "http"
+	if u.Scheme == "etcds" {
+		scheme = "https"
+	}
+	var endpoints []string
+	for _, host := range strings.Split(u.Host, ",") {
+		endpoints = append(endpoints, scheme+"://"+host)
+	}
+	password, _ := u.User.Password()
+	registerSecret(password)
+	return newEtcdClient(endpoints, u.User.Username(), password), u.Path, nil
+}
+
+func isEtcdURL(path string) bool {
+	return strings.HasPrefix(path, "etcd://") || strings.HasPrefix(path, "etcds://")
+}
+
+// post sends a gateway request to the first endpoint that answers.
+// timeout 0 leaves the request open, for watches. With a username the
+// auth token is kept across requests, and renewed once it has expired.
+func (c *etcdClient) post(path string, body interface{}, timeout time.Duration) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if c.username == "" || path == "/v3/auth/authenticate" {
+		resp, _, err := c.send(path, data, "", timeout)
+		return resp, err
+	}
+	token, err := c.authToken()
+	if err != nil {
+		return nil, err
+	}
+	resp, expired, err := c.send(path, data, token, timeout)
+	if expired {
+		c.dropToken(token)
+		if token, err = c.authToken(); err != nil {
+			return nil, err
+		}
+		resp, _, err = c.send(path, data, token, timeout)
+	}
+	return resp, err
+}
+
+// send tries the endpoints in turn. expired tells that token was refused,
+// which the other endpoints of the cluster would do too.
+func (c *etcdClient) send(path string, data []byte, token string, timeout time.Duration) (resp *http.Response, expired bool, err error) {
+	var lastErr error
+	for _, endpoint := range c.endpoints {
+		req, err := http.NewRequest("POST", strings.TrimRight(endpoint, "/")+path, bytes.NewReader(data))
+		if err != nil {
+			return nil, false, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		client := *c.http
+		client.Timeout = timeout
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode == http.StatusUnauthorized && token != "" {
+			resp.Body.Close()
+			return nil, true, fmt.Errorf("etcd %s returned %s", endpoint, resp.Status)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("etcd %s returned %s", endpoint, resp.Status)
+			continue
+		}
+		return resp, false, nil
+	}
+	return nil, false, lastErr
+}
+
+// authToken returns the auth token, authenticating when there is none.
+func (c *etcdClient) authToken() (string, error) {
+	c.tokenLock.Lock()
+	defer c.tokenLock.Unlock()
+	if c.token != "" {
+		return c.token, nil
+	}
+	resp, err := c.post("/v3/auth/authenticate", map[string]string{"name": c.username, "password": c.password}, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return "", fmt.Errorf("etcd authentication: %s", err)
+	}
+	c.token = auth.Token
+	return c.token, nil
+}
+
+// dropToken forgets token once refused, unless another request has
+// already replaced it.
+func (c *etcdClient) dropToken(token string) {
+	c.tokenLock.Lock()
+	if c.token == token {
+		c.token = ""
+	}
+	c.tokenLock.Unlock()
+}
+
+func etcdRangeEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return "\x00"
+}
+
+// rangeRequest is the gateway body for key, or for every key under it
+// with prefix set.
+func rangeRequest(key string, prefix bool) map[string]string {
+	req := map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(key))}
+	if prefix {
+		req["range_end"] = base64.StdEncoding.EncodeToString([]byte(etcdRangeEnd(key)))
+	}
+	return req
+}
+
+// get returns the keys and values of key, or of every key under it with
+// prefix set.
+func (c *etcdClient) get(key string, prefix bool) (map[string][]byte, error) {
+	resp, err := c.post("/v3/kv/range", rangeRequest(key, prefix), 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Kvs []etcdKV `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding etcd response: %s", err)
+	}
+	kvs := make(map[string][]byte, len(body.Kvs))
+	for _, kv := range body.Kvs {
+		k, _ := base64.StdEncoding.DecodeString(kv.Key)
+		v, _ := base64.StdEncoding.DecodeString(kv.Value)
+		kvs[string(k)] = v
+	}
+	return kvs, nil
+}
+
+// watch signals changes whenever key (or a key under it with prefix set)
+// is written or deleted, and once after every reconnect, since changes
+// may have been missed meanwhile. It returns when stop is closed.
+func (c *etcdClient) watch(key string, prefix bool, changes chan<- struct{}, stop <-chan struct{}) {
+	signal := func() {
+		select {
+		case changes <- struct{}{}:
+		case <-stop:
+		}
+	}
+	for connected := false; ; {
+		resp, err := c.post("/v3/watch", map[string]interface{}{"create_request": rangeRequest(key, prefix)}, 0)
+		if err == nil {
+			if connected {
+				signal()
+			}
+			connected = true
+			done := make(chan struct{})
+			go func() {
+				select {
+				case <-stop:
+					resp.Body.Close()
+				case <-done:
+				}
+			}()
+			scanner := bufio.NewScanner(resp.Body)
+			scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+			for scanner.Scan() {
+				var msg struct {
+					Result struct {
+						Events []json.RawMessage `json:"events"`
+					} `json:"result"`
+				}
+				if json.Unmarshal(scanner.Bytes(), &msg) == nil && len(msg.Result.Events) > 0 {
+					signal()
+				}
+			}
+			close(done)
+			resp.Body.Close()
+			err = scanner.Err()
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(5 * time.Second):
+		}
+		log.WithFields(log.Fields{"Key": key}).Warn(fmt.Sprintf("etcd watch interrupted, reconnecting: %v", err))
+	}
+}
+
+// readEtcdConfig reads a config document from an etcd:// URL.
+func readEtcdConfig(ref string) ([]byte, error) {
+	client, key, err := parseEtcdURL(ref)
+	if err != nil {
+		return nil, err
+	}
+	kvs, err := client.get(key, false)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := kvs[key]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in etcd", key)
+	}
+	return data, nil
+}
+
+// EtcdType discovers sites from the keys under prefix, each holding a
+// remoteSites entry in YAML or JSON. Region and site default to the key
+// below the prefix, as "region/site".
+type EtcdType struct {
+	Endpoints []string `yaml:"endpoints"`
+	Prefix    string   `yaml:"prefix"`
+	Username  string   `yaml:"username"`
+	Password  string   `yaml:"password"`
+
+	client *etcdClient
+}
+
+func (e *EtcdType) name() string {
+	return "etcd"
+}
+
+func (e *EtcdType) connect() (*etcdClient, error) {
+	if e.client == nil {
+		password, err := resolveSecret(e.Password)
+		if err != nil {
+			return nil, err
+		}
+		endpoints := e.Endpoints
+		if len(endpoints) == 0 {
+			endpoints = []string{"http://127.0.0.1:2379"}
+		}
+		e.client = newEtcdClient(endpoints, e.Username, password)
+	}
+	return e.client, nil
+}
+
+func (e *EtcdType) discover() ([]SiteType, error) {
+	client, err := e.connect()
+	if err != nil {
+		return nil, err
+	}
+	kvs, err := client.get(e.Prefix, true)
+	if err != nil {
+		return nil, err
+	}
+	sites := make([]SiteType, 0, len(kvs))
+	for key, value := range kvs {
+		var site SiteType
+		if err := yaml.Unmarshal(value, &site); err != nil {
+			return nil, fmt.Errorf("etcd key %s: %s", key, err)
+		}
+		parts := strings.SplitN(strings.Trim(strings.TrimPrefix(key, e.Prefix), "/"), "/", 2)
+		if site.Region == "" && len(parts) == 2 {
+			site.Region = parts[0]
+		}
+		if site.Site == "" {
+			site.Site = parts[len(parts)-1]
+		}
+		sites = append(sites, site)
+	}
+	sort.Slice(sites, func(i, j int) bool { return sites[i].key() < sites[j].key() })
+	return sites, nil
+}
+
+func (e *EtcdType) watch(changes chan<- struct{}, stop <-chan struct{}) {
+	client, err := e.connect()
+	if err != nil {
+		return
+	}
+	client.watch(e.Prefix, true, changes, stop)
+}
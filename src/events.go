@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EventType is something that happened in the agent as a whole rather
+// than a single measurement: a finished cycle or a site going down or
+// coming back.
+type EventType struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+type cycleSummary struct {
+	ID          string  `json:"id"`
+	Sites       int     `json:"sites"`
+	Reachable   int     `json:"reachable"`
+	Unreachable int     `json:"unreachable"`
+	Skipped     int     `json:"skipped,omitempty"`
+	Duration    float64 `json:"duration"`
+}
+
+type alertEvent struct {
+	Region string `json:"region"`
+	Site   string `json:"site"`
+	State  string `json:"state"`
+}
+
+var (
+	eventSubscribers = make(map[chan EventType]struct{})
+	eventsLock       sync.Mutex
+)
+
+// publishEvent hands an event to every subscriber that has room for it.
+func publishEvent(kind string, data interface{}) {
+	event := EventType{Type: kind, Time: time.Now(), Data: data}
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+	for ch := range eventSubscribers {
+		select {
+		case ch <- event:
+		default:
+			atomic.AddUint64(&telemetry.exportDropped, 1)
+		}
+	}
+}
+
+func subscribeEvents() chan EventType {
+	ch := make(chan EventType, 16)
+	eventsLock.Lock()
+	eventSubscribers[ch] = struct{}{}
+	eventsLock.Unlock()
+	return ch
+}
+
+func unsubscribeEvents(ch chan EventType) {
+	eventsLock.Lock()
+	delete(eventSubscribers, ch)
+	eventsLock.Unlock()
+}
+
+// noteReachable records whether a check of site produced a result and
+// raises an alert event when that changes.
+func (s *schedulerType) noteReachable(site SiteType, reachable bool) {
+	s.lock.Lock()
+	changed := s.down[site.key()] == reachable
+	if reachable {
+		delete(s.down, site.key())
+	} else {
+		s.down[site.key()] = true
+	}
+	s.lock.Unlock()
+	if !changed {
+		return
+	}
+	state := "up"
+	if !reachable {
+		state = "down"
+	}
+	log.WithFields(log.Fields{"Region": site.Region, "Site": site.Site}).Info(fmt.Sprintf("Site is %s", state))
+	publishEvent("alert", alertEvent{Region: site.Region, Site: site.Site, State: state})
+}
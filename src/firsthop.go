@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// FirstHopType enables the LAN-scope probe of the default gateways, so
+// that WAN measurements can be told apart from local-segment problems.
+type FirstHopType struct {
+	Enabled bool `yaml:"enabled"`
+	// Gateways replaces the default gateways found in the routing table.
+	Gateways []string `yaml:"gateways"`
+	// Interval is the time between checks in seconds, 60 by default.
+	Interval uint `yaml:"interval"`
+	// Count is the number of echo requests per check, 5 by default.
+	Count uint `yaml:"count"`
+}
+
+// gateway is a first hop and the interface it is reached through.
+type gateway struct {
+	IP    net.IP
+	Iface *net.Interface
+}
+
+// configuredGateways finds the interface of each address in list by the
+// subnets configured on the host's interfaces.
+func configuredGateways(list []string) ([]gateway, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var gateways []gateway
+	for _, s := range list {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid gateway address %s", s)
+		}
+		var found *net.Interface
+		for i := range ifaces {
+			addrs, _ := ifaces[i].Addrs()
+			for _, a := range addrs {
+				if n, ok := a.(*net.IPNet); ok && n.Contains(ip) {
+					found = &ifaces[i]
+				}
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("gateway %s is not on a local subnet", s)
+		}
+		gateways = append(gateways, gateway{IP: ip, Iface: found})
+	}
+	return gateways, nil
+}
+
+// pingGateway sends count ICMP echo requests to gw, 200ms apart.
+func pingGateway(gw gateway, count int) ([]time.Duration, error) {
+	return icmpEcho(context.Background(), gw.IP, gw.Iface.Name, count, 200*time.Millisecond)
+}
+
+// checkFirstHop measures one gateway and writes the "firsthop" point:
+// resolve, the ARP or NDP resolution time, and the echo avg and jitter in
+// microseconds, with loss in percent.
+func checkFirstHop(API influxAPI.WriteAPI, gw gateway, count int) {
+	configLock.RLock()
+	local := configData.LocalSite
+	tags := make(map[string]string)
+	for k, v := range configData.Tags {
+		tags[k] = v
+	}
+	configLock.RUnlock()
+	if hostname != "" {
+		tags["host"] = hostname
+	}
+	tags["region1"] = local.Region
+	tags["site1"] = local.Site
+	tags["gateway"] = gw.IP.String()
+	tags["interface"] = gw.Iface.Name
+	logger := log.WithFields(log.Fields{"Gateway": gw.IP.String(), "Interface": gw.Iface.Name})
+	fields := make(map[string]interface{})
+	resolve, err := resolveNeighbor(gw)
+	if err != nil {
+		logger.Debug(fmt.Sprintf("Neighbor resolution failed: %s", err))
+	} else {
+		fields["resolve"] = resolve.Microseconds()
+	}
+	rtts, err := pingGateway(gw, count)
+	if err != nil {
+		logger.Debug(fmt.Sprintf("Failed to ping gateway: %s", err))
+	} else {
+		fields["loss"] = 100 * float64(count-len(rtts)) / float64(count)
+		if len(rtts) > 0 {
+			var sum time.Duration
+			minRTT, maxRTT := rtts[0], rtts[0]
+			for _, rtt := range rtts {
+				sum += rtt
+				if rtt < minRTT {
+					minRTT = rtt
+				}
+				if rtt > maxRTT {
+					maxRTT = rtt
+				}
+			}
+			fields["avg"] = (sum / time.Duration(len(rtts))).Microseconds()
+			fields["jitter"] = (maxRTT - minRTT).Microseconds()
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+	API.WritePoint(newPoint("firsthop", tags, fields, time.Now()))
+}
+
+// runFirstHop checks the gateways every interval until stop is closed.
+// Default gateways are looked up again each time, so route changes are
+// followed.
+func runFirstHop(API influxAPI.WriteAPI, cfg FirstHopType, stop <-chan struct{}) {
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval == 0 {
+		interval = time.Minute
+	}
+	count := int(cfg.Count)
+	if count == 0 {
+		count = 5
+	}
+	for {
+		var gateways []gateway
+		var err error
+		if len(cfg.Gateways) > 0 {
+			gateways, err = configuredGateways(cfg.Gateways)
+		} else {
+			gateways, err = defaultGateways()
+		}
+		if err != nil {
+			log.Warn(fmt.Sprintf("Failed to find gateways: %s", err))
+		}
+		for _, gw := range gateways {
+			checkFirstHop(API, gw, count)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
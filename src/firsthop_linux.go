@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+// defaultGateways reads the IPv4 and IPv6 default routes from /proc.
+func defaultGateways() ([]gateway, error) {
+	var gateways []gateway
+	add := func(name string, ip net.IP) {
+		iface, err := net.InterfaceByName(name)
+		if err == nil {
+			gateways = append(gateways, gateway{IP: ip, Iface: iface})
+		}
+	}
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags ..., addresses in host order.
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[1] != "00000000" {
+			continue
+		}
+		flags, _ := strconv.ParseUint(fields[3], 16, 32)
+		gw, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil || flags&unix.RTF_GATEWAY == 0 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.LittleEndian.PutUint32(ip, uint32(gw))
+		add(fields[0], ip)
+	}
+	if f6, err := os.Open("/proc/net/ipv6_route"); err == nil {
+		defer f6.Close()
+		scanner := bufio.NewScanner(f6)
+		for scanner.Scan() {
+			// dst dst_len src src_len next_hop metric refcnt use flags iface
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 || fields[1] != "00" || strings.Trim(fields[0], "0") != "" || strings.Trim(fields[4], "0") == "" {
+				continue
+			}
+			ip, err := hex.DecodeString(fields[4])
+			if err != nil || len(ip) != net.IPv6len {
+				continue
+			}
+			add(fields[9], net.IP(ip))
+		}
+	}
+	if len(gateways) == 0 {
+		return nil, fmt.Errorf("no default route")
+	}
+	return gateways, nil
+}
+
+// resolveNeighbor times an ARP (IPv4) or NDP (IPv6) resolution of gw,
+// asking directly rather than through the kernel's neighbor cache.
+func resolveNeighbor(gw gateway) (time.Duration, error) {
+	if gw.IP.To4() != nil {
+		return resolveARP(gw)
+	}
+	return resolveNDP(gw)
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// interfaceIP returns an address of iface in the family of ip.
+func interfaceIP(iface *net.Interface, ip net.IP) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip.To4() != nil && n.IP.To4() != nil {
+			return n.IP.To4(), nil
+		}
+		if ip.To4() == nil && n.IP.To4() == nil && n.IP.IsLinkLocalUnicast() {
+			return n.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("no suitable address on %s", iface.Name)
+}
+
+func resolveARP(gw gateway) (time.Duration, error) {
+	src, err := interfaceIP(gw.Iface, gw.IP)
+	if err != nil {
+		return 0, err
+	}
+	if len(gw.Iface.HardwareAddr) != 6 {
+		return 0, fmt.Errorf("%s is not an Ethernet interface", gw.Iface.Name)
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return 0, fmt.Errorf("opening ARP socket: %s", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: gw.Iface.Index}); err != nil {
+		return 0, err
+	}
+	tv := unix.NsecToTimeval(int64(time.Second))
+	unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	// Ethernet/IPv4 request: htype, ptype, hlen, plen, op, sha, spa, tha, tpa.
+	req := make([]byte, 28)
+	binary.BigEndian.PutUint16(req[0:], 1)
+	binary.BigEndian.PutUint16(req[2:], unix.ETH_P_IP)
+	req[4], req[5] = 6, 4
+	binary.BigEndian.PutUint16(req[6:], 1)
+	copy(req[8:], gw.Iface.HardwareAddr)
+	copy(req[14:], src)
+	copy(req[24:], gw.IP.To4())
+	to := &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: gw.Iface.Index, Halen: 6}
+	copy(to.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	start := time.Now()
+	if err := unix.Sendto(fd, req, 0, to); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 128)
+	for time.Since(start) < time.Second {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return 0, fmt.Errorf("no ARP reply from %s", gw.IP)
+		}
+		if n >= 28 && binary.BigEndian.Uint16(buf[6:]) == 2 && bytes.Equal(buf[14:18], gw.IP.To4()) {
+			return time.Since(start), nil
+		}
+	}
+	return 0, fmt.Errorf("no ARP reply from %s", gw.IP)
+}
+
+func resolveNDP(gw gateway) (time.Duration, error) {
+	src, err := interfaceIP(gw.Iface, gw.IP)
+	if err != nil {
+		return 0, err
+	}
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", src.String()+"%"+gw.Iface.Name)
+	if err != nil {
+		return 0, fmt.Errorf("opening ICMPv6 socket: %s", err)
+	}
+	defer conn.Close()
+	pc := conn.IPv6PacketConn()
+	// Neighbor discovery messages must carry hop limit 255.
+	pc.SetHopLimit(255)
+	pc.SetMulticastHopLimit(255)
+	pc.SetMulticastInterface(gw.Iface)
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeNeighborAdvertisement)
+	pc.SetICMPFilter(&filter)
+	// Reserved, target, then the source link-layer address option.
+	body := make([]byte, 4, 28)
+	body = append(body, gw.IP.To16()...)
+	if len(gw.Iface.HardwareAddr) == 6 {
+		body = append(body, 1, 1)
+		body = append(body, gw.Iface.HardwareAddr...)
+	}
+	msg := icmp.Message{Type: ipv6.ICMPTypeNeighborSolicitation, Body: &icmp.RawBody{Data: body}}
+	pkt, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+	// The solicited-node multicast address of the target.
+	dst := net.ParseIP("ff02::1:ff00:0")
+	copy(dst[13:], gw.IP.To16()[13:])
+	start := time.Now()
+	if _, err := conn.WriteTo(pkt, &net.IPAddr{IP: dst, Zone: gw.Iface.Name}); err != nil {
+		return 0, err
+	}
+	conn.SetReadDeadline(start.Add(time.Second))
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return 0, fmt.Errorf("no neighbor advertisement from %s", gw.IP)
+		}
+		reply, err := icmp.ParseMessage(58, buf[:n])
+		if err != nil || reply.Type != ipv6.ICMPTypeNeighborAdvertisement {
+			continue
+		}
+		if raw, ok := reply.Body.(*icmp.RawBody); ok && len(raw.Data) >= 20 && net.IP(raw.Data[4:20]).Equal(gw.IP) {
+			return time.Since(start), nil
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func defaultGateways() ([]gateway, error) {
+	return nil, fmt.Errorf("default gateway lookup is only supported on Linux, set firstHop.gateways")
+}
+
+func resolveNeighbor(gw gateway) (time.Duration, error) {
+	return 0, fmt.Errorf("ARP and NDP probes are only supported on Linux")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// From linux/in6.h, which x/sys/unix leaves out.
+const (
+	ipv6FlowlabelMgr = 32
+	ipv6FlowinfoSend = 33
+	ipv6FlAGet       = 0
+	ipv6FlSAny       = 255
+	ipv6FlFCreate    = 1
+)
+
+// flowlabelReq is struct in6_flowlabel_req.
+type flowlabelReq struct {
+	dst     [16]byte
+	label   uint32
+	action  uint8
+	share   uint8
+	flags   uint16
+	expires uint16
+	linger  uint16
+	_       uint32
+}
+
+// requestFlowLabels has the kernel lease the labels to conn for addr,
+// shared with any other socket, and send the label given at connect.
+// Beyond 32 labels a socket needs CAP_NET_ADMIN.
+func requestFlowLabels(conn *net.UDPConn, addr *net.UDPAddr, labels []int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	err = raw.Control(func(fd uintptr) {
+		for _, label := range labels {
+			req := flowlabelReq{label: htonl(uint32(label)), action: ipv6FlAGet, share: ipv6FlSAny, flags: ipv6FlFCreate}
+			copy(req.dst[:], addr.IP.To16())
+			_, _, errno := unix.Syscall6(unix.SYS_SETSOCKOPT, fd, unix.IPPROTO_IPV6, ipv6FlowlabelMgr, uintptr(unsafe.Pointer(&req)), unsafe.Sizeof(req), 0)
+			if errno != 0 && errno != unix.EEXIST {
+				opErr = errno
+				return
+			}
+		}
+		opErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, ipv6FlowinfoSend, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
+
+// connectFlowLabel connects conn to addr again, with label as the flow
+// label of the packets it sends from now on.
+func connectFlowLabel(conn *net.UDPConn, addr *net.UDPAddr, label int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	sa := unix.RawSockaddrInet6{
+		Family:   unix.AF_INET6,
+		Port:     htons(uint16(addr.Port)),
+		Flowinfo: htonl(uint32(label)),
+	}
+	copy(sa.Addr[:], addr.IP.To16())
+	if addr.Zone != "" {
+		if iface, err := net.InterfaceByName(addr.Zone); err == nil {
+			sa.Scope_id = uint32(iface.Index)
+		}
+	}
+	var opErr error
+	err = raw.Control(func(fd uintptr) {
+		_, _, errno := unix.Syscall(unix.SYS_CONNECT, fd, uintptr(unsafe.Pointer(&sa)), unsafe.Sizeof(sa))
+		if errno != 0 {
+			opErr = errno
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
+
+func htonl(v uint32) uint32 {
+	return v>>24 | v>>8&0xff00 | v<<8&0xff0000 | v<<24
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+func requestFlowLabels(conn *net.UDPConn, addr *net.UDPAddr, labels []int) error {
+	return fmt.Errorf("flow labels are only supported on Linux")
+}
+
+func connectFlowLabel(conn *net.UDPConn, addr *net.UDPAddr, label int) error {
+	return fmt.Errorf("flow labels are only supported on Linux")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// maxFlows bounds the flows of a site, and so its series.
+const maxFlows = 64
+
+// probeFlows measures addr from the site's number of source ports at
+// once. Each flow, a distinct 5-tuple, may hash onto another ECMP path or
+// LAG member; an rtt point is written per flow, tagged with its index,
+// and a "flows" point comparing them, so one bad path shows up instead of
+// being averaged away.
+func probeFlows(ctx context.Context, API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, addr *net.UDPAddr, opts sockOpts, extra map[string]string) {
+	n := int(remoteSite.Flows)
+	stats := make([]rttStats, n)
+	tags := make([]map[string]string, n)
+	ports := make([]int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		port := 0
+		if remoteSite.FlowPort != 0 {
+			port = int(remoteSite.FlowPort) + i
+		}
+		svc, err := dialProbe(remoteSite, addr, opts, port)
+		if err != nil {
+			siteLog(remoteSite).Debug(fmt.Sprintf("Failed to dial %s for flow %d: %s", addr, i, err))
+			continue
+		}
+		flowTags := map[string]string{"flow": strconv.Itoa(i)}
+		for k, v := range extra {
+			flowTags[k] = v
+		}
+		tags[i] = probeTags(localSite, remoteSite, svc, addr, flowTags)
+		ports[i] = svc.LocalAddr().(*net.UDPAddr).Port
+		wg.Add(1)
+		go func(i int, svc *net.UDPConn) {
+			defer wg.Done()
+			defer svc.Close()
+			defer closeOnDone(ctx, svc)()
+			stats[i] = measureRTT(ctx, svc, remoteSite, addr)
+		}(i, svc)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+	for i, s := range stats {
+		if tags[i] != nil {
+			writeRTT(API, remoteSite, addr, tags[i], s, map[string]interface{}{"src_port": int64(ports[i])})
+		}
+	}
+	writeFlows(API, localSite, remoteSite, addr, extra, stats, tags, nil)
+}
+
+// writeFlows writes the "flows" point comparing the flows of a check. The
+// flows that could not be measured have nil tags. ids are what the flows
+// are known by, their index when nil.
+func writeFlows(API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, addr *net.UDPAddr, extra map[string]string, stats []rttStats, tags []map[string]string, ids []int) {
+	var measured, lossy, down, worst int
+	var sent, received int
+	var minAvg, maxAvg int64
+	for i, s := range stats {
+		if tags[i] == nil {
+			continue
+		}
+		measured++
+		sent += s.sent
+		received += s.received
+		switch {
+		case s.received == 0:
+			down++
+		case s.received < s.sent:
+			lossy++
+		}
+		if tags[worst] == nil || s.loss() > stats[worst].loss() || (s.loss() == stats[worst].loss() && s.received > 0 && stats[worst].received > 0 && s.avg() > stats[worst].avg()) {
+			worst = i
+		}
+		if s.received > 0 {
+			minAvg = min(minAvg, s.avg())
+			maxAvg = max(maxAvg, s.avg())
+		}
+	}
+	if measured == 0 {
+		return
+	}
+	if ids != nil {
+		worst = ids[worst]
+	}
+	fields := map[string]interface{}{
+		"flows":       measured,
+		"flows_lossy": lossy,
+		"flows_down":  down,
+		"loss":        100 * float64(sent-received) / float64(sent),
+		"worst_flow":  worst,
+	}
+	if received > 0 {
+		fields["rtt_spread"] = maxAvg - minAvg
+	} else {
+		fields["reachable"] = false
+	}
+	siteLog(remoteSite).Debug(fmt.Sprintf("%d flows to %s, %d lossy and %d down", measured, addr, lossy, down))
+	writeResult(API, "flows", remoteSite, probeTags(localSite, remoteSite, nil, addr, extra), fields)
+}
+
+// maxFlowLabel is the highest IPv6 flow label, they are 20 bits.
+const maxFlowLabel = 0xfffff
+
+// probeFlowLabels measures an IPv6 addr under the site's number of flow
+// labels, from flowLabel on (1 when unset). Unlike flows the 5-tuple stays
+// the same: one socket sends every probe round through each label in
+// turn, so only routers and bonds hashing on the label spread them. The
+// points are those of probeFlows, tagged flow_label instead of flow.
+func probeFlowLabels(ctx context.Context, API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, addr *net.UDPAddr, opts sockOpts, extra map[string]string) {
+	svc, err := dialProbe(remoteSite, addr, opts, 0)
+	if err != nil {
+		siteLog(remoteSite).Debug(fmt.Sprintf("Failed to dial %s: %s", addr, err))
+		return
+	}
+	defer svc.Close()
+	defer closeOnDone(ctx, svc)()
+	n := int(remoteSite.FlowLabels)
+	labels := make([]int, n)
+	for i := range labels {
+		labels[i] = int(remoteSite.FlowLabel) + i
+		if remoteSite.FlowLabel == 0 {
+			labels[i]++
+		}
+	}
+	if err := requestFlowLabels(svc, addr, labels); err != nil {
+		// Measured without labels rather than not at all.
+		siteLog(remoteSite).Warn(fmt.Sprintf("Probing %s without flow labels: %s", addr, err))
+		stats := measureRTT(ctx, svc, remoteSite, addr)
+		if ctx.Err() == nil {
+			writeRTT(API, remoteSite, addr, probeTags(localSite, remoteSite, svc, addr, extra), stats, nil)
+		}
+		return
+	}
+	stats := make([]rttStats, n)
+	tags := make([]map[string]string, n)
+	for i, label := range labels {
+		labelTags := map[string]string{"flow_label": strconv.Itoa(label)}
+		for k, v := range extra {
+			labelTags[k] = v
+		}
+		tags[i] = probeTags(localSite, remoteSite, svc, addr, labelTags)
+	}
+	count := remoteSite.probeCount()
+	gap := sched.probeGap(remoteSite, currentConfig().Adaptive)
+	for p := 0; p < count; p++ {
+		for i, label := range labels {
+			if err := connectFlowLabel(svc, addr, label); err != nil {
+				siteLog(remoteSite).Debug(fmt.Sprintf("Failed to set flow label %d to %s: %s", label, addr, err))
+				return
+			}
+			if !stats[i].probe(ctx, svc, remoteSite, addr) {
+				return
+			}
+		}
+		if p < count-1 && !sleepCtx(ctx, gap) {
+			return
+		}
+	}
+	for i, s := range stats {
+		writeRTT(API, remoteSite, addr, tags[i], s, nil)
+	}
+	writeFlows(API, localSite, remoteSite, addr, extra, stats, tags, labels)
+}
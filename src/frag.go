@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// fragProbe sends one padded probe of size bytes and reports whether the
+// reflector's echo came back. With df set the packet may not be
+// fragmented, so a path MTU below size makes it fail.
+func fragProbe(ctx context.Context, network string, laddr *net.UDPAddr, addr *net.UDPAddr, opts sockOpts, size int, df bool) bool {
+	opts.DontFragment = df
+	svc, err := dialUDP(network, laddr, addr, opts)
+	if err != nil {
+		return false
+	}
+	defer svc.Close()
+	defer closeOnDone(ctx, svc)()
+	// The MAC, if any, is part of the size tested.
+	payload := make([]byte, size-macOverhead())
+	copy(payload, fmt.Sprintf("frag:%d:", rand.Int63()))
+	if _, err := svc.Write(signPacket(payload)); err != nil {
+		// EMSGSIZE: the packet does not fit the local or known path MTU.
+		return false
+	}
+	buf := make([]byte, size+1)
+	svc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		n, err := svc.Read(buf)
+		if err != nil {
+			return false
+		}
+		if n == len(payload) && bytes.Equal(buf[:n], payload) {
+			return true
+		}
+	}
+}
+
+// runFragTest probes addr with an oversized payload with and without DF and
+// writes whether each got through, which tells fragmentation from
+// black-holing on the path.
+func runFragTest(ctx context.Context, API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, addr *net.UDPAddr, opts sockOpts, extra map[string]string) {
+	size := int(remoteSite.FragTest)
+	network := "udp6"
+	if addr.IP.To4() != nil {
+		network = "udp4"
+	}
+	laddr, err := sourceAddr(remoteSite, addr.IP)
+	if err != nil {
+		return
+	}
+	fragmented := fragProbe(ctx, network, laddr, addr, opts, size, false)
+	df := fragProbe(ctx, network, laddr, addr, opts, size, true)
+	if ctx.Err() != nil {
+		return
+	}
+	siteLog(remoteSite).Debug(fmt.Sprintf("Fragmentation test with %d bytes to %s: fragmented %t, DF %t", size, addr, fragmented, df))
+	tags := siteTags(localSite, remoteSite)
+	for k, v := range extra {
+		tags[k] = v
+	}
+	writeResult(API, "frag", remoteSite, tags, map[string]interface{}{"size": size, "fragmented": fragmented, "df": df})
+}
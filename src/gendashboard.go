@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runGenDashboard implements "netcheck gen-dashboard": a Grafana dashboard
+// for the configured sites, querying the InfluxDB bucket with Flux and
+// using the configured measurement and tag names.
+func runGenDashboard(args []string) int {
+	fs := flag.NewFlagSet("gen-dashboard", flag.ExitOnError)
+	fs.Usage = commandUsage("gen-dashboard", fs)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	title := fs.String("title", "netcheck", "Dashboard title")
+	datasource := fs.String("datasource", "", "UID of the InfluxDB (Flux) data source, default asked on import")
+	fs.Parse(args)
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", configFile, err)
+		return 2
+	}
+	configData = cfg
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	out.SetEscapeHTML(false)
+	if err := out.Encode(grafanaDashboard(cfg, *title, *datasource)); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// measurementName and tagName return the names a measurement kind and a
+// built-in tag are written under.
+func measurementName(cfg ConfigType, kind string) string {
+	if name, ok := cfg.InfluxWrite.Measurements[kind]; ok {
+		return name
+	}
+	if cfg.InfluxWrite.Measurement != "" {
+		return cfg.InfluxWrite.Measurement
+	}
+	return kind
+}
+
+func tagName(cfg ConfigType, tag string) string {
+	if name, ok := cfg.InfluxWrite.TagNames[tag]; ok {
+		return name
+	}
+	return tag
+}
+
+type jsonObject map[string]interface{}
+
+func grafanaDashboard(cfg ConfigType, title string, datasource string) jsonObject {
+	ds := jsonObject{"type": "influxdb", "uid": "${DS_INFLUXDB}"}
+	if datasource != "" {
+		ds["uid"] = datasource
+	}
+	region1, site1 := tagName(cfg, "region1"), tagName(cfg, "site1")
+	region2, site2 := tagName(cfg, "region2"), tagName(cfg, "site2")
+	// query selects one field of a measurement as seen from the chosen
+	// local site, with an extra filter, averaged per panel interval. Series
+	// are per remote site, or per field when filtered to one site.
+	query := func(kind string, field string, filter string) string {
+		q := fmt.Sprintf("from(bucket: %q)\n"+
+			"  |> range(start: v.timeRangeStart, stop: v.timeRangeStop)\n"+
+			"  |> filter(fn: (r) => r._measurement == %q and r._field == %q)\n"+
+			"  |> filter(fn: (r) => r[%q] =~ /^${region1:regex}$/ and r[%q] =~ /^${site1:regex}$/)\n",
+			cfg.InfluxBucket, measurementName(cfg, kind), field, region1, site1)
+		group := fmt.Sprintf("%q, %q", region2, site2)
+		if filter != "" {
+			q += "  |> filter(fn: (r) => " + filter + ")\n"
+			group = `"_field"`
+		}
+		return q + "  |> group(columns: [" + group + "])\n" +
+			"  |> toFloat()\n" +
+			"  |> aggregateWindow(every: v.windowPeriod, fn: mean, createEmpty: false)"
+	}
+	siteLegend := fmt.Sprintf("${__field.labels.%s}/${__field.labels.%s}", region2, site2)
+	var panels []jsonObject
+	id := 0
+	panel := func(title string, unit string, legend string, width int, x int, y int, queries ...string) {
+		id++
+		var targets []jsonObject
+		for i, q := range queries {
+			targets = append(targets, jsonObject{"refId": string(rune('A' + i)), "datasource": ds, "query": q})
+		}
+		panels = append(panels, jsonObject{
+			"id":         id,
+			"type":       "timeseries",
+			"title":      title,
+			"datasource": ds,
+			"gridPos":    jsonObject{"h": 8, "w": width, "x": x, "y": y},
+			"fieldConfig": jsonObject{
+				"defaults":  jsonObject{"unit": unit, "displayName": legend},
+				"overrides": []jsonObject{},
+			},
+			"targets": targets,
+		})
+	}
+	panel("RTT", "µs", siteLegend, 12, 0, 0, query("rtt", "avg", ""))
+	panel("Jitter", "µs", siteLegend, 12, 12, 0, query("rtt", "jitter", ""))
+	y := 8
+	frag := false
+	for i, site := range cfg.RemoteSites {
+		filter := fmt.Sprintf("r[%q] == %q and r[%q] == %q", region2, site.Region, site2, site.Site)
+		panel(site.key(), "µs", "${__field.labels._field}", 8, (i%3)*8, y+(i/3)*8, query("rtt", "avg", filter), query("rtt", "jitter", filter))
+		if site.FragTest > 0 {
+			frag = true
+		}
+	}
+	y += (len(cfg.RemoteSites) + 2) / 3 * 8
+	if frag {
+		panel("Fragmented packets delivered", "percentunit", siteLegend, 24, 0, y, query("frag", "fragmented", ""))
+	}
+	variable := func(name string, tag string) jsonObject {
+		return jsonObject{
+			"name":       name,
+			"label":      tag,
+			"type":       "query",
+			"datasource": ds,
+			"query":      fmt.Sprintf("import \"influxdata/influxdb/schema\"\nschema.tagValues(bucket: %q, tag: %q)", cfg.InfluxBucket, tag),
+			"refresh":    2,
+			"includeAll": true,
+			"multi":      true,
+			"current":    jsonObject{"text": "All", "value": "$__all"},
+		}
+	}
+	dashboard := jsonObject{
+		"title":         title,
+		"uid":           "netcheck-" + strings.ToLower(strings.Replace(title, " ", "-", -1)),
+		"tags":          []string{"netcheck"},
+		"schemaVersion": 36,
+		"time":          jsonObject{"from": "now-6h", "to": "now"},
+		"refresh":       "1m",
+		"templating":    jsonObject{"list": []jsonObject{variable("region1", region1), variable("site1", site1)}},
+		"panels":        panels,
+	}
+	if datasource == "" {
+		dashboard["__inputs"] = []jsonObject{{
+			"name":     "DS_INFLUXDB",
+			"label":    "InfluxDB",
+			"type":     "datasource",
+			"pluginId": "influxdb",
+		}}
+	}
+	return dashboard
+}
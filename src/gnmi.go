@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "go-netstat/src/gnmipb"
+)
+
+// GNMIType serves the results over gNMI, under the tree of the netcheck
+// YANG module in src/gnmipb/netcheck.yang. With a username, callers must
+// send it and the password as "username" and "password" metadata, as gNMI
+// clients do; with certFile and keyFile the listener uses TLS.
+type GNMIType struct {
+	Listen   string `yaml:"listen"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+const (
+	gnmiVersion = "0.7.0"
+	// yangRevision is the revision of the netcheck YANG module.
+	yangRevision = "2026-10-15"
+	// minSampleInterval bounds how often a SAMPLE subscription is sent.
+	minSampleInterval = time.Second
+	// defaultSampleInterval is the interval of SAMPLE subscriptions
+	// leaving it to the target.
+	defaultSampleInterval = 10 * time.Second
+)
+
+type gnmiServer struct {
+	pb.UnimplementedGNMIServer
+}
+
+func startGNMI(cfg GNMIType) (*grpc.Server, error) {
+	password, err := resolveSecret(cfg.Password)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving gnmi password: %s", err)
+	}
+	if cfg.Username == "" && !isLoopback(cfg.Listen) {
+		return nil, fmt.Errorf("gnmi.listen %s is not a loopback address, set gnmi.username", cfg.Listen)
+	}
+	var auth func(ctx context.Context) error
+	if cfg.Username != "" {
+		auth = func(ctx context.Context) error {
+			return checkCredentials(ctx, cfg.Username, password)
+		}
+	}
+	opts, err := grpcServerOptions(cfg.CertFile, cfg.KeyFile, auth)
+	if err != nil {
+		return nil, err
+	}
+	l, err := listenTCP(cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %s", cfg.Listen, err)
+	}
+	server := grpc.NewServer(opts...)
+	pb.RegisterGNMIServer(server, &gnmiServer{})
+	go func() {
+		if err := server.Serve(l); err != nil {
+			log.Error(fmt.Sprintf("gNMI server stopped: %s", err))
+		}
+	}()
+	log.WithFields(log.Fields{"Address": cfg.Listen}).Info("gNMI server listening")
+	return server, nil
+}
+
+func checkCredentials(ctx context.Context, username string, password string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	users, passwords := md.Get("username"), md.Get("password")
+	if len(users) == 1 && len(passwords) == 1 &&
+		subtle.ConstantTimeCompare([]byte(users[0]), []byte(username)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(passwords[0]), []byte(password)) == 1 {
+		return nil
+	}
+	return status.Error(codes.Unauthenticated, "invalid username or password")
+}
+
+// checkEncoding accepts the encodings whose scalar leaves go as typed
+// values, which is every leaf of the tree.
+func checkEncoding(encoding pb.Encoding) error {
+	switch encoding {
+	case pb.Encoding_JSON, pb.Encoding_JSON_IETF, pb.Encoding_PROTO:
+		return nil
+	}
+	return status.Error(codes.Unimplemented, fmt.Sprintf("unsupported encoding %s", encoding))
+}
+
+func (g *gnmiServer) Capabilities(ctx context.Context, req *pb.CapabilityRequest) (*pb.CapabilityResponse, error) {
+	return &pb.CapabilityResponse{
+		SupportedModels:    []*pb.ModelData{{Name: "netcheck", Organization: "go-netstat", Version: yangRevision}},
+		SupportedEncodings: []pb.Encoding{pb.Encoding_JSON, pb.Encoding_JSON_IETF, pb.Encoding_PROTO},
+		GNMIVersion:        gnmiVersion,
+	}, nil
+}
+
+func (g *gnmiServer) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	if err := checkEncoding(req.Encoding); err != nil {
+		return nil, err
+	}
+	patterns := gnmiPatterns(req.Prefix, req.Path)
+	reply := &pb.GetResponse{Notification: gnmiSnapshot(req.Prefix.GetTarget(), patterns, nil)}
+	if len(reply.Notification) == 0 && len(req.Path) > 0 {
+		return nil, status.Error(codes.NotFound, "no data at the requested paths")
+	}
+	return reply, nil
+}
+
+// Subscribe serves the three modes: ONCE sends the latest values, POLL
+// sends them again on every poll, and STREAM sends them, then each result
+// as it is written for ON_CHANGE and TARGET_DEFINED subscriptions, and
+// the latest values every sample interval for SAMPLE ones.
+func (g *gnmiServer) Subscribe(stream pb.GNMI_SubscribeServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	list := req.GetSubscribe()
+	if list == nil {
+		return status.Error(codes.InvalidArgument, "the first request must be a subscription list")
+	}
+	if err := checkEncoding(list.Encoding); err != nil {
+		return err
+	}
+	target := list.Prefix.GetTarget()
+	paths := make([]*pb.Path, len(list.Subscription))
+	for i, sub := range list.Subscription {
+		paths[i] = sub.Path
+	}
+	patterns := gnmiPatterns(list.Prefix, paths)
+	send := func(notifications []*pb.Notification) error {
+		for _, n := range notifications {
+			if err := stream.Send(&pb.SubscribeResponse{Response: &pb.SubscribeResponse_Update{Update: n}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	sync := func() error {
+		return stream.Send(&pb.SubscribeResponse{Response: &pb.SubscribeResponse_SyncResponse{SyncResponse: true}})
+	}
+	if !list.UpdatesOnly {
+		if err := send(gnmiSnapshot(target, patterns, nil)); err != nil {
+			return err
+		}
+	}
+	if err := sync(); err != nil {
+		return err
+	}
+	switch list.Mode {
+	case pb.SubscriptionList_ONCE:
+		return nil
+	case pb.SubscriptionList_POLL:
+		for {
+			req, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if req.GetPoll() == nil {
+				return status.Error(codes.InvalidArgument, "only polls may follow a POLL subscription")
+			}
+			if err := send(gnmiSnapshot(target, patterns, nil)); err != nil {
+				return err
+			}
+			if err := sync(); err != nil {
+				return err
+			}
+		}
+	}
+	return streamGNMI(stream.Context(), list, target, send)
+}
+
+// streamGNMI sends the updates of a STREAM subscription until ctx is done.
+func streamGNMI(ctx context.Context, list *pb.SubscriptionList, target string, send func([]*pb.Notification) error) error {
+	var onChange [][]*pb.PathElem
+	var samples []*pb.Subscription
+	for _, sub := range list.Subscription {
+		if sub.Mode == pb.SubscriptionMode_SAMPLE {
+			samples = append(samples, sub)
+		} else {
+			onChange = append(onChange, gnmiPatterns(list.Prefix, []*pb.Path{sub.Path})...)
+		}
+	}
+	if len(list.Subscription) == 0 {
+		onChange = gnmiPatterns(list.Prefix, nil)
+	}
+	results := subscribeResults()
+	defer unsubscribeResults(results)
+	due := make(chan int)
+	for i, sub := range samples {
+		interval := time.Duration(sub.SampleInterval)
+		if interval == 0 {
+			interval = defaultSampleInterval
+		}
+		if interval < minSampleInterval {
+			interval = minSampleInterval
+		}
+		go func(i int, interval time.Duration) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					select {
+					case due <- i:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}(i, interval)
+	}
+	// sent is when each SAMPLE subscription last sent a series, for
+	// suppress_redundant.
+	sent := make([]map[string]time.Time, len(samples))
+	for i := range sent {
+		sent[i] = make(map[string]time.Time)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case res := <-results:
+			if len(onChange) == 0 {
+				continue
+			}
+			if n := gnmiNotification(target, res, onChange); n != nil {
+				if err := send([]*pb.Notification{n}); err != nil {
+					return err
+				}
+			}
+		case i := <-due:
+			sub := samples[i]
+			var seen map[string]time.Time
+			if sub.SuppressRedundant {
+				seen = sent[i]
+			}
+			if err := send(gnmiSnapshot(target, gnmiPatterns(list.Prefix, []*pb.Path{sub.Path}), seen)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// gnmiPatterns joins the prefix to each path; no path is the whole tree.
+func gnmiPatterns(prefix *pb.Path, paths []*pb.Path) [][]*pb.PathElem {
+	if len(paths) == 0 {
+		paths = []*pb.Path{nil}
+	}
+	patterns := make([][]*pb.PathElem, len(paths))
+	for i, path := range paths {
+		patterns[i] = append(append([]*pb.PathElem(nil), prefix.GetElem()...), path.GetElem()...)
+	}
+	return patterns
+}
+
+// matchPath tells whether path is under one of the patterns: names match
+// or are "*", "..." matches any number of elements, and the keys a
+// pattern element has match or are "*".
+func matchPath(patterns [][]*pb.PathElem, path []*pb.PathElem) bool {
+	for _, pattern := range patterns {
+		if matchElems(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchElems(pattern []*pb.PathElem, path []*pb.PathElem) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	if pattern[0].Name == "..." {
+		for i := 0; i <= len(path); i++ {
+			if matchElems(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 || (pattern[0].Name != "*" && pattern[0].Name != path[0].Name) {
+		return false
+	}
+	for k, v := range pattern[0].Key {
+		if v != "*" && path[0].Key[k] != v {
+			return false
+		}
+	}
+	return matchElems(pattern[1:], path[1:])
+}
+
+// gnmiSnapshot returns the latest values under the patterns: the local
+// site, then a notification per series. With seen, series whose result
+// was already sent are left out, and seen is updated.
+func gnmiSnapshot(target string, patterns [][]*pb.PathElem, seen map[string]time.Time) []*pb.Notification {
+	var notifications []*pb.Notification
+	if n := gnmiLocal(target, patterns); n != nil {
+		// The local leaves do not change while the agent runs.
+		if _, sent := seen["local"]; !sent {
+			notifications = append(notifications, n)
+			if seen != nil {
+				seen["local"] = time.Now()
+			}
+		}
+	}
+	if sched == nil {
+		return notifications
+	}
+	sites := sched.list()
+	sort.Slice(sites, func(i, j int) bool { return sites[i].key() < sites[j].key() })
+	for _, site := range sites {
+		for _, res := range siteResults(site.key()) {
+			if seen != nil {
+				key := site.key() + " " + res.Measurement + "," + seriesKey(res.Tags)
+				if !seen[key].Before(res.Time) {
+					continue
+				}
+				seen[key] = res.Time
+			}
+			if n := gnmiNotification(target, res, patterns); n != nil {
+				notifications = append(notifications, n)
+			}
+		}
+	}
+	return notifications
+}
+
+// gnmiLocal returns the /netcheck/state leaves describing the agent.
+func gnmiLocal(target string, patterns [][]*pb.PathElem) *pb.Notification {
+	configLock.RLock()
+	local := configData.LocalSite
+	configLock.RUnlock()
+	prefix := []*pb.PathElem{{Name: "netcheck"}, {Name: "state"}}
+	n := &pb.Notification{Timestamp: time.Now().UnixNano(), Prefix: &pb.Path{Target: target, Elem: prefix}}
+	for _, leaf := range []struct{ name, value string }{
+		{"region", local.Region},
+		{"site", local.Site},
+		{"hostname", hostname},
+		{"version", version},
+	} {
+		if matchPath(patterns, append(prefix, &pb.PathElem{Name: leaf.name})) {
+			n.Update = append(n.Update, &pb.Update{
+				Path: &pb.Path{Elem: []*pb.PathElem{{Name: leaf.name}}},
+				Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: leaf.value}},
+			})
+		}
+	}
+	if len(n.Update) == 0 {
+		return nil
+	}
+	return n
+}
+
+// gnmiNotification returns the fields of res under the patterns, at
+// /netcheck/paths/path[region][site]/measurements/measurement[name][series]/state,
+// or nil when none is.
+func gnmiNotification(target string, res ResultType, patterns [][]*pb.PathElem) *pb.Notification {
+	res = redactResult(res)
+	prefix := []*pb.PathElem{
+		{Name: "netcheck"},
+		{Name: "paths"},
+		{Name: "path", Key: map[string]string{"region": res.Region, "site": res.Site}},
+		{Name: "measurements"},
+		{Name: "measurement", Key: map[string]string{"name": res.Measurement, "series": seriesLabel(res.Tags)}},
+		{Name: "state"},
+	}
+	names := make([]string, 0, len(res.Fields))
+	for k := range res.Fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	n := &pb.Notification{Timestamp: res.Time.UnixNano(), Prefix: &pb.Path{Target: target, Elem: prefix}}
+	for _, name := range names {
+		val := typedValue(res.Fields[name])
+		if val == nil || !matchPath(patterns, append(prefix, &pb.PathElem{Name: name})) {
+			continue
+		}
+		n.Update = append(n.Update, &pb.Update{Path: &pb.Path{Elem: []*pb.PathElem{{Name: name}}}, Val: val})
+	}
+	if len(n.Update) == 0 {
+		return nil
+	}
+	return n
+}
+
+func typedValue(v interface{}) *pb.TypedValue {
+	switch n := v.(type) {
+	case float64:
+		return &pb.TypedValue{Value: &pb.TypedValue_DoubleVal{DoubleVal: n}}
+	case int64:
+		return &pb.TypedValue{Value: &pb.TypedValue_IntVal{IntVal: n}}
+	case int:
+		return &pb.TypedValue{Value: &pb.TypedValue_IntVal{IntVal: int64(n)}}
+	case uint:
+		return &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: uint64(n)}}
+	case bool:
+		return &pb.TypedValue{Value: &pb.TypedValue_BoolVal{BoolVal: n}}
+	case string:
+		return &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: n}}
+	}
+	return nil
+}
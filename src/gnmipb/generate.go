@@ -0,0 +1,5 @@
+// Package gnmipb holds the generated code for the subset of the gNMI
+// service the agent serves.
+package gnmipb
+
+//go:generate protoc --go_out=paths=source_relative:. --go-grpc_out=paths=source_relative:. gnmi.proto
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	log "github.com/sirupsen/logrus"
+)
+
+// GossipType joins the agents into a memberlist gossip cluster. Every
+// member shares its site and reflector, and each client probes every
+// member with a reflector, so the mesh follows nodes as they come and go.
+type GossipType struct {
+	Bind      string   `yaml:"bind"`
+	Advertise string   `yaml:"advertise"`
+	Join      []string `yaml:"join"`
+	SecretKey string   `yaml:"secretKey"`
+}
+
+// gossipMeta is what a member tells the others about itself.
+type gossipMeta struct {
+	Region string `json:"region"`
+	Site   string `json:"site"`
+	Port   uint   `json:"port,omitempty"`
+	Role   string `json:"role"`
+}
+
+// gossipMember is this agent's membership.
+type gossipMember struct {
+	list    *memberlist.Memberlist
+	meta    []byte
+	changes chan struct{}
+}
+
+var gossip *gossipMember
+
+// startGossip joins the cluster through any reachable address of
+// cfg.Join; with none reachable the agent waits to be joined by others.
+func startGossip(cfg *GossipType) (*gossipMember, error) {
+	meta, err := json.Marshal(gossipMeta{
+		Region: configData.LocalSite.Region,
+		Site:   configData.LocalSite.Site,
+		Port:   configData.listenPort(),
+		Role:   configData.role(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	g := &gossipMember{meta: meta, changes: make(chan struct{}, 1)}
+	mc := memberlist.DefaultLANConfig()
+	mc.Name = configData.LocalSite.key()
+	mc.Delegate = g
+	mc.Events = g
+	mc.LogOutput = log.StandardLogger().WriterLevel(log.DebugLevel)
+	if cfg.Bind != "" {
+		if mc.BindAddr, mc.BindPort, err = splitGossipAddr(cfg.Bind); err != nil {
+			return nil, err
+		}
+		mc.AdvertisePort = mc.BindPort
+	}
+	if cfg.Advertise != "" {
+		if mc.AdvertiseAddr, mc.AdvertisePort, err = splitGossipAddr(cfg.Advertise); err != nil {
+			return nil, err
+		}
+	} else if ip := net.ParseIP(configData.LocalSite.Address); ip != nil {
+		mc.AdvertiseAddr = ip.String()
+	}
+	if cfg.SecretKey != "" {
+		secret, err := resolveSecret(cfg.SecretKey)
+		if err != nil {
+			return nil, err
+		}
+		if mc.SecretKey, err = base64.StdEncoding.DecodeString(secret); err != nil {
+			return nil, fmt.Errorf("gossip secretKey is not base64: %s", err)
+		}
+	}
+	if g.list, err = memberlist.Create(mc); err != nil {
+		return nil, fmt.Errorf("starting gossip: %s", err)
+	}
+	if len(cfg.Join) > 0 {
+		n, err := g.list.Join(cfg.Join)
+		if err != nil {
+			log.WithFields(log.Fields{"Join": cfg.Join}).Warn(fmt.Sprintf("Failed to join gossip cluster: %s", err))
+		} else {
+			log.Info(fmt.Sprintf("Joined gossip cluster through %d nodes", n))
+		}
+	}
+	gossip = g
+	return g, nil
+}
+
+func splitGossipAddr(addr string) (string, int, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid gossip address %s: %s", addr, err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid gossip port %s", port)
+	}
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	return host, p, nil
+}
+
+// leave announces the departure and shuts the membership down.
+func (g *gossipMember) leave() {
+	if err := g.list.Leave(5 * time.Second); err != nil {
+		log.Warn(fmt.Sprintf("Failed to leave gossip cluster: %s", err))
+	}
+	g.list.Shutdown()
+}
+
+func (g *gossipMember) NodeMeta(limit int) []byte {
+	return g.meta
+}
+
+func (g *gossipMember) NotifyMsg([]byte)                           {}
+func (g *gossipMember) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+func (g *gossipMember) LocalState(join bool) []byte                { return nil }
+func (g *gossipMember) MergeRemoteState(buf []byte, join bool)     {}
+
+func (g *gossipMember) NotifyJoin(node *memberlist.Node)   { g.changed() }
+func (g *gossipMember) NotifyLeave(node *memberlist.Node)  { g.changed() }
+func (g *gossipMember) NotifyUpdate(node *memberlist.Node) { g.changed() }
+
+func (g *gossipMember) changed() {
+	select {
+	case g.changes <- struct{}{}:
+	default:
+	}
+}
+
+// gossipSites is the discovery source listing the cluster's reflectors.
+type gossipSites struct{}
+
+func (gossipSites) name() string {
+	return "gossip"
+}
+
+func (gossipSites) discover() ([]SiteType, error) {
+	if gossip == nil {
+		return nil, fmt.Errorf("gossip is not running")
+	}
+	var sites []SiteType
+	for _, node := range gossip.list.Members() {
+		var meta gossipMeta
+		if err := json.Unmarshal(node.Meta, &meta); err != nil {
+			log.WithFields(log.Fields{"Node": node.Name}).Debug(fmt.Sprintf("Ignoring gossip member: %s", err))
+			continue
+		}
+		if meta.Role == "client" {
+			continue
+		}
+		sites = append(sites, SiteType{Region: meta.Region, Site: meta.Site, Address: node.Addr.String(), Port: meta.Port})
+	}
+	return sites, nil
+}
+
+func (gossipSites) watch(changes chan<- struct{}, stop <-chan struct{}) {
+	if gossip == nil {
+		return
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-gossip.changes:
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GrafanaType configures annotations written to Grafana when a site goes
+// down or comes back, so graphs show when it happened. Without a
+// dashboardUid they are organization wide and show on any dashboard with
+// an annotation query for their tags.
+type GrafanaType struct {
+	URL          string   `yaml:"url"`
+	Token        string   `yaml:"token"`
+	DashboardUID string   `yaml:"dashboardUid"`
+	Tags         []string `yaml:"tags"`
+}
+
+type grafanaAnnotation struct {
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	Time         int64    `json:"time"`
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
+}
+
+type annotator struct {
+	cfg    GrafanaType
+	token  string
+	events chan EventType
+	client *http.Client
+}
+
+func newAnnotator(cfg GrafanaType) (*annotator, error) {
+	token, err := resolveSecret(cfg.Token)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving grafana token: %s", err)
+	}
+	return &annotator{cfg: cfg, token: token, events: subscribeEvents(), client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// run annotates state and path events until stop is closed.
+func (a *annotator) run(stop <-chan struct{}) {
+	defer unsubscribeEvents(a.events)
+	for {
+		select {
+		case <-stop:
+			return
+		case event := <-a.events:
+			annotation, ok := a.annotation(event)
+			if !ok {
+				continue
+			}
+			if err := a.post(annotation); err != nil {
+				log.WithFields(log.Fields{"URL": a.cfg.URL}).Error(fmt.Sprintf("Failed to write Grafana annotation: %s", err))
+			}
+		}
+	}
+}
+
+// annotation describes a state or path event, tagged with netcheck, the
+// configured tags, both sites and the new state or "path".
+func (a *annotator) annotation(event EventType) (grafanaAnnotation, bool) {
+	var remote SiteType
+	var kind, text string
+	configLock.RLock()
+	local := configData.LocalSite
+	configLock.RUnlock()
+	switch data := event.Data.(type) {
+	case stateEvent:
+		remote = SiteType{Region: data.Region, Site: data.Site}
+		kind = data.To
+		text = fmt.Sprintf("%s → %s is %s, was %s", local.key(), remote.key(), data.To, data.From)
+		if data.Summary != "" {
+			text += ": " + data.Summary
+		}
+	case pathEvent:
+		remote = SiteType{Region: data.Region, Site: data.Site}
+		kind = "path"
+		text = fmt.Sprintf("Route %s → %s changed: %s", local.key(), remote.key(), strings.Join(data.Hops, " "))
+	default:
+		return grafanaAnnotation{}, false
+	}
+	tags := append([]string{"netcheck"}, a.cfg.Tags...)
+	tags = append(tags, "from:"+local.key(), "to:"+remote.key(), kind)
+	return grafanaAnnotation{
+		DashboardUID: a.cfg.DashboardUID,
+		Time:         event.Time.UnixNano() / int64(time.Millisecond),
+		Tags:         tags,
+		Text:         text,
+	}, true
+}
+
+func (a *annotator) post(annotation grafanaAnnotation) error {
+	body, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(a.cfg.URL, "/")+"/api/annotations", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("grafana returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "go-netstat/src/controlpb"
+)
+
+type GRPCType struct {
+	Listen   string `yaml:"listen"`
+	Token    string `yaml:"token"`
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+type controlServer struct {
+	pb.UnimplementedControlServer
+}
+
+// startGRPC serves the control service. With a token, callers must send
+// "authorization: Bearer <token>" metadata; with certFile and keyFile the
+// listener uses TLS. Without a token it only listens on loopback, as
+// SetSites would be open to anyone.
+func startGRPC(cfg GRPCType) (*grpc.Server, error) {
+	token, err := resolveSecret(cfg.Token)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving grpc token: %s", err)
+	}
+	if token == "" && !isLoopback(cfg.Listen) {
+		return nil, fmt.Errorf("grpc.listen %s is not a loopback address, set grpc.token", cfg.Listen)
+	}
+	var auth func(ctx context.Context) error
+	if token != "" {
+		auth = func(ctx context.Context) error {
+			return checkToken(ctx, token)
+		}
+	}
+	opts, err := grpcServerOptions(cfg.CertFile, cfg.KeyFile, auth)
+	if err != nil {
+		return nil, err
+	}
+	l, err := listenTCP(cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %s", cfg.Listen, err)
+	}
+	server := grpc.NewServer(opts...)
+	pb.RegisterControlServer(server, &controlServer{})
+	go func() {
+		if err := server.Serve(l); err != nil {
+			log.Error(fmt.Sprintf("gRPC server stopped: %s", err))
+		}
+	}()
+	log.WithFields(log.Fields{"Address": cfg.Listen}).Info("gRPC control service listening")
+	return server, nil
+}
+
+// grpcServerOptions returns the options of a gRPC server: TLS with a
+// certFile, and auth run before every call when not nil.
+func grpcServerOptions(certFile string, keyFile string, auth func(ctx context.Context) error) ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+	if certFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading grpc certificate: %s", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	if auth != nil {
+		opts = append(opts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				if err := auth(ctx); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := auth(ss.Context()); err != nil {
+					return err
+				}
+				return handler(srv, ss)
+			}))
+	}
+	return opts, nil
+}
+
+func checkToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid token")
+}
+
+func toPBSite(site SiteType, paused bool) *pb.Site {
+	return &pb.Site{
+		Address: redact(site.Address),
+		Region:  site.Region,
+		Site:    site.Site,
+		Tags:    redactTags(site.Tags),
+		Type:    site.Type,
+		Port:    uint32(site.Port),
+		Paused:  paused,
+	}
+}
+
+func toPBResult(r ResultType) *pb.Result {
+	r = redactResult(r)
+	fields := make(map[string]float64, len(r.Fields))
+	for k, v := range r.Fields {
+		switch n := v.(type) {
+		case float64:
+			fields[k] = n
+		case int64:
+			fields[k] = float64(n)
+		case int:
+			fields[k] = float64(n)
+		case uint:
+			fields[k] = float64(n)
+		case bool:
+			if n {
+				fields[k] = 1
+			} else {
+				fields[k] = 0
+			}
+		}
+	}
+	return &pb.Result{
+		Region:       r.Region,
+		Site:         r.Site,
+		Measurement:  r.Measurement,
+		Tags:         r.Tags,
+		Fields:       fields,
+		TimeUnixNano: r.Time.UnixNano(),
+	}
+}
+
+func (c *controlServer) Status(ctx context.Context, req *pb.StatusRequest) (*pb.StatusReply, error) {
+	reply := &pb.StatusReply{Hostname: hostname, Role: configData.role(), Version: version}
+	configLock.RLock()
+	reply.LocalSite = toPBSite(configData.LocalSite, false)
+	configLock.RUnlock()
+	if sched == nil {
+		return reply, nil
+	}
+	sites := sched.list()
+	for _, site := range sites {
+		status := &pb.SiteStatus{Site: toPBSite(site, sched.isPaused(site.key()))}
+		for _, r := range siteResults(site.key()) {
+			status.Results = append(status.Results, toPBResult(r))
+		}
+		reply.Sites = append(reply.Sites, status)
+	}
+	return reply, nil
+}
+
+func (c *controlServer) StreamResults(req *pb.StreamResultsRequest, stream pb.Control_StreamResultsServer) error {
+	want := make(map[string]bool, len(req.Sites))
+	for _, key := range req.Sites {
+		want[key] = true
+	}
+	ch := subscribeResults()
+	defer unsubscribeResults(ch)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case r := <-ch:
+			if len(want) > 0 && !want[r.Region+"/"+r.Site] {
+				continue
+			}
+			if err := stream.Send(toPBResult(r)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// SetSites replaces the remote site list until the next config reload.
+func (c *controlServer) SetSites(ctx context.Context, req *pb.SetSitesRequest) (*pb.SetSitesReply, error) {
+	if sched == nil {
+		return nil, status.Error(codes.FailedPrecondition, "agent does not run probes")
+	}
+	sites := make([]SiteType, 0, len(req.Sites))
+	for _, s := range req.Sites {
+		if s.Address == "" || s.Region == "" || s.Site == "" {
+			return nil, status.Error(codes.InvalidArgument, "address, region and site are required")
+		}
+		sites = append(sites, SiteType{
+			Address: s.Address,
+			Region:  s.Region,
+			Site:    s.Site,
+			Tags:    s.Tags,
+			Type:    s.Type,
+			Port:    uint(s.Port),
+		})
+	}
+	sched.setSites(sites)
+	for _, s := range req.Sites {
+		sched.setPaused(s.Region+"/"+s.Site, s.Paused)
+	}
+	log.WithFields(log.Fields{"Sites": len(sites)}).Info("Site list replaced via gRPC")
+	return &pb.SetSitesReply{Count: uint32(len(sites))}, nil
+}
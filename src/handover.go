@@ -0,0 +1,131 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// upgradeSignals start a handover.
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+// handoverTimeout bounds how long the new process may take to be ready.
+const handoverTimeout = time.Minute
+
+// handover starts the executable, the upgraded binary when it was
+// replaced, with the same arguments, passing it the listening sockets so
+// no probe goes unanswered, and waits until it is ready. The caller then
+// shuts down. On error the new process is gone and this one carries on.
+func handover() (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	socketsLock.Lock()
+	var names []string
+	var files []*os.File
+	for _, name := range socketsOrder {
+		f, err := socketFile(sockets[name], name)
+		if err != nil {
+			socketsLock.Unlock()
+			closeFiles(files)
+			return 0, fmt.Errorf("error passing %s: %s", name, err)
+		}
+		names = append(names, name)
+		files = append(files, f)
+	}
+	socketsLock.Unlock()
+	defer closeFiles(files)
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	var env []string
+	for _, e := range os.Environ() {
+		// The new process pings the watchdog itself.
+		if !strings.HasPrefix(e, "WATCHDOG_PID=") {
+			env = append(env, e)
+		}
+	}
+	env = append(env,
+		handoverEnv+"="+strings.Join(names, ","),
+		readyEnv+"="+strconv.Itoa(3+len(files)))
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = env
+	cmd.ExtraFiles = append(files, w)
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return 0, err
+	}
+	w.Close()
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := r.Read(buf); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("it exited")
+			}
+			ready <- err
+			return
+		}
+		ready <- nil
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(handoverTimeout):
+		err = fmt.Errorf("not ready after %s", handoverTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, fmt.Errorf("new process %d failed: %s", cmd.Process.Pid, err)
+	}
+	pid := cmd.Process.Pid
+	// Not waited for: it outlives this process.
+	cmd.Process.Release()
+	return pid, nil
+}
+
+// socketFile duplicates the descriptor of s. Unlike File on the socket, it
+// leaves the descriptor, shared with the new process, in non-blocking mode:
+// a reader here stuck in a blocking read would take a packet and, once the
+// socket is closed, drop it.
+func socketFile(s syscall.Conn, name string) (*os.File, error) {
+	rc, err := s.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	fd := -1
+	var dupErr error
+	err = rc.Control(func(sysfd uintptr) {
+		// Not to leak into another child started meanwhile.
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if fd, dupErr = syscall.Dup(int(sysfd)); dupErr == nil {
+			syscall.CloseOnExec(fd)
+		}
+	})
+	if err == nil {
+		err = dupErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+var upgradeSignals []os.Signal
+
+func handover() (int, error) {
+	return 0, fmt.Errorf("socket handover is not supported on Windows, restart the service instead")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// reflectorsRunning counts the UDP reflector loops currently serving.
+var reflectorsRunning int32
+
+// healthType is the body of /healthz and /readyz: "ok" or a problem per
+// check.
+type healthType struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// checkHealth runs the liveness checks, plus the exporter check when
+// ready is set.
+func checkHealth(ready bool) healthType {
+	health := healthType{Status: "ok", Checks: make(map[string]string)}
+	fail := func(name string, problem string) {
+		health.Status = "fail"
+		health.Checks[name] = problem
+	}
+	cfg := currentConfig()
+	if cfg.role() != "client" {
+		want := int32(len(cfg.listenAddresses()))
+		if running := atomic.LoadInt32(&reflectorsRunning); running < want {
+			fail("listeners", fmt.Sprintf("%d of %d reflectors running", running, want))
+		} else {
+			health.Checks["listeners"] = "ok"
+		}
+	}
+	if sched != nil {
+		if stalled := sched.stalled(); stalled > 0 {
+			fail("scheduler", fmt.Sprintf("no progress for %s", stalled.Truncate(time.Second)))
+		} else {
+			health.Checks["scheduler"] = "ok"
+		}
+		if ready {
+			if err := sched.writer.ready(); err != nil {
+				fail("exporter", err.Error())
+			} else {
+				health.Checks["exporter"] = "ok"
+			}
+		}
+	}
+	return health
+}
+
+func healthHandler(ready bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health := checkHealth(ready)
+		status := http.StatusOK
+		if health.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, health)
+	}
+}
+
+// stalled returns how long the scheduler has gone without finishing a
+// site check when that is longer than three periods (at least five
+// minutes), else zero.
+func (s *schedulerType) stalled() time.Duration {
+	s.lock.Lock()
+	since := clock.Since(s.progress)
+	s.lock.Unlock()
+	limit := 3 * time.Duration(currentConfig().Period) * time.Second
+	if limit < 5*time.Minute {
+		limit = 5 * time.Minute
+	}
+	if since > limit {
+		return since
+	}
+	return 0
+}
+
+func (s *schedulerType) touch() {
+	s.lock.Lock()
+	s.progress = clock.Now()
+	s.lock.Unlock()
+}
+
+// ready checks that InfluxDB answers. Without a client (dry run) there is
+// nothing to check.
+func (w *writerSwitch) ready() error {
+	w.RLock()
+	client := w.client
+	w.RUnlock()
+	if client == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	ok, err := client.Ready(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("influxdb is not ready")
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// runHealthcheck implements "netcheck healthcheck": it asks the local
+// agent's health endpoint and exits 0 when healthy, 1 otherwise, for
+// container HEALTHCHECK instructions.
+func runHealthcheck(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	fs.Usage = commandUsage("healthcheck", fs)
+	url := fs.String("url", "", "Health endpoint, default from admin.listen in the config")
+	ready := fs.Bool("ready", false, "Check /readyz instead of /healthz")
+	timeout := fs.Duration("timeout", 5*time.Second, "Give up after this long")
+	fs.StringVar(&configFile, "config", configFile, "Config file to read admin.listen from")
+	fs.Parse(args)
+	if *url == "" {
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", configFile, err)
+			return 1
+		}
+		base, err := adminURL(cfg)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		path := "/healthz"
+		if *ready {
+			path = "/readyz"
+		}
+		*url = base + path
+	}
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Get(*url)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(stderr, "%s: %s\n", *url, resp.Status)
+		return 1
+	}
+	return 0
+}
+
+// adminURL is the local agent's admin API address from admin.listen, with
+// a wildcard host replaced by the loopback address.
+func adminURL(cfg ConfigType) (string, error) {
+	if cfg.Admin.Listen == "" {
+		return "", fmt.Errorf("admin.listen is not set, use -url")
+	}
+	host, port, err := net.SplitHostPort(cfg.Admin.Listen)
+	if err != nil {
+		return "", fmt.Errorf("invalid admin.listen %s: %s", cfg.Admin.Listen, err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port), nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fieldStats summarizes one numeric field over a time range.
+type fieldStats struct {
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+	Max float64 `json:"max"`
+	// Count is the number of values, which is less than that of the
+	// results when some do not have the field.
+	Count int `json:"-"`
+}
+
+// count returns the number of values of f in b. Buckets saved before
+// fields were counted have the count of the results.
+func (f fieldStats) count(b historyBucket) int {
+	if f.Count == 0 {
+		return b.Count
+	}
+	return f.Count
+}
+
+type historySeries struct {
+	Tags   map[string]string     `json:"tags"`
+	Count  int                   `json:"count"`
+	First  time.Time             `json:"first"`
+	Last   time.Time             `json:"last"`
+	Fields map[string]fieldStats `json:"fields"`
+}
+
+type historyReply struct {
+	Region      string          `json:"region"`
+	Site        string          `json:"site"`
+	Measurement string          `json:"measurement"`
+	From        time.Time       `json:"from"`
+	To          time.Time       `json:"to"`
+	Resolution  string          `json:"resolution,omitempty"`
+	Series      []historySeries `json:"series"`
+}
+
+// parseTime reads a query time: RFC 3339, or a duration meaning that long
+// before now ("1h", "7d"). Empty gives def.
+func parseTime(value string, now time.Time, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if d, err := parseRetention(value); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %s, expected RFC 3339 or a duration", value)
+	}
+	return t, nil
+}
+
+// handleHistory answers GET /api/sites/{region}/{site}/history with the
+// min, avg and max of every numeric field per series kept locally for the
+// site, between ?from= and ?to= (default: all of it) for ?measurement=
+// (default rtt). RTT and jitter are in microseconds, loss in percent. With
+// the history store the finest tier still covering from is used, and its
+// resolution returned.
+func handleHistory(w http.ResponseWriter, r *http.Request, site SiteType) {
+	q := r.URL.Query()
+	now := time.Now()
+	from, err := parseTime(q.Get("from"), now, time.Time{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTime(q.Get("to"), now, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	measurement := q.Get("measurement")
+	if measurement == "" {
+		measurement = "rtt"
+	}
+	reply := historyReply{Region: site.Region, Site: site.Site, Measurement: measurement, From: from, To: to, Series: []historySeries{}}
+	var found []seriesBuckets
+	if store != nil {
+		var resolution time.Duration
+		resolution, found = store.query(site.key(), measurement, from, to)
+		reply.Resolution = "raw"
+		if resolution > 0 {
+			reply.Resolution = resolution.String()
+		}
+	} else {
+		found = recentBuckets(site.key(), measurement, from, to)
+	}
+	for _, series := range found {
+		reply.Series = append(reply.Series, summarize(series))
+	}
+	sort.Slice(reply.Series, func(i, j int) bool {
+		return seriesKey(reply.Series[i].Tags) < seriesKey(reply.Series[j].Tags)
+	})
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// recentBuckets turns the results kept in memory for the dashboard into
+// one bucket per result, for agents without the history store.
+func recentBuckets(site string, measurement string, from time.Time, to time.Time) []seriesBuckets {
+	series := make(map[string]*seriesBuckets)
+	var list []*seriesBuckets
+	for _, res := range siteHistory(site, measurement) {
+		if res.Time.Before(from) || res.Time.After(to) {
+			continue
+		}
+		key := seriesKey(res.Tags)
+		s := series[key]
+		if s == nil {
+			s = &seriesBuckets{Tags: res.Tags}
+			series[key] = s
+			list = append(list, s)
+		}
+		b := historyBucket{Time: res.Time, Fields: make(map[string]fieldStats)}
+		b.add(res.Fields)
+		s.Buckets = append(s.Buckets, b)
+	}
+	found := make([]seriesBuckets, 0, len(list))
+	for _, s := range list {
+		found = append(found, *s)
+	}
+	return found
+}
+
+// summarize combines the buckets of a series, weighting averages by count.
+func summarize(series seriesBuckets) historySeries {
+	s := historySeries{Tags: series.Tags, Fields: make(map[string]fieldStats)}
+	counts := make(map[string]int)
+	for _, b := range series.Buckets {
+		if s.Count == 0 {
+			s.First = b.Time
+		}
+		s.Last = b.Time
+		s.Count += b.Count
+		for name, f := range b.Fields {
+			stats, seen := s.Fields[name]
+			if !seen {
+				stats = fieldStats{Min: math.Inf(1), Max: math.Inf(-1)}
+			}
+			stats.Min = math.Min(stats.Min, f.Min)
+			stats.Max = math.Max(stats.Max, f.Max)
+			stats.Avg += f.Avg * float64(f.count(b))
+			counts[name] += f.count(b)
+			s.Fields[name] = stats
+		}
+	}
+	for name, stats := range s.Fields {
+		stats.Avg /= float64(counts[name])
+		stats.Count = counts[name]
+		s.Fields[name] = stats
+	}
+	return s
+}
+
+func seriesKey(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		keys = append(keys, k+"="+v)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// seriesLabel tells the series of a site apart: its tags but the path
+// ones, as sorted "key=value" pairs.
+func seriesLabel(tags map[string]string) string {
+	var series []string
+	for k, v := range tags {
+		switch k {
+		case "region1", "site1", "region2", "site2":
+		default:
+			series = append(series, k+"="+v)
+		}
+	}
+	sort.Strings(series)
+	return strings.Join(series, " ")
+}
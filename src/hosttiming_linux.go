@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// From linux/net_tstamp.h, which x/sys/unix leaves out.
+const (
+	sofTimestampingTxSoftware = 1 << 1
+	sofTimestampingRxSoftware = 1 << 3
+	sofTimestampingSoftware   = 1 << 4
+	sofTimestampingOptID      = 1 << 7
+	sofTimestampingOptTsonly  = 1 << 11
+)
+
+// enableHostTiming has the kernel timestamp the packets of fd as the
+// driver sends and receives them, and report the socket's drops. The send
+// timestamps carry the number of the packet on the socket, from zero.
+func enableHostTiming(fd int) error {
+	flags := sofTimestampingTxSoftware | sofTimestampingRxSoftware | sofTimestampingSoftware | sofTimestampingOptID | sofTimestampingOptTsonly
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPING, flags); err != nil {
+		return err
+	}
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RXQ_OVFL, 1)
+}
+
+// resetTxID numbers the packets sent on conn from zero again: the kernel
+// restarts the count when the option is turned back on.
+func resetTxID(conn *net.UDPConn) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return
+	}
+	raw.Control(func(fd uintptr) {
+		unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_TIMESTAMPING, 0)
+		enableHostTiming(int(fd))
+	})
+}
+
+// txTimestamp returns when the driver sent packet id of conn, see
+// resetTxID, from the socket's error queue. Timestamps of earlier packets
+// that came too late for their probe are skipped. The timestamp may trail
+// the write a little, so the queue is polled for up to a millisecond.
+func txTimestamp(conn *net.UDPConn, id uint32) (time.Time, bool) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return time.Time{}, false
+	}
+	var stamp time.Time
+	buf := make([]byte, 64)
+	oob := make([]byte, 256)
+	for wait := 0; wait < 10 && stamp.IsZero(); wait++ {
+		if wait > 0 {
+			time.Sleep(100 * time.Microsecond)
+		}
+		raw.Read(func(fd uintptr) bool {
+			for {
+				_, oobn, _, _, err := unix.Recvmsg(int(fd), buf, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+				if err != nil {
+					return true
+				}
+				t, ok := parseTimestamping(oob[:oobn])
+				if key, keyed := timestampKey(oob[:oobn]); ok && keyed && key == id {
+					stamp = t
+				}
+			}
+		})
+	}
+	return stamp, !stamp.IsZero()
+}
+
+// timestampKey returns the number of the packet a send timestamp is for,
+// from the extended error that comes with it.
+func timestampKey(oob []byte) (uint32, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, m := range msgs {
+		recvErr := m.Header.Level == unix.SOL_IP && m.Header.Type == unix.IP_RECVERR || m.Header.Level == unix.SOL_IPV6 && m.Header.Type == unix.IPV6_RECVERR
+		if recvErr && len(m.Data) >= int(unsafe.Sizeof(unix.SockExtendedErr{})) {
+			ee := (*unix.SockExtendedErr)(unsafe.Pointer(&m.Data[0]))
+			if ee.Origin == unix.SO_EE_ORIGIN_TIMESTAMPING {
+				return ee.Data, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// rxTimestamp finds when the driver received a packet and the drops of
+// the socket so far in the packet's control messages.
+func rxTimestamp(oob []byte) (time.Time, uint32) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	var drops uint32
+	for _, m := range msgs {
+		if m.Header.Level == unix.SOL_SOCKET && m.Header.Type == unix.SO_RXQ_OVFL && len(m.Data) >= 4 {
+			drops = *(*uint32)(unsafe.Pointer(&m.Data[0]))
+		}
+	}
+	stamp, _ := parseTimestamping(oob)
+	return stamp, drops
+}
+
+// parseTimestamping returns the software timestamp of an
+// SCM_TIMESTAMPING control message.
+func parseTimestamping(oob []byte) (time.Time, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, m := range msgs {
+		if m.Header.Level == unix.SOL_SOCKET && m.Header.Type == unix.SCM_TIMESTAMPING && len(m.Data) >= int(unsafe.Sizeof(unix.Timespec{})) {
+			ts := (*unix.Timespec)(unsafe.Pointer(&m.Data[0]))
+			if ts.Sec != 0 || ts.Nsec != 0 {
+				return time.Unix(int64(ts.Sec), int64(ts.Nsec)), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// hostDrops returns the host's UDP buffer errors and backlog drops since
+// boot, from /proc.
+func hostDrops() map[string]interface{} {
+	fields := make(map[string]interface{})
+	if f, err := os.Open("/proc/net/snmp"); err == nil {
+		defer f.Close()
+		var header []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.Fields(scanner.Text())
+			if len(line) == 0 || line[0] != "Udp:" {
+				continue
+			}
+			// A header line, then the values.
+			if header == nil {
+				header = line
+				continue
+			}
+			for i, name := range header {
+				field := map[string]string{"RcvbufErrors": "udp_rcvbuf_errors", "SndbufErrors": "udp_sndbuf_errors", "InErrors": "udp_in_errors"}[name]
+				if field != "" && i < len(line) {
+					if v, err := strconv.ParseInt(line[i], 10, 64); err == nil {
+						fields[field] = v
+					}
+				}
+			}
+		}
+	}
+	if f, err := os.Open("/proc/net/softnet_stat"); err == nil {
+		defer f.Close()
+		var dropped int64
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// One line per CPU, the second column counts the packets
+			// dropped for a full backlog, in hex.
+			line := strings.Fields(scanner.Text())
+			if len(line) > 1 {
+				v, _ := strconv.ParseInt(line[1], 16, 64)
+				dropped += v
+			}
+		}
+		fields["softnet_drops"] = dropped
+	}
+	return fields
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"net"
+	"time"
+)
+
+func resetTxID(conn *net.UDPConn) {}
+
+func txTimestamp(conn *net.UDPConn, id uint32) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func rxTimestamp(oob []byte) (time.Time, uint32) {
+	return time.Time{}, 0
+}
+
+func hostDrops() map[string]interface{} {
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// icmpEcho sends count ICMP echo requests to ip, gap apart, and returns
+// the round trip times of those answered within a second. A raw socket is
+// used when permitted, else an unprivileged ICMP socket. zone is the
+// interface of link-local addresses.
+func icmpEcho(ctx context.Context, ip net.IP, zone string, count int, gap time.Duration) ([]time.Duration, error) {
+	network, proto, echo := "ip4:icmp", 1, icmp.Type(ipv4.ICMPTypeEcho)
+	dgram := "udp4"
+	if ip.To4() == nil {
+		network, proto, echo = "ip6:ipv6-icmp", 58, ipv6.ICMPTypeEchoRequest
+		dgram = "udp6"
+	}
+	var dst net.Addr = &net.IPAddr{IP: ip, Zone: zone}
+	conn, err := icmp.ListenPacket(network, "")
+	if err != nil {
+		if conn, err = icmp.ListenPacket(dgram, ""); err != nil {
+			return nil, err
+		}
+		dst = &net.UDPAddr{IP: ip, Zone: zone}
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+	id := os.Getpid() & 0xffff
+	// Raw sockets see every echo reply on the host, so replies are
+	// matched by a token unique to this call.
+	token := []byte(strconv.FormatInt(time.Now().UnixNano(), 36))
+	buf := make([]byte, 1500)
+	var rtts []time.Duration
+	for seq := 0; seq < count; seq++ {
+		if seq > 0 {
+			if !sleepCtx(ctx, gap) {
+				return rtts, ctx.Err()
+			}
+		}
+		msg := icmp.Message{Type: echo, Body: &icmp.Echo{ID: id, Seq: seq, Data: token}}
+		pkt, err := msg.Marshal(nil)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		if _, err := conn.WriteTo(pkt, dst); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(start.Add(time.Second))
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			reply, err := icmp.ParseMessage(proto, buf[:n])
+			if err != nil || reply.Type == echo {
+				continue
+			}
+			// Unprivileged sockets rewrite the ID, so only the
+			// sequence number and token are compared.
+			if body, ok := reply.Body.(*icmp.Echo); ok && body.Seq == seq && string(body.Data) == string(token) {
+				rtts = append(rtts, time.Since(start))
+				break
+			}
+		}
+	}
+	return rtts, nil
+}
+
+// probeICMP measures the ICMP echo RTT to ip, with the site's probe count
+// and pace, and writes it as an "rtt" point tagged proto icmp.
+func probeICMP(ctx context.Context, API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, ip net.IP, extra map[string]string) {
+	count := remoteSite.probeCount()
+	rtts, err := icmpEcho(ctx, ip, "", count, sched.probeGap(remoteSite, currentConfig().Adaptive))
+	atomic.AddUint64(&telemetry.probes, uint64(count))
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		siteLog(remoteSite).Debug(fmt.Sprintf("Failed to ping %s: %s", ip, err))
+		return
+	}
+	atomic.AddUint64(&telemetry.timeouts, uint64(count-len(rtts)))
+	tags := siteTags(localSite, remoteSite)
+	for k, v := range extra {
+		tags[k] = v
+	}
+	tags["proto"] = "icmp"
+	if len(rtts) == 0 {
+		siteLog(remoteSite).Debug(fmt.Sprintf("No echo reply from %s", ip))
+		writeOutage(API, remoteSite, tags)
+		return
+	}
+	var sum time.Duration
+	minRTT, maxRTT := rtts[0], rtts[0]
+	for _, rtt := range rtts {
+		sum += rtt
+		if rtt < minRTT {
+			minRTT = rtt
+		}
+		if rtt > maxRTT {
+			maxRTT = rtt
+		}
+	}
+	avgRTT := (sum / time.Duration(len(rtts))).Microseconds()
+	siteLog(remoteSite).WithFields(log.Fields{"Client": ip.String()}).Debug(fmt.Sprintf("ICMP RTT is %d microsec, Jitter is %d microsec", avgRTT, (maxRTT - minRTT).Microseconds()))
+	writeResult(API, "rtt", remoteSite, tags, map[string]interface{}{"avg": avgRTT, "jitter": (maxRTT - minRTT).Microseconds()})
+}
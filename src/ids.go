@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// newID returns a random identifier for a session or cycle.
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sessionID returns the ID of the path to site. It is made up the first
+// time it is asked for and kept until the agent stops or the site is
+// removed, so a restart shows up as a new session.
+func (s *schedulerType) sessionID(site SiteType) string {
+	if s == nil {
+		return ""
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id, ok := s.sessions[site.key()]
+	if !ok {
+		id = newID()
+		s.sessions[site.key()] = id
+	}
+	return id
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	influx "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	log "github.com/sirupsen/logrus"
+)
+
+type InfluxWriteType struct {
+	Precision     string            `yaml:"precision"`
+	BatchSize     uint              `yaml:"batchSize"`
+	FlushInterval uint              `yaml:"flushInterval"`
+	DefaultTags   map[string]string `yaml:"defaultTags"`
+	Measurement   string            `yaml:"measurement"`
+	Measurements  map[string]string `yaml:"measurements"`
+	TagNames      map[string]string `yaml:"tagNames"`
+}
+
+func newInfluxClient(url string, token string, cfg InfluxWriteType) (influx.Client, error) {
+	opts := influx.DefaultOptions()
+	if cfg.Precision != "" {
+		precision, err := time.ParseDuration(cfg.Precision)
+		if err != nil {
+			return nil, fmt.Errorf("invalid precision %s: %s", cfg.Precision, err)
+		}
+		opts.SetPrecision(precision)
+	}
+	if cfg.BatchSize > 0 {
+		opts.SetBatchSize(cfg.BatchSize)
+	}
+	if cfg.FlushInterval > 0 {
+		opts.SetFlushInterval(cfg.FlushInterval)
+	}
+	for k, v := range cfg.DefaultTags {
+		opts.AddDefaultTag(k, v)
+	}
+	httpClient := opts.HTTPClient()
+	httpClient.Transport = writeCounter{httpClient.Transport}
+	return influx.NewClientWithOptions(url, token, opts), nil
+}
+
+// writeCounter counts the points of the write requests InfluxDB accepts
+// and of those it rejects, which the client does not retry. The client
+// only reports the errors.
+type writeCounter struct {
+	next http.RoundTripper
+}
+
+func (w writeCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || !strings.HasSuffix(req.URL.Path, "/write") {
+		return w.next.RoundTrip(req)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp, err := w.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	switch {
+	case resp.StatusCode/100 == 2:
+		atomic.AddUint64(&telemetry.pointsWritten, countLines(body, req.Header.Get("Content-Encoding")))
+	case resp.StatusCode < http.StatusTooManyRequests:
+		atomic.AddUint64(&telemetry.pointsDropped, countLines(body, req.Header.Get("Content-Encoding")))
+	}
+	return resp, nil
+}
+
+func (w writeCounter) CloseIdleConnections() {
+	if c, ok := w.next.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// countLines returns the number of points in a line protocol body.
+func countLines(body []byte, encoding string) uint64 {
+	if encoding == "gzip" {
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return 0
+		}
+		if body, err = ioutil.ReadAll(r); err != nil {
+			return 0
+		}
+	}
+	n := uint64(bytes.Count(body, []byte("\n")))
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		n++
+	}
+	return n
+}
+
+var hostname, _ = os.Hostname()
+
+// siteTags returns the tags for a point measured from localSite to
+// remoteSite: global tags, then per-site tags, then the built-in region and
+// site tags, later ones taking precedence.
+func siteTags(localSite SiteType, remoteSite SiteType) map[string]string {
+	cfg := currentConfig()
+	tags := make(map[string]string)
+	for k, v := range cfg.Tags {
+		tags[k] = v
+	}
+	for k, v := range remoteSite.Tags {
+		tags[k] = v
+	}
+	if cfg.TagHostname && hostname != "" {
+		tags["host"] = hostname
+	}
+	if cfg.TagVersion {
+		tags["version"] = version
+	}
+	if cfg.Adaptive.Policy != "" {
+		tags["rate"] = probeRate(sched.probeGap(remoteSite, cfg.Adaptive))
+	}
+	tags["region1"] = localSite.Region
+	tags["region2"] = remoteSite.Region
+	tags["site1"] = localSite.Site
+	tags["site2"] = remoteSite.Site
+	return tags
+}
+
+// newPoint builds a point using the configured measurement name and tag
+// key renames, so the schema can match existing dashboards, after applying
+// the tagLimits rules. measurement is the kind of metric (rtt, loss, ...);
+// measurements maps a kind to its own name, otherwise measurement names a
+// single one for everything.
+func newPoint(measurement string, tags map[string]string, fields map[string]interface{}, ts time.Time) *write.Point {
+	cfg := currentConfig().InfluxWrite
+	tags = limitTags(tags)
+	if name, ok := cfg.Measurements[measurement]; ok {
+		measurement = name
+	} else if cfg.Measurement != "" {
+		measurement = cfg.Measurement
+	}
+	if len(cfg.TagNames) > 0 {
+		renamed := make(map[string]string, len(tags))
+		for k, v := range tags {
+			if name, ok := cfg.TagNames[k]; ok {
+				k = name
+			}
+			renamed[k] = v
+		}
+		tags = renamed
+	}
+	return influx.NewPoint(measurement, tags, fields, ts)
+}
+
+// logWriter is a WriteAPI that logs points in line protocol instead of
+// sending them anywhere, for -dry-run. target names the destination
+// when it is not the default one.
+type logWriter struct {
+	target string
+}
+
+func (l logWriter) WriteRecord(line string) {
+	atomic.AddUint64(&telemetry.pointsWritten, 1)
+	if l.target != "" {
+		log.Info(fmt.Sprintf("Would write to %s %s", l.target, strings.TrimSpace(line)))
+		return
+	}
+	log.Info(fmt.Sprintf("Would write %s", strings.TrimSpace(line)))
+}
+
+func (l logWriter) WritePoint(point *write.Point) {
+	l.WriteRecord(write.PointToLineProtocol(point, time.Nanosecond))
+}
+
+func (logWriter) Flush() {}
+
+func (logWriter) Errors() <-chan error {
+	return nil
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// IPFIXType exports the rtt results of every cycle to an IPFIX collector,
+// one flow-like data record per path, so NetFlow/IPFIX collectors can keep
+// path quality next to flow data. The measured values go in information
+// elements of the enterprise, a private enterprise number.
+type IPFIXType struct {
+	Collector       string `yaml:"collector"`
+	Protocol        string `yaml:"protocol"`
+	Enterprise      uint32 `yaml:"enterprise"`
+	DomainID        uint32 `yaml:"domainId"`
+	TemplateRefresh uint   `yaml:"templateRefresh"`
+}
+
+const (
+	ipfixVersion     = 10
+	ipfixTemplateSet = 2
+	ipfixTemplateV4  = 256
+	ipfixTemplateV6  = 257
+	// ipfixVarLen is the length of variable-length elements in templates.
+	ipfixVarLen = 65535
+	// maxIPFIXDatagram keeps UDP messages within an Ethernet MTU.
+	maxIPFIXDatagram       = 1400
+	defaultTemplateRefresh = 600
+)
+
+func (c IPFIXType) protocol() string {
+	if c.Protocol == "" {
+		return "udp"
+	}
+	return c.Protocol
+}
+
+func (c IPFIXType) templateRefresh() time.Duration {
+	if c.TemplateRefresh == 0 {
+		return defaultTemplateRefresh * time.Second
+	}
+	return time.Duration(c.TemplateRefresh) * time.Second
+}
+
+// ipfixField is a field specifier of the templates.
+type ipfixField struct {
+	id         uint16
+	length     uint16
+	enterprise bool
+}
+
+// ipfixTemplate returns the fields of the records of a family, in order.
+// The standard elements are those of IANA, the enterprise ones are:
+//
+//	1 rtt, unsigned32, microseconds
+//	2 jitter, unsigned32, microseconds
+//	3 loss, float64, percent
+//	4 reachable, boolean
+//	5-8 region1, site1, region2, site2, string
+//	9 cycle, string
+func ipfixTemplate(v6 bool) []ipfixField {
+	src, dst, addrLen := uint16(8), uint16(12), uint16(4)
+	if v6 {
+		src, dst, addrLen = 27, 28, 16
+	}
+	return []ipfixField{
+		{id: 152, length: 8}, // flowStartMilliseconds
+		{id: 153, length: 8}, // flowEndMilliseconds
+		{id: src, length: addrLen},
+		{id: dst, length: addrLen},
+		{id: 4, length: 1},  // protocolIdentifier
+		{id: 11, length: 2}, // destinationTransportPort
+		{id: 1, length: 4, enterprise: true},
+		{id: 2, length: 4, enterprise: true},
+		{id: 3, length: 8, enterprise: true},
+		{id: 4, length: 1, enterprise: true},
+		{id: 5, length: ipfixVarLen, enterprise: true},
+		{id: 6, length: ipfixVarLen, enterprise: true},
+		{id: 7, length: ipfixVarLen, enterprise: true},
+		{id: 8, length: ipfixVarLen, enterprise: true},
+		{id: 9, length: ipfixVarLen, enterprise: true},
+	}
+}
+
+// ipfixTemplates renders the template set of both families.
+func ipfixTemplates(enterprise uint32) []byte {
+	set := []byte{0, ipfixTemplateSet, 0, 0}
+	for _, id := range []uint16{ipfixTemplateV4, ipfixTemplateV6} {
+		fields := ipfixTemplate(id == ipfixTemplateV6)
+		set = appendUint16(set, id)
+		set = appendUint16(set, uint16(len(fields)))
+		for _, f := range fields {
+			if f.enterprise {
+				set = appendUint16(set, f.id|0x8000)
+				set = appendUint16(set, f.length)
+				set = appendUint32(set, enterprise)
+			} else {
+				set = appendUint16(set, f.id)
+				set = appendUint16(set, f.length)
+			}
+		}
+	}
+	binary.BigEndian.PutUint16(set[2:], uint16(len(set)))
+	return set
+}
+
+// ipfixRecord is the data record of one rtt result.
+type ipfixRecord struct {
+	time                           time.Time
+	src, dst                       net.IP
+	proto                          uint8
+	port                           uint16
+	rtt, jitter                    uint32
+	loss                           float64
+	reachable                      bool
+	region1, site1, region2, site2 string
+	cycle                          string
+}
+
+func (r ipfixRecord) template() uint16 {
+	if r.dst.To4() == nil {
+		return ipfixTemplateV6
+	}
+	return ipfixTemplateV4
+}
+
+// newIPFIXRecord builds the record of res. Results whose destination
+// address is not known yet have none.
+func newIPFIXRecord(res ResultType) (ipfixRecord, bool) {
+	site, ok := sched.find(SiteType{Region: res.Region, Site: res.Site}.key())
+	if !ok {
+		return ipfixRecord{}, false
+	}
+	dst := net.ParseIP(res.Tags["dst_ip"])
+	if dst == nil {
+		dst = resolvedAddress(site)
+	}
+	if dst == nil {
+		return ipfixRecord{}, false
+	}
+	v6 := dst.To4() == nil
+	src := net.ParseIP(res.Tags["src_ip"])
+	if src == nil {
+		if addr, err := sourceAddr(site, dst); err == nil && addr != nil {
+			src = addr.IP
+		}
+	}
+	if src == nil || (src.To4() == nil) != v6 {
+		src = net.IPv4zero
+		if v6 {
+			src = net.IPv6zero
+		}
+	}
+	r := ipfixRecord{
+		time:      res.Time,
+		src:       src,
+		dst:       dst,
+		proto:     17,
+		region1:   res.Tags["region1"],
+		site1:     res.Tags["site1"],
+		region2:   res.Region,
+		site2:     res.Site,
+		cycle:     res.Cycle,
+		reachable: true,
+	}
+	switch res.Tags["proto"] {
+	case "tcp":
+		r.proto = 6
+	case "icmp":
+		r.proto = 1
+		if v6 {
+			r.proto = 58
+		}
+	}
+	if r.proto != 1 && r.proto != 58 {
+		port, err := strconv.ParseUint(res.Tags["port"], 10, 16)
+		if err != nil {
+			port = uint64(site.Port)
+			if port == 0 {
+				port = uint64(configData.Port)
+			}
+		}
+		r.port = uint16(port)
+	}
+	if avg, ok := res.Fields["avg"].(int64); ok {
+		r.rtt = uint32(avg)
+	}
+	if jitter, ok := res.Fields["jitter"].(int64); ok {
+		r.jitter = uint32(jitter)
+	}
+	r.loss, _ = res.Fields["loss"].(float64)
+	if reachable, ok := res.Fields["reachable"].(bool); ok {
+		r.reachable = reachable
+	}
+	return r, true
+}
+
+// encode appends the record to buf, in the order of ipfixTemplate.
+func (r ipfixRecord) encode(buf []byte) []byte {
+	ms := uint64(r.time.UnixNano() / int64(time.Millisecond))
+	buf = appendUint64(buf, ms)
+	buf = appendUint64(buf, ms)
+	if r.template() == ipfixTemplateV6 {
+		buf = append(append(buf, r.src.To16()...), r.dst.To16()...)
+	} else {
+		buf = append(append(buf, r.src.To4()...), r.dst.To4()...)
+	}
+	buf = append(buf, r.proto)
+	buf = appendUint16(buf, r.port)
+	buf = appendUint32(buf, r.rtt)
+	buf = appendUint32(buf, r.jitter)
+	buf = appendUint64(buf, math.Float64bits(r.loss))
+	// IPFIX booleans are 1 for true and 2 for false.
+	if r.reachable {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 2)
+	}
+	for _, s := range []string{r.region1, r.site1, r.region2, r.site2, r.cycle} {
+		if len(s) > ipfixVarLen {
+			s = s[:ipfixVarLen]
+		}
+		if len(s) < 255 {
+			buf = append(buf, byte(len(s)))
+		} else {
+			buf = appendUint16(append(buf, 255), uint16(len(s)))
+		}
+		buf = append(buf, s...)
+	}
+	return buf
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v>>32)), uint32(v))
+}
+
+// ipfixExporter sends the records of each cycle to the collector.
+type ipfixExporter struct {
+	cfg  IPFIXType
+	conn net.Conn
+	// seq is the number of data records sent so far, the sequence number
+	// of the next message.
+	seq uint32
+	// templates is when the templates were last sent.
+	templates time.Time
+}
+
+// runIPFIX collects the rtt results of each cycle and exports them when
+// the cycle ends, until stop is closed.
+func runIPFIX(stop <-chan struct{}) {
+	results := subscribeResults()
+	defer unsubscribeResults(results)
+	events := subscribeEvents()
+	defer unsubscribeEvents(events)
+	e := &ipfixExporter{}
+	defer e.close()
+	var pending []ResultType
+	for {
+		select {
+		case <-stop:
+			return
+		case res := <-results:
+			if res.Measurement == "rtt" {
+				pending = append(pending, res)
+			}
+		case event := <-events:
+			if event.Type != "cycle" {
+				continue
+			}
+			// The results of the cycle were all published before
+			// its event, some may not have been received yet.
+			for drained := false; !drained; {
+				select {
+				case res := <-results:
+					if res.Measurement == "rtt" {
+						pending = append(pending, res)
+					}
+				default:
+					drained = true
+				}
+			}
+			configLock.RLock()
+			cfg := configData.IPFIX
+			configLock.RUnlock()
+			e.export(cfg, pending)
+			pending = nil
+		}
+	}
+}
+
+func (e *ipfixExporter) close() {
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+}
+
+// export sends results as data records, after the templates on a new
+// connection and, over UDP, every templateRefresh.
+func (e *ipfixExporter) export(cfg IPFIXType, results []ResultType) {
+	if cfg.Collector == "" || len(results) == 0 {
+		return
+	}
+	if cfg != e.cfg {
+		e.close()
+		e.cfg = cfg
+	}
+	records := make([]ipfixRecord, 0, len(results))
+	for _, res := range results {
+		if r, ok := newIPFIXRecord(res); ok {
+			records = append(records, r)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].template() < records[j].template() })
+	logger := log.WithFields(log.Fields{"Collector": cfg.Collector, "Records": len(records)})
+	if dryRun {
+		logger.Info("Would export IPFIX records")
+		return
+	}
+	if e.conn == nil {
+		conn, err := net.DialTimeout(cfg.protocol(), cfg.Collector, 5*time.Second)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to connect to IPFIX collector: %s", err))
+			return
+		}
+		e.conn = conn
+		e.templates = time.Time{}
+	}
+	templates := e.templates.IsZero() || (cfg.protocol() == "udp" && time.Since(e.templates) >= cfg.templateRefresh())
+	maxSize := maxIPFIXDatagram
+	if cfg.protocol() == "tcp" {
+		maxSize = math.MaxUint16
+	}
+	for _, msg := range e.messages(records, templates, maxSize) {
+		e.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := e.conn.Write(msg); err != nil {
+			logger.Error(fmt.Sprintf("Failed to export IPFIX records: %s", err))
+			// Reconnected, and so sent the templates, next cycle.
+			e.close()
+			return
+		}
+	}
+	if templates {
+		e.templates = time.Now()
+	}
+	logger.Debug("Exported IPFIX records")
+}
+
+// messages packs the records, sorted by template, into messages of at most
+// maxSize bytes, the first starting with the template set when templates.
+func (e *ipfixExporter) messages(records []ipfixRecord, templates bool, maxSize int) [][]byte {
+	var msgs [][]byte
+	var msg []byte
+	var set int
+	var count uint32
+	start := func() {
+		msg = make([]byte, 16, maxSize)
+		set, count = 0, 0
+		if templates && len(msgs) == 0 {
+			msg = append(msg, ipfixTemplates(e.cfg.Enterprise)...)
+		}
+	}
+	endSet := func() {
+		if set > 0 {
+			binary.BigEndian.PutUint16(msg[set+2:], uint16(len(msg)-set))
+		}
+		set = 0
+	}
+	finish := func() {
+		endSet()
+		binary.BigEndian.PutUint16(msg[0:], ipfixVersion)
+		binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+		binary.BigEndian.PutUint32(msg[4:], uint32(time.Now().Unix()))
+		binary.BigEndian.PutUint32(msg[8:], e.seq)
+		binary.BigEndian.PutUint32(msg[12:], e.cfg.DomainID)
+		e.seq += count
+		msgs = append(msgs, msg)
+	}
+	start()
+	for _, r := range records {
+		data := r.encode(nil)
+		id := r.template()
+		newSet := set == 0 || binary.BigEndian.Uint16(msg[set:]) != id
+		size := len(data)
+		if newSet {
+			size += 4
+		}
+		if len(msg)+size > maxSize && count > 0 {
+			finish()
+			start()
+			newSet = true
+		}
+		if newSet {
+			endSet()
+			set = len(msg)
+			msg = append(appendUint16(msg, id), 0, 0)
+		}
+		msg = append(msg, data...)
+		count++
+	}
+	if count > 0 || templates {
+		finish()
+	}
+	return msgs
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubernetesType discovers peers from inside a cluster: the ready pods
+// behind a (headless) service, or every node when nodes is set, and with
+// targets the NetcheckTarget resources. The resources are watched, so
+// changes are picked up without waiting for the discovery interval.
+type KubernetesType struct {
+	Namespace   string `yaml:"namespace"`
+	Service     string `yaml:"service"`
+	Nodes       bool   `yaml:"nodes"`
+	Targets     bool   `yaml:"targets"`
+	Region      string `yaml:"region"`
+	RegionLabel string `yaml:"regionLabel"`
+
+	lock      sync.Mutex
+	transport *http.Transport
+}
+
+type kubeEndpoints struct {
+	Subsets []struct {
+		Addresses []struct {
+			IP        string `json:"ip"`
+			NodeName  string `json:"nodeName"`
+			TargetRef struct {
+				Name string `json:"name"`
+			} `json:"targetRef"`
+		} `json:"addresses"`
+		Ports []struct {
+			Port uint `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+type kubeNodes struct {
+	Items []struct {
+		Metadata struct {
+			Name   string            `json:"name"`
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+		Status struct {
+			Addresses []struct {
+				Type    string `json:"type"`
+				Address string `json:"address"`
+			} `json:"addresses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+func (k *KubernetesType) name() string {
+	return "kubernetes"
+}
+
+// open requests an API path with the pod's service account. A timeout
+// of 0 leaves the response open, for a watch.
+func (k *KubernetesType) open(path string, timeout time.Duration) (*http.Response, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+	k.lock.Lock()
+	if k.transport == nil {
+		ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+		if err != nil {
+			k.lock.Unlock()
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		k.transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+	client := &http.Client{Timeout: timeout, Transport: k.transport}
+	k.lock.Unlock()
+	// The token is rotated, so read it every time.
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", "https://"+net.JoinHostPort(host, port)+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
+// get reads an API path.
+func (k *KubernetesType) get(path string, v interface{}) error {
+	resp, err := k.open(path, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// kubeEvent is a watch event, of which only the resource version matters.
+type kubeEvent struct {
+	Type   string `json:"type"`
+	Object struct {
+		Code     int    `json:"code"`
+		Message  string `json:"message"`
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+	} `json:"object"`
+}
+
+// kubeQuery returns path with the query of params, selector's among them.
+func kubeQuery(path string, selector url.Values, params ...string) string {
+	q := url.Values{}
+	for k, v := range selector {
+		q[k] = v
+	}
+	for i := 0; i+1 < len(params); i += 2 {
+		q.Set(params[i], params[i+1])
+	}
+	return path + "?" + q.Encode()
+}
+
+// watchList watches the collection at path, selected by selector, and
+// signals changes on the events of the types counted. The watch starts at
+// the version of a list and resumes from the last event when the API
+// server ends it; when it fails or the version expired, the collection is
+// listed again and a change signalled, as events may have been missed.
+func (k *KubernetesType) watchList(path string, selector url.Values, counted map[string]bool, changes chan<- struct{}, stop <-chan struct{}) {
+	signal := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+	var version string
+	for listed := false; ; {
+		var err error
+		if version == "" {
+			var collection struct {
+				Metadata struct {
+					ResourceVersion string `json:"resourceVersion"`
+				} `json:"metadata"`
+			}
+			if err = k.get(kubeQuery(path, selector, "limit", "1"), &collection); err == nil {
+				if listed {
+					signal()
+				}
+				listed = true
+				version = collection.Metadata.ResourceVersion
+			}
+		}
+		if err == nil {
+			version, err = k.watchFrom(path, selector, version, counted, signal, stop)
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if err == nil {
+			continue
+		}
+		version = ""
+		log.WithFields(log.Fields{"Path": path}).Warn(fmt.Sprintf("Kubernetes watch interrupted, reconnecting: %s", err))
+		select {
+		case <-stop:
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// watchFrom watches the collection from version until the API server
+// ends the watch or stop is closed, and returns the version of the last
+// event, or none when the watch has to start over from a list.
+func (k *KubernetesType) watchFrom(path string, selector url.Values, version string, counted map[string]bool, signal func(), stop <-chan struct{}) (string, error) {
+	resp, err := k.open(kubeQuery(path, selector, "watch", "1", "allowWatchBookmarks", "true", "resourceVersion", version), 0)
+	if err != nil {
+		return version, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			resp.Body.Close()
+		case <-done:
+		}
+	}()
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event kubeEvent
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return version, nil
+			}
+			return version, err
+		}
+		if event.Type == "ERROR" {
+			if event.Object.Code == http.StatusGone {
+				// The version is too old to resume from: list again.
+				return "", nil
+			}
+			return version, fmt.Errorf("%s", event.Object.Message)
+		}
+		if v := event.Object.Metadata.ResourceVersion; v != "" {
+			version = v
+		}
+		if counted[event.Type] {
+			signal()
+		}
+	}
+}
+
+func (k *KubernetesType) namespace() string {
+	if k.Namespace != "" {
+		return k.Namespace
+	}
+	if ns, err := ioutil.ReadFile(serviceAccountDir + "/namespace"); err == nil {
+		return strings.TrimSpace(string(ns))
+	}
+	return "default"
+}
+
+// watch signals changes to the pods behind the service, or to the set of
+// nodes. Nodes are modified all the time by their status, so only nodes
+// added and deleted count.
+func (k *KubernetesType) watch(changes chan<- struct{}, stop <-chan struct{}) {
+	if k.Nodes {
+		k.watchList("/api/v1/nodes", nil, map[string]bool{"ADDED": true, "DELETED": true}, changes, stop)
+		return
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/endpoints", k.namespace())
+	k.watchList(path, url.Values{"fieldSelector": {"metadata.name=" + k.Service}}, map[string]bool{"ADDED": true, "MODIFIED": true, "DELETED": true}, changes, stop)
+}
+
+func (k *KubernetesType) region() string {
+	if k.Region != "" {
+		return k.Region
+	}
+	return "kubernetes"
+}
+
+// discover lists the peers. Pods are named after their node when known,
+// which is what a DaemonSet mesh wants; nodes take their region from
+// regionLabel (the zone label by default).
+func (k *KubernetesType) discover() ([]SiteType, error) {
+	var sites []SiteType
+	if k.Nodes {
+		var nodes kubeNodes
+		if err := k.get("/api/v1/nodes", &nodes); err != nil {
+			return nil, err
+		}
+		label := k.RegionLabel
+		if label == "" {
+			label = "topology.kubernetes.io/zone"
+		}
+		for _, node := range nodes.Items {
+			region := node.Metadata.Labels[label]
+			if region == "" {
+				region = k.region()
+			}
+			for _, addr := range node.Status.Addresses {
+				if addr.Type == "InternalIP" {
+					sites = append(sites, SiteType{Address: addr.Address, Region: region, Site: node.Metadata.Name})
+					break
+				}
+			}
+		}
+		return sites, nil
+	}
+	var endpoints kubeEndpoints
+	if err := k.get(fmt.Sprintf("/api/v1/namespaces/%s/endpoints/%s", k.namespace(), k.Service), &endpoints); err != nil {
+		return nil, err
+	}
+	for _, subset := range endpoints.Subsets {
+		var port uint
+		if len(subset.Ports) > 0 {
+			port = subset.Ports[0].Port
+		}
+		for _, addr := range subset.Addresses {
+			name := addr.NodeName
+			if name == "" {
+				name = addr.TargetRef.Name
+			}
+			if name == "" {
+				name = addr.IP
+			}
+			sites = append(sites, SiteType{Address: addr.IP, Region: k.region(), Site: name, Port: port})
+		}
+	}
+	return sites, nil
+}
+
+// kubeTargets discovers sites from NetcheckTarget custom resources, whose
+// spec is a remoteSites entry. Region defaults to the resource's
+// namespace and site to its name.
+type kubeTargets struct {
+	*KubernetesType
+}
+
+type kubeTargetList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec json.RawMessage `json:"spec"`
+	} `json:"items"`
+}
+
+func (k kubeTargets) name() string {
+	return "netchecktargets"
+}
+
+func (k kubeTargets) path() string {
+	if k.Namespace == "all" {
+		return "/apis/netcheck.io/v1alpha1/netchecktargets"
+	}
+	return fmt.Sprintf("/apis/netcheck.io/v1alpha1/namespaces/%s/netchecktargets", k.namespace())
+}
+
+// watch signals changes to the NetcheckTarget resources.
+func (k kubeTargets) watch(changes chan<- struct{}, stop <-chan struct{}) {
+	k.watchList(k.path(), nil, map[string]bool{"ADDED": true, "MODIFIED": true, "DELETED": true}, changes, stop)
+}
+
+func (k kubeTargets) discover() ([]SiteType, error) {
+	var targets kubeTargetList
+	if err := k.get(k.path(), &targets); err != nil {
+		return nil, err
+	}
+	var sites []SiteType
+	for _, item := range targets.Items {
+		var site SiteType
+		if err := yaml.Unmarshal(item.Spec, &site); err != nil {
+			return nil, fmt.Errorf("NetcheckTarget %s/%s: %s", item.Metadata.Namespace, item.Metadata.Name, err)
+		}
+		if site.Region == "" {
+			site.Region = item.Metadata.Namespace
+		}
+		if site.Site == "" {
+			site.Site = item.Metadata.Name
+		}
+		sites = append(sites, site)
+	}
+	return sites, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// handoverEnv names the sockets a process inherits from the one it
+// replaces, comma separated, as "udp <address>" or "tcp <address>": the
+// first is file descriptor 3, the next 4 and so on. readyEnv is the
+// descriptor of the pipe telling the old process the new one is ready.
+const (
+	handoverEnv = "NETCHECK_HANDOVER"
+	readyEnv    = "NETCHECK_HANDOVER_READY"
+)
+
+var (
+	inherited    = make(map[string]*os.File)
+	readyPipe    *os.File
+	sockets      = make(map[string]syscall.Conn)
+	socketsLock  sync.Mutex
+	socketsOrder []string
+)
+
+func init() {
+	names := os.Getenv(handoverEnv)
+	if names == "" {
+		return
+	}
+	for i, name := range strings.Split(names, ",") {
+		inherited[name] = os.NewFile(uintptr(3+i), name)
+	}
+	if fd, err := strconv.Atoi(os.Getenv(readyEnv)); err == nil {
+		readyPipe = os.NewFile(uintptr(fd), "handover")
+	}
+	// A later upgrade sets them again.
+	os.Unsetenv(handoverEnv)
+	os.Unsetenv(readyEnv)
+}
+
+// takeInherited returns the inherited socket with name, nil if none.
+func takeInherited(name string) *os.File {
+	socketsLock.Lock()
+	defer socketsLock.Unlock()
+	f := inherited[name]
+	delete(inherited, name)
+	return f
+}
+
+// registerSocket keeps a listening socket to hand over on upgrade.
+func registerSocket(name string, s syscall.Conn) {
+	socketsLock.Lock()
+	defer socketsLock.Unlock()
+	if _, ok := sockets[name]; !ok {
+		socketsOrder = append(socketsOrder, name)
+	}
+	sockets[name] = s
+}
+
+// listenTCP listens on address, or takes over the listener of the process
+// this one replaces.
+func listenTCP(address string) (net.Listener, error) {
+	name := "tcp " + address
+	if f := takeInherited(name); f != nil {
+		l, err := net.FileListener(f)
+		f.Close()
+		if err == nil {
+			log.WithFields(log.Fields{"Address": address}).Debug("Took over TCP listener")
+			registerSocket(name, l.(*net.TCPListener))
+			return l, nil
+		}
+		log.Warn(fmt.Sprintf("Failed to take over TCP listener %s: %s", address, err))
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	registerSocket(name, l.(*net.TCPListener))
+	return l, nil
+}
+
+// handoverReady tells the process this one replaces that it may stop, and
+// closes the inherited sockets no listener took, after a config change.
+func handoverReady() {
+	socketsLock.Lock()
+	for name, f := range inherited {
+		log.WithFields(log.Fields{"Socket": name}).Debug("Closing unused inherited socket")
+		f.Close()
+		delete(inherited, name)
+	}
+	socketsLock.Unlock()
+	if readyPipe == nil {
+		return
+	}
+	readyPipe.Write([]byte{1})
+	readyPipe.Close()
+	readyPipe = nil
+	// The unit's main process is this one from now on; systemd takes it
+	// with NotifyAccess=all.
+	sdNotify(fmt.Sprintf("MAINPID=%d", os.Getpid()))
+}
+
+func isUpgradeSignal(sig os.Signal) bool {
+	for _, s := range upgradeSignals {
+		if sig == s {
+			return true
+		}
+	}
+	return false
+}
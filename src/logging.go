@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+var (
+	logFormat     string
+	logOutput     string
+	logMaxSize    int
+	logMaxAge     int
+	logMaxBackups int
+	logCompress   bool
+	// logDest is where logs go once setupLogging ran, for restoring it
+	// after the terminal view.
+	logDest io.Writer = os.Stderr
+)
+
+func init() {
+	logFlags(flag.CommandLine)
+}
+
+// logFlags registers the logging flags on a command's flag set.
+func logFlags(fs *flag.FlagSet) {
+	fs.StringVar(&logFormat, "log-format", "text", "Log format: text or json")
+	fs.StringVar(&logOutput, "log-output", "stderr", "Write logs to stderr, stdout or the file given")
+	fs.IntVar(&logMaxSize, "log-max-size", 0, "Rotate the log file once it reaches this many megabytes")
+	fs.IntVar(&logMaxAge, "log-max-age", 0, "Remove rotated log files older than this many days")
+	fs.IntVar(&logMaxBackups, "log-max-backups", 0, "Keep at most this many rotated log files")
+	fs.BoolVar(&logCompress, "log-compress", false, "Gzip rotated log files")
+}
+
+// setupLogging applies -log-format and -log-output. A log file is rotated
+// when any of the rotation limits is set.
+func setupLogging() error {
+	switch logFormat {
+	case "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %s, expected text or json", logFormat)
+	}
+	switch logOutput {
+	case "", "stderr":
+		logDest = os.Stderr
+	case "stdout":
+		logDest = os.Stdout
+	default:
+		if logMaxSize > 0 || logMaxAge > 0 || logMaxBackups > 0 {
+			// Without -log-max-size the file is rotated at 100 megabytes.
+			logDest = &lumberjack.Logger{
+				Filename:   logOutput,
+				MaxSize:    logMaxSize,
+				MaxAge:     logMaxAge,
+				MaxBackups: logMaxBackups,
+				Compress:   logCompress,
+			}
+			break
+		}
+		f, err := os.OpenFile(logOutput, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("opening log file: %s", err)
+		}
+		logDest = f
+	}
+	log.SetOutput(logDest)
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/mdns"
+)
+
+const defaultMDNSService = "_netcheck._udp"
+
+// MDNSType discovers reflectors on the local network segment over mDNS.
+// With advertise the agent's own reflector is announced as well, with its
+// region and site in the TXT record.
+type MDNSType struct {
+	Service   string `yaml:"service"`
+	Advertise bool   `yaml:"advertise"`
+}
+
+func (m *MDNSType) service() string {
+	if m.Service != "" {
+		return m.Service
+	}
+	return defaultMDNSService
+}
+
+func (m *MDNSType) name() string {
+	return "mdns"
+}
+
+func (m *MDNSType) discover() ([]SiteType, error) {
+	iface, err := mdnsInterface()
+	if err != nil {
+		return nil, err
+	}
+	entries := make(chan *mdns.ServiceEntry, 32)
+	var sites []SiteType
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for entry := range entries {
+			if site, ok := mdnsSite(entry); ok {
+				sites = append(sites, site)
+			}
+		}
+	}()
+	err = mdns.Query(&mdns.QueryParam{
+		Service:   m.service(),
+		Domain:    "local",
+		Timeout:   2 * time.Second,
+		Interface: iface,
+		Entries:   entries,
+	})
+	close(entries)
+	<-done
+	if err != nil {
+		return nil, fmt.Errorf("mdns query for %s: %s", m.service(), err)
+	}
+	return sites, nil
+}
+
+// mdnsSite turns an announcement into a site, taking region and site from
+// the TXT record and falling back to the instance name for the site.
+func mdnsSite(entry *mdns.ServiceEntry) (SiteType, bool) {
+	site := SiteType{Port: uint(entry.Port)}
+	switch {
+	case entry.AddrV4 != nil:
+		site.Address = entry.AddrV4.String()
+	case entry.AddrV6 != nil:
+		site.Address = entry.AddrV6.String()
+	default:
+		return site, false
+	}
+	for _, field := range entry.InfoFields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "region":
+			site.Region = kv[1]
+		case "site":
+			site.Site = kv[1]
+		}
+	}
+	if site.Site == "" {
+		site.Site = strings.SplitN(entry.Name, ".", 2)[0]
+	}
+	return site, true
+}
+
+// mdnsInterface is the configured interface, if any, for multicast.
+func mdnsInterface() (*net.Interface, error) {
+	if configData.Interface == "" {
+		return nil, nil
+	}
+	return net.InterfaceByName(configData.Interface)
+}
+
+// mdnsAdvertiser answers mDNS queries for the local reflector until closed.
+type mdnsAdvertiser struct {
+	server *mdns.Server
+}
+
+func (a mdnsAdvertiser) Close() error {
+	return a.server.Shutdown()
+}
+
+// advertiseMDNS announces the local site's reflector on port.
+func advertiseMDNS(m *MDNSType, port uint) (mdnsAdvertiser, error) {
+	local := configData.LocalSite
+	instance := hostname
+	if instance == "" {
+		instance = local.Site
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(local.Address); ip != nil {
+		ips = append(ips, ip)
+	}
+	txt := []string{"region=" + local.Region, "site=" + local.Site}
+	zone, err := mdns.NewMDNSService(instance, m.service(), "local.", "", int(port), ips, txt)
+	if err != nil {
+		return mdnsAdvertiser{}, fmt.Errorf("mdns service: %s", err)
+	}
+	iface, err := mdnsInterface()
+	if err != nil {
+		return mdnsAdvertiser{}, err
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: zone, Iface: iface})
+	if err != nil {
+		return mdnsAdvertiser{}, fmt.Errorf("mdns server: %s", err)
+	}
+	return mdnsAdvertiser{server}, nil
+}
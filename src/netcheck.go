@@ -3,46 +3,35 @@ package main
 import (
 	"flag"
 	"fmt"
-	influx "github.com/influxdata/influxdb-client-go/v2"
-	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
-	"io/ioutil"
-	"net"
-	"strconv"
-	"time"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 var (
-	debug      bool
-	configFile string
-	configData ConfigType
+	debug        bool
+	tuiMode      bool
+	dryRun       bool
+	configFile   string
+	configFormat string
+	configData   ConfigType
+	// agentSignals takes the signals that stop or reload the agent, from
+	// the OS or from the Windows service manager.
+	agentSignals = make(chan os.Signal, 1)
 )
 
-type SiteType struct {
-	Address string `yaml:"address"`
-	Region  string `yaml:"region"`
-	Site    string `yaml:"site"`
-}
-type ConfigType struct {
-	Period       uint       `yaml:"period"`
-	LocalSite    SiteType   `yaml:"localSite"`
-	RemoteSites  []SiteType `yaml:"remoteSites"`
-	InfluxURL    string     `yaml:"influxUrl"`
-	Port         uint       `yaml:"port"`
-	InfluxBucket string     `yaml:"influxBucket"`
-	InfluxOrg    string     `yaml:"influxOrg"`
-	InfluxToken  string     `yaml:"influxToken"`
-}
-
-type TimestampType struct {
-	Received string
-	Current  string
-}
-
 func init() {
 	flag.BoolVar(&debug, "debug", false, "Use debug logging")
-	flag.StringVar(&configFile, "config", "/etc/netcheck/config.yaml", "Config file")
+	flag.BoolVar(&tuiMode, "tui", false, "Show a live table of sites in the terminal")
+	flag.BoolVar(&dryRun, "dry-run", false, "Probe as usual but log points instead of writing them")
+	defaultConfig, ok := os.LookupEnv("NETCHECK_CONFIG")
+	if !ok {
+		defaultConfig = "/etc/netcheck/config.yaml"
+	}
+	flag.StringVar(&configFile, "config", defaultConfig, "Config file, empty to configure from flags and environment only (env NETCHECK_CONFIG)")
+	flag.StringVar(&configFormat, "config-format", "auto", "Config file format: auto, yaml, json or toml")
 }
 
 func min(a int64, b int64) int64 {
@@ -59,121 +48,174 @@ func max(a int64, b int64) int64 {
 	return b
 }
 
-func startUDPServer(port uint) {
-	svc, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+// runAgent implements the run command: the scheduler, the reflector or
+// both depending on the role, until a signal stops it.
+func runAgent(args []string) int {
+	flag.CommandLine.Usage = commandUsage("run", flag.CommandLine)
+	flag.CommandLine.Parse(args)
+	if debug {
+		log.SetLevel(log.DebugLevel)
+	}
+	if err := setupLogging(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	log.WithFields(log.Fields{"Version": version, "Commit": commit}).Info("Starting netcheck")
+	var err error
+	configData, err = loadConfig(configFile)
 	if err != nil {
-		log.Fatal("Error listening socket")
+		log.Fatal(err)
+	}
+	if errs := validateConfig(configData); len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(fmt.Sprintf("%s: %s", configFile, err))
+		}
+		log.Fatal("Invalid config, see netcheck validate")
 	}
-	defer svc.Close()
-	buf := make([]byte, 9000)
-	for {
-		n, addr, err := svc.ReadFrom(buf)
+	publishReflector(configData)
+	if err := openVault(configData.Vault); err != nil {
+		log.Fatal(err)
+	}
+	if err := startProbeKey(configData.ProbeKey); err != nil {
+		log.Fatal(err)
+	}
+	stop := make(chan struct{})
+	// One reload waits while the scheduler is busy; a newer one replaces
+	// it, so signals are never held up.
+	reloads := make(chan ConfigType, 1)
+	done := make(chan struct{})
+	role := configData.role()
+	if cfg := configData.Discovery.Gossip; cfg != nil {
+		member, err := startGossip(cfg)
 		if err != nil {
-			log.Info("Error reading")
-			continue
+			log.Fatal(err)
 		}
-		go serve(svc, addr, buf[:n])
+		defer member.leave()
 	}
-
-}
-
-func serve(svc net.PacketConn, addr net.Addr, buf []byte) {
-	log.WithFields(log.Fields{"Client": addr.String()}).Debug(string(buf))
-	svc.WriteTo(buf, addr)
-}
-
-func readerFunc(c chan TimestampType, conn *net.UDPConn) {
-	buf := make([]byte, 9000)
-	n, _, err := conn.ReadFrom(buf)
-	ct := time.Now().UnixNano()
-	if err != nil {
-		log.Debug("Socket closed")
-		return
+	if role != "server" {
+		if done, err = startClient(reloads, stop); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		close(done)
+		if c := configData.Discovery.Coordinator; c != nil {
+			go runRegistration(c, stop)
+		}
 	}
-	res := TimestampType{Received: string(buf[:n]), Current: fmt.Sprintf("%d", ct)}
-	c <- res
-}
-func CheckSite(API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, port uint) {
-	var minRTT int64
-	var maxRTT int64
-	var avgRTT int64
-	var ts string
-	var timer *time.Timer
-	var res TimestampType
-	log.WithFields(log.Fields{"Region": remoteSite.Region, "Site": remoteSite.Site}).Debug(fmt.Sprintf("Checking %d", remoteSite.Address))
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", remoteSite.Address, port))
-	if err != nil {
-		log.WithFields(log.Fields{"Region": remoteSite.Region, "Site": remoteSite.Site}).Debug(fmt.Sprintf("Failed to parse %d:%d", remoteSite.Address, port))
-		return
+	var listeners []io.Closer
+	if role != "client" {
+		if listeners, err = startListeners(stop); err != nil {
+			log.Fatal(err)
+		}
 	}
-	svc, err := net.DialUDP("udp", nil, addr)
-
-	c := make(chan TimestampType)
-	minRTT = 0
-	maxRTT = 0
-	avgRTT = 0
-	for i := 0; i <= 9; i++ {
-		ts = strconv.FormatInt(time.Now().UnixNano(), 10)
-		svc.Write([]byte(ts))
-		timer = time.NewTimer(10 * time.Second)
-		go readerFunc(c, svc)
-		select {
-		case res = <-c:
-			log.WithFields(log.Fields{"Region": remoteSite.Region, "Site": remoteSite.Site}).Debug(fmt.Sprintf("Got response from %d", remoteSite.Address))
-		case <-timer.C:
-			log.WithFields(log.Fields{"Region": remoteSite.Region, "Site": remoteSite.Site}).Debug(fmt.Sprintf("Failed to get response from %d", remoteSite.Address))
+	if configData.Admin.Listen != "" {
+		if err := startAdmin(configData.Admin); err != nil {
+			log.Fatal(err)
 		}
-		if !timer.Stop() {
-			svc.Close()
-			log.WithFields(log.Fields{"Region": remoteSite.Region, "Site": remoteSite.Site}).Debug(fmt.Sprintf("Timeout on %d", remoteSite.Address))
-			return
+	}
+	if configData.GRPC.Listen != "" {
+		server, err := startGRPC(configData.GRPC)
+		if err != nil {
+			log.Fatal(err)
 		}
-		received, _ := strconv.ParseInt(res.Received, 10, 64)
-		current, _ := strconv.ParseInt(res.Current, 10, 64)
-		rtt := time.Unix(0, current).Sub(time.Unix(0, received)).Microseconds()
-		minRTT = min(minRTT, rtt)
-		maxRTT = max(maxRTT, rtt)
-		avgRTT += rtt
-		time.Sleep(time.Second)
-	}
-	avgRTT = int64(avgRTT / 10)
-	log.WithFields(log.Fields{"Client": addr.String()}).Debug(fmt.Sprintf("RTT is %d microsec, Jitter is %d microsec", avgRTT, maxRTT-minRTT))
-	p := influx.NewPoint("rtt", map[string]string{"region1": localSite.Region, "region2": remoteSite.Region, "site1": localSite.Site, "site2": remoteSite.Site}, map[string]interface{}{"avg": avgRTT, "jitter": maxRTT - minRTT}, time.Now())
-	API.WritePoint(p)
-}
-
-func main() {
-	flag.Parse()
-	if debug {
-		log.SetLevel(log.DebugLevel)
+		defer server.Stop()
 	}
-	configData.RemoteSites = make([]SiteType, 0)
-	cfg, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		log.Fatal("Failed to open config file")
+	if configData.GNMI.Listen != "" {
+		server, err := startGNMI(configData.GNMI)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer server.Stop()
 	}
-	err = yaml.Unmarshal(cfg, &configData)
-	if err != nil {
-		log.Fatal("error parsing file %s", err)
+	if err := dropPrivileges(configData.User, configData.Group); err != nil {
+		log.Fatal(err)
 	}
-	duration, err := time.ParseDuration(fmt.Sprintf("%ds", configData.Period))
-	if err != nil {
-		log.Fatal("error parsing period %s", err)
-	}
-	go startUDPServer(configData.Port)
-	client := influx.NewClient(configData.InfluxURL, configData.InfluxToken)
-	writeAPI := client.WriteAPI(configData.InfluxOrg, configData.InfluxBucket)
-	ticker := time.NewTicker(duration)
-	defer ticker.Stop()
-	if len(configData.RemoteSites) == 0 {
-		done := make(chan bool)
-		<-done
+	tuiDone := make(chan struct{})
+	if tuiMode && sched != nil {
+		logs := &tuiLog{}
+		log.SetOutput(logs)
+		go func() {
+			runTUI(logs, stop)
+			close(tuiDone)
+		}()
 	} else {
-		for {
-			<-ticker.C
-			for _, site := range configData.RemoteSites {
-				CheckSite(writeAPI, configData.LocalSite, site, configData.Port)
+		close(tuiDone)
+	}
+	signal.Notify(agentSignals, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}, upgradeSignals...)...)
+	changes := make(chan struct{})
+	if configData.WatchConfig {
+		if err := watchFiles(configData.files, changes, stop); err != nil {
+			log.Error(fmt.Sprintf("Failed to watch config files: %s", err))
+		}
+	}
+	handoverReady()
+	sdNotify("READY=1")
+	go runWatchdog(stop)
+	handedOver := false
+	for running := true; running; {
+		select {
+		case sig := <-agentSignals:
+			if isUpgradeSignal(sig) {
+				pid, err := handover()
+				if err != nil {
+					log.Error(fmt.Sprintf("Failed to hand over: %s", err))
+					continue
+				}
+				log.Info(fmt.Sprintf("Handed over to process %d, shutting down", pid))
+				handedOver = true
+				running = false
+				continue
+			}
+			if sig != syscall.SIGHUP {
+				log.Info(fmt.Sprintf("Received %s, shutting down", sig))
+				running = false
+				continue
+			}
+		case <-changes:
+			log.Info("Config files changed")
+		}
+		sdNotify("RELOADING=1")
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			log.Error(fmt.Sprintf("Failed to reload config: %s", err))
+			sdNotify("READY=1")
+			continue
+		}
+		if errs := validateConfig(cfg); len(errs) > 0 {
+			for _, err := range errs {
+				log.Error(fmt.Sprintf("%s: %s", configFile, err))
 			}
+			log.Error("Invalid config, not reloaded")
+			sdNotify("READY=1")
+			continue
+		}
+		if role != "server" {
+			select {
+			case <-reloads:
+				log.Debug("Dropped a reload not applied yet")
+			default:
+			}
+			reloads <- cfg
 		}
+		sdNotify("READY=1")
+	}
+	if !handedOver {
+		sdNotify("STOPPING=1")
+	}
+	close(stop)
+	if handedOver {
+		// The new process answers probes from now on; these copies of
+		// the sockets would keep taking packets while the checks wind down.
+		for _, l := range listeners {
+			l.Close()
+		}
+		listeners = nil
+	}
+	<-tuiDone
+	log.SetOutput(logDest)
+	<-done
+	for _, l := range listeners {
+		l.Close()
 	}
+	return 0
 }
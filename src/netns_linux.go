@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// inNetns runs fn on a thread switched into the named network namespace.
+// Sockets created by fn stay in that namespace after the thread switches
+// back. name is either a path or a name under /var/run/netns.
+func inNetns(name string, fn func() error) error {
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join("/var/run/netns", name)
+	}
+	result := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		orig, err := unix.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			runtime.UnlockOSThread()
+			result <- fmt.Errorf("opening current network namespace: %s", err)
+			return
+		}
+		defer unix.Close(orig)
+		target, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			runtime.UnlockOSThread()
+			result <- fmt.Errorf("opening network namespace %s: %s", name, err)
+			return
+		}
+		defer unix.Close(target)
+		if err := unix.Setns(target, unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			result <- fmt.Errorf("entering network namespace %s: %s", name, err)
+			return
+		}
+		err = fn()
+		if unix.Setns(orig, unix.CLONE_NEWNET) == nil {
+			// Only hand the thread back once it is in the original
+			// namespace again; otherwise it dies with this goroutine.
+			runtime.UnlockOSThread()
+		}
+		result <- err
+	}()
+	return <-result
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "fmt"
+
+func inNetns(name string, fn func() error) error {
+	return fmt.Errorf("network namespaces are only supported on Linux")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// ntpEpoch is the start of the NTP era, 70 years before the Unix epoch.
+var ntpEpoch = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const ntpPort = 123
+
+func ntpTime(t time.Time) uint64 {
+	d := t.Sub(ntpEpoch)
+	secs := uint64(d / time.Second)
+	frac := uint64(d%time.Second) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+func fromNTPTime(v uint64) time.Time {
+	frac := (v & 0xffffffff) * uint64(time.Second) >> 32
+	return ntpEpoch.Add(time.Duration(v>>32) * time.Second).Add(time.Duration(frac))
+}
+
+// ntpSample is the outcome of one SNTP query.
+type ntpSample struct {
+	offset  time.Duration
+	delay   time.Duration
+	stratum uint8
+}
+
+// queryNTP sends one SNTP client request over svc and computes the clock
+// offset and round trip delay from the four timestamps of the answer.
+func queryNTP(svc *net.UDPConn) (ntpSample, error) {
+	req := make([]byte, 48)
+	req[0] = 4<<3 | 3 // version 4, client mode
+	sent := time.Now()
+	binary.BigEndian.PutUint64(req[40:], ntpTime(sent))
+	if _, err := svc.Write(req); err != nil {
+		return ntpSample{}, err
+	}
+	buf := make([]byte, 128)
+	svc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		n, err := svc.Read(buf)
+		if err != nil {
+			return ntpSample{}, err
+		}
+		received := time.Now()
+		// Answers to earlier, timed out requests carry another origin.
+		if n < 48 || buf[0]&7 != 4 || !bytes.Equal(buf[24:32], req[40:48]) {
+			continue
+		}
+		if buf[0]>>6 == 3 {
+			return ntpSample{}, fmt.Errorf("server clock not synchronized")
+		}
+		if buf[1] == 0 {
+			return ntpSample{}, fmt.Errorf("kiss-o'-death %q", buf[12:16])
+		}
+		t2 := fromNTPTime(binary.BigEndian.Uint64(buf[32:]))
+		t3 := fromNTPTime(binary.BigEndian.Uint64(buf[40:]))
+		return ntpSample{
+			offset:  (t2.Sub(sent) + t3.Sub(received)) / 2,
+			delay:   received.Sub(sent) - t3.Sub(t2),
+			stratum: buf[1],
+		}, nil
+	}
+}
+
+// probeNTP queries the NTP server at addr and writes the "ntp" measurement:
+// the local clock's offset from the server and the round trip delay in
+// microseconds, and the server's stratum. With count set the server is
+// queried that many times and the sample with the lowest delay is kept, as
+// NTP clients do; otherwise once per check, to stay clear of rate limits.
+func probeNTP(ctx context.Context, API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, addr *net.UDPAddr, extra map[string]string) {
+	network := "udp6"
+	if addr.IP.To4() != nil {
+		network = "udp4"
+	}
+	laddr, err := sourceAddr(remoteSite, addr.IP)
+	if err != nil {
+		siteLog(remoteSite).Debug(err.Error())
+		return
+	}
+	svc, err := dialUDP(network, laddr, addr, probeSockOpts(remoteSite))
+	if err != nil {
+		siteLog(remoteSite).Debug(fmt.Sprintf("Failed to dial %s: %s", addr, err))
+		return
+	}
+	defer svc.Close()
+	defer closeOnDone(ctx, svc)()
+	tags := siteTags(localSite, remoteSite)
+	for k, v := range extra {
+		tags[k] = v
+	}
+	tags["proto"] = "ntp"
+	count := 1
+	if remoteSite.Count > 0 {
+		count = int(remoteSite.Count)
+	}
+	gap := sched.probeGap(remoteSite, currentConfig().Adaptive)
+	var best *ntpSample
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			if !sleepCtx(ctx, gap) {
+				return
+			}
+		}
+		sample, err := queryNTP(svc)
+		atomic.AddUint64(&telemetry.probes, 1)
+		if err != nil {
+			atomic.AddUint64(&telemetry.timeouts, 1)
+			siteLog(remoteSite).Debug(fmt.Sprintf("NTP query to %s failed: %s", addr, err))
+			continue
+		}
+		if best == nil || sample.delay < best.delay {
+			best = &sample
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if best == nil {
+		siteLog(remoteSite).Debug(fmt.Sprintf("No NTP response from %s", addr))
+		writeOutage(API, remoteSite, tags)
+		return
+	}
+	siteLog(remoteSite).WithFields(log.Fields{"Client": addr.String()}).Debug(fmt.Sprintf("Clock offset is %d microsec, delay is %d microsec", best.offset.Microseconds(), best.delay.Microseconds()))
+	writeResult(API, "ntp", remoteSite, tags, map[string]interface{}{
+		"offset":  best.offset.Microseconds(),
+		"delay":   best.delay.Microseconds(),
+		"stratum": int(best.stratum),
+	})
+}
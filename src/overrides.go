@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// configOverride is a flag.Value standing in for one scalar config key.
+// Every such key can be set with -<key> or NETCHECK_<KEY>, the flag taking
+// precedence over the environment and both over the config file.
+type configOverride struct {
+	key   string
+	index []int
+	value string
+	set   bool
+}
+
+var (
+	overrides       []*configOverride
+	remoteSitesFlag string
+)
+
+func init() {
+	registerOverrides(reflect.TypeOf(ConfigType{}), "", nil)
+	flag.StringVar(&remoteSitesFlag, "remoteSites", "", fmt.Sprintf("Remote sites as region/site@address[,...] (env %s)", envName("remoteSites")))
+}
+
+func (o *configOverride) String() string {
+	if o == nil {
+		return ""
+	}
+	return o.value
+}
+
+func (o *configOverride) Set(value string) error {
+	o.value = value
+	o.set = true
+	return nil
+}
+
+// envName maps a config key such as localSite.address to
+// NETCHECK_LOCAL_SITE_ADDRESS.
+func envName(key string) string {
+	var b strings.Builder
+	b.WriteString("NETCHECK_")
+	for i, r := range key {
+		switch {
+		case r == '.':
+			b.WriteRune('_')
+		case unicode.IsUpper(r) && wordStart(key, i):
+			b.WriteRune('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// wordStart reports whether the capital at key[i] starts a word. A run of
+// capitals is one word, so sourceIP gives SOURCE_IP and ptrTTLMax gives
+// PTR_TTL_MAX.
+func wordStart(key string, i int) bool {
+	if i == 0 || key[i-1] == '.' {
+		return false
+	}
+	prev := rune(key[i-1])
+	if !unicode.IsUpper(prev) {
+		return true
+	}
+	return i+1 < len(key) && unicode.IsLower(rune(key[i+1]))
+}
+
+func registerOverrides(t reflect.Type, prefix string, index []int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		idx := append(append([]int{}, index...), i)
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		switch ft.Kind() {
+		case reflect.Struct:
+			registerOverrides(ft, key+".", idx)
+		case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Float64:
+			o := &configOverride{key: key, index: idx}
+			overrides = append(overrides, o)
+			flag.Var(o, key, fmt.Sprintf("Override config key %s (env %s)", key, envName(key)))
+		}
+	}
+}
+
+// applyOverrides sets every config key given by flag or environment.
+func applyOverrides(cfg *ConfigType) error {
+	for _, o := range overrides {
+		value, ok := o.value, o.set
+		if !ok {
+			value, ok = os.LookupEnv(envName(o.key))
+		}
+		if !ok {
+			continue
+		}
+		if err := setField(reflect.ValueOf(cfg).Elem(), o.index, value); err != nil {
+			return fmt.Errorf("%s: %s", o.key, err)
+		}
+	}
+	sites, ok := remoteSitesFlag, remoteSitesFlag != ""
+	if !ok {
+		sites, ok = os.LookupEnv(envName("remoteSites"))
+	}
+	if ok {
+		list, err := parseSiteList(sites)
+		if err != nil {
+			return fmt.Errorf("remoteSites: %s", err)
+		}
+		cfg.RemoteSites = list
+	}
+	return nil
+}
+
+func setField(v reflect.Value, index []int, value string) error {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	}
+	return nil
+}
+
+// parseSiteList parses "region/site@address" entries separated by commas.
+func parseSiteList(list string) ([]SiteType, error) {
+	sites := make([]SiteType, 0)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		at := strings.LastIndex(entry, "@")
+		slash := strings.Index(entry, "/")
+		if at < 0 || slash < 0 || slash > at {
+			return nil, fmt.Errorf("invalid entry %s, expected region/site@address", entry)
+		}
+		sites = append(sites, SiteType{Region: entry[:slash], Site: entry[slash+1 : at], Address: entry[at+1:]})
+	}
+	return sites, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// TimeSourceType is the clock one-way delay timestamps are taken from:
+// "system" (the default), "gps" for a system clock disciplined by a GPS
+// receiver, or "ptp" for the PTP hardware clock at device. MaxError is
+// the bound of a PTP clock in microseconds, which the kernel does not
+// know.
+type TimeSourceType struct {
+	Type     string `yaml:"type"`
+	Device   string `yaml:"device"`
+	MaxError uint   `yaml:"maxError"`
+}
+
+const defaultPHC = "/dev/ptp0"
+
+var (
+	owdPrefix  = []byte("owd:")
+	phcWarning sync.Once
+)
+
+// owdTime returns the time from the configured source, the name of the
+// source and its estimated error in microseconds, -1 when unknown. An
+// unsynchronized clock is "unsynced", whatever the source, and one whose
+// state is unknown "system".
+func owdTime() (time.Time, string, int64) {
+	src := reflector().TimeSource
+	if src.Type == "ptp" {
+		device := src.Device
+		if device == "" {
+			device = defaultPHC
+		}
+		now, err := phcNow(device)
+		if err == nil {
+			return now, "ptp", int64(src.MaxError)
+		}
+		phcWarning.Do(func() {
+			log.Warn(fmt.Sprintf("Taking one-way delay timestamps from the system clock: %s", err))
+		})
+	}
+	now := time.Now()
+	synced, estError := systemSync()
+	switch {
+	case !synced:
+		return now, "unsynced", -1
+	case src.Type == "gps":
+		return now, "gps", estError
+	case estError < 0:
+		return now, "system", estError
+	}
+	return now, "ntp", estError
+}
+
+func isOWDProbe(buf []byte) bool {
+	return bytes.HasPrefix(buf, owdPrefix)
+}
+
+// reflectOWD answers an owd:<id>:<seq>:<sent> probe with the time it
+// arrived, the clock source and its error appended.
+func reflectOWD(svc net.PacketConn, addr net.Addr, buf []byte) {
+	now, source, estError := owdTime()
+	svc.WriteTo([]byte(fmt.Sprintf("%s:%d:%s:%d", buf, now.UnixNano(), source, estError)), addr)
+}
+
+// runOWD sends the site's count of owd probes to addr, timestamped with
+// the configured time source, and writes the "owd" measurement: the
+// average forward and reverse delay in microseconds and, as sync1 and
+// sync2 tags, the clock source of both ends, with their summed error
+// as sync_error. The delays are only as good as the clocks: between two
+// "unsynced" ends they mean nothing.
+func runOWD(ctx context.Context, API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, addr *net.UDPAddr, opts sockOpts, extra map[string]string) {
+	svc, err := dialProbe(remoteSite, addr, opts, 0)
+	if err != nil {
+		siteLog(remoteSite).Debug(fmt.Sprintf("Failed to dial %s: %s", addr, err))
+		return
+	}
+	defer svc.Close()
+	defer closeOnDone(ctx, svc)()
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+	count := remoteSite.probeCount()
+	gap := sched.probeGap(remoteSite, currentConfig().Adaptive)
+	var forward, reverse int64
+	var received int
+	var localSource, remoteSource string
+	var localError, remoteError int64
+	buf := make([]byte, 128)
+	for seq := 0; seq < count; seq++ {
+		sent, source, estError := owdTime()
+		localSource, localError = source, estError
+		svc.Write(signPacket([]byte(fmt.Sprintf("owd:%s:%d:%d", id, seq, sent.UnixNano()))))
+		svc.SetReadDeadline(time.Now().Add(probeTimeout))
+		for {
+			n, err := svc.Read(buf)
+			if err != nil {
+				break
+			}
+			now, _, _ := owdTime()
+			// owd:<id>:<seq>:<sent>:<arrived>:<source>:<error>
+			parts := strings.Split(string(buf[:n]), ":")
+			if len(parts) != 7 || parts[0] != "owd" || parts[1] != id || parts[2] != strconv.Itoa(seq) {
+				continue
+			}
+			arrived, err := strconv.ParseInt(parts[4], 10, 64)
+			if err != nil {
+				break
+			}
+			forward += (arrived - sent.UnixNano()) / 1000
+			reverse += (now.UnixNano() - arrived) / 1000
+			received++
+			remoteSource = parts[5]
+			remoteError, _ = strconv.ParseInt(parts[6], 10, 64)
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if seq < count-1 && !sleepCtx(ctx, gap) {
+			return
+		}
+	}
+	if received == 0 {
+		siteLog(remoteSite).Debug(fmt.Sprintf("No one-way delay replies from %s", addr))
+		return
+	}
+	fields := map[string]interface{}{
+		"forward":  forward / int64(received),
+		"reverse":  reverse / int64(received),
+		"received": received,
+	}
+	if localError >= 0 && remoteError >= 0 {
+		fields["sync_error"] = localError + remoteError
+	}
+	extraTags := map[string]string{"sync1": localSource, "sync2": remoteSource}
+	for k, v := range extra {
+		extraTags[k] = v
+	}
+	siteLog(remoteSite).Debug(fmt.Sprintf("One-way delay to %s is %d microsec, back %d microsec, clocks %s and %s", addr, fields["forward"], fields["reverse"], localSource, remoteSource))
+	writeResult(API, "owd", remoteSite, probeTags(localSite, remoteSite, svc, addr, extraTags), fields)
+}
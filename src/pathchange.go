@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+	"time"
+)
+
+// maxHops bounds the traceroutes of path change detection.
+const maxHops = 30
+
+// pathEvent reports that the route to a site changed. Previous and Current
+// are hashes of the hop lists.
+type pathEvent struct {
+	Region   string   `json:"region"`
+	Site     string   `json:"site"`
+	Previous string   `json:"previous"`
+	Current  string   `json:"current"`
+	Hops     []string `json:"hops"`
+}
+
+func pathHash(hops []string) string {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(hops, ",")))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// samePath compares two hop lists. Hops that did not answer match any
+// address, so a lost ICMP error does not count as a change.
+func samePath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] && a[i] != "*" && b[i] != "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// tracePath traces the route to site and writes the "path" measurement:
+// the number of hops, whether the site was reached and a hash of the hop
+// list. It returns the hops, nil when the trace failed.
+func tracePath(site SiteType, port uint) []string {
+	ips, err := resolveHost(site.Address)
+	if err != nil || len(ips) == 0 {
+		siteLog(site).Debug(fmt.Sprintf("Failed to resolve %s for traceroute: %v", site.Address, err))
+		return nil
+	}
+	ips = filterFamily(ips, siteFamily(site))
+	if len(ips) == 0 {
+		return nil
+	}
+	hops, reached, err := traceroute(site, &net.UDPAddr{IP: ips[0], Port: int(port)}, maxHops)
+	if err != nil {
+		siteLog(site).Debug(fmt.Sprintf("Traceroute failed: %s", err))
+		return nil
+	}
+	configLock.RLock()
+	tags := siteTags(configData.LocalSite, site)
+	API := sched.writer.forSite(site)
+	configLock.RUnlock()
+	fields := map[string]interface{}{
+		"hops":    len(hops),
+		"reached": reached,
+		"hash":    pathHash(hops),
+	}
+	API.WritePoint(newPoint("path", tags, fields, time.Now()))
+	return hops
+}
+
+// runTraceroutes traces the route to every site with a traceroute
+// interval that is due, until stop is closed, and publishes a "path" event
+// when a route differs from the one traced before.
+func runTraceroutes(stop <-chan struct{}) {
+	last := make(map[string]time.Time)
+	paths := make(map[string][]string)
+	for {
+		configLock.RLock()
+		defaultPort := configData.Port
+		defaultInterval := configData.Traceroute
+		configLock.RUnlock()
+		for _, site := range sched.snapshot() {
+			interval := site.Traceroute
+			if interval == 0 {
+				interval = defaultInterval
+			}
+			if interval == 0 || time.Since(last[site.key()]) < time.Duration(interval)*time.Second {
+				continue
+			}
+			last[site.key()] = time.Now()
+			port := defaultPort
+			if p := site.servicePort(); p != 0 {
+				port = p
+			}
+			if site.Port != 0 {
+				port = site.Port
+			}
+			hops := tracePath(site, port)
+			if hops == nil {
+				continue
+			}
+			if prev, ok := paths[site.key()]; ok && !samePath(prev, hops) {
+				siteLog(site).Info(fmt.Sprintf("Path changed: %s", strings.Join(hops, " ")))
+				publishEvent("path", pathEvent{Region: site.Region, Site: site.Site, Previous: pathHash(prev), Current: pathHash(hops), Hops: hops})
+			}
+			paths[site.key()] = hops
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
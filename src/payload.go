@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxPayload is the largest request or reply the reflector handles.
+const maxPayload = 9000
+
+var replyMarker = []byte(" reply=")
+
+// probePayload builds the probe for timestamp ts. With requestSize the
+// probe is padded to that many bytes; with replySize the reflector is asked
+// to answer with that many, so both directions of an asymmetric path can
+// be loaded realistically. Without either the probe is ts alone, which
+// every reflector echoes. The padding leaves room for the MAC, if any.
+func probePayload(ts string, site SiteType) []byte {
+	buf := []byte(ts)
+	if site.ReplySize > 0 {
+		buf = append(buf, fmt.Sprintf("%s%d", replyMarker, site.ReplySize)...)
+	}
+	if size := int(site.RequestSize) - macOverhead(); size > len(buf) {
+		buf = append(buf, ' ')
+		buf = append(buf, bytes.Repeat([]byte{'.'}, size-len(buf))...)
+	}
+	return buf
+}
+
+// taggedPackets maps the prefix of each packet kind other than the plain
+// probe to the number of colon separated fields that follow it: an ID,
+// a sequence number (the TTL for trace) and, for burst, stream and owd,
+// the send time.
+var taggedPackets = map[string]int{
+	"burst:":     3,
+	"stream:":    3,
+	"ecn:":       2,
+	"keepalive:": 2,
+	"owd:":       3,
+	"hello:":     2,
+	"trace:":     2,
+}
+
+var fragPrefix = []byte("frag:")
+
+// parseProbe checks that buf is a probe as built by probePayload, or a
+// reply to one, and returns its timestamp and requested reply size. A
+// probe is a decimal timestamp, optionally followed by the reply size
+// and by padding dots, each after a space.
+func parseProbe(buf []byte) (string, int, error) {
+	if len(buf) == 0 || len(buf) > maxPayload {
+		return "", 0, fmt.Errorf("bad length %d", len(buf))
+	}
+	fields := bytes.SplitN(buf, []byte{' '}, 3)
+	if !isDigits(fields[0], 19) {
+		return "", 0, fmt.Errorf("bad timestamp")
+	}
+	ts, rest := string(fields[0]), fields[1:]
+	replySize := 0
+	if len(rest) > 0 && bytes.HasPrefix(rest[0], replyMarker[1:]) {
+		size := rest[0][len(replyMarker)-1:]
+		if !isDigits(size, 4) {
+			return "", 0, fmt.Errorf("bad reply size")
+		}
+		replySize, _ = strconv.Atoi(string(size))
+		if replySize > maxPayload {
+			return "", 0, fmt.Errorf("reply size %d over %d", replySize, maxPayload)
+		}
+		rest = rest[1:]
+	}
+	if len(rest) > 1 || len(rest) == 1 && len(bytes.Trim(rest[0], ".")) > 0 {
+		return "", 0, fmt.Errorf("bad padding")
+	}
+	return ts, replySize, nil
+}
+
+// checkPacket reports why buf is not a packet the reflector answers, or
+// nil if it is, so that noise reaching the open port is not echoed into
+// the measurements.
+func checkPacket(buf []byte) error {
+	if len(buf) == 0 || len(buf) > maxPayload {
+		return fmt.Errorf("bad length %d", len(buf))
+	}
+	if bytes.HasPrefix(buf, fragPrefix) {
+		// frag:<id>: padded with zero bytes to the tested size.
+		fields := bytes.SplitN(buf[len(fragPrefix):], []byte{':'}, 2)
+		if len(fields) != 2 || !isDigits(fields[0], 19) || len(bytes.Trim(fields[1], "\x00")) > 0 {
+			return fmt.Errorf("bad frag probe")
+		}
+		return nil
+	}
+	for prefix, count := range taggedPackets {
+		if !bytes.HasPrefix(buf, []byte(prefix)) {
+			continue
+		}
+		fields := bytes.Split(buf[len(prefix):], []byte{':'})
+		if len(fields) != count {
+			return fmt.Errorf("bad %s probe", strings.TrimSuffix(prefix, ":"))
+		}
+		for _, f := range fields {
+			if len(f) == 0 || len(f) > 19 || len(bytes.Trim(f, "0123456789abcdefghijklmnopqrstuvwxyz")) > 0 {
+				return fmt.Errorf("bad %s probe", strings.TrimSuffix(prefix, ":"))
+			}
+		}
+		return nil
+	}
+	_, _, err := parseProbe(buf)
+	return err
+}
+
+// isDigits reports whether b is 1 to max decimal digits.
+func isDigits(b []byte, max int) bool {
+	if len(b) == 0 || len(b) > max {
+		return false
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// rejectPacket counts and, sampled, logs a packet that failed parsing.
+func rejectPacket(addr net.Addr, err error) {
+	atomic.AddUint64(&telemetry.rejected, 1)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		logPacket(log.WithFields(log.Fields{"Client": addr.String()}), reflector().LogSample, fmt.Sprintf("Rejected packet: %s", err))
+	}
+}
+
+// reflectPadded answers a probe asking for a reply size with its
+// timestamp padded to that size. It reports false for other packets.
+// Without a probe key, which only agents sharing it can sign with, the
+// reply is no larger than the request, so that spoofed requests cannot
+// turn the reflector into an amplifier.
+func reflectPadded(svc net.PacketConn, addr net.Addr, buf []byte) bool {
+	ts, n, err := parseProbe(buf)
+	if err != nil || n == 0 {
+		return false
+	}
+	if n > len(buf) && !probeKeyed() {
+		n = len(buf)
+	}
+	reply := []byte(ts)
+	if n > len(reply) {
+		reply = append(reply, ' ')
+		reply = append(reply, bytes.Repeat([]byte{'.'}, n-len(reply))...)
+	}
+	svc.WriteTo(reply, addr)
+	return true
+}
@@ -0,0 +1,43 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import "testing"
+
+func FuzzCheckPacket(f *testing.F) {
+	for _, p := range packetKinds {
+		f.Add([]byte(p))
+	}
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		if err := checkPacket(buf); err != nil {
+			return
+		}
+		if len(buf) == 0 || len(buf) > maxPayload {
+			t.Fatalf("accepted %d bytes", len(buf))
+		}
+	})
+}
+
+func FuzzParseProbe(f *testing.F) {
+	for _, p := range packetKinds {
+		f.Add([]byte(p))
+	}
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		ts, size, err := parseProbe(buf)
+		if err != nil {
+			return
+		}
+		if !isDigits([]byte(ts), 19) || size < 0 || size > maxPayload {
+			t.Fatalf("accepted %q with timestamp %q and reply size %d", buf, ts, size)
+		}
+		if err := checkPacket(buf); err != nil {
+			t.Fatalf("parsed %q but checkPacket rejects it: %s", buf, err)
+		}
+		// What the prober would build from it parses back the same.
+		again := probePayload(ts, SiteType{ReplySize: uint(size)})
+		if ts2, size2, err := parseProbe(again); err != nil || ts2 != ts || size2 != size {
+			t.Fatalf("%q rebuilt as %q: %q %d %v", buf, again, ts2, size2, err)
+		}
+	})
+}
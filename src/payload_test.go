@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// packetKinds are the packets each prober sends, all of which the
+// reflector must answer.
+var packetKinds = []string{
+	"1792053546196388608",
+	"1792053546196388608 reply=1200",
+	"1792053546196388608 .......",
+	"1792053546196388608 reply=64 ..........",
+	"burst:k2x9:3:1792053546196388608",
+	"stream:k2x9:17:1792053546196388608",
+	"ecn:k2x9:1",
+	"keepalive:k2x9:4",
+	"owd:k2x9:2:1792053546196388608",
+	"hello:k2x9:0",
+	"trace:1792053546196388608:12",
+	"frag:5577006791947779410:\x00\x00\x00\x00",
+}
+
+func TestCheckPacketKinds(t *testing.T) {
+	for _, p := range packetKinds {
+		if err := checkPacket([]byte(p)); err != nil {
+			t.Errorf("%q rejected: %s", p, err)
+		}
+	}
+	for _, p := range []string{"", "hello", "trace:1:2:3", "burst:k:1", "12ab", "1792053546196388608 reply=99999", "frag:1:x"} {
+		if err := checkPacket([]byte(p)); err == nil {
+			t.Errorf("%q accepted", p)
+		}
+	}
+}
+
+func TestProbePayloadParses(t *testing.T) {
+	for _, site := range []SiteType{{}, {ReplySize: 512}, {RequestSize: 100}, {RequestSize: 1400, ReplySize: 64}} {
+		buf := probePayload("1792053546196388608", site)
+		ts, size, err := parseProbe(buf)
+		if err != nil || ts != "1792053546196388608" || size != int(site.ReplySize) {
+			t.Errorf("%+v: got %q %d %v", site, ts, size, err)
+		}
+		if site.RequestSize > 0 && len(buf) != int(site.RequestSize) {
+			t.Errorf("%+v: %d bytes", site, len(buf))
+		}
+	}
+}
+
+func TestReflectPaddedNoAmplification(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	req := []byte("1792053546196388608 reply=9000")
+	if !reflectPadded(server, client.LocalAddr(), req) {
+		t.Fatal("not answered")
+	}
+	buf := make([]byte, maxPayload)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n > len(req) {
+		t.Errorf("%d byte reply to a %d byte request", n, len(req))
+	}
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dropPrivileges switches to the configured user and group once every
+// privileged socket is open. The group defaults to the user's primary
+// group and supplementary groups are cleared. A process already running
+// as them, such as one started by a handover from a process that had
+// dropped privileges, has nothing to drop and could not set its groups.
+func dropPrivileges(userName string, groupName string) error {
+	if userName == "" && groupName == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return fmt.Errorf("looking up user %s: %s", userName, err)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return fmt.Errorf("looking up group %s: %s", groupName, err)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	if (uid < 0 || syscall.Getuid() == uid) && syscall.Getgid() == gid && syscall.Geteuid() == syscall.Getuid() {
+		log.WithFields(log.Fields{"Uid": syscall.Getuid(), "Gid": gid}).Debug("Already running as the configured user")
+		return nil
+	}
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("setting groups: %s", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setting group %d: %s", gid, err)
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setting user %d: %s", uid, err)
+		}
+	}
+	log.WithFields(log.Fields{"Uid": syscall.Getuid(), "Gid": syscall.Getgid()}).Info("Dropped privileges")
+	return nil
+}
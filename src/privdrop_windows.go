@@ -0,0 +1,10 @@
+package main
+
+import "fmt"
+
+func dropPrivileges(userName string, groupName string) error {
+	if userName == "" && groupName == "" {
+		return nil
+	}
+	return fmt.Errorf("user and group are not supported on Windows, run the service as the wanted account instead")
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// probeTimeout is how long a probe waits for its reply before it counts
+// as lost.
+const probeTimeout = 10 * time.Second
+
+// readReply waits on svc for the reply to the probe sent at ts and returns
+// when it arrived. Late replies to earlier probes are skipped and
+// malformed ones rejected. An error means the deadline passed or the
+// socket was closed. The deadline is that of path, see replyTimeout, and
+// the RTTs seen count towards it.
+func readReply(svc *net.UDPConn, ts string, path string) (time.Time, []byte, error) {
+	buf := make([]byte, maxPayload+1)
+	oob := make([]byte, 256)
+	svc.SetReadDeadline(time.Now().Add(replyTimeout(path)))
+	for {
+		n, oobn, _, _, err := svc.ReadMsgUDP(buf, oob)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				notePathTimeout(path)
+			}
+			return time.Time{}, nil, err
+		}
+		arrived := time.Now()
+		got, _, err := parseProbe(buf[:n])
+		if err != nil {
+			rejectPacket(svc.RemoteAddr(), err)
+			continue
+		}
+		if sent, err := strconv.ParseInt(got, 10, 64); err == nil {
+			notePathRTT(path, arrived.Sub(time.Unix(0, sent)))
+		}
+		if got == ts {
+			return arrived, oob[:oobn], nil
+		}
+	}
+}
+
+func CheckSite(ctx context.Context, API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, port uint) {
+	if p := remoteSite.servicePort(); p != 0 {
+		port = p
+	}
+	if remoteSite.Port != 0 {
+		port = remoteSite.Port
+	}
+	siteLog(remoteSite).Debug(fmt.Sprintf("Checking %s", remoteSite.Address))
+	if connectTypes[remoteSite.Type] && len(remoteSite.Protocols) == 0 && siteProxy(remoteSite) != "" {
+		// The proxy resolves the name.
+		probeConnect(ctx, API, localSite, remoteSite, net.JoinHostPort(remoteSite.Address, fmt.Sprint(port)), remoteSite.Type, nil)
+		return
+	}
+	ips, err := resolveHost(remoteSite.Address)
+	if err != nil {
+		siteLog(remoteSite).Debug(fmt.Sprintf("Failed to resolve %s: %s", remoteSite.Address, err))
+		return
+	}
+	family := siteFamily(remoteSite)
+	all := remoteSite.AllAddresses || currentConfig().AllAddresses
+	var selected []net.IP
+	if family == "both" {
+		for _, af := range []string{"ipv4", "ipv6"} {
+			found := filterFamily(ips, af)
+			if len(found) > 0 && !all {
+				found = found[:1]
+			}
+			selected = append(selected, found...)
+		}
+	} else {
+		selected = filterFamily(ips, family)
+		if len(selected) > 0 && !all {
+			selected = selected[:1]
+		}
+	}
+	if len(selected) == 0 {
+		siteLog(remoteSite).Debug(fmt.Sprintf("No %s address for %s", family, remoteSite.Address))
+		return
+	}
+	noteResolved(remoteSite, selected[0])
+	for _, ip := range selected {
+		extra := make(map[string]string)
+		if currentConfig().TagResolvedIP || (all && len(selected) > 1) {
+			extra["dst_ip"] = ip.String()
+		}
+		if family == "both" {
+			extra["af"] = "ipv6"
+			if ip.To4() != nil {
+				extra["af"] = "ipv4"
+			}
+		}
+		ports := []uint{port}
+		if len(remoteSite.Ports) > 0 {
+			ports = remoteSite.Ports
+		}
+		for i, p := range ports {
+			portTags := extra
+			if len(remoteSite.Ports) > 0 {
+				portTags = map[string]string{"port": fmt.Sprint(p)}
+				for k, v := range extra {
+					portTags[k] = v
+				}
+			}
+			addr := &net.UDPAddr{IP: ip, Port: int(p)}
+			for _, proto := range remoteSite.protocols() {
+				switch proto {
+				case "tcp", "tls", "http":
+					probeConnect(ctx, API, localSite, remoteSite, addr.String(), proto, portTags)
+				case "ntp":
+					probeNTP(ctx, API, localSite, remoteSite, addr, portTags)
+				case "icmp":
+					// ICMP has no ports, it is probed once.
+					if i == 0 {
+						probeICMP(ctx, API, localSite, remoteSite, ip, extra)
+					}
+				default:
+					udpTags := portTags
+					if len(remoteSite.Protocols) > 0 {
+						// Compared with other protocols, UDP
+						// points need a proto tag too.
+						udpTags = map[string]string{"proto": "udp"}
+						for k, v := range portTags {
+							udpTags[k] = v
+						}
+					}
+					probeUDP(ctx, API, localSite, remoteSite, addr, udpTags)
+				}
+			}
+		}
+	}
+}
+
+// probeUDP runs the UDP measurements configured for remoteSite against
+// one address: the optional fragmentation, ECN, burst and one-way delay
+// tests, then the stream or the regular probes, per class when classes are
+// set. The settings the reflector does not support are left out.
+func probeUDP(ctx context.Context, API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, addr *net.UDPAddr, extra map[string]string) {
+	remoteSite = negotiate(ctx, remoteSite, addr)
+	if remoteSite.FragTest > 0 {
+		runFragTest(ctx, API, localSite, remoteSite, addr, probeSockOpts(remoteSite), extra)
+	}
+	if remoteSite.ECN != "" {
+		runECNTest(ctx, API, localSite, remoteSite, addr, probeSockOpts(remoteSite), extra)
+	}
+	if remoteSite.Burst > 0 {
+		runBurst(ctx, API, localSite, remoteSite, addr, probeSockOpts(remoteSite), extra)
+	}
+	if remoteSite.OWD {
+		runOWD(ctx, API, localSite, remoteSite, addr, probeSockOpts(remoteSite), extra)
+	}
+	if remoteSite.Stream.Rate > 0 {
+		runStream(ctx, API, localSite, remoteSite, addr, probeSockOpts(remoteSite), extra)
+		return
+	}
+	if len(remoteSite.Classes) == 0 {
+		probeAddress(ctx, API, localSite, remoteSite, addr, probeSockOpts(remoteSite), extra)
+		return
+	}
+	// Classes are probed at the same time so they see the same
+	// network conditions.
+	var wg sync.WaitGroup
+	for class, dscp := range remoteSite.Classes {
+		opts := probeSockOpts(remoteSite)
+		opts.DSCP = dscp
+		classTags := map[string]string{"class": class}
+		for k, v := range extra {
+			classTags[k] = v
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probeAddress(ctx, API, localSite, remoteSite, addr, opts, classTags)
+		}()
+	}
+	wg.Wait()
+}
+
+// probeAddress measures one address of remoteSite and writes the result.
+// extra holds the tags that tell several series of one site apart.
+func probeAddress(ctx context.Context, API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, addr *net.UDPAddr, opts sockOpts, extra map[string]string) {
+	if remoteSite.Flows > 1 {
+		probeFlows(ctx, API, localSite, remoteSite, addr, opts, extra)
+		return
+	}
+	if remoteSite.FlowLabels > 1 && addr.IP.To4() == nil {
+		probeFlowLabels(ctx, API, localSite, remoteSite, addr, opts, extra)
+		return
+	}
+	svc, err := dialProbe(remoteSite, addr, opts, 0)
+	if err != nil {
+		siteLog(remoteSite).Debug(fmt.Sprintf("Failed to dial %s: %s", addr, err))
+		return
+	}
+	defer svc.Close()
+	defer closeOnDone(ctx, svc)()
+	tags := probeTags(localSite, remoteSite, svc, addr, extra)
+	stats := measureRTT(ctx, svc, remoteSite, addr)
+	if ctx.Err() != nil {
+		return
+	}
+	writeRTT(API, remoteSite, addr, tags, stats, nil)
+}
+
+// dialProbe opens the socket probes to addr are sent from, from the
+// given local port or an ephemeral one when 0, in the site's network
+// namespace when set.
+func dialProbe(remoteSite SiteType, addr *net.UDPAddr, opts sockOpts, port int) (*net.UDPConn, error) {
+	network := "udp6"
+	if addr.IP.To4() != nil {
+		network = "udp4"
+	}
+	laddr, err := sourceAddr(remoteSite, addr.IP)
+	if err != nil {
+		return nil, err
+	}
+	if port != 0 {
+		if laddr == nil {
+			laddr = &net.UDPAddr{}
+		}
+		laddr = &net.UDPAddr{IP: laddr.IP, Port: port}
+	}
+	var svc *net.UDPConn
+	if remoteSite.Netns != "" {
+		err = inNetns(remoteSite.Netns, func() error {
+			var err error
+			svc, err = dialUDP(network, laddr, addr, opts)
+			return err
+		})
+	} else {
+		svc, err = dialUDP(network, laddr, addr, opts)
+	}
+	return svc, err
+}
+
+// probeTags returns the tags of the points measured through svc, which
+// may be nil for points about several sockets.
+func probeTags(localSite SiteType, remoteSite SiteType, svc *net.UDPConn, addr *net.UDPAddr, extra map[string]string) map[string]string {
+	tags := siteTags(localSite, remoteSite)
+	if currentConfig().TagSourceIP && svc != nil {
+		tags["src_ip"] = svc.LocalAddr().(*net.UDPAddr).IP.String()
+	}
+	for k, v := range extra {
+		tags[k] = v
+	}
+	enrichTags(tags, addr.IP)
+	return tags
+}
+
+// rttStats are the outcome of the probes sent through one socket, RTTs in
+// microseconds.
+type rttStats struct {
+	sent, received int
+	minRTT, maxRTT int64
+	totalRTT       int64
+
+	// With hostTiming, the replies with kernel timestamps, their RTT as
+	// measured and between the driver's (or the eBPF programs') send and
+	// receive, and the socket's drops. hostLost counts the replies that
+	// the eBPF programs saw arrive in time but the agent never got.
+	stamped      int
+	stampedRTT   int64
+	totalWireRTT int64
+	drops        uint32
+	hostLost     int
+}
+
+func (s rttStats) avg() int64 {
+	return s.totalRTT / int64(s.received)
+}
+
+func (s rttStats) loss() float64 {
+	return 100 * float64(s.sent-s.received) / float64(s.sent)
+}
+
+// measureRTT sends the site's probes through svc one after the other,
+// with the probe gap in between, until done or ctx ends.
+func measureRTT(ctx context.Context, svc *net.UDPConn, remoteSite SiteType, addr *net.UDPAddr) rttStats {
+	var stats rttStats
+	count := remoteSite.probeCount()
+	cfg := currentConfig()
+	gap := sched.probeGap(remoteSite, cfg.Adaptive)
+	if cfg.HostTiming {
+		// Probe i is packet i of the socket for txTimestamp, whatever
+		// was sent on it before.
+		resetTxID(svc)
+		defer ebpfWatch(svc)()
+	}
+	for i := 0; i < count; i++ {
+		if !stats.probe(ctx, svc, remoteSite, addr) {
+			return stats
+		}
+		if i < count-1 && !sleepCtx(ctx, gap) {
+			return stats
+		}
+	}
+	return stats
+}
+
+// probe sends one probe through svc and waits for its reply. It returns
+// false when ctx ended meanwhile.
+func (s *rttStats) probe(ctx context.Context, svc *net.UDPConn, remoteSite SiteType, addr *net.UDPAddr) bool {
+	sent := time.Now()
+	ts := strconv.FormatInt(sent.UnixNano(), 10)
+	svc.Write(signPacket(probePayload(ts, remoteSite)))
+	atomic.AddUint64(&telemetry.probes, 1)
+	s.sent++
+	arrived, oob, err := readReply(svc, ts, remoteSite.key()+" "+addr.String())
+	if ctx.Err() != nil {
+		return false
+	}
+	var txStamp time.Time
+	if currentConfig().HostTiming {
+		// Read even when the reply was lost, to empty the error
+		// queue.
+		txStamp, _ = txTimestamp(svc, uint32(s.sent-1))
+	}
+	if err != nil {
+		// A lost probe does not end the check, the next one is sent
+		// after the usual gap.
+		if currentConfig().HostTiming && ebpfLost(svc, ts) {
+			s.hostLost++
+		}
+		atomic.AddUint64(&telemetry.timeouts, 1)
+		siteLog(remoteSite).Debug(fmt.Sprintf("Failed to get response from %s", addr))
+		return true
+	}
+	logPacket(siteLog(remoteSite), remoteSite.logSample(), fmt.Sprintf("Got response from %s", addr))
+	rtt := arrived.Sub(sent).Microseconds()
+	s.minRTT = min(s.minRTT, rtt)
+	s.maxRTT = max(s.maxRTT, rtt)
+	s.totalRTT += rtt
+	s.received++
+	if currentConfig().HostTiming {
+		rxStamp, drops := rxTimestamp(oob)
+		if sent, arrived := ebpfStamps(svc, ts); sent != 0 && arrived > sent {
+			s.stamped++
+			s.stampedRTT += rtt
+			s.totalWireRTT += int64(arrived-sent) / 1000
+		} else if !txStamp.IsZero() && !rxStamp.IsZero() {
+			s.stamped++
+			s.stampedRTT += rtt
+			s.totalWireRTT += rxStamp.Sub(txStamp).Microseconds()
+		}
+		s.drops = drops
+	}
+	return true
+}
+
+// writeRTT writes the rtt point of stats, with the extra fields given,
+// or the outage point when no probe was answered.
+func writeRTT(API influxAPI.WriteAPI, remoteSite SiteType, addr *net.UDPAddr, tags map[string]string, stats rttStats, extra map[string]interface{}) {
+	if stats.received == 0 {
+		siteLog(remoteSite).Debug(fmt.Sprintf("No response from %s", addr))
+		writeOutage(API, remoteSite, tags)
+		return
+	}
+	siteLog(remoteSite).WithFields(log.Fields{"Client": addr.String()}).Debug(fmt.Sprintf("RTT is %d microsec, Jitter is %d microsec, Loss is %.0f%%", stats.avg(), stats.maxRTT-stats.minRTT, stats.loss()))
+	fields := map[string]interface{}{"avg": stats.avg(), "jitter": stats.maxRTT - stats.minRTT, "loss": stats.loss()}
+	if stats.stamped > 0 {
+		fields["wire_rtt"] = stats.totalWireRTT / int64(stats.stamped)
+		fields["host_delay"] = (stats.stampedRTT - stats.totalWireRTT) / int64(stats.stamped)
+	}
+	if currentConfig().HostTiming {
+		fields["socket_drops"] = int64(stats.drops)
+		if ebpfLoaded() {
+			fields["host_lost"] = int64(stats.hostLost)
+		}
+	}
+	for k, v := range extra {
+		fields[k] = v
+	}
+	writeResult(API, "rtt", remoteSite, tags, fields)
+}
+
+// writeOutage writes the rtt point of a check none of whose probes were
+// answered: loss 100% and reachable false, with no RTT, so that outages
+// show up in the data rather than as gaps.
+func writeOutage(API influxAPI.WriteAPI, remoteSite SiteType, tags map[string]string) {
+	writeResult(API, "rtt", remoteSite, tags, map[string]interface{}{"loss": 100.0, "reachable": false})
+}
+
+// sourceAddr picks the configured local address (per site, else global)
+// matching the family of dst. The setting is a comma separated list so one
+// IPv4 and one IPv6 source can be given. No setting leaves it to routing.
+func sourceAddr(site SiteType, dst net.IP) (*net.UDPAddr, error) {
+	list := site.SourceAddress
+	if list == "" {
+		list = currentConfig().SourceAddress
+	}
+	if list == "" {
+		return nil, nil
+	}
+	for _, s := range strings.Split(list, ",") {
+		ip := net.ParseIP(strings.TrimSpace(s))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address %s", s)
+		}
+		if (ip.To4() != nil) == (dst.To4() != nil) {
+			return &net.UDPAddr{IP: ip}, nil
+		}
+	}
+	return nil, fmt.Errorf("no source address of the same family as %s in %s", dst, list)
+}
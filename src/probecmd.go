@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// printWriter stands in for the Influx write API and prints points
+// instead, one per line, or only counts them when quiet.
+type printWriter struct {
+	json    bool
+	quiet   bool
+	written int
+}
+
+func (p *printWriter) WriteRecord(line string) {
+	p.written++
+	if !p.quiet {
+		fmt.Println(line)
+	}
+}
+
+func (p *printWriter) WritePoint(point *write.Point) {
+	p.written++
+	if p.quiet {
+		return
+	}
+	tags := make(map[string]string)
+	for _, t := range point.TagList() {
+		tags[t.Key] = t.Value
+	}
+	fields := make(map[string]interface{})
+	for _, f := range point.FieldList() {
+		fields[f.Key] = f.Value
+	}
+	if p.json {
+		data, _ := json.Marshal(ResultType{Measurement: point.Name(), Tags: tags, Fields: fields, Time: point.Time()})
+		fmt.Println(string(data))
+		return
+	}
+	var parts []string
+	for k, v := range tags {
+		parts = append(parts, k+"="+v)
+	}
+	for k, v := range fields {
+		// Microseconds, counted by some measurements and averaged by
+		// others.
+		var us float64
+		isNumber := true
+		switch n := v.(type) {
+		case int64:
+			us = float64(n)
+		case float64:
+			us = n
+		default:
+			isNumber = false
+		}
+		switch {
+		case (k == "avg" || k == "jitter") && isNumber:
+			parts = append(parts, fmt.Sprintf("%s=%.3fms", k, us/1000))
+		default:
+			parts = append(parts, fmt.Sprintf("%s=%v", k, v))
+		}
+	}
+	sort.Strings(parts)
+	fmt.Printf("%s %s\n", point.Name(), strings.Join(parts, " "))
+}
+
+func (p *printWriter) Flush() {}
+
+func (p *printWriter) Errors() <-chan error {
+	return nil
+}
+
+// splitTarget splits a host[:port] argument. A port given with the host
+// replaces *port; without either it is an error.
+func splitTarget(target string, port *uint) (string, error) {
+	host := target
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return "", fmt.Errorf("invalid port %s", p)
+		}
+		host = h
+		*port = uint(n)
+	}
+	if *port == 0 {
+		return "", fmt.Errorf("no port given, use host:port or -port")
+	}
+	return host, nil
+}
+
+// runProbe implements "netcheck probe": a single check of any reflector,
+// printed to stdout, without a config file or Influx.
+func runProbe(args []string) int {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	fs.Usage = commandUsage("probe", fs)
+	count := fs.Uint("count", 10, "Number of probes")
+	port := fs.Uint("port", 0, "Reflector port, when not given with the host")
+	asJSON := fs.Bool("json", false, "Print results as JSON")
+	tcp := fs.Bool("tcp", false, "Measure TCP connect time instead of UDP round trips")
+	probeKey := probeKeyFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	target := fs.Arg(0)
+	// Flags may also follow the host.
+	fs.Parse(fs.Args()[1:])
+	host, err := splitTarget(target, port)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if err := startProbeKey(*probeKey); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	site := SiteType{Address: host, Region: "probe", Site: host, Count: *count}
+	if *tcp {
+		site.Type = "tcp"
+	}
+	out := &printWriter{json: *asJSON}
+	CheckSite(context.Background(), out, SiteType{Region: "local", Site: hostname}, site, *port)
+	if out.written == 0 {
+		fmt.Fprintf(stderr, "no result from %s\n", net.JoinHostPort(host, fmt.Sprint(*port)))
+		return 1
+	}
+	return 0
+}
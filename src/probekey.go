@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// With a probe key every packet a prober sends to a reflector ends with
+// " mac=" and the hex HMAC-SHA256, truncated to 128 bits, of what comes
+// before it. Reflectors with the key drop packets without a valid MAC and
+// answer the rest as if it was not there, so only agents sharing the key
+// get answers. The previous key stays valid after a change, as agents
+// renew it from Vault at different times.
+var macMarker = []byte(" mac=")
+
+const macLen = 32
+
+var probeKeys struct {
+	sync.RWMutex
+	current  []byte
+	previous []byte
+}
+
+// setProbeKey makes key the one probes are signed with.
+func setProbeKey(key string) {
+	probeKeys.Lock()
+	defer probeKeys.Unlock()
+	if probeKeys.current != nil && string(probeKeys.current) == key {
+		return
+	}
+	probeKeys.previous = probeKeys.current
+	probeKeys.current = []byte(key)
+}
+
+// startProbeKey resolves the probeKey reference ref, if any. A leased Vault
+// secret is renewed, and a new key read on expiry, for as long as the
+// process runs.
+func startProbeKey(ref string) error {
+	if ref == "" {
+		return nil
+	}
+	if !strings.HasPrefix(ref, "vault:") {
+		key, err := resolveSecret(ref)
+		if err != nil {
+			return fmt.Errorf("error resolving probeKey: %s", err)
+		}
+		setProbeKey(key)
+		return nil
+	}
+	secret, err := resolveVaultSecret(ref)
+	if err != nil {
+		return fmt.Errorf("error resolving probeKey: %s", err)
+	}
+	setProbeKey(secret.value)
+	updates := make(chan string)
+	go watchVaultSecret(ref, secret, updates)
+	go func() {
+		for key := range updates {
+			setProbeKey(key)
+			log.Info("Probe key changed")
+		}
+	}()
+	return nil
+}
+
+// probeKeyFlag adds -probe-key to the commands probing or reflecting
+// without a config file.
+func probeKeyFlag(fs *flag.FlagSet) *string {
+	return fs.String("probe-key", "", "Probe MAC key, literal, env:NAME or file:/path, as probeKey in the config")
+}
+
+func probeMAC(key []byte, buf []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(buf)
+	mac := make([]byte, macLen)
+	hex.Encode(mac, h.Sum(nil)[:macLen/2])
+	return mac
+}
+
+// probeKeyed tells whether packets are signed and checked.
+func probeKeyed() bool {
+	probeKeys.RLock()
+	defer probeKeys.RUnlock()
+	return probeKeys.current != nil
+}
+
+// macOverhead is how many bytes signPacket adds to a packet.
+func macOverhead() int {
+	probeKeys.RLock()
+	defer probeKeys.RUnlock()
+	if probeKeys.current == nil {
+		return 0
+	}
+	return len(macMarker) + macLen
+}
+
+// signPacket returns buf with its MAC appended, or buf as is without a
+// probe key.
+func signPacket(buf []byte) []byte {
+	probeKeys.RLock()
+	key := probeKeys.current
+	probeKeys.RUnlock()
+	if key == nil {
+		return buf
+	}
+	signed := make([]byte, 0, len(buf)+len(macMarker)+macLen)
+	signed = append(signed, buf...)
+	signed = append(signed, macMarker...)
+	return append(signed, probeMAC(key, buf)...)
+}
+
+// verifyPacket checks the MAC of buf and returns buf without it. Without a
+// probe key buf is returned as is.
+func verifyPacket(buf []byte) ([]byte, error) {
+	probeKeys.RLock()
+	current, previous := probeKeys.current, probeKeys.previous
+	probeKeys.RUnlock()
+	if current == nil {
+		return buf, nil
+	}
+	n := len(buf) - len(macMarker) - macLen
+	if n <= 0 || !hmac.Equal(buf[n:n+len(macMarker)], macMarker) {
+		return nil, fmt.Errorf("no MAC")
+	}
+	payload, mac := buf[:n], buf[n+len(macMarker):]
+	if hmac.Equal(mac, probeMAC(current, payload)) || previous != nil && hmac.Equal(mac, probeMAC(previous, payload)) {
+		return payload, nil
+	}
+	return nil, fmt.Errorf("bad MAC")
+}
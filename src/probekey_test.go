@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestProbeKeyRotation(t *testing.T) {
+	defer func() {
+		probeKeys.current, probeKeys.previous = nil, nil
+	}()
+	probe := []byte("1792053546196388608 reply=64")
+	if got := signPacket(probe); string(got) != string(probe) {
+		t.Fatalf("signed without a key: %q", got)
+	}
+	setProbeKey("one")
+	signed := signPacket(probe)
+	if len(signed) != len(probe)+macOverhead() {
+		t.Fatalf("%d bytes signed", len(signed))
+	}
+	if got, err := verifyPacket(signed); err != nil || string(got) != string(probe) {
+		t.Fatalf("verified as %q, %v", got, err)
+	}
+	for _, bad := range [][]byte{probe, append(append([]byte{}, signed[:len(signed)-1]...), '0'), []byte(" mac=")} {
+		if _, err := verifyPacket(bad); err == nil {
+			t.Errorf("%q verified", bad)
+		}
+	}
+	setProbeKey("two")
+	if _, err := verifyPacket(signed); err != nil {
+		t.Errorf("previous key rejected: %s", err)
+	}
+	setProbeKey("three")
+	if _, err := verifyPacket(signed); err == nil {
+		t.Error("key before the previous one accepted")
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// secretKeys are the config keys holding credentials, at any depth.
+var secretKeys = map[string]bool{
+	"token":       true,
+	"influxToken": true,
+	"password":    true,
+	"secretKey":   true,
+}
+
+const redacted = "REDACTED"
+
+var (
+	secretValues = make(map[string]bool)
+	secretsLock  sync.RWMutex
+	// urlPassword matches the password of a URL's user info.
+	urlPassword = regexp.MustCompile(`(://[^/:@\s]*:)[^/@\s]+@`)
+)
+
+func init() {
+	log.AddHook(redactHook{})
+}
+
+// registerSecret has the logs and the admin API redact value from now
+// on. Values under 6 characters are left alone, they would redact
+// unrelated text.
+func registerSecret(value string) {
+	if len(value) < 6 {
+		return
+	}
+	secretsLock.Lock()
+	secretValues[value] = true
+	secretsLock.Unlock()
+}
+
+// redact replaces the registered secrets and URL passwords in s.
+func redact(s string) string {
+	s = urlPassword.ReplaceAllString(s, "${1}"+redacted+"@")
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+	for value := range secretValues {
+		if strings.Contains(s, value) {
+			s = strings.ReplaceAll(s, value, redacted)
+		}
+	}
+	return s
+}
+
+// redactTags returns a copy of tags with their values redacted.
+func redactTags(tags map[string]string) map[string]string {
+	if tags == nil {
+		return nil
+	}
+	out := make(map[string]string, len(tags))
+	for k, v := range tags {
+		out[k] = redact(v)
+	}
+	return out
+}
+
+// redactResult returns res with its tag values and string fields
+// redacted, for the streaming outputs that do not go through writeJSON.
+func redactResult(res ResultType) ResultType {
+	res.Tags = redactTags(res.Tags)
+	fields := make(map[string]interface{}, len(res.Fields))
+	for k, v := range res.Fields {
+		if s, ok := v.(string); ok {
+			v = redact(s)
+		}
+		fields[k] = v
+	}
+	res.Fields = fields
+	return res
+}
+
+// stderr is where the commands print their errors, redacted as the logs
+// are: they may quote config file names and server replies.
+var stderr io.Writer = redactWriter{os.Stderr}
+
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactHook redacts the message and the string fields of every log
+// entry. It is added first, so it runs before the other hooks.
+type redactHook struct{}
+
+func (redactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (redactHook) Fire(entry *log.Entry) error {
+	entry.Message = redact(entry.Message)
+	for k, v := range entry.Data {
+		switch val := v.(type) {
+		case string:
+			entry.Data[k] = redact(val)
+		case error:
+			entry.Data[k] = redact(val.Error())
+		}
+	}
+	return nil
+}
+
+// isSecretRef tells whether a secret key's value refers to the secret
+// rather than holding it, see resolveSecret.
+func isSecretRef(value string) bool {
+	for _, prefix := range []string{"env:", "file:", "vault:", "aws-sm://", "aws-ssm://"} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// configTree returns the config as an ordered YAML tree, leaving out the
+// keys at their zero value.
+func configTree(v reflect.Value) (interface{}, bool) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, false
+		}
+		// A set pointer is kept even to a zero value, as in
+		// enabled: false.
+		value, _ := configTree(v.Elem())
+		return value, true
+	case reflect.Struct:
+		var tree yaml.MapSlice
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if field.PkgPath != "" || key == "" || key == "-" {
+				continue
+			}
+			if value, ok := configTree(v.Field(i)); ok {
+				tree = append(tree, yaml.MapItem{Key: key, Value: value})
+			}
+		}
+		return tree, len(tree) > 0
+	case reflect.Map:
+		keys := make([]string, 0, v.Len())
+		values := make(map[string]reflect.Value, v.Len())
+		for _, k := range v.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			keys = append(keys, key)
+			values[key] = v.MapIndex(k)
+		}
+		sort.Strings(keys)
+		var tree yaml.MapSlice
+		for _, key := range keys {
+			// Map entries are kept even when zero: their key says
+			// something.
+			value, _ := configTree(values[key])
+			tree = append(tree, yaml.MapItem{Key: key, Value: value})
+		}
+		return tree, len(tree) > 0
+	case reflect.Slice:
+		var list []interface{}
+		for i := 0; i < v.Len(); i++ {
+			value, _ := configTree(v.Index(i))
+			list = append(list, value)
+		}
+		return list, len(list) > 0
+	}
+	if v.IsZero() {
+		return v.Interface(), false
+	}
+	return v.Interface(), true
+}
+
+// redactTree replaces the literal values of secret keys and the
+// passwords of URLs in a config tree, in place.
+func redactTree(tree interface{}) {
+	switch node := tree.(type) {
+	case yaml.MapSlice:
+		for i, item := range node {
+			value, ok := item.Value.(string)
+			switch {
+			case ok && secretKeys[fmt.Sprint(item.Key)] && !isSecretRef(value):
+				node[i].Value = redacted
+			case ok:
+				node[i].Value = urlPassword.ReplaceAllString(value, "${1}"+redacted+"@")
+			default:
+				redactTree(item.Value)
+			}
+		}
+	case []interface{}:
+		for i, item := range node {
+			if value, ok := item.(string); ok {
+				node[i] = urlPassword.ReplaceAllString(value, "${1}"+redacted+"@")
+				continue
+			}
+			redactTree(item)
+		}
+	}
+}
+
+// registerConfigSecrets registers the literal values of the secret keys
+// of cfg, see registerSecret.
+func registerConfigSecrets(cfg ConfigType) {
+	var walk func(tree interface{})
+	walk = func(tree interface{}) {
+		switch node := tree.(type) {
+		case yaml.MapSlice:
+			for _, item := range node {
+				if value, ok := item.Value.(string); ok && secretKeys[fmt.Sprint(item.Key)] && !isSecretRef(value) {
+					registerSecret(value)
+				}
+				walk(item.Value)
+			}
+		case []interface{}:
+			for _, item := range node {
+				walk(item)
+			}
+		}
+	}
+	tree, _ := configTree(reflect.ValueOf(cfg))
+	walk(tree)
+}
+
+// runConfig implements "netcheck config show": it prints the effective
+// config, after includes, environment references and overrides, without
+// the keys at their zero value.
+func runConfig(args []string) int {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	fs.Usage = commandUsage("config", fs)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	redactSecrets := fs.Bool("redacted", false, "Replace credentials and URL passwords with "+redacted+", for sharing")
+	if len(args) == 0 || args[0] != "show" {
+		fs.Usage()
+		return 2
+	}
+	fs.Parse(args[1:])
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", configFile, err)
+		return 2
+	}
+	tree, _ := configTree(reflect.ValueOf(cfg))
+	if *redactSecrets {
+		redactTree(tree)
+	}
+	data, err := yaml.Marshal(tree)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	os.Stdout.Write(data)
+	return 0
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RedisType keeps the latest state of every path in a Redis hash and
+// publishes the results of every cycle and the agent events on pub/sub
+// channels, for consumers such as chat bots and status pages that have no
+// use for the TSDB. URL is redis://[[username]:password@]host[:port][/db],
+// rediss:// for TLS. Password may be a secret reference, see
+// resolveSecret, and replaces that of the URL. Keys and channels start
+// with prefix, "netcheck" by default. TTL, in seconds, expires the hashes
+// of paths no longer checked.
+type RedisType struct {
+	URL      string `yaml:"url"`
+	Password string `yaml:"password"`
+	Prefix   string `yaml:"prefix"`
+	TTL      uint   `yaml:"ttl"`
+}
+
+const (
+	defaultRedisPort   = "6379"
+	defaultRedisPrefix = "netcheck"
+	redisTimeout       = 5 * time.Second
+)
+
+func (c RedisType) prefix() string {
+	if c.Prefix == "" {
+		return defaultRedisPrefix
+	}
+	return c.Prefix
+}
+
+// redisTarget is where and how to connect, from the URL.
+type redisTarget struct {
+	address  string
+	username string
+	password string
+	db       int
+	tls      bool
+}
+
+func parseRedisURL(raw string) (redisTarget, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redisTarget{}, err
+	}
+	var t redisTarget
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		t.tls = true
+	default:
+		return redisTarget{}, fmt.Errorf("scheme must be redis or rediss")
+	}
+	if u.Hostname() == "" {
+		return redisTarget{}, fmt.Errorf("no host")
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultRedisPort
+	}
+	t.address = net.JoinHostPort(u.Hostname(), port)
+	if u.User != nil {
+		t.username = u.User.Username()
+		t.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if t.db, err = strconv.Atoi(db); err != nil || t.db < 0 {
+			return redisTarget{}, fmt.Errorf("bad database %q", db)
+		}
+	}
+	return t, nil
+}
+
+// redisConn speaks enough RESP, the Redis protocol, to send commands and
+// check their replies.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// dialRedis connects, authenticates and selects the database of the URL.
+func dialRedis(cfg RedisType) (*redisConn, error) {
+	t, err := parseRedisURL(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Password != "" {
+		if t.password, err = resolveSecret(cfg.Password); err != nil {
+			return nil, fmt.Errorf("error resolving redis password: %s", err)
+		}
+	}
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	if t.tls {
+		host, _, _ := net.SplitHostPort(t.address)
+		conn, err = tls.DialWithDialer(dialer, "tcp", t.address, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", t.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	var setup [][]string
+	switch {
+	case t.username != "":
+		setup = append(setup, []string{"AUTH", t.username, t.password})
+	case t.password != "":
+		setup = append(setup, []string{"AUTH", t.password})
+	}
+	if t.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(t.db)})
+	}
+	if err := c.do(setup); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// do sends the commands in one go, then reads their replies. An error
+// reply is returned as is; the connection is not usable after any error.
+func (c *redisConn) do(cmds [][]string) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+	for _, cmd := range cmds {
+		fmt.Fprintf(c.w, "*%d\r\n", len(cmd))
+		for _, arg := range cmd {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	for range cmds {
+		if err := c.readReply(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readReply reads a reply, with the ones it is an array of.
+func (c *redisConn) readReply() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return fmt.Errorf("empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return nil
+	case '-':
+		return fmt.Errorf("%s", line[1:])
+	case '$', '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return fmt.Errorf("bad reply %q", line)
+		}
+		if line[0] == '$' {
+			if n >= 0 {
+				_, err = c.r.Discard(n + 2)
+			}
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := c.readReply(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("bad reply %q", line)
+}
+
+func (c *redisConn) close() {
+	c.conn.Close()
+}
+
+// redisCycle is the message of a cycle on the cycles channel.
+type redisCycle struct {
+	cycleSummary
+	Time    time.Time    `json:"time"`
+	Results []ResultType `json:"results"`
+}
+
+// redisPath is the message of a path on its results channel.
+type redisPath struct {
+	pathState
+	Cycle   string       `json:"cycle"`
+	Results []ResultType `json:"results"`
+}
+
+// runRedis publishes every event as it happens and, at the end of every
+// cycle, its results, updating the hashes of the paths checked.
+func runRedis(stop <-chan struct{}) {
+	results := subscribeResults()
+	defer unsubscribeResults(results)
+	events := subscribeEvents()
+	defer unsubscribeEvents(events)
+	e := &redisExporter{}
+	defer e.close()
+	var pending []ResultType
+	for {
+		select {
+		case <-stop:
+			return
+		case res := <-results:
+			pending = append(pending, res)
+		case event := <-events:
+			configLock.RLock()
+			cfg := configData.Redis
+			configLock.RUnlock()
+			cmds := [][]string{redisPublish(cfg.prefix()+":events", event)}
+			if event.Type == "cycle" {
+				// The results of the cycle were all published before
+				// its event, some may not have been received yet.
+				for drained := false; !drained; {
+					select {
+					case res := <-results:
+						pending = append(pending, res)
+					default:
+						drained = true
+					}
+				}
+				summary, _ := event.Data.(cycleSummary)
+				cmds = append(cmds, redisCycleCommands(cfg, summary, event.Time, pending)...)
+				pending = nil
+			}
+			e.send(cfg, event.Type, cmds)
+		}
+	}
+}
+
+// redisCycleCommands returns the commands updating the hash of every path
+// of results and publishing them, per path and as a whole.
+func redisCycleCommands(cfg RedisType, summary cycleSummary, at time.Time, results []ResultType) [][]string {
+	prefix := cfg.prefix()
+	paths := make(map[string][]ResultType)
+	var keys []string
+	for _, res := range results {
+		if res.Site == "" {
+			continue
+		}
+		key := res.Region + ":" + res.Site
+		if _, ok := paths[key]; !ok {
+			keys = append(keys, key)
+		}
+		paths[key] = append(paths[key], res)
+	}
+	sort.Strings(keys)
+	var cmds [][]string
+	for _, key := range keys {
+		state := sched.status(SiteType{Region: paths[key][0].Region, Site: paths[key][0].Site})
+		hash := prefix + ":path:" + key
+		cmds = append(cmds, append([]string{"HSET", hash}, redisPathFields(state, summary.ID, paths[key])...))
+		if cfg.TTL > 0 {
+			cmds = append(cmds, []string{"EXPIRE", hash, strconv.FormatUint(uint64(cfg.TTL), 10)})
+		}
+		cmds = append(cmds, redisPublish(prefix+":results:"+key, redisPath{pathState: state, Cycle: summary.ID, Results: paths[key]}))
+	}
+	if results == nil {
+		results = []ResultType{}
+	}
+	return append(cmds, redisPublish(prefix+":cycles", redisCycle{cycleSummary: summary, Time: at, Results: results}))
+}
+
+// redisPathFields returns the field/value pairs of the hash of a path: its
+// state, and the fields of its latest result of each measurement as
+// "measurement.field", with its time as "measurement.time". When a path
+// has several series of a measurement, the last one is kept.
+func redisPathFields(state pathState, cycle string, results []ResultType) []string {
+	fields := map[string]string{
+		"region":  state.Region,
+		"site":    state.Site,
+		"state":   state.State,
+		"summary": state.Summary,
+		"cycle":   cycle,
+		"updated": clock.Now().UTC().Format(time.RFC3339),
+	}
+	if !state.Since.IsZero() {
+		fields["since"] = state.Since.UTC().Format(time.RFC3339)
+	}
+	if !state.Checked.IsZero() {
+		fields["checked"] = state.Checked.UTC().Format(time.RFC3339)
+	}
+	for _, res := range results {
+		// The state is that of the hash.
+		if res.Measurement == "state" {
+			continue
+		}
+		fields[res.Measurement+".time"] = res.Time.UTC().Format(time.RFC3339Nano)
+		for name, value := range res.Fields {
+			// Already in the hash, or only telling results apart.
+			if name == "cycle" || name == "session" {
+				continue
+			}
+			fields[res.Measurement+"."+name] = fmt.Sprint(value)
+		}
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		pairs = append(pairs, name, fields[name])
+	}
+	return pairs
+}
+
+// redisPublish returns the command publishing msg as JSON on channel.
+func redisPublish(channel string, msg interface{}) []string {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		data = []byte("null")
+	}
+	return []string{"PUBLISH", channel, string(data)}
+}
+
+// redisExporter keeps its connection across cycles, reconnecting after an
+// error or a config change.
+type redisExporter struct {
+	cfg  RedisType
+	conn *redisConn
+}
+
+func (e *redisExporter) close() {
+	if e.conn != nil {
+		e.conn.close()
+		e.conn = nil
+	}
+}
+
+// send runs cmds, connecting first when needed; what is the event they
+// are for, for the logs.
+func (e *redisExporter) send(cfg RedisType, what string, cmds [][]string) {
+	if cfg.URL == "" {
+		return
+	}
+	if cfg != e.cfg {
+		e.close()
+		e.cfg = cfg
+	}
+	logger := log.WithFields(log.Fields{"Event": what, "Commands": len(cmds)})
+	if dryRun {
+		logger.Info("Would send Redis commands")
+		return
+	}
+	if e.conn == nil {
+		conn, err := dialRedis(cfg)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to connect to Redis: %s", err))
+			return
+		}
+		e.conn = conn
+	}
+	if err := e.conn.do(cmds); err != nil {
+		logger.Error(fmt.Sprintf("Failed to send to Redis: %s", err))
+		// Reconnected next time.
+		e.close()
+		return
+	}
+	logger.Debug("Sent Redis commands")
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// reportCell is the RTT (microseconds) and loss (percent) of one path,
+// averaged over the series measured on it.
+type reportCell struct {
+	rtt, loss           float64
+	rttCount, lossCount int
+	down                bool
+}
+
+func (c *reportCell) add(rtt float64, hasRTT bool, loss float64, hasLoss bool, weight int) {
+	if hasRTT {
+		c.rtt = (c.rtt*float64(c.rttCount) + rtt*float64(weight)) / float64(c.rttCount+weight)
+		c.rttCount += weight
+	}
+	if hasLoss {
+		c.loss = (c.loss*float64(c.lossCount) + loss*float64(weight)) / float64(c.lossCount+weight)
+		c.lossCount += weight
+	}
+}
+
+func (c *reportCell) String() string {
+	switch {
+	case c == nil:
+		return "-"
+	case c.rttCount == 0 && (c.down || c.lossCount > 0):
+		return "down"
+	case c.rttCount == 0:
+		return "-"
+	case c.lossCount == 0:
+		return fmt.Sprintf("%.1fms", c.rtt/1000)
+	}
+	return fmt.Sprintf("%.1fms %.1f%%", c.rtt/1000, c.loss)
+}
+
+// reportMatrix holds a cell per measuring site (row) and measured site
+// (column).
+type reportMatrix struct {
+	rows, cols []string
+	cells      map[string]*reportCell
+}
+
+func (m *reportMatrix) cell(row string, col string) *reportCell {
+	if m.cells == nil {
+		m.cells = make(map[string]*reportCell)
+	}
+	c := m.cells[row+"|"+col]
+	if c == nil {
+		c = &reportCell{}
+		m.cells[row+"|"+col] = c
+		m.addRow(row)
+		m.addCol(col)
+	}
+	return c
+}
+
+func (m *reportMatrix) addRow(row string) {
+	for _, r := range m.rows {
+		if r == row {
+			return
+		}
+	}
+	m.rows = append(m.rows, row)
+}
+
+func (m *reportMatrix) addCol(col string) {
+	for _, c := range m.cols {
+		if c == col {
+			return
+		}
+	}
+	m.cols = append(m.cols, col)
+}
+
+// print writes the matrix as an aligned text table, rows and columns
+// sorted, for pasting into chats and tickets.
+func (m *reportMatrix) print(title string) {
+	sort.Strings(m.rows)
+	sort.Strings(m.cols)
+	table := [][]string{append([]string{"FROM \\ TO"}, m.cols...)}
+	for _, row := range m.rows {
+		line := []string{row}
+		for _, col := range m.cols {
+			line = append(line, m.cells[row+"|"+col].String())
+		}
+		table = append(table, line)
+	}
+	widths := make([]int, len(table[0]))
+	for _, line := range table {
+		for i, text := range line {
+			if len(text) > widths[i] {
+				widths[i] = len(text)
+			}
+		}
+	}
+	fmt.Println(title)
+	for _, line := range table {
+		var b strings.Builder
+		for i, text := range line {
+			if i == 0 {
+				b.WriteString(text + strings.Repeat(" ", widths[i]-len(text)))
+			} else {
+				b.WriteString("  " + strings.Repeat(" ", widths[i]-len(text)) + text)
+			}
+		}
+		fmt.Println(b.String())
+	}
+}
+
+// runReport implements "netcheck report": a site by site matrix of RTT
+// and loss, averaged over -from from the local agent's history, or from
+// one check of every configured site with -run.
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.Usage = commandUsage("report", fs)
+	admin := addAdminFlags(fs)
+	from := fs.String("from", "1h", "Average the agent's results since then, a duration back from now or an RFC 3339 time")
+	run := fs.Bool("run", false, "Check every configured site once instead of asking the agent")
+	fs.Parse(args)
+	matrix := &reportMatrix{}
+	var title string
+	if *run {
+		if debug {
+			log.SetLevel(log.DebugLevel)
+		} else {
+			log.SetLevel(log.WarnLevel)
+		}
+		if err := reportRun(matrix); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		title = "RTT and loss, one check"
+	} else {
+		if err := reportHistory(admin, *from, matrix); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		title = "RTT and loss, average since " + *from
+	}
+	if len(matrix.cols) == 0 {
+		fmt.Fprintln(stderr, "no sites")
+		return 1
+	}
+	matrix.print(title)
+	return 0
+}
+
+// reportHistory fills matrix from the RTT history of every site of the
+// local agent.
+func reportHistory(admin *adminFlags, from string, matrix *reportMatrix) error {
+	resp, err := admin.do("GET", "/status")
+	if err != nil {
+		return err
+	}
+	var states []pathState
+	err = json.NewDecoder(resp.Body).Decode(&states)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	var down []string
+	for _, state := range states {
+		key := state.Region + "/" + state.Site
+		resp, err := admin.do("GET", "/api/sites/"+key+"/history?measurement=rtt&from="+url.QueryEscape(from))
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+		var reply historyReply
+		err = json.NewDecoder(resp.Body).Decode(&reply)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+		matrix.addCol(key)
+		for _, series := range reply.Series {
+			row := series.Tags["region1"] + "/" + series.Tags["site1"]
+			rtt, hasRTT := series.Fields["avg"]
+			loss, hasLoss := series.Fields["loss"]
+			matrix.cell(row, key).add(rtt.Avg, hasRTT, loss.Avg, hasLoss, series.Count)
+		}
+		if len(reply.Series) == 0 && state.State == "down" {
+			down = append(down, key)
+		}
+	}
+	// Sites without any result in the window are down from every site
+	// measuring.
+	if len(matrix.rows) == 0 {
+		matrix.addRow("local")
+	}
+	for _, key := range down {
+		for _, row := range matrix.rows {
+			matrix.cell(row, key).down = true
+		}
+	}
+	return nil
+}
+
+// reportRun fills matrix from one check of every configured site, from
+// the local site.
+func reportRun(matrix *reportMatrix) error {
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("%s: %s", configFile, err)
+	}
+	configData = cfg
+	out := &printWriter{quiet: true}
+	var wg sync.WaitGroup
+	for _, site := range cfg.RemoteSites {
+		wg.Add(1)
+		go func(site SiteType) {
+			defer wg.Done()
+			CheckSite(context.Background(), out, cfg.LocalSite, site, cfg.Port)
+		}(site)
+	}
+	wg.Wait()
+	local := cfg.LocalSite.key()
+	matrix.addRow(local)
+	for _, site := range cfg.RemoteSites {
+		cell := matrix.cell(local, site.key())
+		cell.down = true
+		for _, res := range siteResults(site.key()) {
+			if res.Measurement != "rtt" {
+				continue
+			}
+			avg, hasRTT := res.Fields["avg"].(int64)
+			loss, hasLoss := res.Fields["loss"].(float64)
+			cell.add(float64(avg), hasRTT, loss, hasLoss, 1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type dnsCacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+var (
+	dnsCache     = make(map[string]dnsCacheEntry)
+	dnsCacheLock sync.Mutex
+	lastResolved = make(map[string]string)
+)
+
+// resolveHost returns the addresses of host, IPv4 first. With resolveMode
+// "ttl" answers are cached for as long as their TTL allows, otherwise the
+// system resolver is asked on every call.
+func resolveHost(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	var ips []net.IP
+	var err error
+	if currentConfig().ResolveMode == "ttl" {
+		ips, err = lookupCached(host)
+	} else {
+		ips, err = lookupSystem(host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+	// Cached answers are shared between checks: sort a copy.
+	ips = append([]net.IP(nil), ips...)
+	sortIPv4First(ips)
+	return ips, nil
+}
+
+// siteFamily returns the address family to probe remoteSite over: ipv4,
+// ipv6 or auto.
+func siteFamily(site SiteType) string {
+	if site.Family != "" {
+		return site.Family
+	}
+	if family := currentConfig().Family; family != "" {
+		return family
+	}
+	return "auto"
+}
+
+func filterFamily(ips []net.IP, family string) []net.IP {
+	if family != "ipv4" && family != "ipv6" {
+		return ips
+	}
+	var out []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (family == "ipv4") {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+func sortIPv4First(ips []net.IP) {
+	n := 0
+	for i, ip := range ips {
+		if ip.To4() != nil {
+			ips[n], ips[i] = ips[i], ips[n]
+			n++
+		}
+	}
+}
+
+// noteResolved logs when the address picked for a site changes, so DNS
+// based failover can be seen in the logs.
+func noteResolved(site SiteType, ip net.IP) {
+	key := site.Region + "/" + site.Site + "/" + site.Address
+	dnsCacheLock.Lock()
+	prev := lastResolved[key]
+	lastResolved[key] = ip.String()
+	dnsCacheLock.Unlock()
+	if prev != "" && prev != ip.String() {
+		log.WithFields(log.Fields{"Region": site.Region, "Site": site.Site}).Info(fmt.Sprintf("%s now resolves to %s (was %s)", site.Address, ip, prev))
+	}
+}
+
+// resolvedAddress returns the address last picked for a site, nil
+// before its first check.
+func resolvedAddress(site SiteType) net.IP {
+	dnsCacheLock.Lock()
+	defer dnsCacheLock.Unlock()
+	return net.ParseIP(lastResolved[site.Region+"/"+site.Site+"/"+site.Address])
+}
+
+func lookupSystem(host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), host)
+	ips := make([]net.IP, 0, len(addrs))
+	for _, a := range addrs {
+		ips = append(ips, a.IP)
+	}
+	return ips, err
+}
+
+// lookupCached asks the nameservers for the A and AAAA records of host,
+// and caches them for their TTL. The answer of one family is kept when the
+// query of the other fails. Names the system resolver treats on its own,
+// those in /etc/hosts and unqualified ones it completes with the search
+// domains, are left to it, as are those the nameservers do not resolve.
+func lookupCached(host string) ([]net.IP, error) {
+	dnsCacheLock.Lock()
+	entry, ok := dnsCache[host]
+	dnsCacheLock.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.ips, nil
+	}
+	if !strings.Contains(strings.TrimSuffix(host, "."), ".") || inHostsFile(host) {
+		return lookupSystem(host)
+	}
+	servers, err := nameservers()
+	if err != nil {
+		return lookupSystem(host)
+	}
+	for _, server := range servers {
+		var ips []net.IP
+		var ttl uint32 = 1<<32 - 1
+		for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+			found, t, err := queryDNS(server, host, qtype)
+			if err != nil {
+				log.WithFields(log.Fields{"Server": server}).Debug(fmt.Sprintf("DNS query for %s failed: %s", host, err))
+				continue
+			}
+			if len(found) > 0 && t < ttl {
+				ttl = t
+			}
+			ips = append(ips, found...)
+		}
+		if len(ips) == 0 {
+			continue
+		}
+		dnsCacheLock.Lock()
+		dnsCache[host] = dnsCacheEntry{ips: ips, expires: time.Now().Add(time.Duration(ttl) * time.Second)}
+		dnsCacheLock.Unlock()
+		return ips, nil
+	}
+	return lookupSystem(host)
+}
+
+// inHostsFile tells whether /etc/hosts names host.
+func inHostsFile(host string) bool {
+	f, err := os.Open("/etc/hosts")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	host = strings.TrimSuffix(host, ".")
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		for i := 1; i < len(fields); i++ {
+			if strings.EqualFold(strings.TrimSuffix(fields[i], "."), host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func nameservers() ([]string, error) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no nameservers in /etc/resolv.conf")
+	}
+	return servers, nil
+}
+
+// queryDNS asks server for records of qtype and returns the addresses and
+// the smallest TTL among them.
+func queryDNS(server string, host string, qtype dnsmessage.Type) ([]net.IP, uint32, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	req, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write(req); err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	var resp dnsmessage.Message
+	if err := resp.Unpack(buf[:n]); err != nil {
+		return nil, 0, err
+	}
+	if resp.ID != id {
+		return nil, 0, fmt.Errorf("mismatched DNS reply from %s", server)
+	}
+	if resp.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("%s: %s", host, resp.RCode)
+	}
+	var ips []net.IP
+	var ttl uint32 = 1<<32 - 1
+	for _, ans := range resp.Answers {
+		switch body := ans.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(body.A[:]))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(body.AAAA[:]))
+		default:
+			continue
+		}
+		if ans.Header.TTL < ttl {
+			ttl = ans.Header.TTL
+		}
+	}
+	return ips, ttl, nil
+}
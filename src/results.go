@@ -0,0 +1,212 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// ResultType is the latest measurement of one series.
+type ResultType struct {
+	Region      string                 `json:"region"`
+	Site        string                 `json:"site"`
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags"`
+	Fields      map[string]interface{} `json:"fields"`
+	Time        time.Time              `json:"time"`
+	Cycle       string                 `json:"cycle,omitempty"`
+}
+
+// historySize is how many results per site are kept for the dashboard.
+const historySize = 120
+
+// maxSiteSeries bounds the series kept per site, the oldest going first.
+// Sites and series without a result for resultsIdle are dropped too, see
+// pruneResults.
+const (
+	maxSiteSeries = 256
+	resultsIdle   = 24 * time.Hour
+)
+
+var (
+	results     = make(map[string]map[string]ResultType)
+	history     = make(map[string][]ResultType)
+	subscribers = make(map[chan ResultType]struct{})
+	resultsLock sync.Mutex
+)
+
+// writeResult writes a point for remoteSite and keeps it as the site's
+// latest result for that series. Subscribers get a copy; slow ones miss
+// results rather than hold up probing.
+func writeResult(API influxAPI.WriteAPI, measurement string, remoteSite SiteType, tags map[string]string, fields map[string]interface{}) {
+	now := clock.Now()
+	// The IDs go into the exported point only: as fields, so they do
+	// not add series.
+	pointFields := make(map[string]interface{}, len(fields)+2)
+	for k, v := range fields {
+		pointFields[k] = v
+	}
+	if session := sched.sessionID(remoteSite); session != "" {
+		pointFields["session"] = session
+	}
+	if remoteSite.cycle != "" {
+		pointFields["cycle"] = remoteSite.cycle
+	}
+	result := ResultType{Region: remoteSite.Region, Site: remoteSite.Site, Measurement: measurement, Tags: tags, Fields: fields, Time: now, Cycle: remoteSite.cycle}
+	if rolledUp(measurement) {
+		addRollup(result)
+	}
+	if !rolledUp(measurement) || currentConfig().Rollup.KeepPaths {
+		exportResult(API, measurement, tags, pointFields, now)
+	}
+	series := measurement + "," + seriesKey(tags)
+	resultsLock.Lock()
+	defer resultsLock.Unlock()
+	site := results[remoteSite.key()]
+	if site == nil {
+		site = make(map[string]ResultType)
+		results[remoteSite.key()] = site
+	}
+	site[series] = result
+	if len(site) > maxSiteSeries {
+		oldest := series
+		for k, r := range site {
+			if r.Time.Before(site[oldest].Time) {
+				oldest = k
+			}
+		}
+		delete(site, oldest)
+	}
+	if store != nil {
+		store.add(result)
+	}
+	past := append(history[remoteSite.key()], result)
+	if len(past) > historySize {
+		past = past[len(past)-historySize:]
+	}
+	history[remoteSite.key()] = past
+	for ch := range subscribers {
+		select {
+		case ch <- result:
+		default:
+			atomic.AddUint64(&telemetry.exportDropped, 1)
+		}
+	}
+}
+
+// exportResult writes the point of a result. When influxWrite's
+// measurements names a loss measurement, the loss of an rtt result is
+// written there as a point of its own, with the same tags and IDs.
+func exportResult(API influxAPI.WriteAPI, measurement string, tags map[string]string, fields map[string]interface{}, ts time.Time) {
+	loss, hasLoss := fields["loss"]
+	_, split := currentConfig().InfluxWrite.Measurements["loss"]
+	if measurement != "rtt" || !hasLoss || !split {
+		API.WritePoint(newPoint(measurement, tags, fields, ts))
+		return
+	}
+	rttFields := make(map[string]interface{}, len(fields))
+	lossFields := map[string]interface{}{"loss": loss}
+	for k, v := range fields {
+		switch k {
+		case "loss":
+		case "session", "cycle":
+			rttFields[k] = v
+			lossFields[k] = v
+		default:
+			rttFields[k] = v
+		}
+	}
+	API.WritePoint(newPoint("rtt", tags, rttFields, ts))
+	API.WritePoint(newPoint("loss", tags, lossFields, ts))
+}
+
+// subscribeResults returns a channel receiving every result written from
+// now on, until passed to unsubscribeResults.
+func subscribeResults() chan ResultType {
+	ch := make(chan ResultType, 64)
+	resultsLock.Lock()
+	subscribers[ch] = struct{}{}
+	resultsLock.Unlock()
+	return ch
+}
+
+func unsubscribeResults(ch chan ResultType) {
+	resultsLock.Lock()
+	delete(subscribers, ch)
+	resultsLock.Unlock()
+}
+
+// siteResults returns the latest results of a site, oldest series first.
+func siteResults(key string) []ResultType {
+	resultsLock.Lock()
+	defer resultsLock.Unlock()
+	list := make([]ResultType, 0, len(results[key]))
+	for _, r := range results[key] {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+	return list
+}
+
+// siteHistory returns the kept results of one measurement of a site,
+// oldest first.
+func siteHistory(key string, measurement string) []ResultType {
+	resultsLock.Lock()
+	defer resultsLock.Unlock()
+	var list []ResultType
+	for _, r := range history[key] {
+		if r.Measurement == measurement {
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+// latestResult returns when a result for the site was last written,
+// outage points aside.
+func latestResult(key string) time.Time {
+	resultsLock.Lock()
+	defer resultsLock.Unlock()
+	var latest time.Time
+	for _, r := range results[key] {
+		if reachable, ok := r.Fields["reachable"].(bool); ok && !reachable {
+			continue
+		}
+		if r.Time.After(latest) {
+			latest = r.Time
+		}
+	}
+	return latest
+}
+
+// pruneResults drops the series without a result since resultsIdle
+// before now, and the sites left without any.
+func pruneResults(now time.Time) {
+	resultsLock.Lock()
+	defer resultsLock.Unlock()
+	for key, site := range results {
+		for series, r := range site {
+			if now.Sub(r.Time) > resultsIdle {
+				delete(site, series)
+			}
+		}
+		if len(site) == 0 {
+			delete(results, key)
+		}
+	}
+	for key, past := range history {
+		if len(past) == 0 || now.Sub(past[len(past)-1].Time) > resultsIdle {
+			delete(history, key)
+		}
+	}
+}
+
+func forgetResults(key string) {
+	resultsLock.Lock()
+	delete(results, key)
+	delete(history, key)
+	resultsLock.Unlock()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// RollupType replaces the rtt points of every path with one "rollup"
+// point per region pair and cycle, for meshes too large to export path by
+// path. The paths are still kept locally, for the admin API, history and
+// summaries, and exported too with keepPaths.
+type RollupType struct {
+	Enabled     bool      `yaml:"enabled"`
+	Percentiles []float64 `yaml:"percentiles"`
+	KeepPaths   bool      `yaml:"keepPaths"`
+}
+
+var defaultPercentiles = []float64{50, 95, 99}
+
+func (r RollupType) percentiles() []float64 {
+	if len(r.Percentiles) == 0 {
+		return defaultPercentiles
+	}
+	return r.Percentiles
+}
+
+// rolledUp tells whether points of measurement go into the rollups.
+func rolledUp(measurement string) bool {
+	return currentConfig().Rollup.Enabled && measurement == "rtt"
+}
+
+// regionRollup collects the rtt results from the local region to one
+// remote region.
+type regionRollup struct {
+	region1, region2 string
+	paths            []ResultType
+}
+
+var (
+	rollups     = make(map[string]*regionRollup)
+	rollupsLock sync.Mutex
+)
+
+func addRollup(res ResultType) {
+	rollupsLock.Lock()
+	defer rollupsLock.Unlock()
+	key := res.Tags["region1"] + "/" + res.Region
+	r := rollups[key]
+	if r == nil {
+		r = &regionRollup{region1: res.Tags["region1"], region2: res.Region}
+		rollups[key] = r
+	}
+	r.paths = append(r.paths, res)
+}
+
+// flushRollups writes the rollups collected since the last flush, one
+// point per region pair: the number of paths and of paths down, the mean
+// and percentiles of their average RTT, their mean and highest loss, and
+// the worst path, the one with the most loss or else the highest RTT.
+func flushRollups(API influxAPI.WriteAPI, cycle string) {
+	rollupsLock.Lock()
+	pending := rollups
+	rollups = make(map[string]*regionRollup)
+	rollupsLock.Unlock()
+	configLock.RLock()
+	percentiles := configData.Rollup.percentiles()
+	tags := make(map[string]string)
+	for k, v := range configData.Tags {
+		tags[k] = v
+	}
+	if configData.TagHostname && hostname != "" {
+		tags["host"] = hostname
+	}
+	configLock.RUnlock()
+	for _, r := range pending {
+		var avgs []float64
+		var loss, maxLoss float64
+		var down int
+		var worst *ResultType
+		for i := range r.paths {
+			p := &r.paths[i]
+			l, _ := p.Fields["loss"].(float64)
+			loss += l
+			maxLoss = math.Max(maxLoss, l)
+			avg, ok := p.Fields["avg"].(int64)
+			if ok {
+				avgs = append(avgs, float64(avg))
+			} else {
+				down++
+			}
+			if worst == nil || l > worstLoss(worst) || (l == worstLoss(worst) && ok && float64(avg) > worstAvg(worst)) {
+				worst = p
+			}
+		}
+		fields := map[string]interface{}{
+			"paths":      len(r.paths),
+			"paths_down": down,
+			"loss":       loss / float64(len(r.paths)),
+			"max_loss":   maxLoss,
+			"worst_path": worst.Tags["site1"] + "->" + worst.Site,
+		}
+		if len(avgs) > 0 {
+			sort.Float64s(avgs)
+			var sum float64
+			for _, avg := range avgs {
+				sum += avg
+			}
+			fields["avg"] = sum / float64(len(avgs))
+			for _, p := range percentiles {
+				rank := int(math.Ceil(p / 100 * float64(len(avgs))))
+				if rank < 1 {
+					rank = 1
+				}
+				fields[fmt.Sprintf("p%g", p)] = avgs[rank-1]
+			}
+		}
+		if cycle != "" {
+			fields["cycle"] = cycle
+		}
+		pointTags := map[string]string{"region1": r.region1, "region2": r.region2}
+		for k, v := range tags {
+			pointTags[k] = v
+		}
+		API.WritePoint(newPoint("rollup", pointTags, fields, clock.Now()))
+	}
+}
+
+func worstLoss(res *ResultType) float64 {
+	l, _ := res.Fields["loss"].(float64)
+	return l
+}
+
+func worstAvg(res *ResultType) float64 {
+	avg, ok := res.Fields["avg"].(int64)
+	if !ok {
+		return math.Inf(1)
+	}
+	return float64(avg)
+}
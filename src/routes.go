@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	influx "github.com/influxdata/influxdb-client-go/v2"
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	log "github.com/sirupsen/logrus"
+)
+
+// InfluxRouteType sends the points of the sites it matches to another
+// Influx. Unset connection settings are the global ones. Only the Influx
+// points are routed: the other exporters, such as Redis, IPFIX and gNMI,
+// have a single destination for all sites.
+type InfluxRouteType struct {
+	Regions      []string `yaml:"regions"`
+	Sites        []string `yaml:"sites"`
+	InfluxURL    string   `yaml:"influxUrl"`
+	InfluxOrg    string   `yaml:"influxOrg"`
+	InfluxBucket string   `yaml:"influxBucket"`
+	InfluxToken  string   `yaml:"influxToken"`
+}
+
+// matches tells whether the route takes the points of site, given as
+// region and site name.
+func (r InfluxRouteType) matches(region string, site string) bool {
+	for _, name := range r.Regions {
+		if name == region {
+			return true
+		}
+	}
+	for _, name := range r.Sites {
+		if name == region+"/"+site {
+			return true
+		}
+	}
+	return false
+}
+
+func (r InfluxRouteType) name() string {
+	return strings.Join(append(append([]string(nil), r.Regions...), r.Sites...), ",")
+}
+
+// routeWriter is the client of one influxRoutes entry.
+type routeWriter struct {
+	route  InfluxRouteType
+	client influx.Client
+	api    influxAPI.WriteAPI
+}
+
+// openRoutes connects to the Influx of every route, or logs their points
+// in -dry-run. The global token must have been resolved already. Routes
+// without a token of their own are reconnected when the global one is
+// renewed, see swap, and those with a Vault token when theirs is.
+func (w *writerSwitch) openRoutes(routes []InfluxRouteType) error {
+	w.tagNames = configData.InfluxWrite.TagNames
+	for i, route := range routes {
+		if route.InfluxURL == "" {
+			route.InfluxURL = configData.InfluxURL
+		}
+		if route.InfluxOrg == "" {
+			route.InfluxOrg = configData.InfluxOrg
+		}
+		if route.InfluxBucket == "" {
+			route.InfluxBucket = configData.InfluxBucket
+		}
+		if dryRun {
+			w.routes = append(w.routes, routeWriter{route: route, api: logWriter{target: route.InfluxURL + " " + route.InfluxBucket}})
+			continue
+		}
+		token := configData.InfluxToken
+		if route.InfluxToken != "" {
+			var err error
+			if token, err = w.routeToken(i, route.InfluxToken); err != nil {
+				closeRoutes(w.routes)
+				return fmt.Errorf("error resolving influxRoutes[%d].influxToken: %s", i, err)
+			}
+		}
+		client, err := newInfluxClient(route.InfluxURL, token, configData.InfluxWrite)
+		if err != nil {
+			closeRoutes(w.routes)
+			return fmt.Errorf("error configuring influxRoutes[%d]: %s", i, err)
+		}
+		api := client.WriteAPI(route.InfluxOrg, route.InfluxBucket)
+		go countWriteErrors(api)
+		w.routes = append(w.routes, routeWriter{route: route, client: client, api: api})
+	}
+	return nil
+}
+
+// routeToken resolves the token of route i, watching it when it is a
+// Vault one.
+func (w *writerSwitch) routeToken(i int, ref string) (string, error) {
+	if !strings.HasPrefix(ref, "vault:") {
+		return resolveSecret(ref)
+	}
+	secret, err := resolveVaultSecret(ref)
+	if err != nil {
+		return "", err
+	}
+	updates := make(chan string)
+	go watchVaultSecret(ref, secret, updates)
+	go func() {
+		for token := range updates {
+			log.WithFields(log.Fields{"Route": i}).Info("Influx route token renewed, reconnecting")
+			w.swapRoute(i, token)
+		}
+	}()
+	return secret.value, nil
+}
+
+// swapRoute replaces the client of route i by one using token.
+func (w *writerSwitch) swapRoute(i int, token string) {
+	w.Lock()
+	old := w.routes[i]
+	if err := w.reconnectRoute(i, token); err != nil {
+		w.Unlock()
+		log.Error(fmt.Sprintf("Failed to reconnect influxRoutes[%d]: %s", i, err))
+		return
+	}
+	stale := append(w.dropBucketAPIs(old.client), old.api)
+	w.Unlock()
+	retire([]influx.Client{old.client}, stale)
+}
+
+// reconnectRoute gives route i a new client using token. The caller holds
+// the write lock, and retires the old client.
+func (w *writerSwitch) reconnectRoute(i int, token string) error {
+	r := &w.routes[i]
+	client, err := newInfluxClient(r.route.InfluxURL, token, configData.InfluxWrite)
+	if err != nil {
+		return err
+	}
+	r.client = client
+	r.api = client.WriteAPI(r.route.InfluxOrg, r.route.InfluxBucket)
+	go countWriteErrors(r.api)
+	return nil
+}
+
+// retire flushes the APIs of replaced clients, then closes these.
+func retire(clients []influx.Client, apis []influxAPI.WriteAPI) {
+	for _, api := range apis {
+		api.Flush()
+	}
+	for _, client := range clients {
+		client.Close()
+	}
+}
+
+func closeRoutes(writers []routeWriter) {
+	for _, w := range writers {
+		w.api.Flush()
+		if w.client != nil {
+			w.client.Close()
+		}
+	}
+}
+
+// setTagNames takes over the influxWrite.tagNames of a reloaded
+// configuration, for routing.
+func (w *writerSwitch) setTagNames(names map[string]string) {
+	w.Lock()
+	w.tagNames = names
+	w.Unlock()
+}
+
+// pointSite returns the region and site a point is about: the remote
+// site, or the local one for points without one such as "agent". Tag keys
+// renamed by names, influxWrite.tagNames, are followed.
+func pointSite(point *write.Point, names map[string]string) (string, string) {
+	key := func(tag string) string {
+		if name, ok := names[tag]; ok {
+			return name
+		}
+		return tag
+	}
+	tags := make(map[string]string)
+	for _, tag := range point.TagList() {
+		tags[tag.Key] = tag.Value
+	}
+	if region, ok := tags[key("region2")]; ok {
+		return region, tags[key("site2")]
+	}
+	return tags[key("region1")], tags[key("site1")]
+}
+
+// bucketKey identifies the API of a site's org and bucket override. The
+// client is nil in -dry-run, where url tells the destinations apart.
+type bucketKey struct {
+	client           influx.Client
+	url, org, bucket string
+}
+
+// forSite returns the API to write the points of site with: w itself,
+// unless the site overrides the org or bucket.
+func (w *writerSwitch) forSite(site SiteType) influxAPI.WriteAPI {
+	if site.InfluxOrg == "" && site.InfluxBucket == "" {
+		return w
+	}
+	tags := make(map[string]string)
+	for k, v := range currentConfig().Tags {
+		tags[k] = v
+	}
+	for k, v := range site.Tags {
+		tags[k] = v
+	}
+	org, err := expandTags(site.InfluxOrg, tags)
+	if err != nil {
+		siteLog(site).Warn(fmt.Sprintf("Writing to the default org: %s", err))
+		return w
+	}
+	bucket, err := expandTags(site.InfluxBucket, tags)
+	if err != nil {
+		siteLog(site).Warn(fmt.Sprintf("Writing to the default bucket: %s", err))
+		return w
+	}
+	return siteWriter{writerSwitch: w, org: org, bucket: bucket}
+}
+
+// bucketAPI returns the API writing to org and bucket through client,
+// creating it on first use.
+func (w *writerSwitch) bucketAPI(client influx.Client, url string, org string, bucket string) influxAPI.WriteAPI {
+	key := bucketKey{client: client, url: url, org: org, bucket: bucket}
+	w.apisLock.Lock()
+	defer w.apisLock.Unlock()
+	if api, ok := w.apis[key]; ok {
+		return api
+	}
+	var api influxAPI.WriteAPI
+	if client == nil {
+		api = logWriter{target: url + " " + org + "/" + bucket}
+	} else {
+		api = client.WriteAPI(org, bucket)
+		go countWriteErrors(api)
+	}
+	if w.apis == nil {
+		w.apis = make(map[bucketKey]influxAPI.WriteAPI)
+	}
+	w.apis[key] = api
+	return api
+}
+
+// dropBucketAPIs forgets the APIs of client, which is being replaced, and
+// returns them for flushing.
+func (w *writerSwitch) dropBucketAPIs(client influx.Client) []influxAPI.WriteAPI {
+	w.apisLock.Lock()
+	defer w.apisLock.Unlock()
+	var dropped []influxAPI.WriteAPI
+	for key, api := range w.apis {
+		if key.client == client {
+			dropped = append(dropped, api)
+			delete(w.apis, key)
+		}
+	}
+	return dropped
+}
+
+// siteWriter writes the points of a site with an org or bucket override
+// through the client its route, or the default, would use.
+type siteWriter struct {
+	*writerSwitch
+	org, bucket string
+}
+
+func (s siteWriter) WritePoint(point *write.Point) {
+	atomic.AddUint64(&telemetry.pointsQueued, 1)
+	s.RLock()
+	defer s.RUnlock()
+	cfg := currentConfig()
+	client, url, org, bucket := s.client, cfg.InfluxURL, cfg.InfluxOrg, cfg.InfluxBucket
+	if r := s.route(point); r != nil {
+		client, url, org, bucket = r.client, r.route.InfluxURL, r.route.InfluxOrg, r.route.InfluxBucket
+	}
+	if s.org != "" {
+		org = s.org
+	}
+	if s.bucket != "" {
+		bucket = s.bucket
+	}
+	s.bucketAPI(client, url, org, bucket).WritePoint(point)
+}
+
+var tagRef = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// expandTags replaces {tag} references in s with the tag's value.
+func expandTags(s string, tags map[string]string) (string, error) {
+	var missing []string
+	out := tagRef.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[1 : len(ref)-1]
+		val, ok := tags[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return val
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined tags: %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
@@ -0,0 +1,703 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	influx "github.com/influxdata/influxdb-client-go/v2"
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	log "github.com/sirupsen/logrus"
+)
+
+// startClient sets up everything the probing side needs (credentials,
+// enrichment databases, the Influx client) and starts the scheduler. The
+// returned channel is closed once the scheduler has shut down.
+func startClient(reloads <-chan ConfigType, stop <-chan struct{}) (chan struct{}, error) {
+	var err error
+	if err := openGeoIP(configData.GeoIP); err != nil {
+		return nil, err
+	}
+	if err := openASN(configData.ASN); err != nil {
+		return nil, err
+	}
+	if configData.EBPF.Enabled {
+		ifaces, err := configData.ebpfInterfaces()
+		if err == nil {
+			err = startEBPF(ifaces, configData.EBPF.XDP)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error loading the eBPF programs: %s", err)
+		}
+		log.Info(fmt.Sprintf("eBPF timestamping on %d interfaces", len(ifaces)))
+	}
+	tokenUpdates := make(chan string)
+	writer := &writerSwitch{api: logWriter{}}
+	if dryRun {
+		log.Info("Dry run, points are logged instead of written")
+	} else {
+		tokenRef := configData.InfluxToken
+		if strings.HasPrefix(tokenRef, "vault:") {
+			var secret vaultSecret
+			secret, err = resolveVaultSecret(tokenRef)
+			if err == nil {
+				configData.InfluxToken = secret.value
+				go watchVaultSecret(tokenRef, secret, tokenUpdates)
+			}
+		} else {
+			configData.InfluxToken, err = resolveSecret(tokenRef)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error resolving influxToken: %s", err)
+		}
+		client, err := newInfluxClient(configData.InfluxURL, configData.InfluxToken, configData.InfluxWrite)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring influx: %s", err)
+		}
+		writer = newWriterSwitch(client)
+	}
+	if err = writer.openRoutes(configData.InfluxRoutes); err != nil {
+		return nil, err
+	}
+	if configData.History.enabled() {
+		if store, err = openStore(configData.History); err != nil {
+			return nil, err
+		}
+		go store.run(stop)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	publishConfig()
+	sched = newScheduler(ctx, configData.RemoteSites, writer)
+	go runDiscovery(stop)
+	go runCron(stop)
+	go runSessions(stop)
+	go runTraceroutes(stop)
+	go runSummaries(stop)
+	if len(configData.STUN.Servers) > 0 {
+		go runSTUN(configData.STUN, stop)
+	}
+	if configData.FirstHop.Enabled {
+		go runFirstHop(writer, configData.FirstHop, stop)
+	}
+	if configData.Capture.Dir != "" {
+		go runCapture(configData.Capture, stop)
+	}
+	if configData.IPFIX.Collector != "" {
+		go runIPFIX(stop)
+	}
+	if configData.Redis.URL != "" {
+		go runRedis(stop)
+	}
+	if configData.Grafana.URL != "" && !dryRun {
+		a, err := newAnnotator(configData.Grafana)
+		if err != nil {
+			return nil, err
+		}
+		go a.run(stop)
+	}
+	done := make(chan struct{})
+	go func() {
+		runScheduler(time.Duration(configData.Period)*time.Second, tokenUpdates, reloads, stop)
+		if store != nil {
+			if err := store.save(); err != nil {
+				log.Error(fmt.Sprintf("Failed to save history: %s", err))
+			}
+		}
+		close(done)
+	}()
+	return done, nil
+}
+
+// writerSwitch is a WriteAPI whose Influx client can be replaced (after a
+// token renewal) while checks are writing to it. Without a client it
+// writes to api alone.
+type writerSwitch struct {
+	sync.RWMutex
+	client influx.Client
+	api    influxAPI.WriteAPI
+	routes []routeWriter
+	// tagNames are those of influxWrite, read by routing.
+	tagNames map[string]string
+	// apis are the APIs of the org and bucket overrides of sites, by
+	// client, created on first use.
+	apis     map[bucketKey]influxAPI.WriteAPI
+	apisLock sync.Mutex
+}
+
+func newWriterSwitch(client influx.Client) *writerSwitch {
+	api := client.WriteAPI(configData.InfluxOrg, configData.InfluxBucket)
+	go countWriteErrors(api)
+	return &writerSwitch{client: client, api: api}
+}
+
+func (w *writerSwitch) WriteRecord(line string) {
+	atomic.AddUint64(&telemetry.pointsQueued, 1)
+	w.RLock()
+	defer w.RUnlock()
+	w.api.WriteRecord(line)
+}
+
+func (w *writerSwitch) WritePoint(point *write.Point) {
+	atomic.AddUint64(&telemetry.pointsQueued, 1)
+	w.RLock()
+	defer w.RUnlock()
+	w.target(point).WritePoint(point)
+}
+
+// target is the API of the first route matching the point's site, else
+// the default one.
+func (w *writerSwitch) target(point *write.Point) influxAPI.WriteAPI {
+	if r := w.route(point); r != nil {
+		return r.api
+	}
+	return w.api
+}
+
+func (w *writerSwitch) route(point *write.Point) *routeWriter {
+	if len(w.routes) == 0 {
+		return nil
+	}
+	region, site := pointSite(point, w.tagNames)
+	for i := range w.routes {
+		if w.routes[i].route.matches(region, site) {
+			return &w.routes[i]
+		}
+	}
+	return nil
+}
+
+func (w *writerSwitch) Flush() {
+	w.RLock()
+	defer w.RUnlock()
+	w.api.Flush()
+	for _, r := range w.routes {
+		r.api.Flush()
+	}
+}
+
+func (w *writerSwitch) Errors() <-chan error {
+	w.RLock()
+	defer w.RUnlock()
+	return w.api.Errors()
+}
+
+// swap replaces the client by one using the renewed global token, and
+// reconnects the routes using that token too.
+func (w *writerSwitch) swap(client influx.Client, token string) {
+	w.Lock()
+	old := []influx.Client{w.client}
+	stale := []influxAPI.WriteAPI{w.api}
+	w.client = client
+	w.api = client.WriteAPI(configData.InfluxOrg, configData.InfluxBucket)
+	go countWriteErrors(w.api)
+	for i, r := range w.routes {
+		if r.route.InfluxToken != "" {
+			continue
+		}
+		if err := w.reconnectRoute(i, token); err != nil {
+			log.Error(fmt.Sprintf("Failed to reconnect influxRoutes[%d]: %s", i, err))
+			continue
+		}
+		old = append(old, r.client)
+		stale = append(stale, r.api)
+	}
+	for _, c := range old {
+		stale = append(stale, w.dropBucketAPIs(c)...)
+	}
+	w.Unlock()
+	retire(old, stale)
+}
+
+func (w *writerSwitch) close() {
+	w.Lock()
+	defer w.Unlock()
+	w.api.Flush()
+	w.apisLock.Lock()
+	for _, api := range w.apis {
+		api.Flush()
+	}
+	w.apisLock.Unlock()
+	if w.client != nil {
+		w.client.Close()
+	}
+	closeRoutes(w.routes)
+}
+
+// schedulerType owns the runtime site list: the configured sites (which
+// the admin API changes) plus those found by each discovery source. Each
+// cycle works on a snapshot.
+type schedulerType struct {
+	lock       sync.Mutex
+	sites      []SiteType
+	discovered map[string][]SiteType
+	paused     map[string]bool
+	down       map[string]bool
+	states     map[string]pathState
+	adapt      map[string]adaptState
+	// skipped are the sites left out of the last cycle for lack of time.
+	skipped map[string]bool
+	// sessions holds the session ID of each path, see sessionID.
+	sessions map[string]string
+	// ctx ends on shutdown, cancelling the checks derived from it.
+	ctx context.Context
+	// running holds the cancel function of each check in progress, by
+	// site and run number.
+	running map[string]map[uint64]context.CancelFunc
+	runs    uint64
+	// progress is when the last site check finished, for /healthz.
+	progress time.Time
+	writer   *writerSwitch
+	// checks counts the cycles and checks in progress; none are started
+	// once stopping is set.
+	checks   sync.WaitGroup
+	stopping bool
+}
+
+var (
+	sched *schedulerType
+	// configLock serializes reloads with the readers of configData.
+	configLock sync.RWMutex
+	// liveConfig is a copy of configData published after every change.
+	// Checks read it rather than configData, so they do not hold
+	// configLock while probing. Reloads replace maps and slices in
+	// configData instead of changing them, so a copy stays as it was.
+	liveConfig atomic.Value
+)
+
+// publishConfig makes the present configData the one checks see. It is
+// called with configLock held, or before the scheduler starts.
+func publishConfig() {
+	cfg := configData
+	liveConfig.Store(&cfg)
+}
+
+// currentConfig returns the configuration last published. Before the
+// first, as in the one-shot commands, it is configData, which then does
+// not change.
+func currentConfig() *ConfigType {
+	if cfg, ok := liveConfig.Load().(*ConfigType); ok {
+		return cfg
+	}
+	return &configData
+}
+
+// listLocked returns the configured sites followed by the discovered ones,
+// sources in name order. A configured site wins over a discovered one with
+// the same region and site.
+func (s *schedulerType) listLocked() []SiteType {
+	sites := append([]SiteType(nil), s.sites...)
+	seen := make(map[string]bool, len(sites))
+	for _, site := range sites {
+		seen[site.key()] = true
+	}
+	sources := make([]string, 0, len(s.discovered))
+	for source := range s.discovered {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		for _, site := range s.discovered[source] {
+			if !seen[site.key()] {
+				seen[site.key()] = true
+				sites = append(sites, site)
+			}
+		}
+	}
+	return sites
+}
+
+func (s *schedulerType) list() []SiteType {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.listLocked()
+}
+
+// setDiscovered replaces the sites found by one discovery source.
+func (s *schedulerType) setDiscovered(source string, sites []SiteType) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.discovered[source] = sites
+}
+
+func (s *schedulerType) snapshot() []SiteType {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	all := s.listLocked()
+	sites := make([]SiteType, 0, len(all))
+	for _, site := range all {
+		if site.enabled() && !s.paused[site.key()] {
+			sites = append(sites, site)
+		}
+	}
+	return sites
+}
+
+func (s *schedulerType) find(key string) (SiteType, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, site := range s.listLocked() {
+		if site.key() == key {
+			return site, true
+		}
+	}
+	return SiteType{}, false
+}
+
+func (s *schedulerType) setSites(sites []SiteType) {
+	s.lock.Lock()
+	s.sites = sites
+	s.lock.Unlock()
+}
+
+// addSite adds or replaces the site with the same region and site name.
+func (s *schedulerType) addSite(site SiteType) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, existing := range s.sites {
+		if existing.key() == site.key() {
+			s.sites[i] = site
+			return
+		}
+	}
+	s.sites = append(s.sites, site)
+}
+
+func (s *schedulerType) removeSite(key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, site := range s.sites {
+		if site.key() == key {
+			s.sites = append(s.sites[:i:i], s.sites[i+1:]...)
+			delete(s.paused, key)
+			delete(s.down, key)
+			delete(s.states, key)
+			delete(s.adapt, key)
+			delete(s.sessions, key)
+			forgetResults(key)
+			forgetPathTimeouts(key)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *schedulerType) setPaused(key string, paused bool) bool {
+	if _, ok := s.find(key); !ok {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if paused {
+		s.paused[key] = true
+	} else {
+		delete(s.paused, key)
+	}
+	return true
+}
+
+func (s *schedulerType) isPaused(key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.paused[key]
+}
+
+// runCheck checks site with the check timeout as deadline and reports
+// whether the check wrote a result. done is false when the check was
+// cancelled, by shutdown or cancelChecks, and so says nothing about the
+// site.
+func (s *schedulerType) runCheck(site SiteType) (reachable bool, done bool) {
+	cfg := currentConfig()
+	ctx, cancel := s.checkContext(cfg, site)
+	defer cancel()
+	key := site.key()
+	s.lock.Lock()
+	s.runs++
+	run := s.runs
+	if s.running[key] == nil {
+		s.running[key] = make(map[uint64]context.CancelFunc)
+	}
+	s.running[key][run] = cancel
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.running[key], run)
+		if len(s.running[key]) == 0 {
+			delete(s.running, key)
+		}
+		s.lock.Unlock()
+	}()
+	if site.LogSample == 0 {
+		site.LogSample = cfg.LogSample
+	}
+	checked := clock.Now()
+	CheckSite(ctx, s.writer.forSite(site), cfg.LocalSite, site, cfg.Port)
+	switch ctx.Err() {
+	case context.Canceled:
+		siteLog(site).Info("Check cancelled")
+		return false, false
+	case context.DeadlineExceeded:
+		siteLog(site).Warn("Check timed out")
+	}
+	// With a clock that has not moved, as in tests, the result has the
+	// start time of the check.
+	return !latestResult(key).Before(checked), true
+}
+
+// checkContext returns the context of a check of site: cancelled on
+// shutdown, and after the site's check timeout if it has one.
+func (s *schedulerType) checkContext(cfg *ConfigType, site SiteType) (context.Context, context.CancelFunc) {
+	if timeout := cfg.checkTimeout(site); timeout > 0 {
+		return context.WithTimeout(s.ctx, timeout)
+	}
+	return context.WithCancel(s.ctx)
+}
+
+// cancelChecks cancels the checks of the site with key in progress and
+// returns how many there were.
+func (s *schedulerType) cancelChecks(key string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, cancel := range s.running[key] {
+		cancel()
+	}
+	return len(s.running[key])
+}
+
+// check probes one site now, outside the regular cycle. It returns false,
+// without checking, once the scheduler is shutting down.
+func (s *schedulerType) check(site SiteType) bool {
+	site.cycle = newID()
+	s.lock.Lock()
+	if s.stopping {
+		s.lock.Unlock()
+		return false
+	}
+	s.checks.Add(1)
+	s.lock.Unlock()
+	go func() {
+		defer s.checks.Done()
+		reachable, done := s.runCheck(site)
+		if !done {
+			return
+		}
+		s.noteReachable(site, reachable)
+		s.noteState(site, reachable)
+	}()
+	return true
+}
+
+// shutdown refuses new checks, then waits for those in progress.
+func (s *schedulerType) shutdown() {
+	s.lock.Lock()
+	s.stopping = true
+	s.lock.Unlock()
+	s.checks.Wait()
+}
+
+// newScheduler returns a scheduler of sites writing through writer, whose
+// checks end with ctx.
+func newScheduler(ctx context.Context, sites []SiteType, writer *writerSwitch) *schedulerType {
+	return &schedulerType{ctx: ctx, running: make(map[string]map[uint64]context.CancelFunc), sites: sites, discovered: make(map[string][]SiteType), paused: make(map[string]bool), down: make(map[string]bool), states: make(map[string]pathState), adapt: make(map[string]adaptState), skipped: make(map[string]bool), sessions: make(map[string]string), progress: clock.Now(), writer: writer}
+}
+
+// cycleOrder returns the sites of a cycle by priority, highest first.
+// Within a priority the sites skipped last cycle come first, so that
+// overload does not starve the same sites every time.
+func (s *schedulerType) cycleOrder() []SiteType {
+	var sites []SiteType
+	for _, site := range s.snapshot() {
+		if site.Schedule == "" {
+			sites = append(sites, site)
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sort.SliceStable(sites, func(i, j int) bool {
+		if sites[i].Priority != sites[j].Priority {
+			return sites[i].Priority > sites[j].Priority
+		}
+		return s.skipped[sites[i].key()] && !s.skipped[sites[j].key()]
+	})
+	return sites
+}
+
+// runCycle checks every active site without a schedule once, by priority,
+// stopping early when stop is closed. When the period is over before all
+// sites are done the rest is skipped and counted, rather than delaying the
+// next cycle. A site is counted reachable when its check wrote a result;
+// the counts are published as a cycle event.
+func (s *schedulerType) runCycle(stop <-chan struct{}) {
+	start := clock.Now()
+	configLock.RLock()
+	deadline := start.Add(time.Duration(configData.Period) * time.Second)
+	configLock.RUnlock()
+	summary := cycleSummary{ID: newID()}
+	skipped := make(map[string]bool)
+	for _, site := range s.cycleOrder() {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if clock.Now().After(deadline) {
+			skipped[site.key()] = true
+			continue
+		}
+		site.cycle = summary.ID
+		reachable, done := s.runCheck(site)
+		s.touch()
+		if !done {
+			continue
+		}
+		s.noteReachable(site, reachable)
+		s.noteState(site, reachable)
+		s.noteOutcome(site, reachable)
+		summary.Sites++
+		if reachable {
+			summary.Reachable++
+		} else {
+			summary.Unreachable++
+		}
+	}
+	s.touch()
+	s.lock.Lock()
+	s.skipped = skipped
+	s.lock.Unlock()
+	if len(skipped) > 0 {
+		summary.Skipped = len(skipped)
+		atomic.AddUint64(&telemetry.skipped, uint64(len(skipped)))
+		log.Warn(fmt.Sprintf("Period over, skipped %d low-priority sites", len(skipped)))
+	}
+	summary.Duration = clock.Since(start).Seconds()
+	atomic.AddUint64(&telemetry.cycles, 1)
+	pruneResults(clock.Now())
+	prunePathTimeouts(clock.Now())
+	publishEvent("cycle", summary)
+	configLock.RLock()
+	selfTelemetry := configData.SelfTelemetry
+	rollup := configData.Rollup.Enabled
+	configLock.RUnlock()
+	if rollup {
+		flushRollups(s.writer, summary.ID)
+	}
+	if selfTelemetry {
+		writeTelemetry(s.writer, summary)
+	}
+}
+
+// runScheduler checks every remote site once per period until stop is
+// closed. A cycle still running when the next tick comes is not started
+// twice. On stop the checks in progress are cancelled, after which
+// pending points are flushed and the client is closed. Configurations
+// received on reloads replace the site list and period.
+func runScheduler(period time.Duration, tokenUpdates <-chan string, reloads <-chan ConfigType, stop <-chan struct{}) {
+	ticker := clock.NewTicker(period)
+	defer ticker.Stop()
+	defer sched.writer.close()
+	defer sched.shutdown()
+	var cycleDone chan struct{}
+	for {
+		select {
+		case <-stop:
+			return
+		case token := <-tokenUpdates:
+			log.Info("Influx token renewed, reconnecting")
+			client, err := newInfluxClient(configData.InfluxURL, token, configData.InfluxWrite)
+			if err != nil {
+				log.Error(fmt.Sprintf("Failed to reconnect: %s", err))
+				continue
+			}
+			sched.writer.swap(client, token)
+		case cfg := <-reloads:
+			configLock.Lock()
+			applyConfig(cfg, ticker)
+			configLock.Unlock()
+			sched.writer.setTagNames(cfg.InfluxWrite.TagNames)
+			sched.setSites(cfg.RemoteSites)
+		case <-ticker.C():
+			if cycleDone != nil {
+				select {
+				case <-cycleDone:
+				default:
+					log.Warn("Previous cycle still running, skipping this one")
+					continue
+				}
+			}
+			cycleDone = make(chan struct{})
+			sched.checks.Add(1)
+			go func(done chan struct{}) {
+				defer sched.checks.Done()
+				defer close(done)
+				sched.runCycle(stop)
+			}(cycleDone)
+		}
+	}
+}
+
+// applyConfig takes over the parts of a reloaded configuration that can
+// change at runtime. Listener, Influx and credential settings need a restart.
+func applyConfig(cfg ConfigType, ticker Ticker) {
+	if cfg.Period != configData.Period && cfg.Period > 0 {
+		ticker.Reset(time.Duration(cfg.Period) * time.Second)
+		configData.Period = cfg.Period
+	}
+	if fmt.Sprint(cfg.listenAddresses()) != fmt.Sprint(configData.listenAddresses()) || cfg.InfluxURL != configData.InfluxURL || cfg.InfluxOrg != configData.InfluxOrg || cfg.InfluxBucket != configData.InfluxBucket || fmt.Sprint(cfg.InfluxRoutes) != fmt.Sprint(configData.InfluxRoutes) || cfg.ProbeKey != configData.ProbeKey {
+		log.Warn("Listener, Influx or probe key settings changed, restart required to apply them")
+	}
+	if fmt.Sprint(cfg.EBPF) != fmt.Sprint(configData.EBPF) {
+		log.Warn("eBPF settings changed, restart required to apply them")
+	}
+	configData.Port = cfg.Port
+	configData.Traceroute = cfg.Traceroute
+	configData.CheckTimeout = cfg.CheckTimeout
+	configData.LocalSite = cfg.LocalSite
+	configData.RemoteSites = cfg.RemoteSites
+	configData.Tags = cfg.Tags
+	configData.TagHostname = cfg.TagHostname
+	configData.TagVersion = cfg.TagVersion
+	configData.TagSourceIP = cfg.TagSourceIP
+	configData.TagPTR = cfg.TagPTR
+	configData.TagResolvedIP = cfg.TagResolvedIP
+	configData.ResolveMode = cfg.ResolveMode
+	configData.AllAddresses = cfg.AllAddresses
+	configData.Family = cfg.Family
+	configData.SourceAddress = cfg.SourceAddress
+	configData.Interface = cfg.Interface
+	configData.DSCP = cfg.DSCP
+	configData.TTL = cfg.TTL
+	configData.DontFragment = cfg.DontFragment
+	configData.SocketBuffers.Probe = cfg.SocketBuffers.Probe
+	configData.Proxy = cfg.Proxy
+	configData.InfluxWrite.Measurement = cfg.InfluxWrite.Measurement
+	configData.InfluxWrite.Measurements = cfg.InfluxWrite.Measurements
+	configData.InfluxWrite.TagNames = cfg.InfluxWrite.TagNames
+	configData.TagLimits = cfg.TagLimits
+	configData.LogSample = cfg.LogSample
+	configData.LogRate = cfg.LogRate
+	configData.LogPayloads = cfg.LogPayloads
+	configData.SelfTelemetry = cfg.SelfTelemetry
+	configData.HostTiming = cfg.HostTiming
+	configData.TimeSource = cfg.TimeSource
+	configData.Rollup = cfg.Rollup
+	configData.ProbeTimeout = cfg.ProbeTimeout
+	configData.PathStates = cfg.PathStates
+	configData.IPFIX = cfg.IPFIX
+	configData.Redis = cfg.Redis
+	configData.Adaptive = cfg.Adaptive
+	configData.Summaries = cfg.Summaries
+	configData.Capture = cfg.Capture
+	configData.RemoteSitesURL = cfg.RemoteSitesURL
+	configData.Discovery = cfg.Discovery
+	publishConfig()
+	publishReflector(configData)
+	log.Info(fmt.Sprintf("Configuration reloaded, %d remote sites", len(cfg.RemoteSites)))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// startTestScheduler sets up the scheduler on a fake clock, with a site
+// whose reflector runs on loopback, and returns the site and the clock.
+// The globals are restored when the test ends.
+func startTestScheduler(t *testing.T, schedule string) (SiteType, *fakeClock) {
+	savedClock, savedConfig, savedSched := clock, configData, sched
+	fake := newFakeClock(time.Date(2026, 1, 1, 12, 3, 30, 0, time.Local))
+	clock = fake
+	configData = ConfigType{
+		Period:       60,
+		LocalSite:    SiteType{Region: "local", Site: "test"},
+		ProbeTimeout: ProbeTimeoutType{Adaptive: true, Max: 200},
+	}
+	path, err := startSimulatedPath(impairment{}, impairment{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	site := SiteType{Address: "127.0.0.1", Port: uint(path.addr.Port), Region: "test", Site: "reflector", Count: 1, Schedule: schedule}
+	ctx, cancel := context.WithCancel(context.Background())
+	sched = newScheduler(ctx, []SiteType{site}, &writerSwitch{api: logWriter{}})
+	t.Cleanup(func() {
+		cancel()
+		path.close()
+		clock, configData, sched = savedClock, savedConfig, savedSched
+	})
+	return site, fake
+}
+
+// waitForTimers waits until n timers or tickers are set on c, so that
+// advancing it fires them.
+func waitForTimers(t *testing.T, c *fakeClock, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.lock.Lock()
+		set := len(c.waiters)
+		c.lock.Unlock()
+		if set >= n {
+			return
+		}
+	}
+	t.Fatalf("%d timers never set", n)
+}
+
+// nextCycle returns the summary of the next cycle event on events, or
+// false when none comes within wait.
+func nextCycle(events chan EventType, wait time.Duration) (cycleSummary, bool) {
+	timeout := time.After(wait)
+	for {
+		select {
+		case event := <-events:
+			if summary, ok := event.Data.(cycleSummary); ok && event.Type == "cycle" {
+				return summary, true
+			}
+		case <-timeout:
+			return cycleSummary{}, false
+		}
+	}
+}
+
+func TestSchedulerCyclePerPeriod(t *testing.T) {
+	_, fake := startTestScheduler(t, "")
+	events := subscribeEvents()
+	defer unsubscribeEvents(events)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		runScheduler(time.Minute, nil, nil, stop)
+		close(done)
+	}()
+	waitForTimers(t, fake, 1)
+	fake.Advance(59 * time.Second)
+	if _, ok := nextCycle(events, 100*time.Millisecond); ok {
+		t.Fatal("cycle before the period was over")
+	}
+	for i, step := range []time.Duration{time.Second, time.Minute} {
+		fake.Advance(step)
+		summary, ok := nextCycle(events, 2*time.Second)
+		if !ok {
+			t.Fatalf("no cycle %d", i+1)
+		}
+		if summary.Sites != 1 || summary.Reachable != 1 {
+			t.Errorf("cycle %d: %+v", i+1, summary)
+		}
+	}
+	close(stop)
+	<-done
+	if sched.check(SiteType{Region: "test", Site: "late"}) {
+		t.Error("check started after shutdown")
+	}
+}
+
+func TestCronSchedule(t *testing.T) {
+	site, fake := startTestScheduler(t, "*/5 * * * *")
+	results := subscribeResults()
+	defer unsubscribeResults(results)
+	stop := make(chan struct{})
+	defer close(stop)
+	go runCron(stop)
+	// 12:04 does not match.
+	waitForTimers(t, fake, 1)
+	fake.Advance(30 * time.Second)
+	waitForTimers(t, fake, 1)
+	select {
+	case res := <-results:
+		t.Fatalf("checked at %s: %+v", fake.Now().Format("15:04"), res)
+	case <-time.After(100 * time.Millisecond):
+	}
+	fake.Advance(time.Minute)
+	select {
+	case res := <-results:
+		if res.Site != site.Site || res.Measurement != "rtt" {
+			t.Errorf("unexpected result %+v", res)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("not checked at 12:05")
+	}
+	sched.shutdown()
+}
+
+func TestPathTimeoutsPruned(t *testing.T) {
+	site, fake := startTestScheduler(t, "")
+	other := "test/other 127.0.0.2:4000"
+	notePathRTT(site.key()+" 127.0.0.1:4000", time.Millisecond)
+	notePathRTT(other, time.Millisecond)
+	defer forgetPathTimeouts("test/other")
+	sched.removeSite(site.key())
+	pathTimeoutsLock.Lock()
+	_, removed := pathTimeouts[site.key()+" 127.0.0.1:4000"]
+	_, kept := pathTimeouts[other]
+	pathTimeoutsLock.Unlock()
+	if removed || !kept {
+		t.Fatalf("after removeSite: removed site kept %v, other site kept %v", removed, kept)
+	}
+	prunePathTimeouts(fake.Now().Add(pathIdle + time.Second))
+	pathTimeoutsLock.Lock()
+	_, kept = pathTimeouts[other]
+	pathTimeoutsLock.Unlock()
+	if kept {
+		t.Fatal("idle path not pruned")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// resolveSecret expands a credential reference from the config.
+// "env:NAME" reads the environment variable NAME, "file:/path" reads the
+// file contents (trailing newline stripped), "vault:path#key" reads from
+// Vault, "aws-sm://" and "aws-ssm://" read from AWS Secrets Manager and SSM
+// Parameter Store, anything else is used as is. The value is redacted from
+// the logs and the admin API from then on.
+func resolveSecret(ref string) (string, error) {
+	val, err := lookupSecret(ref)
+	if err == nil {
+		registerSecret(val)
+	}
+	return val, err
+}
+
+func lookupSecret(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return val, nil
+	case strings.HasPrefix(ref, "file:"):
+		path := strings.TrimPrefix(ref, "file:")
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading secret file %s: %s", path, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(ref, "vault:"):
+		secret, err := resolveVaultSecret(ref)
+		return secret.value, err
+	case strings.HasPrefix(ref, "aws-sm://"):
+		return resolveAWSSecretsManager(ref)
+	case strings.HasPrefix(ref, "aws-ssm://"):
+		return resolveAWSParameter(ref)
+	}
+	return ref, nil
+}
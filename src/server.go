@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// listenAddresses returns the addresses the reflector listens on: the
+// listen list when given, else every address on listenPort and
+// listenPorts.
+func (c ConfigType) listenAddresses() []string {
+	if len(c.Listen) > 0 {
+		return c.Listen
+	}
+	addresses := []string{fmt.Sprintf(":%d", c.listenPort())}
+	for _, port := range c.ListenPorts {
+		addresses = append(addresses, fmt.Sprintf(":%d", port))
+	}
+	return addresses
+}
+
+// reflectorSettings are the settings the packet path reads. They are
+// published as one snapshot, swapped on reload, so reflector goroutines
+// never wait on configLock.
+type reflectorSettings struct {
+	LogSample   uint
+	LogRate     uint
+	LogPayloads bool
+	TimeSource  TimeSourceType
+}
+
+var reflectorConfig atomic.Value
+
+func settingsOf(cfg ConfigType) reflectorSettings {
+	return reflectorSettings{LogSample: cfg.LogSample, LogRate: cfg.LogRate, LogPayloads: cfg.LogPayloads, TimeSource: cfg.TimeSource}
+}
+
+// publishReflector makes cfg's packet-path settings the current ones.
+func publishReflector(cfg ConfigType) {
+	reflectorConfig.Store(settingsOf(cfg))
+}
+
+// reflector returns the packet-path settings last published. Before the
+// first, as in the one-shot commands, they come from configData, which
+// then does not change.
+func reflector() reflectorSettings {
+	if s, ok := reflectorConfig.Load().(reflectorSettings); ok {
+		return s
+	}
+	return settingsOf(configData)
+}
+
+// startListeners opens a UDP reflector (and a TCP one when enabled) on
+// every listen address. The returned closers shut them down.
+func startListeners(stop <-chan struct{}) ([]io.Closer, error) {
+	var closers []io.Closer
+	fail := func(err error) ([]io.Closer, error) {
+		for _, c := range closers {
+			c.Close()
+		}
+		return nil, err
+	}
+	opts := sockOpts{
+		Device:     configData.Interface,
+		RecvTOS:    true,
+		RecvBuffer: configData.SocketBuffers.Listener.Receive,
+		SendBuffer: configData.SocketBuffers.Listener.Send,
+	}
+	for _, address := range configData.listenAddresses() {
+		svc, err := listenUDP(address, opts)
+		if err != nil {
+			return fail(fmt.Errorf("Error listening socket %s: %s", address, err))
+		}
+		closers = append(closers, svc)
+		go startUDPServer(svc, stop)
+		if configData.TCPReflector {
+			l, err := listenTCP(address)
+			if err != nil {
+				return fail(fmt.Errorf("Error listening TCP socket %s: %s", address, err))
+			}
+			closers = append(closers, l)
+			go startTCPServer(l, stop)
+		}
+		log.WithFields(log.Fields{"Address": address}).Debug("Reflector listening")
+	}
+	if m := configData.Discovery.MDNS; m != nil && m.Advertise {
+		adv, err := advertiseMDNS(m, configData.listenPort())
+		if err != nil {
+			return fail(err)
+		}
+		closers = append(closers, adv)
+	}
+	return closers, nil
+}
+
+func startUDPServer(svc net.PacketConn, stop <-chan struct{}) {
+	atomic.AddInt32(&reflectorsRunning, 1)
+	defer atomic.AddInt32(&reflectorsRunning, -1)
+	reflectUDP(svc.(*net.UDPConn), svc, stop)
+}
+
+// reflectUDP answers the probes read from udp through out, which is udp
+// itself except in simulations, until stop is closed.
+func reflectUDP(udp *net.UDPConn, out net.PacketConn, stop <-chan struct{}) {
+	buf := make([]byte, maxPayload+len(macMarker)+macLen)
+	oob := make([]byte, 128)
+	for {
+		n, oobn, _, addr, err := udp.ReadMsgUDP(buf, oob)
+		if err != nil {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			log.Info("Error reading")
+			continue
+		}
+		pkt, err := verifyPacket(buf[:n])
+		if err != nil {
+			rejectPacket(addr, err)
+			continue
+		}
+		if err := checkPacket(pkt); err != nil {
+			rejectPacket(addr, err)
+			continue
+		}
+		// Echoes are sent in order before the next read reuses buf, so
+		// back-to-back probes come back as they arrived.
+		if isECNProbe(pkt) {
+			tos, ok := parseTOS(oob[:oobn])
+			reflectECN(out, addr, pkt, tos, ok)
+			continue
+		}
+		serve(out, addr, pkt)
+	}
+}
+
+func serve(svc net.PacketConn, addr net.Addr, buf []byte) {
+	if log.IsLevelEnabled(log.DebugLevel) {
+		logPacket(log.WithFields(log.Fields{"Client": addr.String()}), reflector().LogSample, describePacket(buf))
+	}
+	if isKeepalive(buf) {
+		reflectKeepalive(svc, addr, buf)
+		return
+	}
+	if isHello(buf) {
+		reflectHello(svc, addr, buf)
+		return
+	}
+	if isOWDProbe(buf) {
+		reflectOWD(svc, addr, buf)
+		return
+	}
+	if reflectPadded(svc, addr, buf) {
+		return
+	}
+	svc.WriteTo(buf, addr)
+}
+
+// startTCPServer accepts and immediately closes connections, so TCP probes
+// towards a reflector have something to connect to.
+func startTCPServer(l net.Listener, stop <-chan struct{}) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			log.Info("Error accepting")
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runServer implements "netcheck server": only the reflector, configured
+// from its own flags, so reflector hosts need no config file.
+func runServer(args []string) int {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	fs.Usage = commandUsage("server", fs)
+	port := fs.Uint("port", 0, "Port to listen on")
+	listen := fs.String("listen", "", "Comma separated address:port list to listen on instead of -port")
+	tcp := fs.Bool("tcp", false, "Accept TCP connections too, for TCP probes")
+	iface := fs.String("interface", "", "Bind to this network interface")
+	fs.BoolVar(&debug, "debug", false, "Use debug logging")
+	logSample := fs.Uint("log-sample", 0, "With -debug, log one in this many packets")
+	logRate := fs.Uint("log-rate", defaultLogRate, "With -debug, log at most this many packets a second")
+	logPayloads := fs.Bool("log-payloads", false, "With -debug, log the start of packet payloads")
+	probeKey := probeKeyFlag(fs)
+	logFlags(fs)
+	fs.Parse(args)
+	if debug {
+		log.SetLevel(log.DebugLevel)
+	}
+	if err := setupLogging(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	configData = ConfigType{Role: "server", Port: *port, TCPReflector: *tcp, Interface: *iface, LogSample: *logSample, LogRate: *logRate, LogPayloads: *logPayloads}
+	if *listen != "" {
+		configData.Listen = strings.Split(*listen, ",")
+	}
+	if *port == 0 && len(configData.Listen) == 0 {
+		fmt.Fprintln(stderr, "-port or -listen is required")
+		return 2
+	}
+	if errs := validateConfig(configData); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(stderr, err)
+		}
+		return 2
+	}
+	publishReflector(configData)
+	if err := startProbeKey(*probeKey); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	stop := make(chan struct{})
+	listeners, err := startListeners(stop)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	log.WithFields(log.Fields{"Addresses": strings.Join(configData.listenAddresses(), ",")}).Info("Reflector started")
+	handoverReady()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM}, upgradeSignals...)...)
+	for {
+		sig := <-sigs
+		if !isUpgradeSignal(sig) {
+			log.Info(fmt.Sprintf("Received %s, shutting down", sig))
+			break
+		}
+		pid, err := handover()
+		if err != nil {
+			log.Error(fmt.Sprintf("Failed to hand over: %s", err))
+			continue
+		}
+		log.Info(fmt.Sprintf("Handed over to process %d, shutting down", pid))
+		break
+	}
+	close(stop)
+	for _, l := range listeners {
+		l.Close()
+	}
+	return 0
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+)
+
+func runAsService(args []string) (bool, int) {
+	return false, 0
+}
+
+func runService(args []string) int {
+	fmt.Fprintln(stderr, "netcheck service is only available on Windows")
+	return 2
+}
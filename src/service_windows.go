@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceName = "netcheck"
+
+// agentService runs the agent under the Windows service control manager,
+// turning stop and parameter change requests into the signals the agent
+// already handles.
+type agentService struct {
+	args []string
+}
+
+func (s *agentService) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	done := make(chan int, 1)
+	go func() {
+		done <- runAgent(s.args)
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange}
+	for {
+		select {
+		case code := <-done:
+			return false, uint32(code)
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				agentSignals <- syscall.SIGTERM
+			case svc.ParamChange:
+				agentSignals <- syscall.SIGHUP
+			}
+		}
+	}
+}
+
+// eventLogHook copies log entries to the Windows event log.
+type eventLogHook struct {
+	elog *eventlog.Log
+}
+
+func (h *eventLogHook) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel, log.ErrorLevel, log.WarnLevel, log.InfoLevel}
+}
+
+func (h *eventLogHook) Fire(entry *log.Entry) error {
+	msg, err := entry.String()
+	if err != nil {
+		return err
+	}
+	switch entry.Level {
+	case log.InfoLevel:
+		return h.elog.Info(1, msg)
+	case log.WarnLevel:
+		return h.elog.Warning(2, msg)
+	default:
+		return h.elog.Error(3, msg)
+	}
+}
+
+// runAsService runs the agent as the service when started by the service
+// control manager. It returns false when the process was started any
+// other way.
+func runAsService(args []string) (bool, int) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false, 0
+	}
+	if len(args) > 0 && args[0] == "run" {
+		args = args[1:]
+	}
+	if elog, err := eventlog.Open(serviceName); err == nil {
+		defer elog.Close()
+		log.AddHook(&eventLogHook{elog: elog})
+	}
+	if err := svc.Run(serviceName, &agentService{args: args}); err != nil {
+		log.Error(fmt.Sprintf("Service failed: %s", err))
+		return true, 1
+	}
+	return true, 0
+}
+
+// runService implements "netcheck service install|uninstall|start|stop".
+// Arguments after install are passed to the agent when the service
+// starts.
+func runService(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "Usage: netcheck service install [run flags] | uninstall | start | stop")
+		return 2
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		fmt.Fprintf(stderr, "connecting to the service manager: %s\n", err)
+		return 1
+	}
+	defer m.Disconnect()
+	switch args[0] {
+	case "install":
+		err = installService(m, args[1:])
+	case "uninstall":
+		err = uninstallService(m)
+	case "start":
+		err = startService(m)
+	case "stop":
+		err = stopService(m)
+	default:
+		fmt.Fprintf(stderr, "unknown service action %s\n", args[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func installService(m *mgr.Mgr, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.Abs(exe)
+	if err != nil {
+		return err
+	}
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "netcheck network availability agent",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("creating service: %s", err)
+	}
+	defer s.Close()
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("registering event log source: %s", err)
+	}
+	fmt.Printf("Service %s installed\n", serviceName)
+	return nil
+}
+
+func uninstallService(m *mgr.Mgr) error {
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("deleting service: %s", err)
+	}
+	eventlog.Remove(serviceName)
+	fmt.Printf("Service %s removed\n", serviceName)
+	return nil
+}
+
+func startService(m *mgr.Mgr) error {
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+	return s.Start()
+}
+
+func stopService(m *mgr.Mgr) error {
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return fmt.Errorf("stopping service: %s", err)
+	}
+	for deadline := time.Now().Add(30 * time.Second); status.State != svc.Stopped; {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s did not stop in time", serviceName)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var keepalivePrefix = []byte("keepalive:")
+
+func isKeepalive(buf []byte) bool {
+	return bytes.HasPrefix(buf, keepalivePrefix)
+}
+
+// bindingEvent reports that the reflector of a site saw the agent's
+// keepalives come from another address, i.e. a NAT on the way mapped the
+// session to a new external address or port.
+type bindingEvent struct {
+	Region   string `json:"region"`
+	Site     string `json:"site"`
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+}
+
+// reflectKeepalive answers a keepalive with the address it came from, as
+// seen by the reflector.
+func reflectKeepalive(svc net.PacketConn, addr net.Addr, buf []byte) {
+	svc.WriteTo([]byte(fmt.Sprintf("%s %s", buf, addr)), addr)
+}
+
+// runSession keeps one socket open to site and sends a keepalive every
+// interval until stop is closed, so that NAT bindings on the way stay
+// open. Changes of the address the reflector sees are published as
+// "binding" events.
+func runSession(site SiteType, port uint, interval time.Duration, stop <-chan struct{}) {
+	ips, err := resolveHost(site.Address)
+	if err != nil || len(ips) == 0 {
+		siteLog(site).Debug(fmt.Sprintf("Failed to resolve %s for keepalives: %v", site.Address, err))
+		return
+	}
+	addr := &net.UDPAddr{IP: ips[0], Port: int(port)}
+	if negotiate(context.Background(), site, addr).Keepalive == 0 {
+		return
+	}
+	network := "udp6"
+	if addr.IP.To4() != nil {
+		network = "udp4"
+	}
+	laddr, err := sourceAddr(site, addr.IP)
+	if err != nil {
+		siteLog(site).Debug(err.Error())
+		return
+	}
+	svc, err := dialUDP(network, laddr, addr, probeSockOpts(site))
+	if err != nil {
+		siteLog(site).Debug(fmt.Sprintf("Failed to dial %s: %s", addr, err))
+		return
+	}
+	defer svc.Close()
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+	var observed string
+	buf := make([]byte, 512)
+	for seq := 0; ; seq++ {
+		msg := fmt.Sprintf("%s%s:%d", keepalivePrefix, id, seq)
+		svc.Write(signPacket([]byte(msg)))
+		svc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			n, err := svc.Read(buf)
+			if err != nil {
+				siteLog(site).Debug(fmt.Sprintf("No keepalive answer from %s", addr))
+				break
+			}
+			parts := strings.SplitN(string(buf[:n]), " ", 2)
+			if parts[0] != msg {
+				continue
+			}
+			if len(parts) < 2 {
+				siteLog(site).Debug("Reflector does not report the observed address")
+				break
+			}
+			if observed != "" && parts[1] != observed {
+				siteLog(site).Info(fmt.Sprintf("NAT binding changed from %s to %s", observed, parts[1]))
+				publishEvent("binding", bindingEvent{Region: site.Region, Site: site.Site, Previous: observed, Current: parts[1]})
+			}
+			observed = parts[1]
+			break
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// runSessions keeps a session running for every active site with a
+// keepalive interval, following changes to the site list, until stop is
+// closed.
+func runSessions(stop <-chan struct{}) {
+	running := make(map[string]chan struct{})
+	defer func() {
+		for _, done := range running {
+			close(done)
+		}
+	}()
+	for {
+		configLock.RLock()
+		defaultPort := configData.Port
+		configLock.RUnlock()
+		wanted := make(map[string]bool)
+		for _, site := range sched.snapshot() {
+			if site.Keepalive == 0 || (site.Type != "" && site.Type != "udp") {
+				continue
+			}
+			port := defaultPort
+			if site.Port != 0 {
+				port = site.Port
+			}
+			// A changed address, port or interval starts a new session.
+			key := fmt.Sprintf("%s %s %d %d", site.key(), site.Address, port, site.Keepalive)
+			wanted[key] = true
+			if _, ok := running[key]; !ok {
+				done := make(chan struct{})
+				running[key] = done
+				go runSession(site, port, time.Duration(site.Keepalive)*time.Second, done)
+			}
+		}
+		for key, done := range running {
+			if !wanted[key] {
+				close(done)
+				delete(running, key)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// impairment is what a simulated path does to the packets going one way.
+type impairment struct {
+	latency      time.Duration
+	jitter       time.Duration
+	loss         float64
+	reorder      float64
+	reorderDelay time.Duration
+}
+
+// impairedConn delays, drops and reorders what is sent through it, to
+// simulate one direction of a path over loopback. The decisions come from
+// a seeded generator, the same number of draws per packet, so a run with
+// the same seed loses and reorders the same packets.
+type impairedConn struct {
+	net.PacketConn
+	impairment
+
+	lock      sync.Mutex
+	rand      *rand.Rand
+	sent      int
+	dropped   int
+	reordered int
+}
+
+func newImpairedConn(conn net.PacketConn, imp impairment, seed int64) *impairedConn {
+	return &impairedConn{PacketConn: conn, impairment: imp, rand: rand.New(rand.NewSource(seed))}
+}
+
+func (c *impairedConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.impair(b, func(pkt []byte) {
+		c.PacketConn.WriteTo(pkt, addr)
+	})
+	return len(b), nil
+}
+
+// impair has send called with b, unless it is dropped, once it is due.
+func (c *impairedConn) impair(b []byte, send func([]byte)) {
+	c.lock.Lock()
+	c.sent++
+	drop := c.rand.Float64()*100 < c.loss
+	delay := c.latency
+	spread := c.rand.Int63n(2*int64(c.jitter) + 1)
+	delay += time.Duration(spread) - c.jitter
+	late := c.rand.Float64()*100 < c.reorder
+	switch {
+	case drop:
+		c.dropped++
+	case late:
+		c.reordered++
+		delay += c.reorderDelay
+	}
+	c.lock.Unlock()
+	if drop {
+		return
+	}
+	if delay <= 0 {
+		send(b)
+		return
+	}
+	// The caller reuses b for the next packet.
+	pkt := append([]byte(nil), b...)
+	time.AfterFunc(delay, func() {
+		send(pkt)
+	})
+}
+
+// relayUDP forwards the probes read from front to the reflector at target
+// through request, from a socket per prober so that the replies can be
+// sent back to it, until front is closed.
+func relayUDP(front *net.UDPConn, target *net.UDPAddr, request *impairedConn) {
+	upstreams := make(map[string]*net.UDPConn)
+	defer func() {
+		for _, up := range upstreams {
+			up.Close()
+		}
+	}()
+	buf := make([]byte, maxPayload+len(macMarker)+macLen)
+	for {
+		n, prober, err := front.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		up := upstreams[prober.String()]
+		if up == nil {
+			if up, err = net.DialUDP("udp4", nil, target); err != nil {
+				continue
+			}
+			upstreams[prober.String()] = up
+			go func(up *net.UDPConn, prober *net.UDPAddr) {
+				reply := make([]byte, maxPayload+1)
+				for {
+					n, err := up.Read(reply)
+					if err != nil {
+						return
+					}
+					front.WriteToUDP(reply[:n], prober)
+				}
+			}(up, prober)
+		}
+		request.impair(buf[:n], func(pkt []byte) {
+			up.Write(pkt)
+		})
+	}
+}
+
+// simulatedPath is a reflector on loopback behind impaired replies and,
+// when requests are impaired too, a relay. Probes go to addr.
+type simulatedPath struct {
+	addr    *net.UDPAddr
+	reply   *impairedConn
+	request *impairedConn
+	conns   []io.Closer
+	stop    chan struct{}
+}
+
+// startSimulatedPath starts the reflector and the relay, if request
+// impairs anything. The generators of the two directions are seeded from
+// seed.
+func startSimulatedPath(reply impairment, request impairment, seed int64) (*simulatedPath, error) {
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	udp, err := net.ListenUDP("udp4", loopback)
+	if err != nil {
+		return nil, err
+	}
+	p := &simulatedPath{
+		addr:  udp.LocalAddr().(*net.UDPAddr),
+		reply: newImpairedConn(udp, reply, seed),
+		conns: []io.Closer{udp},
+		stop:  make(chan struct{}),
+	}
+	go reflectUDP(udp, p.reply, p.stop)
+	if request != (impairment{}) {
+		front, err := net.ListenUDP("udp4", loopback)
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.conns = append(p.conns, front)
+		p.request = newImpairedConn(front, request, seed+1)
+		go relayUDP(front, p.addr, p.request)
+		p.addr = front.LocalAddr().(*net.UDPAddr)
+	}
+	return p, nil
+}
+
+func (p *simulatedPath) close() {
+	close(p.stop)
+	for _, c := range p.conns {
+		c.Close()
+	}
+}
+
+// runSimulate implements "netcheck simulate": the reflector and a check
+// of it in one process over loopback, with the probes and the reflector's
+// replies impaired as given. The results can be compared with the expected ones
+// so that changes to the measurements can be tested without a network.
+func runSimulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	fs.Usage = commandUsage("simulate", fs)
+	latency := fs.Duration("latency", 0, "Delay added to every reply")
+	jitter := fs.Duration("jitter", 0, "Random variation of the delay, up to this much either way")
+	loss := fs.Float64("loss", 0, "Percentage of replies dropped")
+	reorder := fs.Float64("reorder", 0, "Percentage of replies held back by -reorder-delay")
+	reorderDelay := fs.Duration("reorder-delay", 5*time.Millisecond, "Extra delay of reordered packets")
+	requestLatency := fs.Duration("request-latency", 0, "Delay added to every probe on its way to the reflector")
+	requestJitter := fs.Duration("request-jitter", 0, "Random variation of the probes' delay, up to this much either way")
+	requestLoss := fs.Float64("request-loss", 0, "Percentage of probes dropped")
+	requestReorder := fs.Float64("request-reorder", 0, "Percentage of probes held back by -reorder-delay")
+	seed := fs.Int64("seed", 1, "Seed of the impairments; the same seed gives the same losses")
+	count := fs.Uint("count", 10, "Number of probes")
+	burst := fs.Uint("burst", 0, "Also send a burst of this many probes")
+	streamRate := fs.Uint("stream-rate", 0, "Measure with a stream of this many packets per second instead")
+	streamDuration := fs.Uint("stream-duration", 0, "Seconds the stream lasts, default 10")
+	cycles := fs.Uint("cycles", 1, "Number of checks, one per -period")
+	period := fs.Duration("period", time.Minute, "Time between checks with -cycles")
+	speed := fs.Float64("speed", 1, "Run the agent's clock this many times faster than real time, to replay many periods quickly")
+	asJSON := fs.Bool("json", false, "Print results as JSON")
+	expectRTT := fs.Duration("expect-rtt", 0, "Fail unless the average RTT is within -tolerance of this")
+	tolerance := fs.Duration("tolerance", 2*time.Millisecond, "Allowed difference from -expect-rtt")
+	expectLoss := fs.Float64("expect-loss", -1, "Fail unless the loss is this percentage")
+	fs.Parse(args)
+	if debug {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.WarnLevel)
+	}
+	reply := impairment{latency: *latency, jitter: *jitter, loss: *loss, reorder: *reorder, reorderDelay: *reorderDelay}
+	request := impairment{latency: *requestLatency, jitter: *requestJitter, loss: *requestLoss, reorder: *requestReorder}
+	if request != (impairment{}) {
+		request.reorderDelay = *reorderDelay
+	}
+	for _, imp := range []impairment{reply, request} {
+		if imp.loss < 0 || imp.loss > 100 || imp.reorder < 0 || imp.reorder > 100 {
+			fmt.Fprintln(stderr, "-loss, -reorder, -request-loss and -request-reorder must be between 0 and 100")
+			return 2
+		}
+		if imp.jitter > imp.latency {
+			fmt.Fprintln(stderr, "-jitter and -request-jitter must not exceed their latency")
+			return 2
+		}
+	}
+	if *speed <= 0 || *period <= 0 {
+		fmt.Fprintln(stderr, "-speed and -period must be positive")
+		return 2
+	}
+	if *speed != 1 {
+		clock = newScaledClock(*speed)
+	}
+	path, err := startSimulatedPath(reply, request, *seed)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer path.close()
+
+	site := SiteType{Address: "127.0.0.1", Region: "sim", Site: "sim", Count: *count, Burst: *burst}
+	site.Stream = StreamType{Rate: *streamRate, Duration: *streamDuration}
+	printer := &printWriter{json: *asJSON}
+	ticker := clock.NewTicker(*period)
+	defer ticker.Stop()
+	for i := uint(0); i < *cycles; i++ {
+		if i > 0 {
+			<-ticker.C()
+		}
+		CheckSite(context.Background(), printer, SiteType{Region: "local", Site: "sim"}, site, uint(path.addr.Port))
+	}
+	if !*asJSON {
+		if c := path.request; c != nil {
+			c.lock.Lock()
+			fmt.Printf("simulated %d probes, %d dropped, %d reordered\n", c.sent, c.dropped, c.reordered)
+			c.lock.Unlock()
+		}
+		c := path.reply
+		c.lock.Lock()
+		fmt.Printf("simulated %d replies, %d dropped, %d reordered\n", c.sent, c.dropped, c.reordered)
+		c.lock.Unlock()
+	}
+
+	latest := make(map[string]ResultType)
+	for _, res := range siteResults(site.key()) {
+		latest[res.Measurement] = res
+	}
+	rtt, ok := latest["rtt"]
+	if !ok {
+		fmt.Fprintln(stderr, "no result")
+		return 1
+	}
+	// A stream counts its loss in its own point.
+	lossResult := rtt
+	if *streamRate > 0 {
+		lossResult = latest["stream"]
+	}
+	failed := false
+	if *expectRTT > 0 {
+		avg, _ := rtt.Fields["avg"].(int64)
+		got := time.Duration(avg) * time.Microsecond
+		if diff := got - *expectRTT; diff > *tolerance || -diff > *tolerance {
+			fmt.Fprintf(stderr, "rtt %s, expected %s ± %s\n", got, *expectRTT, *tolerance)
+			failed = true
+		}
+	}
+	if *expectLoss >= 0 {
+		got, _ := lossResult.Fields["loss"].(float64)
+		if got != *expectLoss {
+			fmt.Fprintf(stderr, "loss %.1f%%, expected %.1f%%\n", got, *expectLoss)
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
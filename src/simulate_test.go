@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// simulateRTT runs count probes over a simulated path, with the gaps
+// between them shortened and lost probes given up on quickly.
+func simulateRTT(t *testing.T, reply impairment, request impairment, count uint) (rttStats, *simulatedPath) {
+	savedClock, savedConfig := clock, configData
+	clock = newScaledClock(1000)
+	configData = ConfigType{ProbeTimeout: ProbeTimeoutType{Adaptive: true, Max: 200}}
+	defer func() {
+		clock, configData = savedClock, savedConfig
+	}()
+	path, err := startSimulatedPath(reply, request, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer path.close()
+	svc, err := net.DialUDP("udp4", nil, path.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close()
+	site := SiteType{Address: "127.0.0.1", Region: "sim", Site: "sim", Count: count}
+	return measureRTT(context.Background(), svc, site, path.addr), path
+}
+
+// within fails unless the duration in microseconds us is in [lo, hi].
+func within(t *testing.T, what string, us int64, lo time.Duration, hi time.Duration) {
+	t.Helper()
+	if got := time.Duration(us) * time.Microsecond; got < lo || got > hi {
+		t.Errorf("%s %s, expected %s to %s", what, got, lo, hi)
+	}
+}
+
+func TestSimulatedLatency(t *testing.T) {
+	stats, _ := simulateRTT(t, impairment{latency: 20 * time.Millisecond}, impairment{}, 10)
+	if stats.received != 10 {
+		t.Fatalf("%d of 10 replies", stats.received)
+	}
+	within(t, "rtt", stats.avg(), 20*time.Millisecond, 25*time.Millisecond)
+	within(t, "jitter", stats.maxRTT-stats.minRTT, 0, 5*time.Millisecond)
+}
+
+func TestSimulatedJitter(t *testing.T) {
+	stats, _ := simulateRTT(t, impairment{latency: 20 * time.Millisecond, jitter: 5 * time.Millisecond}, impairment{}, 20)
+	if stats.received != 20 {
+		t.Fatalf("%d of 20 replies", stats.received)
+	}
+	within(t, "rtt", stats.avg(), 15*time.Millisecond, 27*time.Millisecond)
+	within(t, "jitter", stats.maxRTT-stats.minRTT, time.Microsecond, 13*time.Millisecond)
+}
+
+func TestSimulatedLoss(t *testing.T) {
+	stats, path := simulateRTT(t, impairment{loss: 25}, impairment{}, 20)
+	if path.reply.dropped == 0 {
+		t.Fatal("no reply dropped")
+	}
+	if want := 100 * float64(path.reply.dropped) / 20; stats.loss() != want {
+		t.Errorf("loss %.0f%%, %d of 20 replies dropped", stats.loss(), path.reply.dropped)
+	}
+	// The same seed loses the same replies.
+	again, _ := simulateRTT(t, impairment{loss: 25}, impairment{}, 20)
+	if again.received != stats.received {
+		t.Errorf("%d replies, then %d with the same seed", stats.received, again.received)
+	}
+}
+
+func TestSimulatedRequestPath(t *testing.T) {
+	reply := impairment{latency: 10 * time.Millisecond}
+	request := impairment{latency: 15 * time.Millisecond, loss: 20}
+	stats, path := simulateRTT(t, reply, request, 20)
+	if path.request.dropped == 0 {
+		t.Fatal("no probe dropped")
+	}
+	if path.reply.sent != 20-path.request.dropped {
+		t.Errorf("%d replies to the %d probes through", path.reply.sent, 20-path.request.dropped)
+	}
+	if want := 100 * float64(path.request.dropped) / 20; stats.loss() != want {
+		t.Errorf("loss %.0f%%, %d of 20 probes dropped", stats.loss(), path.request.dropped)
+	}
+	within(t, "rtt", stats.avg(), 25*time.Millisecond, 30*time.Millisecond)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gopkg.in/yaml.v2"
+)
+
+// urlSites fetches a remote site list, in the format of remoteSitesFile,
+// from remoteSitesUrl: an http(s) URL, an s3://bucket/key object or a
+// gs://bucket/object object. The ETag of the last answer is sent back, so
+// an unchanged list is not transferred again.
+type urlSites struct {
+	url    string
+	etag   string
+	sites  []SiteType
+	client *http.Client
+}
+
+func (u *urlSites) name() string {
+	return "remoteSitesUrl"
+}
+
+func (u *urlSites) discover() ([]SiteType, error) {
+	if u.client == nil {
+		u.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	var data []byte
+	var etag string
+	var err error
+	switch {
+	case strings.HasPrefix(u.url, "s3://"):
+		data, etag, err = u.fetchS3()
+	case strings.HasPrefix(u.url, "gs://"):
+		var token string
+		if token, err = gcsToken(); err == nil {
+			data, etag, err = u.fetchHTTP("https://storage.googleapis.com/"+strings.TrimPrefix(u.url, "gs://"), token)
+		}
+	default:
+		data, etag, err = u.fetchHTTP(u.url, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		sites, err := parseSites(u.url, data)
+		if err != nil {
+			return nil, err
+		}
+		u.sites = sites
+		u.etag = etag
+	}
+	// A copy: the caller filters the list in place.
+	return append([]SiteType(nil), u.sites...), nil
+}
+
+// fetchHTTP returns the body and ETag of url, or a nil body when it is
+// unchanged.
+func (u *urlSites) fetchHTTP(url string, token string) ([]byte, string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.etag != "" {
+		req.Header.Set("If-None-Match", u.etag)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, "", nil
+	case http.StatusOK:
+	default:
+		return nil, "", fmt.Errorf("fetching %s: %s", u.url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	return data, resp.Header.Get("ETag"), err
+}
+
+func (u *urlSites) fetchS3() ([]byte, string, error) {
+	loc, err := url.Parse(u.url)
+	if err != nil {
+		return nil, "", err
+	}
+	sess, err := getAWSSession()
+	if err != nil {
+		return nil, "", err
+	}
+	input := &s3.GetObjectInput{Bucket: aws.String(loc.Host), Key: aws.String(strings.TrimPrefix(loc.Path, "/"))}
+	if u.etag != "" {
+		input.IfNoneMatch = aws.String(u.etag)
+	}
+	out, err := s3.New(sess).GetObject(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == http.StatusNotModified {
+			return nil, "", nil
+		}
+		return nil, "", fmt.Errorf("fetching %s: %s", u.url, err)
+	}
+	defer out.Body.Close()
+	data, err := ioutil.ReadAll(out.Body)
+	return data, aws.StringValue(out.ETag), err
+}
+
+// gcsToken returns an access token for Google Cloud Storage: the
+// GOOGLE_OAUTH_ACCESS_TOKEN environment variable, else the instance's
+// service account from the metadata server, else none (public objects).
+func gcsToken() (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	req, err := http.NewRequest("GET", "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := (&http.Client{Timeout: 2 * time.Second}).Do(req)
+	if err != nil {
+		return "", nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("decoding metadata token: %s", err)
+	}
+	return token.AccessToken, nil
+}
+
+// parseSites parses a site list in YAML or JSON.
+func parseSites(name string, data []byte) ([]SiteType, error) {
+	data, err := expandEnv(data)
+	if err != nil {
+		return nil, err
+	}
+	sites := make([]SiteType, 0)
+	if err := yaml.Unmarshal(data, &sites); err != nil {
+		return nil, fmt.Errorf("error parsing site list %s: %s", name, err)
+	}
+	return sites, nil
+}
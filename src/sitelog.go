@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// debugLogger logs for sites with debug set, at debug level whatever the
+// global level, through the standard logger's output and format.
+var debugLogger = log.New()
+
+// siteLog returns an entry for logging about site.
+func siteLog(site SiteType) *log.Entry {
+	fields := log.Fields{"Region": site.Region, "Site": site.Site}
+	if session := sched.sessionID(site); session != "" {
+		fields["Session"] = session
+	}
+	if site.cycle != "" {
+		fields["Cycle"] = site.cycle
+	}
+	if site.Debug && !log.IsLevelEnabled(log.DebugLevel) {
+		std := log.StandardLogger()
+		debugLogger.SetOutput(std.Out)
+		debugLogger.SetFormatter(std.Formatter)
+		debugLogger.SetLevel(log.DebugLevel)
+		return debugLogger.WithFields(fields)
+	}
+	return log.WithFields(fields)
+}
+
+var packetsSeen uint64
+
+// sampled reports whether a packet-level log line should be written: one
+// in n of them, all when n is 0 or 1.
+func sampled(n uint) bool {
+	if n <= 1 {
+		return true
+	}
+	return atomic.AddUint64(&packetsSeen, 1)%uint64(n) == 0
+}
+
+// defaultLogRate is how many packet-level lines are logged per second
+// when logRate is not set.
+const defaultLogRate = 20
+
+var packetLogs struct {
+	sync.Mutex
+	second     time.Time
+	lines      uint
+	suppressed uint64
+}
+
+// logPacket writes a packet-level debug line, one in sample of them and
+// at most logRate a second. The first line after some were dropped for
+// the rate says how many.
+func logPacket(entry *log.Entry, sample uint, msg string) {
+	if !entry.Logger.IsLevelEnabled(log.DebugLevel) || !sampled(sample) {
+		return
+	}
+	rate := reflector().LogRate
+	if rate == 0 {
+		rate = defaultLogRate
+	}
+	now := time.Now().Truncate(time.Second)
+	packetLogs.Lock()
+	if !now.Equal(packetLogs.second) {
+		packetLogs.second = now
+		packetLogs.lines = 0
+	}
+	if packetLogs.lines >= rate {
+		packetLogs.suppressed++
+		packetLogs.Unlock()
+		return
+	}
+	packetLogs.lines++
+	suppressed := packetLogs.suppressed
+	packetLogs.suppressed = 0
+	packetLogs.Unlock()
+	if suppressed > 0 {
+		entry = entry.WithField("Suppressed", suppressed)
+	}
+	entry.Debug(msg)
+}
+
+// describePacket names the kind and size of a reflected packet, with its
+// start when logPayloads is set: payloads are measurement data.
+func describePacket(buf []byte) string {
+	kind := "probe"
+	for prefix := range taggedPackets {
+		if strings.HasPrefix(string(buf), prefix) {
+			kind = strings.TrimSuffix(prefix, ":")
+		}
+	}
+	if strings.HasPrefix(string(buf), string(fragPrefix)) {
+		kind = "frag"
+	}
+	desc := fmt.Sprintf("Reflected %s packet, %d bytes", kind, len(buf))
+	if reflector().LogPayloads {
+		if len(buf) > 64 {
+			buf = buf[:64]
+		}
+		desc += fmt.Sprintf(": %q", buf)
+	}
+	return desc
+}
+
+// logSample is how many of the site's packets share one log line. The
+// scheduler fills in the default from the check's config snapshot; the
+// fallback is for the one-shot commands, whose config does not change.
+func (s SiteType) logSample() uint {
+	if s.LogSample != 0 {
+		return s.LogSample
+	}
+	return currentConfig().LogSample
+}
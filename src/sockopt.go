@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sockOpts are the socket level settings applied to probe and listener
+// sockets before they are bound.
+type sockOpts struct {
+	Device string
+	DSCP   uint
+	ECN    uint
+	TTL    uint
+
+	// RecvTOS asks for the TOS / traffic class of received packets.
+	RecvTOS bool
+
+	DontFragment bool
+	RecvBuffer   int
+	SendBuffer   int
+
+	// HostTiming asks for kernel timestamps and the socket's drops, see
+	// enableHostTiming.
+	HostTiming bool
+}
+
+type BuffersType struct {
+	Receive int `yaml:"receive"`
+	Send    int `yaml:"send"`
+}
+
+type SocketBuffersType struct {
+	Listener BuffersType `yaml:"listener"`
+	Probe    BuffersType `yaml:"probe"`
+}
+
+func (o sockOpts) control(network string, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = applySockOpts(int(fd), network, o)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// probeSockOpts merges the global and per-site socket settings for a probe.
+func probeSockOpts(site SiteType) sockOpts {
+	cfg := currentConfig()
+	opts := sockOpts{Device: cfg.Interface, DSCP: cfg.DSCP, TTL: cfg.TTL}
+	if site.Interface != "" {
+		opts.Device = site.Interface
+	}
+	if site.DSCP != 0 {
+		opts.DSCP = site.DSCP
+	}
+	if site.TTL != 0 {
+		opts.TTL = site.TTL
+	}
+	opts.DontFragment = cfg.DontFragment || site.DontFragment
+	opts.RecvBuffer = cfg.SocketBuffers.Probe.Receive
+	opts.SendBuffer = cfg.SocketBuffers.Probe.Send
+	opts.HostTiming = cfg.HostTiming
+	return opts
+}
+
+func dialUDP(network string, laddr *net.UDPAddr, raddr *net.UDPAddr, opts sockOpts) (*net.UDPConn, error) {
+	d := net.Dialer{Control: opts.control}
+	if laddr != nil {
+		d.LocalAddr = laddr
+	}
+	conn, err := d.Dial(network, raddr.String())
+	if err != nil {
+		return nil, err
+	}
+	udp := conn.(*net.UDPConn)
+	if err := setBuffers(udp, opts); err != nil {
+		udp.Close()
+		return nil, err
+	}
+	return udp, nil
+}
+
+func listenUDP(address string, opts sockOpts) (net.PacketConn, error) {
+	name := "udp " + address
+	var conn net.PacketConn
+	if f := takeInherited(name); f != nil {
+		// The socket options were set by the process that opened it.
+		var err error
+		conn, err = net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			log.Warn(fmt.Sprintf("Failed to take over UDP socket %s: %s", address, err))
+			conn = nil
+		} else {
+			log.WithFields(log.Fields{"Address": address}).Debug("Took over UDP socket")
+		}
+	}
+	if conn == nil {
+		lc := net.ListenConfig{Control: opts.control}
+		var err error
+		if conn, err = lc.ListenPacket(context.Background(), "udp", address); err != nil {
+			return nil, err
+		}
+	}
+	if err := setBuffers(conn.(*net.UDPConn), opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	registerSocket(name, conn.(*net.UDPConn))
+	return conn, nil
+}
+
+func setBuffers(conn *net.UDPConn, opts sockOpts) error {
+	if opts.RecvBuffer > 0 {
+		if err := conn.SetReadBuffer(opts.RecvBuffer); err != nil {
+			return fmt.Errorf("setting receive buffer: %s", err)
+		}
+	}
+	if opts.SendBuffer > 0 {
+		if err := conn.SetWriteBuffer(opts.SendBuffer); err != nil {
+			return fmt.Errorf("setting send buffer: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func applySockOpts(fd int, network string, o sockOpts) error {
+	if o.Device != "" {
+		if err := unix.SetsockoptString(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE, o.Device); err != nil {
+			return fmt.Errorf("binding to device %s: %s", o.Device, err)
+		}
+	}
+	if o.DSCP != 0 || o.ECN != 0 {
+		var err error
+		if network == "udp6" || network == "tcp6" {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, int(o.DSCP<<2|o.ECN))
+		} else {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, int(o.DSCP<<2|o.ECN))
+		}
+		if err != nil {
+			return fmt.Errorf("setting DSCP %d and ECN %d: %s", o.DSCP, o.ECN, err)
+		}
+	}
+	if o.RecvTOS {
+		// Dual stack sockets see IPv4 packets too, so both are asked
+		// for; the one not applying to the socket fails harmlessly.
+		unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_RECVTOS, 1)
+		unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_RECVTCLASS, 1)
+	}
+	if o.TTL != 0 {
+		var err error
+		if network == "udp6" || network == "tcp6" {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, int(o.TTL))
+		} else {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TTL, int(o.TTL))
+		}
+		if err != nil {
+			return fmt.Errorf("setting TTL %d: %s", o.TTL, err)
+		}
+	}
+	if o.DontFragment {
+		var err error
+		if network == "udp6" || network == "tcp6" {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_DONTFRAG, 1)
+		} else {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_DO)
+		}
+		if err != nil {
+			return fmt.Errorf("setting don't fragment: %s", err)
+		}
+	}
+	if o.HostTiming {
+		if err := enableHostTiming(fd); err != nil {
+			return fmt.Errorf("enabling kernel timestamps: %s", err)
+		}
+	}
+	return nil
+}
+
+// parseTOS finds the TOS / traffic class in the control messages of a
+// received packet.
+func parseTOS(oob []byte) (uint, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, m := range msgs {
+		switch {
+		case m.Header.Level == unix.IPPROTO_IP && m.Header.Type == unix.IP_TOS && len(m.Data) >= 1:
+			return uint(m.Data[0]), true
+		case m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_TCLASS && len(m.Data) >= 4:
+			return uint(*(*int32)(unsafe.Pointer(&m.Data[0]))), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "fmt"
+
+func applySockOpts(fd int, network string, o sockOpts) error {
+	if o.Device != "" {
+		return fmt.Errorf("binding to a device is only supported on Linux")
+	}
+	if o.DSCP != 0 || o.ECN != 0 {
+		return fmt.Errorf("DSCP and ECN marking are only supported on Linux")
+	}
+	if o.TTL != 0 {
+		return fmt.Errorf("setting the TTL is only supported on Linux")
+	}
+	if o.DontFragment {
+		return fmt.Errorf("setting don't fragment is only supported on Linux")
+	}
+	if o.HostTiming {
+		return fmt.Errorf("host timing is only supported on Linux")
+	}
+	return nil
+}
+
+func parseTOS(oob []byte) (uint, bool) {
+	return 0, false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// SRVType discovers reflectors from DNS SRV records. Each target becomes a
+// site named after its first label, in the region given or else the
+// target's second label (reflector1.fra.example.net is fra/reflector1).
+type SRVType struct {
+	Names  []string `yaml:"names"`
+	Region string   `yaml:"region"`
+}
+
+func (s *SRVType) name() string {
+	return "srv"
+}
+
+func (s *SRVType) discover() ([]SiteType, error) {
+	var sites []SiteType
+	for _, name := range s.Names {
+		_, records, err := net.LookupSRV("", "", name)
+		if err != nil {
+			return nil, fmt.Errorf("looking up %s: %s", name, err)
+		}
+		for _, rec := range records {
+			target := strings.TrimSuffix(rec.Target, ".")
+			labels := strings.Split(target, ".")
+			site := SiteType{Address: target, Region: s.Region, Site: labels[0], Port: uint(rec.Port)}
+			if site.Region == "" && len(labels) > 1 {
+				site.Region = labels[1]
+			}
+			sites = append(sites, site)
+		}
+	}
+	return sites, nil
+}
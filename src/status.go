@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// pathState is the last known state of the path to a remote site: "ok",
+// "degraded" (over its thresholds), "down" (no result), "paused",
+// "disabled" (enabled: false in the config) or "unknown" (not checked yet).
+type pathState struct {
+	Region  string    `json:"region"`
+	Site    string    `json:"site"`
+	State   string    `json:"state"`
+	Summary string    `json:"summary,omitempty"`
+	Since   time.Time `json:"since,omitempty"`
+	Checked time.Time `json:"checked,omitempty"`
+
+	// pending is the state the checks since count have seen, which the
+	// path moves to once pathStates allows.
+	pending string
+	count   uint
+}
+
+// PathStatesType sets how many checks in a row must see a path degraded,
+// down or ok again before it changes to that state, 1 by default, so one
+// bad check does not flap it.
+type PathStatesType struct {
+	DegradedAfter uint `yaml:"degradedAfter"`
+	DownAfter     uint `yaml:"downAfter"`
+	UpAfter       uint `yaml:"upAfter"`
+}
+
+func (p PathStatesType) after(state string) uint {
+	n := map[string]uint{"degraded": p.DegradedAfter, "down": p.DownAfter, "ok": p.UpAfter}[state]
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
+// stateCodes are the values of the state_code field, for graphing.
+var stateCodes = map[string]int{"ok": 0, "degraded": 1, "down": 2}
+
+// stateEvent is a path changing state.
+type stateEvent struct {
+	Region  string `json:"region"`
+	Site    string `json:"site"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// noteState records the outcome of a check of site against its
+// thresholds, moving the path to the state seen once enough checks in a
+// row saw it. Since only moves when the state changes, which is published
+// as a "state" event. The state is written as a "state" point.
+func (s *schedulerType) noteState(site SiteType, reachable bool) {
+	seen := "ok"
+	problem, summary := checkSummary(site, site.Thresholds.RTT, site.Thresholds.Loss)
+	switch {
+	case !reachable:
+		seen = "down"
+	case problem:
+		seen = "degraded"
+	}
+	configLock.RLock()
+	rules := configData.PathStates
+	local := configData.LocalSite
+	configLock.RUnlock()
+	s.lock.Lock()
+	state, ok := s.states[site.key()]
+	if !ok {
+		state = pathState{Region: site.Region, Site: site.Site, State: "unknown"}
+	}
+	from := state.State
+	state.Checked = clock.Now()
+	state.Summary = ""
+	if reachable {
+		state.Summary = summary
+	}
+	switch {
+	case seen == state.State:
+		state.pending, state.count = "", 0
+	case seen == state.pending:
+		state.count++
+	default:
+		state.pending, state.count = seen, 1
+	}
+	// The first check sets the state right away.
+	if state.State == "unknown" || (state.pending != "" && state.count >= rules.after(state.pending)) {
+		state.State, state.Since = seen, state.Checked
+		state.pending, state.count = "", 0
+	}
+	s.states[site.key()] = state
+	s.lock.Unlock()
+	if state.State != from && !(from == "unknown" && state.State == "ok") {
+		siteLog(site).Info(fmt.Sprintf("Path is %s, was %s", state.State, from))
+		publishEvent("state", stateEvent{Region: site.Region, Site: site.Site, From: from, To: state.State, Summary: state.Summary})
+	}
+	fields := map[string]interface{}{"state": state.State, "state_code": stateCodes[state.State]}
+	if state.pending != "" {
+		fields["pending"] = state.pending
+	}
+	writeResult(s.writer.forSite(site), "state", site, siteTags(local, site), fields)
+}
+
+// status returns the state of a site.
+func (s *schedulerType) status(site SiteType) pathState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	state, ok := s.states[site.key()]
+	if !ok {
+		state = pathState{Region: site.Region, Site: site.Site, State: "unknown"}
+	}
+	if s.paused[site.key()] {
+		state.State = "paused"
+	}
+	if !site.enabled() {
+		state.State = "disabled"
+	}
+	return state
+}
+
+// handleStatus answers /status with the state of every site, or of the
+// sites given with ?site=region/site.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	keep := siteFilter(r)
+	list := []pathState{}
+	for _, site := range sched.list() {
+		if !keep(ResultType{Region: site.Region, Site: site.Site}) {
+			continue
+		}
+		list = append(list, sched.status(site))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// runStatus implements "netcheck status": it prints the state of every
+// or the given paths from the local agent, and exits 1 unless all are ok.
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	fs.Usage = commandUsage("status", fs)
+	admin := addAdminFlags(fs)
+	jsonOut := fs.Bool("json", false, "Print JSON")
+	sites := parseSiteArgs(fs, args)
+	query := ""
+	for _, site := range sites {
+		query += "&site=" + site
+	}
+	if query != "" {
+		query = "?" + query[1:]
+	}
+	resp, err := admin.do("GET", "/status"+query)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	defer resp.Body.Close()
+	var states []pathState
+	if err := json.NewDecoder(resp.Body).Decode(&states); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if len(states) == 0 && len(sites) > 0 {
+		fmt.Fprintf(stderr, "no such site: %s\n", strings.Join(sites, ", "))
+		return 2
+	}
+	code := 0
+	for _, state := range states {
+		if state.State != "ok" {
+			code = 1
+		}
+	}
+	if *jsonOut {
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		out.Encode(states)
+		return code
+	}
+	for _, state := range states {
+		since := ""
+		if !state.Since.IsZero() {
+			since = "since " + state.Since.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%-9s %-30s %-28s %s\n", strings.ToUpper(state.State), SiteType{Region: state.Region, Site: state.Site}.key(), since, state.Summary)
+	}
+	return code
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HistoryType configures the local history store: results kept as
+// measured for raw, then as one minute rollups for minute, then as one hour
+// rollups for hour. With path the store is saved there every five minutes
+// and on shutdown, and read back at startup.
+type HistoryType struct {
+	Path   string `yaml:"path"`
+	Raw    string `yaml:"raw"`
+	Minute string `yaml:"minute"`
+	Hour   string `yaml:"hour"`
+}
+
+func (h HistoryType) enabled() bool {
+	return h.Path != "" || h.Raw != "" || h.Minute != "" || h.Hour != ""
+}
+
+// retention returns the retention of each tier, defaulting to 6 hours of
+// raw results, 3 days of minutes and 30 days of hours.
+func (h HistoryType) retention() ([3]time.Duration, error) {
+	var r [3]time.Duration
+	for i, v := range []struct {
+		key, value string
+		def        time.Duration
+	}{{"raw", h.Raw, 6 * time.Hour}, {"minute", h.Minute, 72 * time.Hour}, {"hour", h.Hour, 720 * time.Hour}} {
+		r[i] = v.def
+		if v.value == "" {
+			continue
+		}
+		d, err := parseRetention(v.value)
+		if err != nil {
+			return r, fmt.Errorf("history.%s: %s", v.key, err)
+		}
+		r[i] = d
+	}
+	return r, nil
+}
+
+// parseRetention reads a duration, allowing days ("7d") besides the units
+// of time.ParseDuration.
+func parseRetention(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %s", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// historyResolutions is the bucket size of each tier; raw results are not
+// bucketed.
+var historyResolutions = [3]time.Duration{0, time.Minute, time.Hour}
+
+// historyBucket summarizes the results of one series within a bucket.
+type historyBucket struct {
+	Time   time.Time
+	Count  int
+	Fields map[string]fieldStats
+}
+
+// add merges the numeric fields of one result into the bucket.
+func (b *historyBucket) add(fields map[string]interface{}) {
+	for name, v := range fields {
+		f, ok := fieldFloat(v)
+		if !ok {
+			continue
+		}
+		stats, seen := b.Fields[name]
+		if !seen {
+			stats = fieldStats{Min: f, Avg: f, Max: f, Count: 1}
+		} else {
+			if f < stats.Min {
+				stats.Min = f
+			}
+			if f > stats.Max {
+				stats.Max = f
+			}
+			stats.Count = stats.count(*b) + 1
+			stats.Avg += (f - stats.Avg) / float64(stats.Count)
+		}
+		b.Fields[name] = stats
+	}
+	b.Count++
+}
+
+type storedSeries struct {
+	Site        string
+	Measurement string
+	Tags        map[string]string
+	Tiers       [3][]historyBucket
+}
+
+type historyStore struct {
+	lock sync.Mutex
+	// saveLock keeps saves, which write the file without lock, one at a
+	// time.
+	saveLock  sync.Mutex
+	path      string
+	retention [3]time.Duration
+	series    map[string]*storedSeries
+}
+
+var store *historyStore
+
+// openStore creates the store, loading the saved one from cfg.Path if it
+// exists.
+func openStore(cfg HistoryType) (*historyStore, error) {
+	retention, err := cfg.retention()
+	if err != nil {
+		return nil, err
+	}
+	s := &historyStore{path: cfg.Path, retention: retention, series: make(map[string]*storedSeries)}
+	if s.path == "" {
+		return s, nil
+	}
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("opening history %s: %s", s.path, err)
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(&s.series); err != nil {
+		log.WithFields(log.Fields{"Path": s.path}).Warn(fmt.Sprintf("Discarding unreadable history: %s", err))
+		s.series = make(map[string]*storedSeries)
+	}
+	s.prune(time.Now())
+	return s, nil
+}
+
+// add records a result in every tier.
+func (s *historyStore) add(res ResultType) {
+	key := res.Measurement + "," + seriesKey(res.Tags)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	series := s.series[key]
+	if series == nil {
+		series = &storedSeries{Site: SiteType{Region: res.Region, Site: res.Site}.key(), Measurement: res.Measurement, Tags: res.Tags}
+		s.series[key] = series
+	}
+	for i, resolution := range historyResolutions {
+		t := res.Time
+		if resolution > 0 {
+			t = t.Truncate(resolution)
+		}
+		tier := series.Tiers[i]
+		if n := len(tier); n == 0 || resolution == 0 || !tier[n-1].Time.Equal(t) {
+			tier = append(tier, historyBucket{Time: t, Fields: make(map[string]fieldStats)})
+		}
+		tier[len(tier)-1].add(res.Fields)
+		series.Tiers[i] = tier
+	}
+}
+
+// prune drops buckets past their tier's retention, and series left empty.
+func (s *historyStore) prune(now time.Time) {
+	for key, series := range s.series {
+		empty := true
+		for i := range series.Tiers {
+			tier := series.Tiers[i]
+			cut := 0
+			for cut < len(tier) && now.Sub(tier[cut].Time) > s.retention[i] {
+				cut++
+			}
+			series.Tiers[i] = append([]historyBucket(nil), tier[cut:]...)
+			if len(series.Tiers[i]) > 0 {
+				empty = false
+			}
+		}
+		if empty {
+			delete(s.series, key)
+		}
+	}
+}
+
+// seriesBuckets is one series' buckets within a queried range.
+type seriesBuckets struct {
+	Tags    map[string]string
+	Buckets []historyBucket
+}
+
+// query returns the buckets between from and to of each series of a
+// site's measurement from the finest tier still covering from, and that
+// tier's resolution.
+func (s *historyStore) query(site string, measurement string, from time.Time, to time.Time) (time.Duration, []seriesBuckets) {
+	now := time.Now()
+	tier := len(historyResolutions) - 1
+	for i := range historyResolutions {
+		if !from.IsZero() && now.Sub(from) <= s.retention[i] {
+			tier = i
+			break
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var list []seriesBuckets
+	for _, series := range s.series {
+		if series.Site != site || series.Measurement != measurement {
+			continue
+		}
+		found := seriesBuckets{Tags: series.Tags}
+		for _, b := range series.Tiers[tier] {
+			if !b.Time.Before(from) && !b.Time.After(to) {
+				found.Buckets = append(found.Buckets, b)
+			}
+		}
+		if len(found.Buckets) > 0 {
+			list = append(list, found)
+		}
+	}
+	return historyResolutions[tier], list
+}
+
+// save prunes the store and writes it to its path, replacing the previous
+// file only once the new one is complete. The store is encoded under lock,
+// and written after, so results are not held up by the disk.
+func (s *historyStore) save() error {
+	s.saveLock.Lock()
+	defer s.saveLock.Unlock()
+	var snapshot bytes.Buffer
+	s.lock.Lock()
+	s.prune(time.Now())
+	var err error
+	if s.path != "" {
+		err = gob.NewEncoder(&snapshot).Encode(s.series)
+	}
+	s.lock.Unlock()
+	if s.path == "" || err != nil {
+		return err
+	}
+	tmp, err := os.Create(filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp"))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(snapshot.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// run saves (or, without a path, prunes) the store every five minutes
+// until stop is closed.
+func (s *historyStore) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.save(); err != nil {
+				log.WithFields(log.Fields{"Path": s.path}).Error(fmt.Sprintf("Failed to save history: %s", err))
+			}
+		}
+	}
+}
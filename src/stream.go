@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// siteFilter returns a check for the "site" query parameters of r, each a
+// "region/site" key; without any every site passes.
+func siteFilter(r *http.Request) func(ResultType) bool {
+	want := make(map[string]bool)
+	for _, key := range r.URL.Query()["site"] {
+		want[key] = true
+	}
+	return func(res ResultType) bool {
+		return len(want) == 0 || want[res.Region+"/"+res.Site]
+	}
+}
+
+// sameOrigin tells whether r comes from a page of the agent itself, or
+// from a client that is not a browser and sends no Origin. Browsers let
+// any page open WebSockets, cookies or not, so others are refused.
+func sameOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || !strings.EqualFold(u.Host, r.Host) {
+		return fmt.Errorf("origin %s not allowed", origin)
+	}
+	return nil
+}
+
+// resultSocket streams every result as a JSON message as soon as it is
+// written. Clients only ever receive; anything they send is discarded.
+var resultSocket = websocket.Server{
+	Handshake: func(_ *websocket.Config, r *http.Request) error { return sameOrigin(r) },
+	Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+		match := siteFilter(ws.Request())
+		closed := make(chan struct{})
+		go func() {
+			var discard []byte
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+			close(closed)
+		}()
+		ch := subscribeResults()
+		defer unsubscribeResults(ch)
+		log.WithFields(log.Fields{"Client": ws.Request().RemoteAddr}).Debug("WebSocket client connected")
+		for {
+			select {
+			case <-closed:
+				return
+			case res := <-ch:
+				if !match(res) {
+					continue
+				}
+				data, err := json.Marshal(res)
+				if err != nil {
+					continue
+				}
+				if err := websocket.Message.Send(ws, redact(string(data))); err != nil {
+					return
+				}
+			}
+		}
+	},
+}
+
+// handleEvents sends cycle summaries and alerts as server-sent events,
+// with a comment line every 30 seconds to keep idle connections open.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	ch := subscribeEvents()
+	defer unsubscribeEvents(ch)
+	flusher.Flush()
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case event := <-ch:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, redact(string(data)))
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// StreamType replaces a site's discrete probes by a continuous stream of
+// Rate packets per second for Duration seconds (10 by default).
+type StreamType struct {
+	Rate     uint `yaml:"rate"`
+	Duration uint `yaml:"duration"`
+}
+
+func (s StreamType) duration() time.Duration {
+	if s.Duration == 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(s.Duration) * time.Second
+}
+
+// percentile returns the nearest-rank percentile p of sorted.
+func percentile(sorted []int64, p float64) int64 {
+	return sorted[int(math.Ceil(p/100*float64(len(sorted))))-1]
+}
+
+// runStream sends "stream:id:seq:sendNano" packets to addr at a fixed rate
+// and writes the "stream" measurement: sent, received, loss in percent,
+// the longest run of consecutive losses, duplicates and reordered echoes,
+// and RTT avg, min, max, p50, p95, p99 and jitter (mean difference between
+// the RTTs of consecutive packets) in microseconds. An "rtt" point with the
+// stream's average and spread is written too, so thresholds and dashboards
+// keep working.
+func runStream(ctx context.Context, API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, addr *net.UDPAddr, opts sockOpts, extra map[string]string) {
+	network := "udp6"
+	if addr.IP.To4() != nil {
+		network = "udp4"
+	}
+	laddr, err := sourceAddr(remoteSite, addr.IP)
+	if err != nil {
+		siteLog(remoteSite).Debug(err.Error())
+		return
+	}
+	svc, err := dialUDP(network, laddr, addr, opts)
+	if err != nil {
+		siteLog(remoteSite).Debug(fmt.Sprintf("Failed to dial %s: %s", addr, err))
+		return
+	}
+	defer svc.Close()
+	defer closeOnDone(ctx, svc)()
+	cfg := remoteSite.Stream
+	interval := time.Second / time.Duration(cfg.Rate)
+	sent := int(cfg.duration() / interval)
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+	// rtts[seq] is the RTT of packet seq, -1 until its echo arrives.
+	rtts := make([]int64, sent)
+	for i := range rtts {
+		rtts[i] = -1
+	}
+	var received, duplicates, reordered int
+	done := make(chan struct{})
+	svc.SetReadDeadline(time.Now().Add(cfg.duration() + 2*time.Second))
+	go func() {
+		defer close(done)
+		buf := make([]byte, 64)
+		last := -1
+		for received < sent {
+			n, err := svc.Read(buf)
+			if err != nil {
+				return
+			}
+			parts := strings.Split(string(buf[:n]), ":")
+			if len(parts) != 4 || parts[0] != "stream" || parts[1] != id {
+				continue
+			}
+			seq, err := strconv.Atoi(parts[2])
+			if err != nil || seq < 0 || seq >= sent {
+				continue
+			}
+			sentAt, err := strconv.ParseInt(parts[3], 10, 64)
+			if err != nil {
+				continue
+			}
+			if rtts[seq] >= 0 {
+				duplicates++
+				continue
+			}
+			rtts[seq] = time.Since(time.Unix(0, sentAt)).Microseconds()
+			received++
+			if seq < last {
+				reordered++
+			} else {
+				last = seq
+			}
+		}
+	}()
+	ticker := clock.NewTicker(interval)
+	for seq := 0; seq < sent && ctx.Err() == nil; seq++ {
+		svc.Write(signPacket([]byte(fmt.Sprintf("stream:%s:%d:%d", id, seq, time.Now().UnixNano()))))
+		if seq < sent-1 {
+			select {
+			case <-ticker.C():
+			case <-ctx.Done():
+			}
+		}
+	}
+	ticker.Stop()
+	<-done
+	if ctx.Err() != nil {
+		return
+	}
+	fields := map[string]interface{}{
+		"sent":       sent,
+		"received":   received,
+		"loss":       100 * float64(sent-received) / float64(sent),
+		"duplicates": duplicates,
+		"reordered":  reordered,
+	}
+	var got []int64
+	var jitter float64
+	lossRun, maxLossRun := 0, 0
+	prev := int64(-1)
+	for _, rtt := range rtts {
+		if rtt < 0 {
+			lossRun++
+			if lossRun > maxLossRun {
+				maxLossRun = lossRun
+			}
+			continue
+		}
+		lossRun = 0
+		if prev >= 0 {
+			jitter += math.Abs(float64(rtt - prev))
+		}
+		prev = rtt
+		got = append(got, rtt)
+	}
+	fields["max_loss_run"] = maxLossRun
+	tags := siteTags(localSite, remoteSite)
+	for k, v := range extra {
+		tags[k] = v
+	}
+	siteLog(remoteSite).Debug(fmt.Sprintf("Stream of %d to %s: %d received, %d reordered, %d duplicates", sent, addr, received, reordered, duplicates))
+	if len(got) == 0 {
+		writeResult(API, "stream", remoteSite, tags, fields)
+		return
+	}
+	var sum int64
+	for _, rtt := range got {
+		sum += rtt
+	}
+	avg := sum / int64(len(got))
+	if len(got) > 1 {
+		fields["jitter"] = jitter / float64(len(got)-1)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	fields["avg"] = avg
+	fields["min"] = got[0]
+	fields["max"] = got[len(got)-1]
+	fields["p50"] = percentile(got, 50)
+	fields["p95"] = percentile(got, 95)
+	fields["p99"] = percentile(got, 99)
+	writeResult(API, "stream", remoteSite, tags, fields)
+	writeResult(API, "rtt", remoteSite, tags, map[string]interface{}{"avg": avg, "jitter": got[len(got)-1] - got[0]})
+}
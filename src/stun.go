@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// STUNType lists the STUN servers used to learn the agent's public address
+// and what kind of NAT it is behind.
+type STUNType struct {
+	Servers []string `yaml:"servers"`
+	// Interval is the time between lookups in seconds, 300 by default.
+	Interval uint `yaml:"interval"`
+}
+
+const (
+	stunMagic            = 0x2112A442
+	stunBindingRequest   = 0x0001
+	stunBindingResponse  = 0x0101
+	stunMappedAddress    = 0x0001
+	stunXORMappedAddress = 0x0020
+)
+
+// natEvent reports a change of the public address or NAT type. NAT is
+// "none" when the public address is a local one, "cone" when every server
+// saw the same mapping, "symmetric" when the mapping depends on the
+// destination and "unknown" when fewer than two servers answered.
+type natEvent struct {
+	Address  string `json:"address"`
+	NAT      string `json:"nat"`
+	Previous string `json:"previous,omitempty"`
+}
+
+// stunBinding sends a binding request to server over conn and returns the
+// address the server saw the request come from.
+func stunBinding(conn *net.UDPConn, server string) (*net.UDPAddr, error) {
+	addr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return nil, err
+	}
+	req := make([]byte, 20)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagic)
+	rand.Read(req[8:20])
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.WriteToUDP(req, addr); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				break
+			}
+			if !from.IP.Equal(addr.IP) || n < 20 || binary.BigEndian.Uint16(buf[0:]) != stunBindingResponse || string(buf[8:20]) != string(req[8:20]) {
+				continue
+			}
+			return parseSTUNMapped(buf[:n])
+		}
+	}
+	return nil, fmt.Errorf("no answer from %s", server)
+}
+
+// parseSTUNMapped finds the (XOR-)MAPPED-ADDRESS of a binding response.
+func parseSTUNMapped(msg []byte) (*net.UDPAddr, error) {
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if 20+length > len(msg) {
+		return nil, fmt.Errorf("truncated STUN response")
+	}
+	var mapped *net.UDPAddr
+	attrs := msg[20 : 20+length]
+	for len(attrs) >= 4 {
+		kind := binary.BigEndian.Uint16(attrs[0:])
+		size := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+size > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+size]
+		// Only IPv4 (family 1) mappings are asked for.
+		if (kind == stunXORMappedAddress || kind == stunMappedAddress) && size >= 8 && value[1] == 1 {
+			port := binary.BigEndian.Uint16(value[2:])
+			ip := net.IP(append([]byte(nil), value[4:8]...))
+			if kind == stunXORMappedAddress {
+				port ^= stunMagic >> 16
+				for i := range ip {
+					ip[i] ^= msg[4+i]
+				}
+				return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+			}
+			mapped = &net.UDPAddr{IP: ip, Port: int(port)}
+		}
+		attrs = attrs[4+(size+3)&^3:]
+	}
+	if mapped == nil {
+		return nil, fmt.Errorf("no mapped address in STUN response")
+	}
+	return mapped, nil
+}
+
+// isLocalIP reports whether ip is configured on one of the host's
+// interfaces.
+func isLocalIP(ip net.IP) bool {
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupNAT asks every server from one socket and compares the mappings.
+func lookupNAT(servers []string) (natEvent, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return natEvent{}, err
+	}
+	defer conn.Close()
+	var mappings []*net.UDPAddr
+	for _, server := range servers {
+		mapped, err := stunBinding(conn, server)
+		if err != nil {
+			log.WithFields(log.Fields{"Server": server}).Debug(fmt.Sprintf("STUN lookup failed: %s", err))
+			continue
+		}
+		mappings = append(mappings, mapped)
+	}
+	if len(mappings) == 0 {
+		return natEvent{}, fmt.Errorf("no STUN server answered")
+	}
+	event := natEvent{Address: mappings[0].IP.String(), NAT: "unknown"}
+	switch {
+	case isLocalIP(mappings[0].IP):
+		event.NAT = "none"
+	case len(mappings) > 1:
+		event.NAT = "cone"
+		for _, m := range mappings[1:] {
+			if m.String() != mappings[0].String() {
+				event.NAT = "symmetric"
+			}
+		}
+	}
+	return event, nil
+}
+
+// runSTUN looks up the public address and NAT type every interval until
+// stop is closed, publishing a "nat" event when either changes.
+func runSTUN(cfg STUNType, stop <-chan struct{}) {
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval == 0 {
+		interval = 5 * time.Minute
+	}
+	var last natEvent
+	for {
+		event, err := lookupNAT(cfg.Servers)
+		if err != nil {
+			log.Warn(fmt.Sprintf("Public address lookup failed: %s", err))
+		} else if event.Address != last.Address || event.NAT != last.NAT {
+			event.Previous = last.Address
+			log.WithFields(log.Fields{"Address": event.Address, "NAT": event.NAT}).Info("Public address changed")
+			publishEvent("nat", event)
+			last = event
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
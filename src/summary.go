@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SummaryType is a periodic CSV summary of every path: RTT average and
+// p95, loss and availability, written to dir and/or mailed.
+type SummaryType struct {
+	Every string    `yaml:"every"`
+	Dir   string    `yaml:"dir"`
+	Email EmailType `yaml:"email"`
+}
+
+// EmailType is where and how to mail summaries. Password may be a secret
+// reference, see resolveSecret.
+type EmailType struct {
+	SMTP     string   `yaml:"smtp"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+}
+
+// summaryPeriods are the values of every, with the length of the period.
+var summaryPeriods = map[string]int{"daily": 1, "weekly": 7}
+
+// pathSummary accumulates the rtt results of one series over a period.
+type pathSummary struct {
+	from, to, series string
+	rtts             []float64
+	loss             float64
+	lossCount        int
+	checks, up       int
+}
+
+func (p *pathSummary) add(res ResultType) {
+	p.checks++
+	if reachable, ok := res.Fields["reachable"].(bool); ok && !reachable {
+		if loss, ok := res.Fields["loss"].(float64); ok {
+			p.loss += loss
+			p.lossCount++
+		}
+		return
+	}
+	p.up++
+	switch avg := res.Fields["avg"].(type) {
+	case int64:
+		p.rtts = append(p.rtts, float64(avg))
+	case float64:
+		p.rtts = append(p.rtts, avg)
+	}
+	if loss, ok := res.Fields["loss"].(float64); ok {
+		p.loss += loss
+		p.lossCount++
+	}
+}
+
+// record is the CSV line of the path, RTTs in milliseconds.
+func (p *pathSummary) record(start time.Time, end time.Time) []string {
+	var avg, p95, loss string
+	if len(p.rtts) > 0 {
+		sorted := append([]float64(nil), p.rtts...)
+		sort.Float64s(sorted)
+		var sum float64
+		for _, rtt := range sorted {
+			sum += rtt
+		}
+		avg = fmt.Sprintf("%.3f", sum/float64(len(sorted))/1000)
+		p95 = fmt.Sprintf("%.3f", sorted[int(math.Ceil(0.95*float64(len(sorted))))-1]/1000)
+	}
+	if p.lossCount > 0 {
+		loss = fmt.Sprintf("%.2f", p.loss/float64(p.lossCount))
+	}
+	return []string{
+		start.Format("2006-01-02"), end.Format("2006-01-02"), p.from, p.to, p.series,
+		fmt.Sprint(p.checks), avg, p95, loss,
+		fmt.Sprintf("%.2f", 100*float64(p.up)/float64(p.checks)),
+	}
+}
+
+var summaryHeader = []string{"start", "end", "from", "to", "series", "checks", "avg_rtt_ms", "p95_rtt_ms", "loss_pct", "availability_pct"}
+
+// summaryPeriod collects the paths of one daily or weekly period.
+type summaryPeriod struct {
+	every      string
+	start, end time.Time
+	paths      map[string]*pathSummary
+}
+
+func newSummaryPeriod(every string, now time.Time) *summaryPeriod {
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if every == "weekly" {
+		// Weeks start on Monday.
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	}
+	return &summaryPeriod{every: every, start: start, end: start.AddDate(0, 0, summaryPeriods[every]), paths: make(map[string]*pathSummary)}
+}
+
+func (s *summaryPeriod) add(res ResultType) {
+	key := seriesKey(res.Tags)
+	p := s.paths[key]
+	if p == nil {
+		p = &pathSummary{
+			from:   res.Tags["region1"] + "/" + res.Tags["site1"],
+			to:     res.Region + "/" + res.Site,
+			series: seriesLabel(res.Tags),
+		}
+		s.paths[key] = p
+	}
+	p.add(res)
+}
+
+// csv renders the period, one line per path sorted by from, to and series.
+func (s *summaryPeriod) csv() []byte {
+	paths := make([]*pathSummary, 0, len(s.paths))
+	for _, p := range s.paths {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		a, b := paths[i], paths[j]
+		if a.from != b.from {
+			return a.from < b.from
+		}
+		if a.to != b.to {
+			return a.to < b.to
+		}
+		return a.series < b.series
+	})
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(summaryHeader)
+	for _, p := range paths {
+		w.Write(p.record(s.start, s.end))
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// runSummaries collects every rtt result into a daily and a weekly
+// period and, when a period ends, delivers it to the summaries configured
+// at that time, until stop is closed. The first periods start with the
+// agent, so they cover less than a day or a week.
+func runSummaries(stop <-chan struct{}) {
+	results := subscribeResults()
+	defer unsubscribeResults(results)
+	periods := make(map[string]*summaryPeriod)
+	for every := range summaryPeriods {
+		periods[every] = newSummaryPeriod(every, clock.Now())
+	}
+	next := func() (string, time.Time) {
+		var every string
+		var end time.Time
+		for e, period := range periods {
+			if every == "" || period.end.Before(end) {
+				every, end = e, period.end
+			}
+		}
+		return every, end
+	}
+	for {
+		every, end := next()
+		timer := clock.NewTimer(end.Sub(clock.Now()))
+	wait:
+		for {
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case res := <-results:
+				if res.Measurement != "rtt" {
+					continue
+				}
+				configLock.RLock()
+				enabled := len(configData.Summaries) > 0
+				configLock.RUnlock()
+				if !enabled {
+					continue
+				}
+				for _, period := range periods {
+					period.add(res)
+				}
+			case <-timer.C():
+				break wait
+			}
+		}
+		done := periods[every]
+		periods[every] = newSummaryPeriod(every, end)
+		configLock.RLock()
+		local := configData.LocalSite
+		summaries := configData.Summaries
+		configLock.RUnlock()
+		for _, summary := range summaries {
+			if summary.Every == every {
+				go deliverSummary(summary, local, done)
+			}
+		}
+	}
+}
+
+// deliverSummary writes the period to the summary's directory as
+// netcheck-<region>-<site>-<every>-<start>.csv and mails it.
+func deliverSummary(summary SummaryType, local SiteType, period *summaryPeriod) {
+	name := fmt.Sprintf("netcheck-%s-%s-%s-%s.csv", local.Region, local.Site, period.every, period.start.Format("2006-01-02"))
+	data := period.csv()
+	logger := log.WithFields(log.Fields{"Summary": name})
+	if dryRun {
+		logger.Info(fmt.Sprintf("Would write summary:\n%s", data))
+		return
+	}
+	if summary.Dir != "" {
+		if err := writeFileAtomic(filepath.Join(summary.Dir, name), data); err != nil {
+			logger.Error(fmt.Sprintf("Failed to write summary: %s", err))
+		} else {
+			logger.Info("Wrote summary")
+		}
+	}
+	if len(summary.Email.To) > 0 {
+		subject := fmt.Sprintf("netcheck %s summary for %s, %s", period.every, local.key(), period.start.Format("2006-01-02"))
+		if err := mailSummary(summary.Email, subject, name, data); err != nil {
+			logger.Error(fmt.Sprintf("Failed to mail summary: %s", err))
+		} else {
+			logger.Info("Mailed summary")
+		}
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".netcheck-summary-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// mailSummary sends the CSV as an attachment, with STARTTLS when the
+// server offers it.
+func mailSummary(cfg EmailType, subject string, name string, data []byte) error {
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		password, err := resolveSecret(cfg.Password)
+		if err != nil {
+			return fmt.Errorf("error resolving smtp password: %s", err)
+		}
+		host, _, err := net.SplitHostPort(cfg.SMTP)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", cfg.Username, password, host)
+	}
+	boundary := fmt.Sprintf("netcheck-%d", clock.Now().UnixNano())
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", clock.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", boundary)
+	fmt.Fprintf(&msg, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s, attached as %s.\r\n", boundary, subject, name)
+	fmt.Fprintf(&msg, "--%s\r\nContent-Type: text/csv; charset=utf-8\r\n", boundary)
+	fmt.Fprintf(&msg, "Content-Disposition: attachment; filename=%q\r\nContent-Transfer-Encoding: base64\r\n\r\n", name)
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		msg.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	msg.WriteString(encoded + "\r\n")
+	fmt.Fprintf(&msg, "--%s--\r\n", boundary)
+	return smtp.SendMail(cfg.SMTP, auth, cfg.From, cfg.To, msg.Bytes())
+}
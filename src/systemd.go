@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sdNotify sends a state such as "READY=1" to systemd. Outside a
+// Type=notify unit NOTIFY_SOCKET is unset and nothing is sent.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		log.Debug("Failed to notify systemd: " + err.Error())
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Debug("Failed to notify systemd: " + err.Error())
+	}
+}
+
+// runWatchdog pings the systemd watchdog at half its interval while the
+// agent is healthy, so systemd restarts it when the scheduler or the
+// reflector stops working. Without WatchdogSec it returns at once.
+func runWatchdog(stop <-chan struct{}) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(usec) * time.Microsecond / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if health := checkHealth(false); health.Status == "ok" {
+				sdNotify("WATCHDOG=1")
+			} else {
+				log.WithFields(log.Fields{"Checks": health.Checks}).Warn("Unhealthy, not pinging the systemd watchdog")
+			}
+		}
+	}
+}
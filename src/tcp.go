@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/proxy"
+)
+
+// siteProxy returns the proxy URL a site's TCP, TLS and HTTP probes go
+// through, if any.
+func siteProxy(site SiteType) string {
+	if site.Proxy != "" {
+		return site.Proxy
+	}
+	return currentConfig().Proxy
+}
+
+// dialDirect connects to address as the site's UDP probes are sent:
+// from its source address, through its interface, with its DSCP and in
+// its network namespace.
+func dialDirect(ctx context.Context, site SiteType, address string, timeout time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := resolveHost(host)
+	if err != nil {
+		return nil, err
+	}
+	opts := probeSockOpts(site)
+	opts.HostTiming = false
+	dialer := &net.Dialer{Timeout: timeout, Control: opts.control}
+	laddr, err := sourceAddr(site, ips[0])
+	if err != nil {
+		return nil, err
+	}
+	if laddr != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: laddr.IP}
+	}
+	target := net.JoinHostPort(ips[0].String(), port)
+	if site.Netns == "" {
+		return dialer.DialContext(ctx, "tcp", target)
+	}
+	var conn net.Conn
+	err = inNetns(site.Netns, func() error {
+		var err error
+		conn, err = dialer.DialContext(ctx, "tcp", target)
+		return err
+	})
+	return conn, err
+}
+
+// siteDialer dials for a site's probes, as a proxy's forward dialer.
+type siteDialer struct {
+	site    SiteType
+	timeout time.Duration
+}
+
+func (d siteDialer) Dial(network string, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d siteDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	return dialDirect(ctx, d.site, address, d.timeout)
+}
+
+// dialTCP connects to address for site, directly or through its
+// socks5:// or http:// (CONNECT) proxy, giving up when ctx ends.
+func dialTCP(ctx context.Context, site SiteType, address string, timeout time.Duration) (net.Conn, error) {
+	direct := siteDialer{site: site, timeout: timeout}
+	proxyURL := siteProxy(site)
+	if proxyURL == "" {
+		return direct.DialContext(ctx, "tcp", address)
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %s: %s", proxyURL, err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		dialer, err := proxy.FromURL(u, direct)
+		if err != nil {
+			return nil, err
+		}
+		if d, ok := dialer.(proxy.ContextDialer); ok {
+			return d.DialContext(ctx, "tcp", address)
+		}
+		return dialer.Dial("tcp", address)
+	case "http":
+		return dialHTTPConnect(ctx, direct, u, address, timeout)
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %s", u.Scheme)
+}
+
+func dialHTTPConnect(ctx context.Context, direct siteDialer, u *url.URL, address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := direct.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer closeOnDone(ctx, conn)()
+	conn.SetDeadline(time.Now().Add(timeout))
+	req := &http.Request{Method: "CONNECT", URL: &url.URL{Opaque: address}, Host: address, Header: make(http.Header)}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+pass)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", address, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connectTimeout bounds one connection of a TCP, TLS or HTTP probe.
+const connectTimeout = 10 * time.Second
+
+// connectTypes are the probe types measured over TCP connections.
+var connectTypes = map[string]bool{"tcp": true, "tls": true, "http": true}
+
+// servicePort is the port a site's type is probed on unless port is set,
+// 0 for the reflector's.
+func (s SiteType) servicePort() uint {
+	switch s.Type {
+	case "ntp":
+		return ntpPort
+	case "tls":
+		return 443
+	case "http":
+		if u, err := url.Parse(s.URL); err == nil && s.URL != "" {
+			if n, err := strconv.ParseUint(u.Port(), 10, 16); err == nil {
+				return uint(n)
+			}
+			if u.Scheme == "https" {
+				return 443
+			}
+		}
+		return 80
+	}
+	return 0
+}
+
+// probeConnect measures, to address, the time to a TCP connection (tcp),
+// to a TLS session over one (tls) or to the response headers of a GET of
+// the site's url (http), the same number of times and at the same pace as
+// the UDP probe, and writes the result tagged with proto: failures count
+// as loss, and the outage point is written when none succeeded.
+func probeConnect(ctx context.Context, API influxAPI.WriteAPI, localSite SiteType, remoteSite SiteType, address string, proto string, extra map[string]string) {
+	var minRTT int64
+	var maxRTT int64
+	var avgRTT int64
+	tags := siteTags(localSite, remoteSite)
+	for k, v := range extra {
+		tags[k] = v
+	}
+	tags["proto"] = proto
+	count := remoteSite.probeCount()
+	gap := sched.probeGap(remoteSite, currentConfig().Adaptive)
+	connected := 0
+	status := 0
+	for i := 0; i < count; i++ {
+		if i > 0 && !sleepCtx(ctx, gap) {
+			return
+		}
+		start := time.Now()
+		var err error
+		switch proto {
+		case "tls":
+			err = probeTLS(ctx, remoteSite, address)
+		case "http":
+			status, err = probeHTTP(ctx, remoteSite, address)
+		default:
+			var conn net.Conn
+			if conn, err = dialTCP(ctx, remoteSite, address, connectTimeout); err == nil {
+				conn.Close()
+			}
+		}
+		atomic.AddUint64(&telemetry.probes, 1)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			atomic.AddUint64(&telemetry.timeouts, 1)
+			siteLog(remoteSite).Debug(fmt.Sprintf("Failed to connect to %s: %s", address, err))
+			continue
+		}
+		rtt := time.Since(start).Microseconds()
+		minRTT = min(minRTT, rtt)
+		maxRTT = max(maxRTT, rtt)
+		avgRTT += rtt
+		connected++
+	}
+	if connected == 0 {
+		siteLog(remoteSite).Debug(fmt.Sprintf("No connection to %s", address))
+		writeOutage(API, remoteSite, tags)
+		return
+	}
+	avgRTT = avgRTT / int64(connected)
+	loss := 100 * float64(count-connected) / float64(count)
+	siteLog(remoteSite).WithFields(log.Fields{"Client": address}).Debug(fmt.Sprintf("%s time is %d microsec, Jitter is %d microsec, Loss is %.0f%%", strings.ToUpper(proto), avgRTT, maxRTT-minRTT, loss))
+	fields := map[string]interface{}{"avg": avgRTT, "jitter": maxRTT - minRTT, "loss": loss}
+	if proto == "http" {
+		fields["status"] = int64(status)
+	}
+	writeResult(API, "rtt", remoteSite, tags, fields)
+}
+
+// probeTLS connects to address and completes a TLS handshake, verifying
+// the certificate for the site's address.
+func probeTLS(ctx context.Context, site SiteType, address string) error {
+	conn, err := dialTCP(ctx, site, address, connectTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+	conn.SetDeadline(time.Now().Add(connectTimeout))
+	return tls.Client(conn, &tls.Config{ServerName: site.Address}).Handshake()
+}
+
+// probeHTTP gets the site's url, by default http://address/, over a new
+// connection to address and returns the status. Redirects are not
+// followed.
+func probeHTTP(ctx context.Context, site SiteType, address string) (int, error) {
+	target := site.URL
+	if target == "" {
+		_, port, _ := net.SplitHostPort(address)
+		target = "http://" + net.JoinHostPort(site.Address, port) + "/"
+	}
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return 0, err
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+				return dialTCP(ctx, site, address, connectTimeout)
+			},
+			DisableKeepAlives: true,
+		},
+		Timeout: connectTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	influxAPI "github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// telemetry counts what the agent itself does, since it started.
+var telemetry struct {
+	cycles        uint64
+	skipped       uint64
+	probes        uint64
+	timeouts      uint64
+	pointsQueued  uint64
+	pointsWritten uint64
+	pointsDropped uint64
+	writeErrors   uint64
+	exportDropped uint64
+	rejected      uint64
+	tagsLimited   uint64
+}
+
+var started = time.Now()
+
+// countWriteErrors counts the failed writes of api until it is closed.
+// The client logs them itself.
+func countWriteErrors(api influxAPI.WriteAPI) {
+	for range api.Errors() {
+		atomic.AddUint64(&telemetry.writeErrors, 1)
+	}
+}
+
+// writeQueue returns the number of points handed to the InfluxDB client
+// that it has neither written nor had rejected: those in its buffers and
+// those waiting to be retried.
+func writeQueue() int64 {
+	queued := int64(atomic.LoadUint64(&telemetry.pointsQueued))
+	done := int64(atomic.LoadUint64(&telemetry.pointsWritten) + atomic.LoadUint64(&telemetry.pointsDropped))
+	if done > queued {
+		return 0
+	}
+	return queued - done
+}
+
+// exportQueue returns the number of results and events waiting for their
+// subscribers: the other exporters, streams and captures.
+func exportQueue() int64 {
+	var n int
+	resultsLock.Lock()
+	for ch := range subscribers {
+		n += len(ch)
+	}
+	resultsLock.Unlock()
+	eventsLock.Lock()
+	for ch := range eventSubscribers {
+		n += len(ch)
+	}
+	eventsLock.Unlock()
+	return int64(n)
+}
+
+// writeTelemetry writes the agent's own counters as the "agent"
+// measurement, tagged with the local site, after each cycle.
+func writeTelemetry(API influxAPI.WriteAPI, summary cycleSummary) {
+	configLock.RLock()
+	local := configData.LocalSite
+	hostTiming := configData.HostTiming
+	tags := make(map[string]string)
+	for k, v := range configData.Tags {
+		tags[k] = v
+	}
+	configLock.RUnlock()
+	if hostname != "" {
+		tags["host"] = hostname
+	}
+	tags["region1"] = local.Region
+	tags["site1"] = local.Site
+	fields := map[string]interface{}{
+		"cycles":         int64(atomic.LoadUint64(&telemetry.cycles)),
+		"cycle":          summary.ID,
+		"cycle_duration": summary.Duration,
+		"sites":          summary.Sites,
+		"unreachable":    summary.Unreachable,
+		"skipped":        int64(atomic.LoadUint64(&telemetry.skipped)),
+		"probes":         int64(atomic.LoadUint64(&telemetry.probes)),
+		"timeouts":       int64(atomic.LoadUint64(&telemetry.timeouts)),
+		"points_queued":  int64(atomic.LoadUint64(&telemetry.pointsQueued)),
+		"points_written": int64(atomic.LoadUint64(&telemetry.pointsWritten)),
+		"points_dropped": int64(atomic.LoadUint64(&telemetry.pointsDropped)),
+		"write_queue":    writeQueue(),
+		"write_errors":   int64(atomic.LoadUint64(&telemetry.writeErrors)),
+		"export_queue":   exportQueue(),
+		"export_dropped": int64(atomic.LoadUint64(&telemetry.exportDropped)),
+		"rejected":       int64(atomic.LoadUint64(&telemetry.rejected)),
+		"tags_limited":   int64(atomic.LoadUint64(&telemetry.tagsLimited)),
+		"goroutines":     runtime.NumGoroutine(),
+		"uptime":         int64(time.Since(started).Seconds()),
+	}
+	if hostTiming {
+		for k, v := range hostDrops() {
+			fields[k] = v
+		}
+	}
+	API.WritePoint(newPoint("agent", tags, fields, time.Now()))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProbeTimeoutType derives the time a probe waits for its reply from the
+// path's recent RTTs, their p99 times factor (3 by default) within min and
+// max milliseconds (50 and 10000), instead of the fixed probeTimeout, so
+// a dead fast path fails fast.
+type ProbeTimeoutType struct {
+	Adaptive bool    `yaml:"adaptive"`
+	Factor   float64 `yaml:"factor"`
+	Min      uint    `yaml:"min"`
+	Max      uint    `yaml:"max"`
+}
+
+const (
+	// pathSamples is how many RTTs of a path are kept.
+	pathSamples = 200
+	// minPathSamples are needed before the timeout adapts.
+	minPathSamples = 20
+	// pathIdle is how long a path without a probe is kept, see
+	// prunePathTimeouts.
+	pathIdle = time.Hour
+)
+
+func (t ProbeTimeoutType) factor() float64 {
+	if t.Factor == 0 {
+		return 3
+	}
+	return t.Factor
+}
+
+func (t ProbeTimeoutType) min() time.Duration {
+	if t.Min == 0 {
+		return 50 * time.Millisecond
+	}
+	return time.Duration(t.Min) * time.Millisecond
+}
+
+func (t ProbeTimeoutType) max() time.Duration {
+	if t.Max == 0 {
+		return probeTimeout
+	}
+	return time.Duration(t.Max) * time.Millisecond
+}
+
+// pathRTTs are the recent RTTs of a path in microseconds, the probes
+// lost in a row since the last reply, and when the path was last probed.
+type pathRTTs struct {
+	samples  []int64
+	next     int
+	timeouts uint
+	seen     time.Time
+}
+
+var (
+	pathTimeouts     = make(map[string]*pathRTTs)
+	pathTimeoutsLock sync.Mutex
+)
+
+// replyTimeout returns how long a probe on path waits for its reply.
+// Every probe lost in a row doubles the timeout, up to max, so a path
+// whose RTT grew past it gets replies again.
+func replyTimeout(path string) time.Duration {
+	cfg := currentConfig().ProbeTimeout
+	if !cfg.Adaptive || path == "" {
+		return probeTimeout
+	}
+	pathTimeoutsLock.Lock()
+	defer pathTimeoutsLock.Unlock()
+	p := pathTimeouts[path]
+	if p == nil || len(p.samples) < minPathSamples {
+		return cfg.max()
+	}
+	sorted := append([]int64(nil), p.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	p99 := sorted[int(math.Ceil(0.99*float64(len(sorted))))-1]
+	timeout := time.Duration(float64(p99)*cfg.factor()) * time.Microsecond
+	for i := uint(0); i < p.timeouts && timeout < cfg.max(); i++ {
+		timeout *= 2
+	}
+	if timeout < cfg.min() {
+		timeout = cfg.min()
+	}
+	if timeout > cfg.max() {
+		timeout = cfg.max()
+	}
+	return timeout
+}
+
+// notePathRTT adds an RTT to the samples of path, late replies included.
+func notePathRTT(path string, rtt time.Duration) {
+	if path == "" {
+		return
+	}
+	pathTimeoutsLock.Lock()
+	defer pathTimeoutsLock.Unlock()
+	p := pathTimeouts[path]
+	if p == nil {
+		p = &pathRTTs{}
+		pathTimeouts[path] = p
+	}
+	if len(p.samples) < pathSamples {
+		p.samples = append(p.samples, rtt.Microseconds())
+	} else {
+		p.samples[p.next] = rtt.Microseconds()
+		p.next = (p.next + 1) % pathSamples
+	}
+	p.timeouts = 0
+	p.seen = clock.Now()
+}
+
+func notePathTimeout(path string) {
+	pathTimeoutsLock.Lock()
+	defer pathTimeoutsLock.Unlock()
+	if p := pathTimeouts[path]; p != nil {
+		p.timeouts++
+		p.seen = clock.Now()
+	}
+}
+
+// prunePathTimeouts drops the paths not probed since pathIdle before now,
+// those of addresses a site no longer resolves to or discovery dropped.
+func prunePathTimeouts(now time.Time) {
+	pathTimeoutsLock.Lock()
+	defer pathTimeoutsLock.Unlock()
+	for path, p := range pathTimeouts {
+		if now.Sub(p.seen) > pathIdle {
+			delete(pathTimeouts, path)
+		}
+	}
+}
+
+// forgetPathTimeouts drops the paths of the site with key.
+func forgetPathTimeouts(key string) {
+	pathTimeoutsLock.Lock()
+	defer pathTimeoutsLock.Unlock()
+	for path := range pathTimeouts {
+		if strings.HasPrefix(path, key+" ") {
+			delete(pathTimeouts, path)
+		}
+	}
+}
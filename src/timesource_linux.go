@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// defaultTAIOffset is TAI - UTC in seconds, for when the kernel was not
+// told.
+const defaultTAIOffset = 37
+
+var (
+	phcFile *os.File
+	phcLock sync.Mutex
+)
+
+// phcNow reads the PTP hardware clock at device. PHCs keep TAI, so the
+// kernel's TAI offset is taken off.
+func phcNow(device string) (time.Time, error) {
+	phcLock.Lock()
+	defer phcLock.Unlock()
+	if phcFile == nil || phcFile.Name() != device {
+		if phcFile != nil {
+			phcFile.Close()
+		}
+		f, err := os.Open(device)
+		if err != nil {
+			phcFile = nil
+			return time.Time{}, err
+		}
+		phcFile = f
+	}
+	// FD_TO_CLOCKID
+	clock := int32(^int(phcFile.Fd())<<3 | 3)
+	var ts unix.Timespec
+	if err := unix.ClockGettime(clock, &ts); err != nil {
+		return time.Time{}, err
+	}
+	offset := int64(defaultTAIOffset)
+	var tx unix.Timex
+	if _, err := unix.Adjtimex(&tx); err == nil && tx.Tai != 0 {
+		offset = int64(tx.Tai)
+	}
+	return time.Unix(int64(ts.Sec)-offset, int64(ts.Nsec)), nil
+}
+
+// systemSync tells whether the system clock is synchronized, by NTP,
+// chrony or gpsd, and its estimated error in microseconds.
+func systemSync() (bool, int64) {
+	var tx unix.Timex
+	state, err := unix.Adjtimex(&tx)
+	if err != nil || state == unix.TIME_ERROR {
+		return false, 0
+	}
+	return true, tx.Esterror
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func phcNow(device string) (time.Time, error) {
+	return time.Time{}, fmt.Errorf("PTP hardware clocks are only supported on Linux")
+}
+
+// systemSync cannot tell the state of the clock here; it is taken as
+// synchronized, with an unknown error.
+func systemSync() (bool, int64) {
+	return true, -1
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type topologyNode struct {
+	ID     string `json:"id"`
+	Region string `json:"region"`
+	Site   string `json:"site"`
+}
+
+// topologyEdge is the latest measurement of one path. RTT and jitter are
+// in microseconds.
+type topologyEdge struct {
+	From   string      `json:"from"`
+	To     string      `json:"to"`
+	Up     bool        `json:"up"`
+	RTT    interface{} `json:"rtt,omitempty"`
+	Jitter interface{} `json:"jitter,omitempty"`
+	Loss   interface{} `json:"loss,omitempty"`
+	Time   int64       `json:"time,omitempty"`
+}
+
+type topologyType struct {
+	Nodes []topologyNode `json:"nodes"`
+	Edges []topologyEdge `json:"edges"`
+}
+
+// localTopology is the graph measured by this agent: an edge from the
+// local site to every remote site.
+func localTopology() topologyType {
+	configLock.RLock()
+	local := configData.LocalSite
+	configLock.RUnlock()
+	topo := topologyType{Nodes: []topologyNode{{ID: local.key(), Region: local.Region, Site: local.Site}}}
+	for _, site := range sched.list() {
+		topo.Nodes = append(topo.Nodes, topologyNode{ID: site.key(), Region: site.Region, Site: site.Site})
+		sched.lock.Lock()
+		edge := topologyEdge{From: local.key(), To: site.key(), Up: !sched.down[site.key()]}
+		sched.lock.Unlock()
+		if past := siteHistory(site.key(), "rtt"); len(past) > 0 {
+			res := past[len(past)-1]
+			edge.RTT = res.Fields["avg"]
+			edge.Jitter = res.Fields["jitter"]
+			edge.Loss = res.Fields["loss"]
+			edge.Time = res.Time.Unix()
+		}
+		topo.Edges = append(topo.Edges, edge)
+	}
+	return topo
+}
+
+// handleTopology returns the local topology as JSON, or as Graphviz DOT
+// with ?format=dot.
+func handleTopology(w http.ResponseWriter, r *http.Request) {
+	topo := localTopology()
+	if r.URL.Query().Get("format") == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		writeDOT(w, topo)
+		return
+	}
+	writeJSON(w, http.StatusOK, topo)
+}
+
+// writeDOT writes topo as a directed graph with sites clustered by region
+// and edges labelled with their RTT in milliseconds; paths that are down
+// are red and dashed.
+func writeDOT(out io.Writer, topo topologyType) {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	fmt.Fprintln(w, "digraph netcheck {")
+	fmt.Fprintln(w, "  node [shape=box];")
+	regions := make(map[string][]topologyNode)
+	var names []string
+	for _, node := range topo.Nodes {
+		if _, ok := regions[node.Region]; !ok {
+			names = append(names, node.Region)
+		}
+		regions[node.Region] = append(regions[node.Region], node)
+	}
+	sort.Strings(names)
+	for i, region := range names {
+		fmt.Fprintf(w, "  subgraph cluster_%d {\n    label=%q;\n", i, region)
+		for _, node := range regions[region] {
+			fmt.Fprintf(w, "    %q [label=%q];\n", node.ID, node.Site)
+		}
+		fmt.Fprintln(w, "  }")
+	}
+	for _, edge := range topo.Edges {
+		attrs := []string{}
+		if rtt, ok := fieldFloat(edge.RTT); ok {
+			attrs = append(attrs, fmt.Sprintf("label=\"%.1fms\"", rtt/1000))
+		}
+		if !edge.Up {
+			attrs = append(attrs, "color=red", "style=dashed")
+		}
+		fmt.Fprintf(w, "  %q -> %q [%s];\n", edge.From, edge.To, strings.Join(attrs, ", "))
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// fieldFloat returns a numeric result field, as written (int64) or after
+// a JSON round trip (float64).
+func fieldFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// runTopology implements "netcheck topology": it merges the topology of
+// every agent given into one graph of the whole mesh.
+func runTopology(args []string) int {
+	fs := flag.NewFlagSet("topology", flag.ExitOnError)
+	fs.Usage = commandUsage("topology", fs)
+	format := fs.String("format", "dot", "Output format, dot or json")
+	token := fs.String("token", "", "Admin API token of the agents")
+	timeout := fs.Duration("timeout", 10*time.Second, "Give up on an agent after this long")
+	fs.Parse(args)
+	if fs.NArg() == 0 || (*format != "dot" && *format != "json") {
+		fs.Usage()
+		return 2
+	}
+	client := &http.Client{Timeout: *timeout}
+	merged := topologyType{}
+	seen := make(map[string]bool)
+	failed := false
+	for _, agent := range fs.Args() {
+		topo, err := fetchTopology(client, strings.TrimRight(agent, "/")+"/api/topology", *token)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", agent, err)
+			failed = true
+			continue
+		}
+		for _, node := range topo.Nodes {
+			if !seen[node.ID] {
+				seen[node.ID] = true
+				merged.Nodes = append(merged.Nodes, node)
+			}
+		}
+		merged.Edges = append(merged.Edges, topo.Edges...)
+	}
+	if *format == "json" {
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		out.Encode(merged)
+	} else {
+		writeDOT(os.Stdout, merged)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func fetchTopology(client *http.Client, url string, token string) (topologyType, error) {
+	var topo topologyType
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return topo, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return topo, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return topo, fmt.Errorf("%s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&topo)
+	return topo, err
+}
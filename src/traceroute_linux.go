@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// traceroute sends UDP probes to addr with increasing TTL and returns the
+// address of each hop, "*" where none answered. ICMP errors are read from
+// the socket's error queue (IP_RECVERR), so no raw socket or privileges
+// are needed. The trace ends when the reflector echoes a probe, the
+// destination reports the port unreachable or maxHops is reached.
+func traceroute(site SiteType, addr *net.UDPAddr, maxHops int) ([]string, bool, error) {
+	v4 := addr.IP.To4() != nil
+	family, network := unix.AF_INET6, "udp6"
+	ttlLevel, ttlOpt, errLevel, errOpt := unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, unix.IPPROTO_IPV6, unix.IPV6_RECVERR
+	var sa unix.Sockaddr
+	if v4 {
+		family, network = unix.AF_INET, "udp4"
+		ttlLevel, ttlOpt, errLevel, errOpt = unix.IPPROTO_IP, unix.IP_TTL, unix.IPPROTO_IP, unix.IP_RECVERR
+		sa4 := &unix.SockaddrInet4{Port: addr.Port}
+		copy(sa4.Addr[:], addr.IP.To4())
+		sa = sa4
+	} else {
+		sa6 := &unix.SockaddrInet6{Port: addr.Port}
+		copy(sa6.Addr[:], addr.IP.To16())
+		sa = sa6
+	}
+	fd, err := unix.Socket(family, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	defer unix.Close(fd)
+	opts := probeSockOpts(site)
+	opts.TTL = 0
+	if err := applySockOpts(fd, network, opts); err != nil {
+		return nil, false, err
+	}
+	if err := unix.SetsockoptInt(fd, errLevel, errOpt, 1); err != nil {
+		return nil, false, fmt.Errorf("enabling IP_RECVERR: %s", err)
+	}
+	if err := unix.Connect(fd, sa); err != nil {
+		return nil, false, err
+	}
+	id := fmt.Sprintf("trace:%d:", time.Now().UnixNano())
+	buf := make([]byte, 512)
+	oob := make([]byte, 512)
+	var hops []string
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		if err := unix.SetsockoptInt(fd, ttlLevel, ttlOpt, ttl); err != nil {
+			return nil, false, err
+		}
+		probe := fmt.Sprintf("%s%d", id, ttl)
+		if _, err := unix.Write(fd, signPacket([]byte(probe))); err != nil {
+			return nil, false, err
+		}
+		hop, reached := "*", false
+		deadline := time.Now().Add(time.Second)
+	wait:
+		for {
+			left := time.Until(deadline)
+			if left <= 0 {
+				break
+			}
+			fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+			if n, err := unix.Poll(fds, int(left/time.Millisecond)+1); err != nil || n == 0 {
+				continue
+			}
+			if fds[0].Revents&unix.POLLERR != 0 {
+				n, oobn, _, _, err := unix.Recvmsg(fd, buf, oob, unix.MSG_ERRQUEUE)
+				if err != nil {
+					continue
+				}
+				// The queued error carries the probe that caused it,
+				// so late answers to earlier hops are told apart.
+				if string(buf[:n]) != probe {
+					continue
+				}
+				from, final, ok := parseRecvErr(oob[:oobn])
+				if !ok {
+					continue
+				}
+				hop, reached = from, final
+				break wait
+			}
+			if fds[0].Revents&unix.POLLIN != 0 {
+				n, err := unix.Read(fd, buf)
+				if err == nil && strings.HasPrefix(string(buf[:n]), probe) {
+					hop, reached = addr.IP.String(), true
+					break wait
+				}
+			}
+		}
+		hops = append(hops, hop)
+		if reached {
+			return hops, true, nil
+		}
+	}
+	return hops, false, nil
+}
+
+// parseRecvErr returns the sender of the ICMP error in oob and whether it
+// ends the trace (anything but time exceeded).
+func parseRecvErr(oob []byte) (string, bool, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return "", false, false
+	}
+	for _, m := range msgs {
+		if !(m.Header.Level == unix.IPPROTO_IP && m.Header.Type == unix.IP_RECVERR) && !(m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_RECVERR) {
+			continue
+		}
+		size := int(unsafe.Sizeof(unix.SockExtendedErr{}))
+		if len(m.Data) < size+8 {
+			continue
+		}
+		ee := (*unix.SockExtendedErr)(unsafe.Pointer(&m.Data[0]))
+		// The offending node's address follows as a sockaddr.
+		offender := m.Data[size:]
+		var from net.IP
+		var exceeded bool
+		switch ee.Origin {
+		case unix.SO_EE_ORIGIN_ICMP:
+			from = net.IP(offender[4:8])
+			exceeded = ee.Type == 11
+		case unix.SO_EE_ORIGIN_ICMP6:
+			if len(offender) < 24 {
+				continue
+			}
+			from = net.IP(offender[8:24])
+			exceeded = ee.Type == 3
+		default:
+			continue
+		}
+		return from.String(), !exceeded, true
+	}
+	return "", false, false
+}
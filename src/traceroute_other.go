@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+func traceroute(site SiteType, addr *net.UDPAddr, maxHops int) ([]string, bool, error) {
+	return nil, false, fmt.Errorf("traceroute is only supported on Linux")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Thresholds for coloring the terminal table, in microseconds and percent.
+const (
+	tuiWarnRTT  = 20000
+	tuiBadRTT   = 100000
+	tuiBadLoss  = 5
+	tuiLogLines = 5
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiRed    = "\x1b[31m"
+	ansiDim    = "\x1b[2m"
+)
+
+// tuiLog keeps the last log lines so they can be shown under the table
+// instead of scrolling it away.
+type tuiLog struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+func (t *tuiLog) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		t.lines = append(t.lines, line)
+	}
+	if len(t.lines) > tuiLogLines {
+		t.lines = t.lines[len(t.lines)-tuiLogLines:]
+	}
+	return len(p), nil
+}
+
+func (t *tuiLog) last() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]string(nil), t.lines...)
+}
+
+// runTUI redraws a table of the sites and their latest RTT, jitter and loss
+// every second until stop is closed.
+func runTUI(logs *tuiLog, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	fmt.Print("\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\n")
+	for {
+		drawTUI(logs)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func drawTUI(logs *tuiLog) {
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&buf, "netcheck %s  %s\n\n", configData.LocalSite.key(), time.Now().Format("15:04:05"))
+	fmt.Fprintf(&buf, "%-30s %-40s %10s %10s %7s %9s\n", "SITE", "ADDRESS", "RTT ms", "JITTER ms", "LOSS %", "AGE")
+	sites := sched.list()
+	sort.Slice(sites, func(i, j int) bool { return sites[i].key() < sites[j].key() })
+	for _, site := range sites {
+		row := fmt.Sprintf("%-30s %-40s ", site.key(), site.Address)
+		rtts := siteHistory(site.key(), "rtt")
+		if sched.isPaused(site.key()) {
+			buf.WriteString(ansiDim + row + "paused" + ansiReset + "\n")
+			continue
+		}
+		if len(rtts) == 0 {
+			buf.WriteString(ansiDim + row + "waiting" + ansiReset + "\n")
+			continue
+		}
+		res := rtts[len(rtts)-1]
+		avg, _ := res.Fields["avg"].(int64)
+		jitter, _ := res.Fields["jitter"].(int64)
+		loss, hasLoss := res.Fields["loss"].(float64)
+		color := ansiGreen
+		switch {
+		case avg > tuiBadRTT || loss > tuiBadLoss:
+			color = ansiRed
+		case avg > tuiWarnRTT || loss > 0:
+			color = ansiYellow
+		}
+		lossText := "-"
+		if hasLoss {
+			lossText = fmt.Sprintf("%.1f", loss)
+		}
+		fmt.Fprintf(&buf, "%s%s%10.1f %10.1f %7s %9s%s\n", color, row, float64(avg)/1000, float64(jitter)/1000, lossText,
+			time.Since(res.Time).Truncate(time.Second), ansiReset)
+	}
+	buf.WriteString("\n")
+	for _, line := range logs.last() {
+		buf.WriteString(ansiDim + line + ansiReset + "\n")
+	}
+	os.Stdout.Write(buf.Bytes())
+}
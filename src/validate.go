@@ -0,0 +1,393 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// validateConfig checks a parsed configuration for missing or unusable
+// values and returns one error per problem, keyed by the YAML path and
+// with its line when known.
+func validateConfig(cfg ConfigType) []error {
+	return cfg.locate(checkConfig(cfg))
+}
+
+func checkConfig(cfg ConfigType) []error {
+	var errs []error
+	required := func(key string, val string) {
+		if val == "" {
+			errs = append(errs, fmt.Errorf("%s: required", key))
+		}
+	}
+	role := cfg.role()
+	if role != "client" && role != "server" && role != "both" {
+		errs = append(errs, fmt.Errorf("role: must be client, server or both"))
+	}
+	if role != "server" && cfg.Period == 0 {
+		errs = append(errs, fmt.Errorf("period: must be greater than 0"))
+	}
+	if cfg.Port > 65535 || (cfg.Port == 0 && (role != "client" && cfg.ListenPort == 0 && len(cfg.Listen) == 0)) {
+		errs = append(errs, fmt.Errorf("port: must be between 1 and 65535"))
+	}
+	if cfg.ListenPort > 65535 {
+		errs = append(errs, fmt.Errorf("listenPort: must be between 1 and 65535"))
+	}
+	for i, port := range cfg.ListenPorts {
+		if port == 0 || port > 65535 {
+			errs = append(errs, fmt.Errorf("listenPorts[%d]: must be between 1 and 65535", i))
+		}
+	}
+	if len(cfg.ListenPorts) > 0 && len(cfg.Listen) > 0 {
+		errs = append(errs, fmt.Errorf("listenPorts: cannot be used with listen, give the ports there"))
+	}
+	for i, address := range cfg.Listen {
+		if _, err := net.ResolveUDPAddr("udp", address); err != nil {
+			errs = append(errs, fmt.Errorf("listen[%d]: %s", i, err))
+		}
+	}
+	if p := cfg.Adaptive.Policy; p != "" && p != "faster" && p != "slower" {
+		errs = append(errs, fmt.Errorf("adaptive.policy: must be faster or slower"))
+	}
+	if cfg.Adaptive.Factor != 0 && cfg.Adaptive.Factor <= 1 {
+		errs = append(errs, fmt.Errorf("adaptive.factor: must be greater than 1"))
+	}
+	for i, gw := range cfg.FirstHop.Gateways {
+		if net.ParseIP(gw) == nil {
+			errs = append(errs, fmt.Errorf("firstHop.gateways[%d]: invalid IP address %s", i, gw))
+		}
+	}
+	for tag, rule := range cfg.TagLimits.Tags {
+		if rule.Prefix > 32 {
+			errs = append(errs, fmt.Errorf("tagLimits.tags.%s.prefix: must be at most 32", tag))
+		}
+		if rule.Prefix6 > 128 {
+			errs = append(errs, fmt.Errorf("tagLimits.tags.%s.prefix6: must be at most 128", tag))
+		}
+	}
+	for i, route := range cfg.InfluxRoutes {
+		if len(route.Regions) == 0 && len(route.Sites) == 0 {
+			errs = append(errs, fmt.Errorf("influxRoutes[%d]: regions or sites required", i))
+		}
+		for j, site := range route.Sites {
+			if parts := strings.Split(site, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				errs = append(errs, fmt.Errorf("influxRoutes[%d].sites[%d]: must be region/site", i, j))
+			}
+		}
+	}
+	for i, summary := range cfg.Summaries {
+		if _, ok := summaryPeriods[summary.Every]; !ok {
+			errs = append(errs, fmt.Errorf("summaries[%d].every: must be daily or weekly", i))
+		}
+		if summary.Dir == "" && len(summary.Email.To) == 0 {
+			errs = append(errs, fmt.Errorf("summaries[%d]: dir or email.to required", i))
+		}
+		if len(summary.Email.To) > 0 {
+			if _, _, err := net.SplitHostPort(summary.Email.SMTP); err != nil {
+				errs = append(errs, fmt.Errorf("summaries[%d].email.smtp: %s", i, err))
+			}
+			required(fmt.Sprintf("summaries[%d].email.from", i), summary.Email.From)
+		}
+	}
+	if cfg.ProbeTimeout.Factor < 0 {
+		errs = append(errs, fmt.Errorf("probeTimeout.factor: must be positive"))
+	}
+	if cfg.ProbeTimeout.Max != 0 && cfg.ProbeTimeout.Min > cfg.ProbeTimeout.Max {
+		errs = append(errs, fmt.Errorf("probeTimeout.min: must not be over max"))
+	}
+	if cfg.Admin.Listen != "" && cfg.Admin.Token == "" && !isLoopback(cfg.Admin.Listen) {
+		errs = append(errs, fmt.Errorf("admin.token: required unless admin.listen is a loopback address"))
+	}
+	if cfg.GRPC.Listen != "" && cfg.GRPC.Token == "" && !isLoopback(cfg.GRPC.Listen) {
+		errs = append(errs, fmt.Errorf("grpc.token: required unless grpc.listen is a loopback address"))
+	}
+	if cfg.GNMI.Listen != "" && cfg.GNMI.Username == "" && !isLoopback(cfg.GNMI.Listen) {
+		errs = append(errs, fmt.Errorf("gnmi.username: required unless gnmi.listen is a loopback address"))
+	}
+	if cfg.Admin.Coordinator && cfg.Admin.Token == "" {
+		errs = append(errs, fmt.Errorf("admin.token: required with admin.coordinator"))
+	}
+	if cfg.GNMI.Username != "" && cfg.GNMI.Password == "" {
+		errs = append(errs, fmt.Errorf("gnmi.password: required with gnmi.username"))
+	}
+	if (cfg.GNMI.CertFile == "") != (cfg.GNMI.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("gnmi.certFile: goes with gnmi.keyFile"))
+	}
+	if cfg.IPFIX.Collector != "" {
+		if _, _, err := net.SplitHostPort(cfg.IPFIX.Collector); err != nil {
+			errs = append(errs, fmt.Errorf("ipfix.collector: %s", err))
+		}
+		if cfg.IPFIX.Enterprise == 0 {
+			errs = append(errs, fmt.Errorf("ipfix.enterprise: required, the private enterprise number of the information elements"))
+		}
+	}
+	switch cfg.IPFIX.Protocol {
+	case "", "udp", "tcp":
+	default:
+		errs = append(errs, fmt.Errorf("ipfix.protocol: must be udp or tcp"))
+	}
+	if cfg.Redis.URL != "" {
+		if _, err := parseRedisURL(cfg.Redis.URL); err != nil {
+			errs = append(errs, fmt.Errorf("redis.url: %s", err))
+		}
+	}
+	for i, p := range cfg.Rollup.Percentiles {
+		if p <= 0 || p > 100 {
+			errs = append(errs, fmt.Errorf("rollup.percentiles[%d]: must be over 0 and at most 100", i))
+		}
+	}
+	if cfg.EBPF.Enabled && !cfg.HostTiming {
+		errs = append(errs, fmt.Errorf("ebpf.enabled: needs hostTiming"))
+	}
+	switch cfg.TimeSource.Type {
+	case "", "system", "gps", "ptp":
+	default:
+		errs = append(errs, fmt.Errorf("timeSource.type: must be system, gps or ptp"))
+	}
+	if cfg.Capture.Dir != "" {
+		if info, err := os.Stat(cfg.Capture.Dir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("capture.dir: %s is not a directory", cfg.Capture.Dir))
+		}
+	}
+	if cfg.Capture.Snaplen > 65535 {
+		errs = append(errs, fmt.Errorf("capture.snaplen: must be at most 65535"))
+	}
+	if _, err := cfg.History.retention(); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.User != "" {
+		if _, err := user.Lookup(cfg.User); err != nil {
+			errs = append(errs, fmt.Errorf("user: %s", err))
+		}
+	}
+	if cfg.Group != "" {
+		if _, err := user.LookupGroup(cfg.Group); err != nil {
+			errs = append(errs, fmt.Errorf("group: %s", err))
+		}
+	}
+	validFamily := func(key string, val string) {
+		if val != "" && val != "auto" && val != "ipv4" && val != "ipv6" && val != "both" {
+			errs = append(errs, fmt.Errorf("%s: must be ipv4, ipv6, both or auto", key))
+		}
+	}
+	validSources := func(key string, val string) {
+		if val == "" {
+			return
+		}
+		for _, s := range strings.Split(val, ",") {
+			if net.ParseIP(strings.TrimSpace(s)) == nil {
+				errs = append(errs, fmt.Errorf("%s: invalid address %s", key, s))
+			}
+		}
+	}
+	validDSCP := func(key string, val uint) {
+		if val > 63 {
+			errs = append(errs, fmt.Errorf("%s: must be between 0 and 63", key))
+		}
+	}
+	validTTL := func(key string, val uint) {
+		if val > 255 {
+			errs = append(errs, fmt.Errorf("%s: must be between 1 and 255", key))
+		}
+	}
+	validFamily("family", cfg.Family)
+	validSources("sourceAddress", cfg.SourceAddress)
+	validDSCP("dscp", cfg.DSCP)
+	validTTL("ttl", cfg.TTL)
+	if role == "server" {
+		return errs
+	}
+	required("influxUrl", cfg.InfluxURL)
+	required("influxOrg", cfg.InfluxOrg)
+	required("influxBucket", cfg.InfluxBucket)
+	required("influxToken", cfg.InfluxToken)
+	required("localSite.region", cfg.LocalSite.Region)
+	required("localSite.site", cfg.LocalSite.Site)
+	for i, site := range cfg.RemoteSites {
+		key := fmt.Sprintf("remoteSites[%d]", i)
+		required(key+".region", site.Region)
+		required(key+".site", site.Site)
+		validFamily(key+".family", site.Family)
+		validSources(key+".sourceAddress", site.SourceAddress)
+		validDSCP(key+".dscp", site.DSCP)
+		validTTL(key+".ttl", site.TTL)
+		if site.Port > 65535 || (site.Port == 0 && cfg.Port == 0) {
+			errs = append(errs, fmt.Errorf("%s.port: must be between 1 and 65535", key))
+		}
+		for i, port := range site.Ports {
+			if port == 0 || port > 65535 {
+				errs = append(errs, fmt.Errorf("%s.ports[%d]: must be between 1 and 65535", key, i))
+			}
+		}
+		if site.Type != "" && site.Type != "udp" && site.Type != "icmp" && site.Type != "ntp" && !connectTypes[site.Type] {
+			errs = append(errs, fmt.Errorf("%s.type: must be udp, tcp, tls, http, icmp or ntp", key))
+		}
+		for j, proto := range site.Protocols {
+			if proto != "udp" && proto != "icmp" && !connectTypes[proto] {
+				errs = append(errs, fmt.Errorf("%s.protocols[%d]: must be udp, tcp, tls, http or icmp", key, j))
+			}
+		}
+		if site.URL != "" {
+			if u, err := url.Parse(site.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Errorf("%s.url: must be an http:// or https:// URL", key))
+			}
+		}
+		if site.Schedule != "" {
+			if _, err := cron.ParseStandard(site.Schedule); err != nil {
+				errs = append(errs, fmt.Errorf("%s.schedule: %s", key, err))
+			}
+		}
+		if _, ok := ecnCodepoints[site.ECN]; site.ECN != "" && !ok {
+			errs = append(errs, fmt.Errorf("%s.ecn: must be ect0, ect1 or ce", key))
+		}
+		if site.RequestSize > maxPayload {
+			errs = append(errs, fmt.Errorf("%s.requestSize: must be at most %d bytes", key, maxPayload))
+		}
+		if site.ReplySize > maxPayload {
+			errs = append(errs, fmt.Errorf("%s.replySize: must be at most %d bytes", key, maxPayload))
+		}
+		if site.Stream.Rate > 1000 {
+			errs = append(errs, fmt.Errorf("%s.stream.rate: must be at most 1000 packets per second", key))
+		}
+		if site.Stream.Duration > 0 && site.Stream.Rate == 0 {
+			errs = append(errs, fmt.Errorf("%s.stream.duration: needs stream.rate", key))
+		}
+		if site.FragTest > 9000 {
+			errs = append(errs, fmt.Errorf("%s.fragTest: must be at most 9000 bytes", key))
+		}
+		for class, dscp := range site.Classes {
+			validDSCP(fmt.Sprintf("%s.classes.%s", key, class), dscp)
+		}
+		if site.Flows > maxFlows {
+			errs = append(errs, fmt.Errorf("%s.flows: must be at most %d", key, maxFlows))
+		}
+		if site.FlowPort != 0 {
+			if site.FlowPort+site.Flows > 65536 {
+				errs = append(errs, fmt.Errorf("%s.flowPort: flows must end at port 65535", key))
+			}
+			if len(site.Classes) > 0 {
+				errs = append(errs, fmt.Errorf("%s.flowPort: cannot be used with classes, they probe at the same time", key))
+			}
+		}
+		if site.FlowLabels > maxFlows {
+			errs = append(errs, fmt.Errorf("%s.flowLabels: must be at most %d", key, maxFlows))
+		}
+		if site.FlowLabels > 1 && site.Flows > 1 {
+			errs = append(errs, fmt.Errorf("%s.flowLabels: cannot be used with flows", key))
+		}
+		if site.FlowLabel != 0 && site.FlowLabel+site.FlowLabels > maxFlowLabel+1 {
+			errs = append(errs, fmt.Errorf("%s.flowLabel: labels must end at %#x", key, maxFlowLabel))
+		}
+		tags := make(map[string]string)
+		for k, v := range cfg.Tags {
+			tags[k] = v
+		}
+		for k, v := range site.Tags {
+			tags[k] = v
+		}
+		if _, err := expandTags(site.InfluxOrg, tags); err != nil {
+			errs = append(errs, fmt.Errorf("%s.influxOrg: %s", key, err))
+		}
+		if _, err := expandTags(site.InfluxBucket, tags); err != nil {
+			errs = append(errs, fmt.Errorf("%s.influxBucket: %s", key, err))
+		}
+		required(key+".address", site.Address)
+	}
+	return errs
+}
+
+// checkAddresses resolves the addresses of the remote sites. It is left
+// out of validateConfig so a name that does not resolve for a while does
+// not stop the agent.
+func checkAddresses(cfg ConfigType) []error {
+	var errs []error
+	for i, site := range cfg.RemoteSites {
+		if site.Address == "" {
+			continue
+		}
+		if _, err := net.ResolveUDPAddr("udp", net.JoinHostPort(site.Address, "0")); err != nil {
+			errs = append(errs, fmt.Errorf("remoteSites[%d].address: %s", i, err))
+		}
+	}
+	return cfg.locate(errs)
+}
+
+// configLines maps the YAML path of every key and list item in data to
+// its line, for error messages.
+func configLines(data []byte) map[string]int {
+	var doc yaml3.Node
+	if err := yaml3.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	lines := make(map[string]int)
+	var walk func(path string, node *yaml3.Node)
+	walk = func(path string, node *yaml3.Node) {
+		switch node.Kind {
+		case yaml3.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i].Value
+				if path != "" {
+					key = path + "." + key
+				}
+				lines[key] = node.Content[i].Line
+				walk(key, node.Content[i+1])
+			}
+		case yaml3.SequenceNode:
+			for i, item := range node.Content {
+				key := fmt.Sprintf("%s[%d]", path, i)
+				lines[key] = item.Line
+				walk(key, item)
+			}
+		}
+	}
+	walk("", doc.Content[0])
+	return lines
+}
+
+// locate prefixes errors keyed by a YAML path with the line of the key,
+// or of the nearest enclosing key present in the file, such as the list
+// item of a site that lacks a required key.
+func (c ConfigType) locate(errs []error) []error {
+	for i, err := range errs {
+		path := strings.SplitN(err.Error(), ": ", 2)[0]
+		for path != "" {
+			if line, ok := c.lines[path]; ok {
+				errs[i] = fmt.Errorf("line %d: %s", line, err)
+				break
+			}
+			cut := strings.LastIndexAny(path, ".[")
+			if cut < 0 {
+				break
+			}
+			path = path[:cut]
+		}
+	}
+	return errs
+}
+
+// runValidate implements the validate subcommand and returns the exit code.
+func runValidate(args []string) int {
+	flag.CommandLine.Usage = commandUsage("validate", flag.CommandLine)
+	flag.CommandLine.Parse(args)
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", configFile, err)
+		return 1
+	}
+	errs := append(validateConfig(cfg), checkAddresses(cfg)...)
+	for _, err := range errs {
+		fmt.Fprintf(stderr, "%s: %s\n", configFile, err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Printf("%s: OK, %d remote sites\n", redact(configFile), len(cfg.RemoteSites))
+	return 0
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type VaultType struct {
+	Address string `yaml:"address"`
+	Token   string `yaml:"token"`
+}
+
+type vaultClient struct {
+	address string
+	token   string
+	http    *http.Client
+}
+
+type vaultResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Errors        []string               `json:"errors"`
+}
+
+// vaultSecret is a value read from Vault with its lease, if any.
+type vaultSecret struct {
+	value     string
+	leaseID   string
+	lease     time.Duration
+	renewable bool
+}
+
+var vault *vaultClient
+
+// openVault sets up the client that vault: references go through, when
+// configured.
+func openVault(cfg *VaultType) error {
+	if cfg == nil {
+		return nil
+	}
+	var err error
+	if vault, err = newVaultClient(*cfg); err != nil {
+		return fmt.Errorf("error configuring vault: %s", err)
+	}
+	return nil
+}
+
+// newVaultClient builds a client from the config, falling back to the
+// standard VAULT_ADDR and VAULT_TOKEN environment variables.
+func newVaultClient(cfg VaultType) (*vaultClient, error) {
+	address := cfg.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	token, err := resolveSecret(cfg.Token)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if address == "" || token == "" {
+		return nil, fmt.Errorf("vault address and token are required")
+	}
+	return &vaultClient{
+		address: strings.TrimRight(address, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// request sends a Vault API request, with body as JSON when not nil.
+func (v *vaultClient) request(method string, path string, body interface{}) (vaultResponse, error) {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return vaultResponse{}, err
+		}
+		payload = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/%s", v.address, strings.TrimLeft(path, "/")), payload)
+	if err != nil {
+		return vaultResponse{}, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := v.http.Do(req)
+	if err != nil {
+		return vaultResponse{}, err
+	}
+	defer resp.Body.Close()
+	var res vaultResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return vaultResponse{}, fmt.Errorf("decoding vault response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return vaultResponse{}, fmt.Errorf("vault returned %s: %s", resp.Status, strings.Join(res.Errors, "; "))
+	}
+	return res, nil
+}
+
+// read fetches key from the secret at path. Both KV v1 and KV v2 layouts
+// are understood. The lease is returned so callers can renew dynamic
+// secrets before they expire.
+func (v *vaultClient) read(path string, key string) (vaultSecret, error) {
+	res, err := v.request("GET", path, nil)
+	if err != nil {
+		return vaultSecret{}, err
+	}
+	data := res.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		data = inner
+	}
+	val, ok := data[key].(string)
+	if !ok {
+		return vaultSecret{}, fmt.Errorf("key %s not found in vault secret %s", key, path)
+	}
+	return vaultSecret{
+		value:     val,
+		leaseID:   res.LeaseID,
+		lease:     time.Duration(res.LeaseDuration) * time.Second,
+		renewable: res.Renewable,
+	}, nil
+}
+
+// renew extends the lease of secret by its lease duration, and returns it
+// with the lease Vault granted. Vault grants less once the lease nears its
+// maximum TTL, after which the secret is no longer renewable and has to be
+// read again.
+func (v *vaultClient) renew(secret vaultSecret) (vaultSecret, error) {
+	res, err := v.request("PUT", "sys/leases/renew", map[string]interface{}{
+		"lease_id":  secret.leaseID,
+		"increment": int(secret.lease / time.Second),
+	})
+	if err != nil {
+		return secret, err
+	}
+	lease := time.Duration(res.LeaseDuration) * time.Second
+	if lease <= 0 {
+		return secret, fmt.Errorf("lease %s not renewed", secret.leaseID)
+	}
+	secret.renewable = res.Renewable && lease >= secret.lease
+	secret.lease = lease
+	return secret, nil
+}
+
+// parseVaultRef splits "vault:path#key" into its path and key.
+func parseVaultRef(ref string) (string, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(ref, "vault:"), "#", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid vault reference %s, expected vault:path#key", ref)
+	}
+	return parts[0], parts[1], nil
+}
+
+func resolveVaultSecret(ref string) (vaultSecret, error) {
+	if vault == nil {
+		return vaultSecret{}, fmt.Errorf("vault reference %s used but vault is not configured", ref)
+	}
+	path, key, err := parseVaultRef(ref)
+	if err != nil {
+		return vaultSecret{}, err
+	}
+	secret, err := vault.read(path, key)
+	if err == nil {
+		registerSecret(secret.value)
+	}
+	return secret, err
+}
+
+// watchVaultSecret keeps a leased secret valid: at two thirds of its lease
+// the lease is renewed, or, when it cannot be, the secret is read again and
+// a changed value is sent to updates. Secrets without a lease are not
+// watched.
+func watchVaultSecret(ref string, secret vaultSecret, updates chan<- string) {
+	path, key, err := parseVaultRef(ref)
+	if err != nil || secret.lease <= 0 {
+		return
+	}
+	logger := log.WithFields(log.Fields{"Path": path})
+	for {
+		time.Sleep(secret.lease * 2 / 3)
+		if secret.renewable && secret.leaseID != "" {
+			renewed, err := vault.renew(secret)
+			if err == nil {
+				logger.Debug(fmt.Sprintf("Renewed vault lease for %s", renewed.lease))
+				secret = renewed
+				continue
+			}
+			logger.Warn(fmt.Sprintf("Failed to renew vault lease, reading the secret again: %s", err))
+		}
+		next, err := vault.read(path, key)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to read vault secret again: %s", err))
+			secret.lease = time.Minute * 3 / 2
+			continue
+		}
+		if next.lease <= 0 {
+			next.lease = secret.lease
+		}
+		if next.value != secret.value {
+			registerSecret(next.value)
+			updates <- next.value
+		}
+		secret = next
+	}
+}
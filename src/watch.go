@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// watchFiles signals on changes whenever one of paths is written, created
+// or replaced. The parent directories are watched so that files swapped in
+// by rename (as most orchestration tools do) are noticed too. Bursts of
+// events are coalesced into a single notification. etcd:// paths are
+// watched in etcd instead. Watching ends when stop is closed.
+func watchFiles(paths []string, changes chan<- struct{}, stop <-chan struct{}) error {
+	var files []string
+	for _, path := range paths {
+		if !isEtcdURL(path) {
+			files = append(files, path)
+			continue
+		}
+		client, key, err := parseEtcdURL(path)
+		if err != nil {
+			return err
+		}
+		go client.watch(key, false, changes, stop)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	paths = files
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		names[abs] = true
+		dirs[filepath.Dir(abs)] = true
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("watching %s: %s", dir, err)
+		}
+	}
+	go func() {
+		defer watcher.Close()
+		var pending <-chan time.Time
+		for {
+			select {
+			case <-stop:
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if names[filepath.Clean(ev.Name)] && ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					pending = time.After(time.Second)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error(fmt.Sprintf("File watcher error: %s", err))
+			case <-pending:
+				pending = nil
+				select {
+				case changes <- struct{}{}:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}